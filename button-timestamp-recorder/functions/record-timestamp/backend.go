@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"dsql-shared/buttonclick"
+	"dsql-shared/dsqlconn"
+	"dsql-shared/dsqltx"
+	"dsql-shared/idgen"
+	"dsql-shared/logging"
+	"dsql-shared/tracing"
+)
+
+// Backends selectable with DB_BACKEND. There is no fallback between them: a
+// connection failure is reported as an error, never as a mocked success.
+const (
+	backendDSQL     = "dsql"     // Aurora DSQL with IAM auth (default)
+	backendPostgres = "postgres" // plain PostgreSQL from DATABASE_URL, for local development
+	backendMemory   = "memory"   // in-process fake that forgets everything on restart
+)
+
+// clickStore records button clicks
+type clickStore interface {
+	// Insert stores a click and returns the stored row and what it cost
+	Insert(ctx context.Context, action, userAgent, ipAddress string) (buttonclick.ButtonClick, insertStats, error)
+	// Backend returns the DB_BACKEND name of the store
+	Backend() string
+}
+
+// newStore returns the store selected by DB_BACKEND. Each backend requires its
+// own settings, so a missing DATABASE_URL or cluster identifier fails at startup.
+func newStore(getenv func(string) string, ids idgen.Generator) (clickStore, error) {
+	switch backend := strings.ToLower(strings.TrimSpace(getenv("DB_BACKEND"))); backend {
+	case "", backendDSQL:
+		cfg, err := dsqlconn.FromEnv(lambdaPoolConfig, getenv)
+		if err != nil {
+			return nil, fmt.Errorf("invalid environment: %w", err)
+		}
+		if err := cfg.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid environment: %w", err)
+		}
+		return &pgxStore{backend: backendDSQL, ids: ids, connect: func(ctx context.Context) (*pgxpool.Pool, error) {
+			logging.FromContext(ctx).Info("connecting to Aurora DSQL", "host", cfg.Host())
+			return dsqlconn.New(ctx, cfg)
+		}}, nil
+
+	case backendPostgres:
+		url := getenv("DATABASE_URL")
+		if url == "" {
+			return nil, errors.New("DB_BACKEND=postgres requires DATABASE_URL")
+		}
+		poolConfig, err := pgxpool.ParseConfig(url)
+		if err != nil {
+			return nil, errors.New("invalid DATABASE_URL") // the parse error may contain the password
+		}
+		poolConfig.MaxConns = lambdaPoolConfig.MaxConns
+		poolConfig.ConnConfig.Tracer = tracing.PgxTracer{}
+		return &pgxStore{backend: backendPostgres, ids: ids, connect: func(ctx context.Context) (*pgxpool.Pool, error) {
+			logging.FromContext(ctx).Info("connecting to PostgreSQL", "host", poolConfig.ConnConfig.Host)
+			return pgxpool.NewWithConfig(ctx, poolConfig)
+		}}, nil
+
+	case backendMemory:
+		slog.Warn("using the in-memory backend: clicks are not persisted")
+		return newMemoryStore(ids), nil
+
+	default:
+		return nil, fmt.Errorf("unknown DB_BACKEND %q (dsql, postgres or memory)", backend)
+	}
+}
+
+// insertStats describes what a clickStore.Insert call cost
+type insertStats struct {
+	TxAttempts   int           // transactions run, including retries
+	OCCRetries   int           // transactions retried after an optimistic concurrency conflict
+	AcquireWait  time.Duration // time spent acquiring connections from the pool
+	PoolCreation time.Duration // time spent creating the pool; zero when an existing pool was reused
+}
+
+// maxIDAttempts bounds how many fresh IDs are tried when an insert hits a duplicate key
+const maxIDAttempts = 3
+
+// pgxStore stores clicks in button_clicks through a pgx pool. The pool is kept
+// across invocations so that warm invocations skip TLS and IAM signing.
+type pgxStore struct {
+	backend string
+	ids     idgen.Generator
+	connect func(ctx context.Context) (*pgxpool.Pool, error)
+
+	mu   sync.Mutex
+	pool *pgxpool.Pool
+}
+
+func (s *pgxStore) Backend() string { return s.backend }
+
+// getPool returns the shared pool, creating it on the first call (cold start)
+// or after resetPool discarded a broken one. The time spent creating it is added
+// to stats.
+func (s *pgxStore) getPool(ctx context.Context, stats *insertStats) (*pgxpool.Pool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.pool != nil {
+		return s.pool, nil
+	}
+	start := time.Now()
+	p, err := s.connect(ctx)
+	stats.PoolCreation += time.Since(start)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create connection pool: %w", err)
+	}
+	s.pool = p
+	return p, nil
+}
+
+// resetPool closes p and clears it from the cache if it is still the shared pool,
+// so the next getPool call creates a fresh one
+func (s *pgxStore) resetPool(p *pgxpool.Pool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.pool == p {
+		s.pool = nil
+	}
+	p.Close()
+}
+
+// runInTxWithReconnect runs fn in a transaction on the shared pool and adds the
+// attempts and pool timings to stats. Optimistic concurrency conflicts are retried
+// by dsqltx. When the connection turns out to be broken the pool is re-created,
+// and fn is retried once if nothing was sent to the server.
+func (s *pgxStore) runInTxWithReconnect(ctx context.Context, stats *insertStats, fn func(tx pgx.Tx) error) error {
+	p, err := s.getPool(ctx, stats)
+	if err != nil {
+		return err
+	}
+	err = runInTx(ctx, p, stats, fn)
+	if err == nil || !dsqlconn.IsConnError(err) {
+		return err
+	}
+
+	logging.FromContext(ctx).Warn("connection error detected, re-creating pool", "error", err)
+	s.resetPool(p)
+	if !pgconn.SafeToRetry(err) {
+		return err
+	}
+
+	p, err = s.getPool(ctx, stats)
+	if err != nil {
+		return err
+	}
+	return runInTx(ctx, p, stats, fn)
+}
+
+// runInTx runs fn with dsqltx.RunInTx and records the attempts and the time spent
+// acquiring connections in stats. The pool serves one invocation at a time, so the
+// growth of its cumulative acquire duration belongs to this call.
+func runInTx(ctx context.Context, p *pgxpool.Pool, stats *insertStats, fn func(tx pgx.Tx) error) error {
+	acquired := p.Stat().AcquireDuration()
+	attempts, err := dsqltx.RunInTx(ctx, p, fn)
+	stats.AcquireWait += p.Stat().AcquireDuration() - acquired
+	stats.TxAttempts += attempts
+	if attempts > 1 {
+		stats.OCCRetries += attempts - 1
+	}
+	return err
+}
+
+func (s *pgxStore) Insert(ctx context.Context, action, userAgent, ipAddress string) (buttonclick.ButtonClick, insertStats, error) {
+	// IDs are unique per worker; a duplicate can only come from another execution
+	// environment that picked the same random worker ID, so retry with a fresh ID
+	var click buttonclick.ButtonClick
+	var stats insertStats
+	var err error
+	for attempt := 1; attempt <= maxIDAttempts; attempt++ {
+		c := buttonclick.NewClick{ID: s.ids.NextID(), Action: action, UserAgent: userAgent, IPAddress: ipAddress}
+		err = s.runInTxWithReconnect(ctx, &stats, func(tx pgx.Tx) error {
+			var err error
+			click, err = buttonclick.NewPgxRepository(tx).Insert(ctx, c)
+			return err
+		})
+		if !errors.Is(err, buttonclick.ErrDuplicateID) {
+			break
+		}
+		logging.FromContext(ctx).Warn("duplicate id, retrying with a new id", "id", c.ID, "attempt", attempt)
+	}
+	return click, stats, err
+}
+
+// memoryStore keeps clicks in a buttonclick.MemoryRepository. It is meant for
+// local development and handler tests; it is only used when DB_BACKEND=memory
+// is set explicitly.
+type memoryStore struct {
+	ids  idgen.Generator
+	repo *buttonclick.MemoryRepository
+}
+
+func newMemoryStore(ids idgen.Generator) *memoryStore {
+	return &memoryStore{ids: ids, repo: buttonclick.NewMemoryRepository()}
+}
+
+func (s *memoryStore) Backend() string { return backendMemory }
+
+func (s *memoryStore) Insert(ctx context.Context, action, userAgent, ipAddress string) (buttonclick.ButtonClick, insertStats, error) {
+	click, err := s.repo.Insert(ctx, buttonclick.NewClick{ID: s.ids.NextID(), Action: action, UserAgent: userAgent, IPAddress: ipAddress})
+	if err != nil {
+		return buttonclick.ButtonClick{}, insertStats{}, err
+	}
+	return click, insertStats{TxAttempts: 1}, nil
+}
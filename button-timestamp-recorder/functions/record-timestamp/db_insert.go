@@ -1,19 +1,27 @@
 package main
 
 import (
-    "context"
-    "encoding/json"
-    "fmt"
-    "os"
-    "strings"
-    "time"
-
-    "github.com/aws/aws-lambda-go/events"
-    "github.com/aws/aws-lambda-go/lambda"
-    "github.com/aws/aws-sdk-go-v2/config"
-    "github.com/aws/aws-sdk-go-v2/feature/dsql/auth"
-    "github.com/jackc/pgx/v5"
-    "github.com/jackc/pgx/v5/pgxpool"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.opentelemetry.io/otel/attribute"
+
+	"dsql-shared/dsqlconn"
+	"dsql-shared/emf"
+	"dsql-shared/idgen"
+	"dsql-shared/logging"
+	"dsql-shared/tracing"
+	"dsql-shared/tracing/provider"
 )
 
 type Response struct {
@@ -23,199 +31,165 @@ type Response struct {
 }
 
 type ResponseBody struct {
-	Success         bool                   `json:"success"`
-	Message         string                 `json:"message"`
-	Timestamp       string                 `json:"timestamp"`
-	UserAgent       string                 `json:"user_agent,omitempty"`
-	SourceIP        string                 `json:"source_ip,omitempty"`
-	DatabaseResult  map[string]interface{} `json:"database_result,omitempty"`
+	Success        bool                   `json:"success"`
+	Message        string                 `json:"message"`
+	Timestamp      string                 `json:"timestamp"`
+	UserAgent      string                 `json:"user_agent,omitempty"`
+	SourceIP       string                 `json:"source_ip,omitempty"`
+	DatabaseResult map[string]interface{} `json:"database_result,omitempty"`
 }
 
-var (
-	dsqlClusterID = os.Getenv("DSQL_CLUSTER_IDENTIFIER")
-	database      = os.Getenv("DATABASE_NAME")
-)
-
-// connectDSQLWithOfficialAuth creates a DSQL connection using the official auth package
-func connectDSQLWithOfficialAuth(ctx context.Context, hostname, database, username, region string) (*pgxpool.Pool, error) {
-	// Build connection URL without password
-	connectionURL := fmt.Sprintf("postgres://%s@%s:5432/%s?sslmode=verify-full&sslnegotiation=direct",
-		username,
-		hostname,
-		database,
-	)
-
-	// Parse pool configuration
-	poolConfig, err := pgxpool.ParseConfig(connectionURL)
-	if err != nil {
-		return nil, fmt.Errorf("unable to parse pool config: %w", err)
-	}
-
-	// Set up BeforeConnect hook for token generation
-	poolConfig.BeforeConnect = func(ctx context.Context, cfg *pgx.ConnConfig) error {
-		// Load AWS configuration
-		awsCfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(region))
-		if err != nil {
-			return fmt.Errorf("failed to load AWS config: %w", err)
-		}
-
-		// Generate authentication token (30 second expiry)
-		tokenOptions := func(options *auth.TokenOptions) {
-			options.ExpiresIn = 30 * time.Second
-		}
-
-		var token string
-		if username == "admin" {
-			// Use admin auth token for admin user
-			token, err = auth.GenerateDBConnectAdminAuthToken(
-				ctx,
-				hostname,
-				region,
-				awsCfg.Credentials,
-				tokenOptions,
-			)
-		} else {
-			// Use regular auth token for non-admin users
-			token, err = auth.GenerateDbConnectAuthToken(
-				ctx,
-				hostname,
-				region,
-				awsCfg.Credentials,
-				tokenOptions,
-			)
-		}
-
-		if err != nil {
-			return fmt.Errorf("failed to generate auth token: %w", err)
-		}
-
-		// Set token as password
-		cfg.Password = token
-		fmt.Printf("Auth token generated (length: %d)\n", len(token))
+var dsqlClusterID = os.Getenv("DSQL_CLUSTER_IDENTIFIER")
+
+// lambdaPoolConfig sizes the pool for a Lambda execution environment, which
+// handles one request at a time. The endpoint is derived from
+// DSQL_CLUSTER_IDENTIFIER and AWS_REGION; other settings may be overridden via
+// the DSQL_* environment variables.
+var lambdaPoolConfig = dsqlconn.Config{
+	User:              dsqlconn.AdminUser,
+	Database:          dsqlconn.DefaultDatabase,
+	MaxConns:          2,
+	MinConns:          0,
+	MaxConnLifetime:   5 * time.Minute,
+	MaxConnIdleTime:   1 * time.Minute,
+	HealthCheckPeriod: 30 * time.Second,
+	TokenTTL:          30 * time.Second,
+}
 
-		return nil
+// insertButtonClick records a button click in store
+func insertButtonClick(ctx context.Context, store clickStore, userAgent, sourceIP string) map[string]interface{} {
+	result := make(map[string]interface{})
+	result["backend"] = store.Backend()
+	if store.Backend() == backendDSQL {
+		result["auth_method"] = "official_dsql_auth"
 	}
 
-	// Configure connection pool
-	poolConfig.MaxConns = 10
-	poolConfig.MinConns = 2
-	poolConfig.MaxConnLifetime = 1 * time.Hour
-	poolConfig.MaxConnIdleTime = 30 * time.Minute
-	poolConfig.HealthCheckPeriod = 1 * time.Minute
-
-	// Create connection pool
-	pool, err := pgxpool.NewWithConfig(ctx, poolConfig)
+	done := logging.TimeDB(ctx)
+	click, stats, err := store.Insert(ctx, "record", userAgent, sourceIP)
+	done()
+	emitInsertMetrics(ctx, stats, err)
+	result["tx_attempts"] = stats.TxAttempts
 	if err != nil {
-		return nil, fmt.Errorf("unable to create connection pool: %w", err)
+		logging.FromContext(ctx).Error("failed to record button click", "backend", store.Backend(), "tx_attempts", stats.TxAttempts, "error", err)
+		result["status"] = "error"
+		result["message"] = fmt.Sprintf("Data insertion failed: %v", err)
+		return result
 	}
 
-	return pool, nil
-}
+	logging.FromContext(ctx).Info("recorded button click", "backend", store.Backend(), "id", click.ID, "tx_attempts", stats.TxAttempts)
+	result["status"] = "success"
+	result["message"] = "Data inserted successfully"
+	result["inserted_id"] = strconv.FormatInt(click.ID, 10) // a string like click.id, so JavaScript does not round it
+	result["inserted_at"] = time.Now().Format("2006-01-02 15:04:05")
+	result["click"] = click
 
-// insertButtonClick inserts a button click using the official DSQL auth
-func insertButtonClick(ctx context.Context, hostname, database, userAgent, sourceIP string) map[string]interface{} {
-	result := make(map[string]interface{})
-	result["auth_method"] = "official_dsql_auth"
+	return result
+}
 
-	region := "ap-northeast-1"
-	username := "admin"
+// metrics receives the CloudWatch EMF metrics; nil (as in tests) discards them
+var metrics *emf.Emitter
 
-	fmt.Printf("Connecting to DSQL at %s with official auth\n", hostname)
+// emitInsertMetrics emits the outcome and cost of an insert as EMF metrics.
+// Failures are counted per SQLSTATE so conflicts and constraint errors can be
+// told apart from connection problems.
+func emitInsertMetrics(ctx context.Context, stats insertStats, err error) {
+	values := []emf.Metric{
+		{Name: "OCCRetries", Unit: emf.Count, Value: float64(stats.OCCRetries)},
+		{Name: "PoolAcquireWait", Unit: emf.Milliseconds, Value: emf.Ms(stats.AcquireWait)},
+	}
+	if stats.PoolCreation > 0 {
+		values = append(values, emf.Metric{Name: "PoolCreationTime", Unit: emf.Milliseconds, Value: emf.Ms(stats.PoolCreation)})
+	}
+	if err == nil {
+		values = append(values, emf.Metric{Name: "ClicksRecorded", Unit: emf.Count, Value: 1})
+	}
+	if emitErr := metrics.Emit(nil, values...); emitErr != nil {
+		logging.FromContext(ctx).Warn("failed to emit metrics", "error", emitErr)
+	}
 
-	// Create connection pool with official auth
-	pool, err := connectDSQLWithOfficialAuth(ctx, hostname, database, username, region)
 	if err != nil {
-		// Check if this is a local development environment issue
-		if isLocalDevelopmentError(err) {
-			return createMockSuccessResponse(userAgent, sourceIP)
+		failure := emf.Metric{Name: "InsertFailures", Unit: emf.Count, Value: 1}
+		if emitErr := metrics.Emit([]emf.Dimension{{Name: "SQLSTATE", Value: sqlState(err)}}, failure); emitErr != nil {
+			logging.FromContext(ctx).Warn("failed to emit metrics", "error", emitErr)
 		}
-		result["status"] = "error"
-		result["message"] = fmt.Sprintf("Failed to create connection pool: %v", err)
-		return result
 	}
-	defer pool.Close()
+}
 
-	// Test connection
-	err = pool.Ping(ctx)
-	if err != nil {
-		// Check if this is a local development environment issue
-		if isLocalDevelopmentError(err) {
-			return createMockSuccessResponse(userAgent, sourceIP)
-		}
-		result["status"] = "error"
-		result["message"] = fmt.Sprintf("Failed to ping database: %v", err)
-		return result
+// sqlState returns the SQLSTATE of err, or a short label for errors that did not
+// come from the server
+func sqlState(err error) string {
+	var pgErr *pgconn.PgError
+	switch {
+	case errors.As(err, &pgErr):
+		return pgErr.Code
+	case dsqlconn.IsConnError(err):
+		return "connection"
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		return "canceled"
+	default:
+		return "other"
 	}
+}
 
-	// Test basic query
-	var version string
-	err = pool.QueryRow(ctx, "SELECT version()").Scan(&version)
-	if err != nil {
-		result["status"] = "error"
-		result["message"] = fmt.Sprintf("Failed to execute test query: %v", err)
-		return result
+// newHandler returns the Lambda handler that records clicks in store
+func newHandler(store clickStore) func(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	return func(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+		logReq := logRequest(ctx, request)
+		ctx, span := tracing.StartInvocation(ctx, logReq.Route, request.Headers,
+			attribute.String("faas.invocation_id", logReq.LambdaRequestID),
+			attribute.String("http.request.method", request.HTTPMethod),
+			attribute.String("http.route", request.Resource),
+		)
+		logReq.TraceID = tracing.TraceID(ctx)
+		ctx, finish := logging.Start(ctx, slog.Default(), logReq)
+
+		response, err := handler(ctx, store, request)
+		finish(response.StatusCode, err)
+		tracing.EndInvocation(span, response.StatusCode, err)
+		flushTraces(ctx)
+		return response, err
 	}
+}
 
-	result["database_version"] = version
-	fmt.Printf("Successfully connected to DSQL! Version: %s\n", version)
+// metricsNamespace is the CloudWatch namespace unless METRICS_NAMESPACE overrides it
+const metricsNamespace = "DSQLButtonClicks"
 
-	// Insert button click record
-	now := time.Now()
-	newID := now.Unix()
+// traceFlushTimeout bounds how long an invocation waits to export its spans
+const traceFlushTimeout = 2 * time.Second
 
-	insertSQL := `
-		INSERT INTO button_clicks (id, action, user_agent, ip_address)
-		VALUES ($1, $2, $3, $4)
-	`
+// flushSpans exports pending spans; it is a no-op unless tracing is configured
+var flushSpans = func(context.Context) error { return nil }
 
-	_, insertErr := pool.Exec(ctx, insertSQL, newID, "record", userAgent, sourceIP)
-	if insertErr != nil {
-		result["status"] = "error"
-		result["message"] = fmt.Sprintf("Data insertion failed: %v", insertErr)
-		return result
+// flushTraces exports the spans before the response is returned, because the
+// execution environment is frozen afterwards
+func flushTraces(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), traceFlushTimeout)
+	defer cancel()
+	if err := flushSpans(ctx); err != nil {
+		slog.Warn("failed to flush traces", "error", err)
 	}
-
-	result["status"] = "success"
-	result["message"] = "Data inserted successfully with official DSQL auth"
-	result["inserted_id"] = newID
-	result["inserted_at"] = now.Format("2006-01-02 15:04:05")
-
-	return result
 }
 
-// isLocalDevelopmentError checks if the error is due to local development environment limitations
-func isLocalDevelopmentError(err error) bool {
-	errorStr := err.Error()
-	return strings.Contains(errorStr, "no such host") ||
-		strings.Contains(errorStr, "hostname resolving error") ||
-		strings.Contains(errorStr, "connection refused") ||
-		strings.Contains(errorStr, "i/o timeout")
-}
-
-// createMockSuccessResponse creates a mock response for local development testing
-func createMockSuccessResponse(userAgent, sourceIP string) map[string]interface{} {
-	now := time.Now()
-	mockID := now.Unix()
-
-	fmt.Printf("LOCAL DEVELOPMENT MODE: Creating mock response for testing\n")
-
-	return map[string]interface{}{
-		"status":           "success",
-		"message":          "Mock data insertion for local development",
-		"auth_method":      "official_dsql_auth",
-		"database_version": "PostgreSQL 15.0 (Mock for local development)",
-		"inserted_id":      mockID,
-		"inserted_at":      now.Format("2006-01-02 15:04:05"),
-		"local_mode":       true,
-		"note":             "This is a mock response for local development. Real DSQL connection will be used in AWS environment.",
+// logRequest returns the identifiers attached to every log line of the request
+func logRequest(ctx context.Context, request events.APIGatewayProxyRequest) logging.Request {
+	r := logging.Request{
+		APIRequestID: request.RequestContext.RequestID,
+		Route:        request.HTTPMethod + " " + request.Path,
+	}
+	if request.Resource != "" {
+		r.Route = request.HTTPMethod + " " + request.Resource
 	}
+	if lc, ok := lambdacontext.FromContext(ctx); ok {
+		r.LambdaRequestID = lc.AwsRequestID
+	}
+	return r
 }
 
-func handler(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+func handler(ctx context.Context, store clickStore, request events.APIGatewayProxyRequest) (Response, error) {
 	// CORSヘッダーを設定
 	headers := map[string]string{
-		"Content-Type":                "application/json",
-		"Access-Control-Allow-Origin": "*",
+		"Content-Type":                 "application/json",
+		"Access-Control-Allow-Origin":  "*",
 		"Access-Control-Allow-Headers": "Content-Type",
 		"Access-Control-Allow-Methods": "OPTIONS,POST,GET",
 	}
@@ -244,28 +218,8 @@ func handler(ctx context.Context, request events.APIGatewayProxyRequest) (Respon
 		sourceIP = "0.0.0.0"
 	}
 
-	// AWS設定からリージョンを取得
-	cfg, err := config.LoadDefaultConfig(ctx)
-	if err != nil {
-		// エラーレスポンス
-		respBody := ResponseBody{
-			Success:        false,
-			Message:        fmt.Sprintf("AWS設定の読み込みに失敗: %v", err),
-			Timestamp:      timestamp.Format("2006年01月02日 15:04:05"),
-		}
-		bodyBytes, _ := json.Marshal(respBody)
-		return Response{
-			StatusCode: 500,
-			Headers:    headers,
-			Body:       string(bodyBytes),
-		}, nil
-	}
-
-	// DSQL操作 - 公式認証パッケージを使用
-	hostname := fmt.Sprintf("%s.dsql.%s.on.aws", dsqlClusterID, cfg.Region)
-
-	fmt.Printf("Starting DSQL Connection with Official Auth Package\n")
-	dbResult := insertButtonClick(ctx, hostname, database, userAgent, sourceIP)
+	// DB_BACKENDで選んだバックエンドに記録
+	dbResult := insertButtonClick(ctx, store, userAgent, sourceIP)
 
 	// レスポンス構築
 	respBody := ResponseBody{
@@ -292,5 +246,37 @@ func handler(ctx context.Context, request events.APIGatewayProxyRequest) (Respon
 }
 
 func main() {
-	lambda.Start(handler)
-}
\ No newline at end of file
+	// JSON logs on stdout; the level comes from LOG_LEVEL
+	slog.SetDefault(logging.FromEnv(os.Stdout, os.Getenv))
+
+	// EMF metrics share stdout with the logs; CloudWatch extracts them from the log stream
+	metrics = emf.FromEnv(os.Stdout, metricsNamespace, os.Getenv, emf.Dimension{Name: "Service", Value: "button-timestamp-recorder"})
+
+	// Traces go wherever OTEL_TRACES_EXPORTER says; a broken setup only disables them
+	var err error
+	flushSpans, err = provider.Setup(context.Background(), "button-timestamp-recorder", os.Getenv)
+	if err != nil {
+		slog.Error("failed to set up tracing, traces are disabled", "error", err)
+	}
+
+	workerID, err := idgen.WorkerID(os.Getenv)
+	if err != nil {
+		fatal("failed to determine ID worker", err)
+	}
+	ids, err := idgen.NewSnowflake(workerID)
+	if err != nil {
+		fatal("failed to create ID generator", err)
+	}
+	store, err := newStore(os.Getenv, ids)
+	if err != nil {
+		fatal("failed to configure the database backend", err)
+	}
+
+	lambda.Start(newHandler(store))
+}
+
+// fatal logs a startup error and exits, failing the Lambda init phase
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
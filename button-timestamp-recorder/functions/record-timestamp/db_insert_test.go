@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+
+	"dsql-shared/buttonclick"
+	"dsql-shared/idgen"
+)
+
+// newTestStore returns the store that DB_BACKEND=memory selects
+func newTestStore(t *testing.T) clickStore {
+	t.Helper()
+	ids, err := idgen.NewSnowflake(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := newStore(func(key string) string {
+		if key == "DB_BACKEND" {
+			return "memory"
+		}
+		return ""
+	}, ids)
+	if err != nil {
+		t.Fatalf("newStore: %v", err)
+	}
+	return store
+}
+
+// responseBody is ResponseBody with a typed database_result. IDs are decoded
+// without going through float64, which cannot hold Snowflake IDs exactly.
+type responseBody struct {
+	Success        bool   `json:"success"`
+	UserAgent      string `json:"user_agent"`
+	SourceIP       string `json:"source_ip"`
+	DatabaseResult struct {
+		Backend    string                   `json:"backend"`
+		AuthMethod string                   `json:"auth_method"`
+		Status     string                   `json:"status"`
+		Message    string                   `json:"message"`
+		InsertedID json.Number              `json:"inserted_id"`
+		Click      *buttonclick.ButtonClick `json:"click"`
+	} `json:"database_result"`
+}
+
+// invoke calls the Lambda handler and decodes the response body
+func invoke(t *testing.T, store clickStore, request events.APIGatewayProxyRequest) (Response, responseBody) {
+	t.Helper()
+	resp, err := newHandler(store)(context.Background(), request)
+	if err != nil {
+		t.Fatalf("handler returned an error: %v", err)
+	}
+	var body responseBody
+	if err := json.Unmarshal([]byte(resp.Body), &body); err != nil {
+		t.Fatalf("response body %q: %v", resp.Body, err)
+	}
+	return resp, body
+}
+
+func postRequest() events.APIGatewayProxyRequest {
+	return events.APIGatewayProxyRequest{
+		HTTPMethod: "POST",
+		Path:       "/record",
+		Resource:   "/record",
+		Headers:    map[string]string{"User-Agent": "test-agent"},
+		RequestContext: events.APIGatewayProxyRequestContext{
+			RequestID: "req-1",
+			Identity:  events.APIGatewayRequestIdentity{SourceIP: "192.0.2.1"},
+		},
+	}
+}
+
+func TestHandlerRecordsClick(t *testing.T) {
+	store := newTestStore(t)
+
+	resp, body := invoke(t, store, postRequest())
+	if resp.StatusCode != 200 || !body.Success {
+		t.Fatalf("status %d, success %v: %s", resp.StatusCode, body.Success, resp.Body)
+	}
+	if resp.Headers["Access-Control-Allow-Origin"] != "*" {
+		t.Errorf("headers = %v, want CORS headers", resp.Headers)
+	}
+	if body.UserAgent != "test-agent" || body.SourceIP != "192.0.2.1" {
+		t.Errorf("user agent %q, source IP %q", body.UserAgent, body.SourceIP)
+	}
+	db := body.DatabaseResult
+	if db.Backend != backendMemory || db.Status != "success" {
+		t.Errorf("database_result = %+v, want a successful memory insert", db)
+	}
+	if db.AuthMethod != "" {
+		t.Errorf("auth_method = %q, it is only reported for dsql", db.AuthMethod)
+	}
+
+	// The stored row is returned with the values taken from the request
+	c := db.Click
+	if c == nil {
+		t.Fatalf("database_result = %s, want the click", resp.Body)
+	}
+	if c.ID == 0 || db.InsertedID.String() != strconv.FormatInt(c.ID, 10) {
+		t.Errorf("click id %d, inserted_id %s", c.ID, db.InsertedID)
+	}
+	// IDs exceed 2^53, so they are sent as strings that JavaScript does not round
+	for _, field := range []string{`"inserted_id":"`, `"id":"`} {
+		if !strings.Contains(resp.Body, field+db.InsertedID.String()+`"`) {
+			t.Errorf("response %s does not contain %s%s\"", resp.Body, field, db.InsertedID)
+		}
+	}
+	if c.Action == nil || *c.Action != "record" || c.UserAgent == nil || *c.UserAgent != "test-agent" ||
+		c.IPAddress == nil || *c.IPAddress != "192.0.2.1" {
+		t.Errorf("click = %+v", c)
+	}
+
+	// Snowflake IDs from one worker differ only in the low bits, so compare them exactly
+	_, second := invoke(t, store, postRequest())
+	if next := second.DatabaseResult.Click; next == nil || second.DatabaseResult.InsertedID == db.InsertedID || next.ID <= c.ID {
+		t.Errorf("second click got id %s after %s", second.DatabaseResult.InsertedID, db.InsertedID)
+	}
+}
+
+func TestHandlerDefaults(t *testing.T) {
+	request := postRequest()
+	request.Headers = nil
+	request.RequestContext.Identity.SourceIP = ""
+
+	_, body := invoke(t, newTestStore(t), request)
+	if body.UserAgent != "Unknown" || body.SourceIP != "0.0.0.0" {
+		t.Errorf("user agent %q, source IP %q, want the defaults", body.UserAgent, body.SourceIP)
+	}
+}
+
+func TestHandlerPreflight(t *testing.T) {
+	resp, err := newHandler(newTestStore(t))(context.Background(), events.APIGatewayProxyRequest{HTTPMethod: "OPTIONS"})
+	if err != nil || resp.StatusCode != 200 || resp.Body != "" {
+		t.Errorf("OPTIONS = %d %q, %v; want an empty 200", resp.StatusCode, resp.Body, err)
+	}
+	if resp.Headers["Access-Control-Allow-Methods"] != "OPTIONS,POST,GET" {
+		t.Errorf("headers = %v, want CORS headers", resp.Headers)
+	}
+}
+
+// failingStore is a clickStore whose inserts always fail
+type failingStore struct{ err error }
+
+func (s failingStore) Backend() string { return backendPostgres }
+
+func (s failingStore) Insert(context.Context, string, string, string) (buttonclick.ButtonClick, insertStats, error) {
+	return buttonclick.ButtonClick{}, insertStats{TxAttempts: 1}, s.err
+}
+
+// A failed insert is reported as a 500, never as a success
+func TestHandlerInsertFailure(t *testing.T) {
+	resp, body := invoke(t, failingStore{errors.New("connection refused")}, postRequest())
+	if resp.StatusCode != 500 || body.Success {
+		t.Errorf("status %d, success %v, want a failure", resp.StatusCode, body.Success)
+	}
+	db := body.DatabaseResult
+	if db.Status != "error" || db.Message != "Data insertion failed: connection refused" {
+		t.Errorf("database_result = %+v", db)
+	}
+	if db.InsertedID != "" || db.Click != nil {
+		t.Errorf("database_result = %s, want no inserted row", resp.Body)
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"dsql-shared/dsqlconn"
+)
+
+// version はビルド時に -ldflags "-X main.version=v1.2.3" で設定する
+var version = "dev"
+
+// runConnectTest は接続と認証を確認し、接続先のユーザーとバージョンを表示する
+func runConnectTest(args []string) error {
+	fs, cfg, err := newFlagSet("connect-test", "")
+	if err != nil {
+		return err
+	}
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return usageError(errors.New("connect-test takes no arguments"))
+	}
+
+	db, err := connectToDSQL(*cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var user, serverVersion string
+	err = db.QueryRow(context.Background(), "SELECT current_user, version()").Scan(&user, &serverVersion)
+	if err != nil {
+		return connectError(fmt.Errorf("failed to query server: %w", err))
+	}
+	fmt.Printf("user: %s\nserver: %s\n", user, serverVersion)
+	return nil
+}
+
+// runSchema はschemaサブコマンドを実行する
+func runSchema(args []string) error {
+	fs, cfg, err := newFlagSet("schema", "init")
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 || args[0] != "init" {
+		fs.Usage()
+		return usageError(errors.New(`unknown schema command (available: "init")`))
+	}
+	if err := parseFlags(fs, args[1:]); err != nil {
+		return err
+	}
+
+	db, err := connectToDSQL(*cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return createTable(context.Background(), db)
+}
+
+// runSeed はサンプルデータを挿入する
+func runSeed(args []string) error {
+	fs, cfg, err := newFlagSet("seed", "")
+	if err != nil {
+		return err
+	}
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	db, err := connectToDSQL(*cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return insertSampleData(context.Background(), db)
+}
+
+// runToken は認証トークンを生成して表示する
+//
+// トークンだけを標準出力に書くため、PGPASSWORD="$(dsql-client token)" のように使える。
+// -envではpsqlが読む環境変数をexport文として書き出す。
+func runToken(args []string) error {
+	fs, cfg, err := newFlagSet("token", "[-env]")
+	if err != nil {
+		return err
+	}
+	// psqlで使うトークンはプールより長い1時間を既定にする（-token-ttlで変更できる）
+	cfg.TokenTTL = time.Hour
+	fs.Lookup("token-ttl").DefValue = cfg.TokenTTL.String()
+	env := fs.Bool("env", false, "print export statements for psql (PGHOST, PGPASSWORD, ...)")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := cfg.Validate(); err != nil {
+		return usageError(err)
+	}
+	if cfg.TokenTTL <= 0 || cfg.TokenTTL > dsqlconn.MaxTokenTTL {
+		return usageError(fmt.Errorf("-token-ttl must be between 1s and %v", dsqlconn.MaxTokenTTL))
+	}
+
+	ctx := context.Background()
+	provider, err := dsqlconn.LoadIAMTokenProvider(ctx, cfg.Region)
+	if err != nil {
+		return connectError(err)
+	}
+	token, err := provider.Token(ctx, dsqlconn.TokenRequest{
+		Host:      cfg.Host(),
+		Region:    cfg.Region,
+		User:      cfg.User,
+		ExpiresIn: cfg.TokenTTL,
+	})
+	if err != nil {
+		return connectError(err)
+	}
+
+	if !*env {
+		fmt.Println(token)
+		return nil
+	}
+	for _, kv := range psqlEnv(*cfg, token) {
+		fmt.Printf("export %s=%s\n", kv[0], shellQuote(kv[1]))
+	}
+	return nil
+}
+
+// psqlEnv はpsqlでcfgの接続先に接続するための環境変数を返す
+//
+// サーバー証明書をシステムの信頼ストアで検証する（PGSSLROOTCERT=systemはlibpq 16以降）。
+func psqlEnv(cfg dsqlconn.Config, token string) [][2]string {
+	port := cfg.Port
+	if port == 0 {
+		port = dsqlconn.DefaultPort
+	}
+	return [][2]string{
+		{"PGHOST", cfg.Host()},
+		{"PGPORT", fmt.Sprint(port)},
+		{"PGDATABASE", cfg.Database},
+		{"PGUSER", cfg.User},
+		{"PGSSLMODE", "verify-full"},
+		{"PGSSLROOTCERT", "system"},
+		{"PGPASSWORD", token},
+	}
+}
+
+// shellQuote はsをシェルの単一引用符で囲む
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// runVersion はバージョンとビルド情報を表示する
+func runVersion(args []string) error {
+	if len(args) > 0 {
+		return usageError(errors.New("version takes no arguments"))
+	}
+
+	fmt.Printf("dsql-client %s (%s, %s/%s)\n", version, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range info.Settings {
+			if s.Key == "vcs.revision" {
+				fmt.Printf("revision: %s\n", s.Value)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"dsql-client/transfer"
+)
+
+// runExport はテーブルまたは問い合わせの結果をファイルに書き出すexportサブコマンドを実行する
+func runExport(args []string) error {
+	fs, cfg, err := newFlagSet("export", "(-table TABLE | -query SQL) [-o FILE]")
+	if err != nil {
+		return err
+	}
+	table := fs.String("table", "", "table to export (schema.table is allowed)")
+	query := fs.String("query", "", "query to export instead of a table")
+	keyList := fs.String("key", "", "comma-separated unique columns for keyset pagination (default: the primary key of -table)")
+	out := fs.String("o", "-", "output file (- for stdout)")
+	formatName := fs.String("format", "", "output format: csv, jsonl or parquet (default: from the output extension, otherwise csv)")
+	compressName := fs.String("compress", "", "compression: none or gzip (default: gzip if the output ends with .gz)")
+	pageSize := fs.Int("page-size", 1000, "rows per query")
+	null := fs.String("null", transfer.DefaultNull, "CSV value written for NULL")
+	manifestPath := fs.String("manifest", "", "manifest file with row count and checksum (default: OUTPUT.manifest.json; none for stdout)")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if (*table == "") == (*query == "") || fs.NArg() > 0 {
+		fs.Usage()
+		return usageError(errors.New("specify either -table or -query"))
+	}
+	format := transfer.FormatFromPath(*out)
+	if *formatName != "" {
+		if format, err = transfer.ParseFormat(*formatName); err != nil {
+			return usageError(err)
+		}
+	}
+	compression := transfer.CompressionFromPath(*out)
+	if *compressName != "" {
+		if compression, err = transfer.ParseCompression(*compressName); err != nil {
+			return usageError(err)
+		}
+	}
+	if format == transfer.Parquet && compression == transfer.Gzip {
+		// Parquetはページごとにgzipで圧縮しているため、ファイル全体は圧縮しない
+		return usageError(errors.New("parquet files are compressed internally; do not use gzip compression"))
+	}
+	if *pageSize < 1 {
+		return usageError(errors.New("-page-size must be at least 1"))
+	}
+	var key []string
+	if *keyList != "" {
+		for _, k := range strings.Split(*keyList, ",") {
+			key = append(key, strings.TrimSpace(k))
+		}
+	}
+	if *manifestPath == "" && *out != "-" {
+		*manifestPath = *out + ".manifest.json"
+	}
+
+	db, err := connectToDSQL(*cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	var dst io.Writer = os.Stdout
+	var file *os.File
+	if *out != "-" {
+		if file, err = os.Create(*out); err != nil {
+			return err
+		}
+		defer file.Close()
+		dst = file
+	}
+	sum := transfer.NewChecksum(dst)
+	buf := bufio.NewWriterSize(sum, 1<<20)
+	var w io.Writer = buf
+	var gz *gzip.Writer
+	if compression == transfer.Gzip {
+		gz = gzip.NewWriter(buf)
+		w = gz
+	}
+
+	ex := &transfer.Exporter{
+		Pool:     db,
+		Table:    *table,
+		Query:    *query,
+		Key:      key,
+		PageSize: *pageSize,
+		Format:   format,
+		Null:     *null,
+		Logf: func(format string, args ...any) {
+			fmt.Fprintf(os.Stderr, format+"\n", args...)
+		},
+	}
+	fmt.Fprintln(os.Stderr, "📤 書き出し中...")
+	stats, err := ex.Run(ctx, w)
+	if err == nil && gz != nil {
+		err = gz.Close()
+	}
+	if err == nil {
+		err = buf.Flush()
+	}
+	if err == nil && file != nil {
+		err = file.Close()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %d行を書き出したところで失敗しました\n", stats.Rows)
+		return err
+	}
+	if len(stats.Key) == 0 {
+		fmt.Fprintln(os.Stderr, "⚠️  -keyを指定していないため、1つの文で問い合わせました（大きな結果はトランザクションの時間制限を超えることがあります）")
+	}
+
+	if *manifestPath != "" {
+		m := &transfer.Manifest{
+			Format:      format,
+			Compression: compression,
+			Table:       *table,
+			Query:       *query,
+			Columns:     stats.Columns,
+			Key:         stats.Key,
+			Rows:        stats.Rows,
+			Bytes:       sum.Bytes(),
+			SHA256:      sum.Sum(),
+			CreatedAt:   time.Now().UTC(),
+		}
+		if format == transfer.CSV {
+			m.Null = *null
+		}
+		if *out != "-" {
+			m.SetDataPath(*manifestPath, *out)
+		}
+		if err := m.Save(*manifestPath); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "🧾 マニフェストを出力しました: %s\n", *manifestPath)
+	}
+	fmt.Fprintf(os.Stderr, "✅ %d行を書き出しました (%dページ, %.1f秒)\n", stats.Rows, stats.Pages, stats.Elapsed.Seconds())
+	return nil
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"dsql-client/transfer"
+)
+
+// runImport はCSVまたはJSON Linesのファイルをテーブルに取り込むimportサブコマンドを実行する
+func runImport(args []string) error {
+	fs, cfg, err := newFlagSet("import", "-table TABLE -f FILE")
+	if err != nil {
+		return err
+	}
+	table := fs.String("table", "", "target table (schema.table is allowed)")
+	file := fs.String("f", "", "CSV or JSON Lines file to import (- for stdin)")
+	formatName := fs.String("format", "", "input format: csv or jsonl (default: from the file extension)")
+	null := fs.String("null", transfer.DefaultNull, "CSV value that means NULL (with -manifest, defaults to the value it records)")
+	batchRows := fs.Int("batch-rows", 500, fmt.Sprintf("rows per INSERT transaction (at most %d)", transfer.MaxRowsPerTx))
+	batchBytes := fs.Int("batch-bytes", 4*1024*1024, fmt.Sprintf("approximate bytes of values per INSERT transaction (at most %d)", transfer.MaxBytesPerTx))
+	workers := fs.Int("workers", 4, "number of batches to insert concurrently")
+	checkpoint := fs.String("checkpoint", "", "checkpoint file to resume a failed import (default: FILE.checkpoint; none for stdin)")
+	onConflict := fs.String("on-conflict", "error", "rows whose key already exists: error or skip")
+	manifestPath := fs.String("manifest", "", "manifest written by export; verifies the checksum and row count (-f, -table and -format default to its values)")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	var manifest *transfer.Manifest
+	if *manifestPath != "" {
+		if manifest, err = transfer.LoadManifest(*manifestPath); err != nil {
+			return usageError(err)
+		}
+		if *file == "" && manifest.File != "" {
+			*file = manifest.DataPath(*manifestPath)
+		}
+		if *table == "" {
+			*table = manifest.Table
+		}
+		// マニフェストにNULLの値がなければ、空のフィールドをNULLとして書き出している
+		if !flagPassed(fs, "null") {
+			*null = manifest.Null
+		}
+	}
+	if *table == "" || *file == "" || fs.NArg() > 0 {
+		fs.Usage()
+		return usageError(errors.New("specify -table and -f"))
+	}
+	format := transfer.FormatFromPath(*file)
+	if manifest != nil {
+		format = manifest.Format
+	}
+	if *formatName != "" {
+		if format, err = transfer.ParseFormat(*formatName); err != nil {
+			return usageError(err)
+		}
+	}
+	if *batchRows < 1 || *batchRows > transfer.MaxRowsPerTx {
+		return usageError(fmt.Errorf("-batch-rows must be between 1 and %d", transfer.MaxRowsPerTx))
+	}
+	if *batchBytes < 1 || *batchBytes > transfer.MaxBytesPerTx {
+		return usageError(fmt.Errorf("-batch-bytes must be between 1 and %d", transfer.MaxBytesPerTx))
+	}
+	if *workers < 1 {
+		return usageError(errors.New("-workers must be at least 1"))
+	}
+	if *onConflict != "error" && *onConflict != "skip" {
+		return usageError(fmt.Errorf("invalid -on-conflict %q (error or skip)", *onConflict))
+	}
+	if *checkpoint == "" && *file != "-" {
+		*checkpoint = *file + ".checkpoint"
+	}
+
+	// 読み込むファイル。マニフェストがある場合の標準入力は一時ファイルに保存したもの
+	dataPath := *file
+	if manifest != nil {
+		if *file == "-" {
+			path, cleanup, err := spool(os.Stdin)
+			if err != nil {
+				return fmt.Errorf("failed to save stdin: %w", err)
+			}
+			defer cleanup()
+			dataPath = path
+		}
+		// 1行も挿入しないうちに、データがマニフェストと一致することを確認する
+		if err := manifest.Verify(dataPath); err != nil {
+			if *file == "-" {
+				return fmt.Errorf("stdin: %w", err)
+			}
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "🧾 マニフェストのチェックサムを確認しました (%d行)\n", manifest.Rows)
+	}
+
+	var in io.Reader = os.Stdin
+	if dataPath != "-" {
+		f, err := os.Open(dataPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	// gzipで圧縮されていれば展開する
+	if in, err = transfer.Decompress(in); err != nil {
+		return fmt.Errorf("%s: %w", *file, err)
+	}
+	src, err := transfer.NewSource(in, format, *null)
+	if err != nil {
+		return fmt.Errorf("%s: %w", *file, err)
+	}
+
+	db, err := connectToDSQL(*cfg, func(c *pgxpool.Config) {
+		c.MaxConns = max(c.MaxConns, int32(*workers))
+	})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	// 中断してもそれまでに完了したバッチはチェックポイントに残る
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	im := &transfer.Importer{
+		Pool:          db,
+		Table:         *table,
+		BatchRows:     *batchRows,
+		BatchBytes:    *batchBytes,
+		Workers:       *workers,
+		SkipConflicts: *onConflict == "skip",
+		Checkpoint:    *checkpoint,
+		SourceName:    *file,
+		Logf: func(format string, args ...any) {
+			fmt.Fprintf(os.Stderr, format+"\n", args...)
+		},
+	}
+	fmt.Fprintf(os.Stderr, "📥 %s を %s に取り込み中...\n", *file, *table)
+	stats, err := im.Run(ctx, src)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %d行を挿入したところで失敗しました\n", stats.Inserted)
+		return err
+	}
+	if manifest != nil && stats.Records != manifest.Rows {
+		return fmt.Errorf("read %d records from %s, but the manifest says %d", stats.Records, *file, manifest.Rows)
+	}
+	if stats.Resumed > 0 {
+		fmt.Fprintf(os.Stderr, "⏩ 前回完了していた%d行を読み飛ばしました\n", stats.Resumed)
+	}
+	if skipped := stats.Records - stats.Resumed - stats.Inserted; skipped > 0 {
+		fmt.Fprintf(os.Stderr, "⚠️  キーが重複する%d行を挿入しませんでした\n", skipped)
+	}
+	fmt.Fprintf(os.Stderr, "✅ %d行を挿入しました (%dバッチ, %.1f秒)\n", stats.Inserted, stats.Batches, stats.Elapsed.Seconds())
+	return nil
+}
+
+// spool はrを一時ファイルに書き出し、そのパスと一時ファイルを削除する関数を返す
+//
+// 標準入力のチェックサムは読み終えるまで分からないため、挿入を始める前に確認できるよう
+// いったんファイルに保存する。
+func spool(r io.Reader) (path string, cleanup func(), err error) {
+	f, err := os.CreateTemp("", "dsql-client-import-*")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup = func() { os.Remove(f.Name()) }
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return f.Name(), cleanup, nil
+}
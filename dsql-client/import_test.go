@@ -0,0 +1,70 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"dsql-client/transfer"
+)
+
+// withStdin はテストの間、標準入力をdataにする
+func withStdin(t *testing.T, data string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "stdin")
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	orig := os.Stdin
+	os.Stdin = f
+	t.Cleanup(func() {
+		os.Stdin = orig
+		f.Close()
+	})
+}
+
+// 標準入力がマニフェストと一致しなければ、接続する前にエラーにする
+func TestImportVerifiesStdinBeforeInserting(t *testing.T) {
+	exported := "id,name\n1,a\n"
+	sum := sha256.Sum256([]byte(exported))
+	manifest := &transfer.Manifest{
+		Format:  transfer.CSV,
+		Table:   "items",
+		Columns: []string{"id", "name"},
+		Rows:    1,
+		Bytes:   int64(len(exported)),
+		SHA256:  hex.EncodeToString(sum[:]),
+	}
+	manifestPath := filepath.Join(t.TempDir(), "items.manifest.json")
+	if err := manifest.Save(manifestPath); err != nil {
+		t.Fatal(err)
+	}
+
+	withStdin(t, "id,name\n1,b\n")
+	err := runImport([]string{"-manifest", manifestPath, "-f", "-"})
+	if err == nil || !strings.Contains(err.Error(), "stdin:") || !strings.Contains(err.Error(), "but the manifest says") {
+		t.Fatalf("runImport error = %v, want a checksum mismatch for stdin", err)
+	}
+}
+
+func TestSpool(t *testing.T) {
+	path, cleanup, err := spool(strings.NewReader("id\n1\n"))
+	if err != nil {
+		t.Fatalf("spool: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil || string(data) != "id\n1\n" {
+		t.Errorf("spooled %q, %v", data, err)
+	}
+	cleanup()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("%s was not removed: %v", path, err)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+
+	"github.com/chzyer/readline"
+)
+
+// errInputInterrupted は入力中にCtrl-Cが押された
+var errInputInterrupted = errors.New("input interrupted")
+
+// lineReader は対話モードの入力を1行ずつ読む
+type lineReader interface {
+	// readLine はpromptを表示して1行読む。入力の終わりではio.EOF、
+	// 入力中にCtrl-Cが押された場合はerrInputInterruptedを返す
+	readLine(prompt string) (string, error)
+	// addHistory は矢印キーで呼び出せる履歴にentryを追加する
+	addHistory(entry string)
+	close() error
+}
+
+// scannerReader は端末でない標準入力を読む（プロンプトと履歴は使わない）
+type scannerReader struct {
+	scanner *bufio.Scanner
+}
+
+func newScannerReader(r io.Reader) *scannerReader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	return &scannerReader{scanner: scanner}
+}
+
+func (r *scannerReader) readLine(string) (string, error) {
+	if !r.scanner.Scan() {
+		if err := r.scanner.Err(); err != nil {
+			return "", err
+		}
+		return "", io.EOF
+	}
+	return r.scanner.Text(), nil
+}
+
+func (r *scannerReader) addHistory(string) {}
+
+func (r *scannerReader) close() error { return nil }
+
+// editorReader は端末の入力を行編集と履歴の呼び出しに対応して読む
+//
+// 履歴ファイルはshellが所有者だけが読めるように書き込むため、readlineには保存させない。
+type editorReader struct {
+	rl *readline.Instance
+}
+
+func newEditorReader() (*editorReader, error) {
+	rl, err := readline.NewEx(&readline.Config{
+		DisableAutoSaveHistory: true,
+		HistoryLimit:           maxHistory,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &editorReader{rl: rl}, nil
+}
+
+func (r *editorReader) readLine(prompt string) (string, error) {
+	r.rl.SetPrompt(prompt)
+	line, err := r.rl.Readline()
+	if errors.Is(err, readline.ErrInterrupt) {
+		return "", errInputInterrupted
+	}
+	return line, err
+}
+
+func (r *editorReader) addHistory(entry string) {
+	r.rl.SaveHistory(entry)
+}
+
+func (r *editorReader) close() error {
+	return r.rl.Close()
+}
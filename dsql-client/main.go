@@ -1,100 +1,145 @@
 package main
 
 import (
-	"database/sql"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
-	"os/exec"
+	"io"
+	"os"
 	"strings"
 	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"dsql-client/resultfmt"
+
+	"dsql-shared/buttonclick"
+	"dsql-shared/dsqlconn"
+	"dsql-shared/dsqltx"
+	"dsql-shared/migrations"
+	"dsql-shared/sqlscript"
 )
 
 const (
-	clusterID = "guabumyfv3jxv2ymjmqtbjqmjq"
-	region    = "ap-northeast-1"
-	database  = "postgres"  // DSQLのデフォルトデータベース名
-	username  = "admin"
+	database = "postgres" // DSQLのデフォルトデータベース名
+	username = "admin"
 )
 
-func generateAuthToken(hostname string) (string, error) {
-	// AWS CLIを使用してAdmin認証トークンを生成
-	cmd := exec.Command("aws", "dsql", "generate-db-connect-admin-auth-token",
-		"--hostname", hostname,
-		"--region", region,
-		"--expires-in", "3600",
-		"--output", "text")
+// clientConfig は接続先を環境変数（DSQL_ENDPOINT、DSQL_REGIONなど）から読んだ設定を返す
+//
+// 接続先のクラスターとリージョンには既定値がない。環境変数もフラグもなければValidateでエラーになる。
+func clientConfig() (dsqlconn.Config, error) {
+	return dsqlconn.FromEnv(dsqlconn.Config{
+		User:              username,
+		Database:          database,
+		MaxConns:          4,
+		MaxConnLifetime:   55 * time.Minute, // DSQLは1時間で接続を切断するため、その前に作り直す
+		MaxConnIdleTime:   10 * time.Minute,
+		HealthCheckPeriod: 1 * time.Minute,
+		TokenTTL:          15 * time.Minute,
+	}, os.Getenv)
+}
 
-	output, err := cmd.Output()
-	if err != nil {
-		return "", fmt.Errorf("failed to generate auth token: %v", err)
+// connectToDSQL はIAM認証トークンをプロセス内で生成する接続プールを作成する
+//
+// トークンは新しい接続を作るたびにBeforeConnectで取得する（期限が近づくと再生成される）ため、
+// 長時間のセッションで接続が作り直されても認証が切れない。configureでプールの設定を変更できる。
+// 接続の経過は標準エラー出力に表示する（標準出力は結果だけにしてパイプで使えるようにする）。
+func connectToDSQL(cfg dsqlconn.Config, configure ...func(*pgxpool.Config)) (*pgxpool.Pool, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, usageError(err)
 	}
 
-	return strings.TrimSpace(string(output)), nil
-}
+	fmt.Fprintf(os.Stderr, "📍 接続先: %s\n", cfg.Host())
+	fmt.Fprintf(os.Stderr, "🌍 リージョン: %s\n", cfg.Region)
+	fmt.Fprintf(os.Stderr, "💾 データベース: %s\n", cfg.Database)
 
-func connectToDSQL() (*sql.DB, error) {
-	hostname := fmt.Sprintf("%s.dsql.%s.on.aws", clusterID, region)
-
-	// 認証トークンを生成
-	fmt.Println("🔑 認証トークンを生成中...")
-	token, err := generateAuthToken(hostname)
+	fmt.Fprintln(os.Stderr, "🔌 DSQLデータベースに接続中...")
+	ctx := context.Background()
+	poolConfig, err := dsqlconn.PoolConfig(ctx, cfg)
 	if err != nil {
-		return nil, err
+		return nil, connectError(err)
+	}
+	for _, f := range configure {
+		f(poolConfig)
+	}
+	pool, err := pgxpool.NewWithConfig(ctx, poolConfig)
+	if err != nil {
+		return nil, connectError(fmt.Errorf("unable to create connection pool: %w", err))
+	}
+	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
+		return nil, connectError(fmt.Errorf("failed to ping database: %w", err))
 	}
 
-	fmt.Printf("✅ 認証トークン生成成功 (長さ: %d文字)\n", len(token))
-
-	// PostgreSQL接続文字列を構築
-	// DSQLはPostgreSQLプロトコルを使用
-	connStr := fmt.Sprintf("host=%s port=5432 dbname=%s user=%s password=%s sslmode=require",
-		hostname, database, username, token)
+	fmt.Fprintln(os.Stderr, "✅ DSQL接続成功!")
+	return pool, nil
+}
 
-	// データベース接続
-	fmt.Println("🔌 DSQLデータベースに接続中...")
-	db, err := sql.Open("postgres", connStr)
+// newFlagSet は接続先のフラグ（--endpoint、--region、--user、--databaseなど）を登録したFlagSetを返す
+//
+// 接続先は定数の既定値、環境変数、フラグの順に上書きする。usageは「使用方法」に表示する引数。
+func newFlagSet(name, usage string) (*flag.FlagSet, *dsqlconn.Config, error) {
+	cfg, err := clientConfig()
 	if err != nil {
-		return nil, fmt.Errorf("failed to open database connection: %v", err)
+		return nil, nil, usageError(err)
 	}
-
-	// 接続テスト
-	if err := db.Ping(); err != nil {
-		return nil, fmt.Errorf("failed to ping database: %v", err)
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	cfg.RegisterFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "使用方法: dsql-client %s\n\nフラグ:\n", strings.TrimSpace(name+" "+usage))
+		fs.PrintDefaults()
 	}
+	return fs, &cfg, nil
+}
 
-	fmt.Println("✅ DSQL接続成功!")
-	return db, nil
+// parseFlags はargsを解析する。誤りはflagパッケージが表示するため、表示済みの使用方法エラーにする
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return &exitError{code: exitUsage, err: err, reported: true}
+	}
+	return nil
 }
 
-func createTable(db *sql.DB) error {
-	fmt.Println("📋 テーブルを作成中...")
+// flagPassed はnameのフラグがコマンドラインで指定されたかどうかを返す
+func flagPassed(fs *flag.FlagSet, name string) bool {
+	passed := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			passed = true
+		}
+	})
+	return passed
+}
 
-	createTableSQL := `
-	CREATE TABLE IF NOT EXISTS button_clicks (
-		id BIGINT PRIMARY KEY,
-		timestamp TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
-		action VARCHAR(100),
-		user_agent TEXT,
-		ip_address VARCHAR(45),
-		created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
-	);`
+func createTable(ctx context.Context, db *pgxpool.Pool) error {
+	fmt.Println("📋 スキーマのマイグレーションを適用中...")
 
-	_, err := db.Exec(createTableSQL)
+	// button_clicksのスキーマは shared/migrations で管理する
+	all, err := migrations.All()
+	if err != nil {
+		return err
+	}
+	n, err := newMigrator(db, all).Up(ctx, 0)
 	if err != nil {
-		return fmt.Errorf("failed to create table: %v", err)
+		return fmt.Errorf("failed to migrate schema: %v", err)
 	}
 
-	fmt.Println("✅ テーブル作成成功!")
+	fmt.Printf("✅ スキーマ適用成功! (新たに適用: %d件)\n", n)
 	return nil
 }
 
-func insertSampleData(db *sql.DB) error {
+func insertSampleData(ctx context.Context, db *pgxpool.Pool) error {
 	fmt.Println("💾 サンプルデータを確認・挿入中...")
 
 	// 既存データの確認
-	var count int
-	err := db.QueryRow("SELECT COUNT(*) FROM button_clicks").Scan(&count)
+	count, err := buttonclick.NewPgxRepository(db).Count(ctx, buttonclick.Filter{})
 	if err != nil {
 		return fmt.Errorf("failed to count existing data: %v", err)
 	}
@@ -104,18 +149,21 @@ func insertSampleData(db *sql.DB) error {
 		return nil
 	}
 
-	// データが存在しない場合のみ挿入
-	insertSQL := `
-	INSERT INTO button_clicks (id, action, user_agent, ip_address) VALUES
-	($1, $2, $3, $4),
-	($5, $6, $7, $8),
-	($9, $10, $11, $12);`
-
-	_, err = db.Exec(insertSQL,
-		1, "record", "Mozilla/5.0 (Test Browser)", "192.168.1.1",
-		2, "record", "Mozilla/5.0 (Another Browser)", "192.168.1.2",
-		3, "record", "Go DSQL Client", "127.0.0.1")
-
+	// データが存在しない場合のみ、1つのトランザクションで挿入
+	samples := []buttonclick.NewClick{
+		{ID: 1, Action: "record", UserAgent: "Mozilla/5.0 (Test Browser)", IPAddress: "192.168.1.1"},
+		{ID: 2, Action: "record", UserAgent: "Mozilla/5.0 (Another Browser)", IPAddress: "192.168.1.2"},
+		{ID: 3, Action: "record", UserAgent: "Go DSQL Client", IPAddress: "127.0.0.1"},
+	}
+	_, err = dsqltx.RunInTx(ctx, db, func(tx pgx.Tx) error {
+		repo := buttonclick.NewPgxRepository(tx)
+		for _, c := range samples {
+			if _, err := repo.Insert(ctx, c); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 	if err != nil {
 		return fmt.Errorf("failed to insert sample data: %v", err)
 	}
@@ -124,142 +172,197 @@ func insertSampleData(db *sql.DB) error {
 	return nil
 }
 
-func executeQuery(db *sql.DB, query string) error {
-	fmt.Printf("📊 SQL実行中: %s\n", query)
+// querier は文を実行できるもの（*pgxpool.Pool、*pgxpool.Conn、pgx.Tx）
+type querier interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}
 
-	// SQLがSELECTかどうかを判定
-	queryLower := strings.ToLower(strings.TrimSpace(query))
-	isSelect := strings.HasPrefix(queryLower, "select")
+// inTransaction はdbがトランザクション中かどうかを返す
+func inTransaction(db querier) bool {
+	switch db := db.(type) {
+	case pgx.Tx:
+		return true
+	case *pgxpool.Conn:
+		return db.Conn().PgConn().TxStatus() != 'I'
+	}
+	return false
+}
 
-	if isSelect {
-		return executeSelectQuery(db, query)
+// executeQuery は文を実行し、返した行数（行を返さない文では影響を受けた行数）を返す
+//
+// 結果の行はformatの形式でwに書き出す。
+func executeQuery(ctx context.Context, db querier, query string, format resultfmt.Format, w io.Writer) (int64, error) {
+	fmt.Fprintf(os.Stderr, "📊 SQL実行中: %s\n", query)
+
+	// 行を返す文（SELECT、WITH ... SELECT、SHOW、INSERT ... RETURNINGなど）かどうかを判定
+	if sqlscript.Classify(query).ReturnsRows {
+		return executeSelectQuery(ctx, db, query, format, w)
 	} else {
-		return executeNonSelectQuery(db, query)
+		return executeNonSelectQuery(ctx, db, query)
 	}
 }
 
-func executeSelectQuery(db *sql.DB, query string) error {
-	rows, err := db.Query(query)
+// executeSelectQuery は結果をformatの形式でwに書き出す
+func executeSelectQuery(ctx context.Context, db querier, query string, format resultfmt.Format, w io.Writer) (int64, error) {
+	rows, err := db.Query(ctx, query)
 	if err != nil {
-		return fmt.Errorf("failed to execute query: %v", err)
+		return 0, fmt.Errorf("failed to execute query: %w", err)
 	}
 	defer rows.Close()
 
 	// カラム名を取得
-	columns, err := rows.Columns()
-	if err != nil {
-		return fmt.Errorf("failed to get columns: %v", err)
+	var columns []string
+	for _, fd := range rows.FieldDescriptions() {
+		columns = append(columns, fd.Name)
 	}
 
-	fmt.Printf("\n=== クエリ結果 ===\n")
-
-	// ヘッダーを表示
-	for i, col := range columns {
-		if i > 0 {
-			fmt.Print(" | ")
-		}
-		fmt.Printf("%-20s", col)
+	out := resultfmt.NewWriter(w, format)
+	if err := out.WriteHeader(columns); err != nil {
+		return 0, err
 	}
-	fmt.Println()
-	fmt.Println(strings.Repeat("-", len(columns)*23))
-
-	// データを表示
+	var n int64
 	for rows.Next() {
-		// 動的にスキャン用のスライスを作成
-		values := make([]interface{}, len(columns))
-		valuePtrs := make([]interface{}, len(columns))
-		for i := range values {
-			valuePtrs[i] = &values[i]
-		}
-
-		err := rows.Scan(valuePtrs...)
+		values, err := rows.Values()
 		if err != nil {
-			return fmt.Errorf("failed to scan row: %v", err)
+			return n, fmt.Errorf("failed to scan row: %v", err)
 		}
-
-		// 値を表示
-		for i, val := range values {
-			if i > 0 {
-				fmt.Print(" | ")
-			}
-
-			var displayValue string
-			if val == nil {
-				displayValue = "NULL"
-			} else {
-				switch v := val.(type) {
-				case time.Time:
-					displayValue = v.Format("2006-01-02 15:04:05")
-				case []byte:
-					displayValue = string(v)
-				default:
-					displayValue = fmt.Sprintf("%v", v)
-				}
-			}
-
-			fmt.Printf("%-20s", displayValue)
+		if err := out.WriteRow(values); err != nil {
+			return n, err
 		}
-		fmt.Println()
+		n++
 	}
 
 	if err = rows.Err(); err != nil {
-		return fmt.Errorf("error iterating rows: %v", err)
+		return n, fmt.Errorf("error iterating rows: %w", err)
 	}
-
-	fmt.Println("\n✅ クエリ実行完了!")
-	return nil
+	return n, out.Flush()
 }
 
-func executeNonSelectQuery(db *sql.DB, query string) error {
-	result, err := db.Exec(query)
-	if err != nil {
-		return fmt.Errorf("failed to execute query: %v", err)
+func executeNonSelectQuery(ctx context.Context, db querier, query string) (int64, error) {
+	// トランザクション中の競合はトランザクション全体をやり直す必要があるため、ここでは再試行しない
+	if inTransaction(db) {
+		tag, err := db.Exec(ctx, query)
+		if err != nil {
+			return 0, fmt.Errorf("failed to execute query: %w", err)
+		}
+		return tag.RowsAffected(), nil
 	}
 
-	// 影響を受けた行数を取得
-	rowsAffected, err := result.RowsAffected()
+	// DSQLの楽観的同時実行制御による競合（OC000など）は再試行する
+	var rowsAffected int64
+	attempts, err := dsqltx.Retry(ctx, func(ctx context.Context) error {
+		tag, err := db.Exec(ctx, query)
+		rowsAffected = tag.RowsAffected()
+		return err
+	})
+	if attempts > 1 {
+		fmt.Fprintf(os.Stderr, "🔁 競合のため再試行しました (試行回数: %d)\n", attempts)
+	}
 	if err != nil {
-		fmt.Println("✅ クエリ実行成功!")
-	} else {
-		fmt.Printf("✅ クエリ実行成功! (影響を受けた行数: %d)\n", rowsAffected)
+		return 0, fmt.Errorf("failed to execute query: %w", err)
 	}
 
-	return nil
+	fmt.Fprintf(os.Stderr, "✅ クエリ実行成功! (影響を受けた行数: %d)\n", rowsAffected)
+
+	return rowsAffected, nil
 }
 
-func selectAllData(db *sql.DB) error {
-	query := "SELECT id, timestamp, action, user_agent, ip_address, created_at FROM button_clicks ORDER BY created_at DESC;"
-	return executeQuery(db, query)
+// 終了コード
+const (
+	exitOK      = 0
+	exitFailure = 1 // SQLの実行エラーなど
+	exitUsage   = 2 // 引数や設定の誤り
+	exitConnect = 3 // 接続・認証の失敗
+)
+
+// exitError は終了コードを指定するエラー
+type exitError struct {
+	code int
+	err  error
+	// reported はflagパッケージが既にメッセージを表示したかどうか
+	reported bool
 }
 
-func main() {
-	fmt.Println("🚀 DSQL Go クライアント開始")
-	fmt.Printf("📍 クラスターID: %s\n", clusterID)
-	fmt.Printf("🌍 リージョン: %s\n", region)
-	fmt.Printf("💾 データベース: %s\n", database)
-	fmt.Println()
-
-	// DSQLに接続
-	db, err := connectToDSQL()
-	if err != nil {
-		log.Fatalf("❌ DSQL接続エラー: %v", err)
+func (e *exitError) Error() string { return e.err.Error() }
+func (e *exitError) Unwrap() error { return e.err }
+
+func usageError(err error) error   { return &exitError{code: exitUsage, err: err} }
+func connectError(err error) error { return &exitError{code: exitConnect, err: err} }
+
+// command はサブコマンド
+type command struct {
+	name    string
+	args    string
+	summary string
+	run     func(args []string) error
+}
+
+var commands = []command{
+	{"repl", "", "対話モードで起動（コマンドを省略した場合）", runREPL},
+	{"connect-test", "", "接続と認証を確認する", runConnectTest},
+	{"query", "SQL", "SQLを実行して結果を表示する", runQuery},
+	{"exec", "-f FILE", "SQLファイルの文を順に実行する", runExec},
+	{"schema", "init", "スキーマのマイグレーションをすべて適用する", runSchema},
+	{"migrate", "up|down|status|force", "マイグレーションを個別に操作する", runMigrate},
+	{"seed", "", "サンプルデータを挿入する（データがある場合は何もしない）", runSeed},
+	{"import", "-table TABLE -f FILE", "CSVまたはJSON Linesのファイルをテーブルに取り込む", runImport},
+	{"export", "-table TABLE -o FILE", "テーブルや問い合わせの結果をCSVまたはJSON Linesに書き出す", runExport},
+	{"port-schema", "-in FILE", "PostgreSQLのスキーマをDSQL向けに変換する", runPortSchema},
+	{"token", "[-env]", "psqlなどで使う認証トークンを表示する", runToken},
+	{"version", "", "バージョンを表示する", runVersion},
+}
+
+// printUsage はコマンドの一覧を表示する
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "使用方法: dsql-client [command] [flags] [args]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "コマンド:")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-34s %s\n", strings.TrimSpace(c.name+" "+c.args), c.summary)
 	}
-	defer db.Close()
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "接続先は --endpoint、--region、--user、--database などのフラグ（または環境変数）で指定します。")
+	fmt.Fprintln(w, "各コマンドのフラグは dsql-client <command> -h で表示します。")
+}
 
-	// テーブル作成
-	if err := createTable(db); err != nil {
-		log.Fatalf("❌ テーブル作成エラー: %v", err)
+// dispatch はargsのサブコマンドを実行する
+func dispatch(args []string) error {
+	// コマンドを省略した場合（フラグだけの場合を含む）は対話モード
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") && args[0] != "-h" && args[0] != "--help" {
+		return runREPL(args)
 	}
+	switch args[0] {
+	case "help", "-h", "--help":
+		printUsage(os.Stdout)
+		return nil
+	}
+	for _, c := range commands {
+		if c.name == args[0] {
+			return c.run(args[1:])
+		}
+	}
+	printUsage(os.Stderr)
+	return usageError(fmt.Errorf("unknown command %q", args[0]))
+}
 
-	// サンプルデータ挿入
-	if err := insertSampleData(db); err != nil {
-		log.Fatalf("❌ データ挿入エラー: %v", err)
+// run はサブコマンドを実行し、終了コードを返す
+func run(args []string) int {
+	err := dispatch(args)
+	if err == nil || errors.Is(err, flag.ErrHelp) {
+		return exitOK
 	}
 
-	// 全データを取得
-	if err := selectAllData(db); err != nil {
-		log.Fatalf("❌ データ取得エラー: %v", err)
+	var e *exitError
+	if !errors.As(err, &e) {
+		e = &exitError{code: exitFailure, err: err}
+	}
+	if !e.reported {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
 	}
+	return e.code
+}
 
-	fmt.Println("\n🎉 全ての操作が完了しました!")
+func main() {
+	os.Exit(run(os.Args[1:]))
 }
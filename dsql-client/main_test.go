@@ -0,0 +1,67 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"dsql-shared/dsqlconn"
+)
+
+// 接続先の既定値はなく、環境変数がなければValidateで失敗する
+func TestClientConfigRequiresEndpoint(t *testing.T) {
+	for _, key := range []string{
+		dsqlconn.EnvEndpoint, dsqlconn.EnvClusterID,
+		dsqlconn.EnvRegion, dsqlconn.EnvAWSRegion, dsqlconn.EnvAWSDefaultRegion,
+	} {
+		t.Setenv(key, "")
+	}
+
+	cfg, err := clientConfig()
+	if err != nil {
+		t.Fatalf("clientConfig: %v", err)
+	}
+	err = cfg.Validate()
+	if err == nil {
+		t.Fatalf("Validate succeeded without an endpoint: host %q", cfg.Host())
+	}
+	for _, want := range []string{"endpoint is required", "region is required"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not contain %q", err, want)
+		}
+	}
+
+	t.Setenv(dsqlconn.EnvEndpoint, "abc.dsql.us-east-1.on.aws")
+	t.Setenv(dsqlconn.EnvAWSRegion, "us-east-1")
+	cfg, err = clientConfig()
+	if err != nil {
+		t.Fatalf("clientConfig: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Validate with endpoint and region: %v", err)
+	}
+}
+
+func TestPsqlEnv(t *testing.T) {
+	cfg := dsqlconn.Config{Endpoint: "abc.dsql.us-east-1.on.aws", Database: "postgres", User: "admin"}
+	got := map[string]string{}
+	for _, kv := range psqlEnv(cfg, "tok'en") {
+		got[kv[0]] = kv[1]
+	}
+	want := map[string]string{
+		"PGHOST":        "abc.dsql.us-east-1.on.aws",
+		"PGPORT":        "5432",
+		"PGDATABASE":    "postgres",
+		"PGUSER":        "admin",
+		"PGSSLMODE":     "verify-full",
+		"PGSSLROOTCERT": "system",
+		"PGPASSWORD":    "tok'en",
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%s = %q, want %q", k, got[k], v)
+		}
+	}
+	if q := shellQuote("tok'en"); q != `'tok'\''en'` {
+		t.Errorf("shellQuote = %s", q)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"dsql-shared/migrations"
+)
+
+const migrateUsage = `使用方法: dsql-client migrate <command>
+
+コマンド:
+  up [-to VERSION]     未適用のマイグレーションを適用（既定は最新まで）
+  down [-steps N]      適用済みのマイグレーションを新しい順にN個戻す（既定は1）
+  status               マイグレーションの適用状況を表示
+  force VERSION        途中で失敗したバージョンのdirtyを解除し、チェックサムを記録し直す`
+
+// runMigrate はmigrateサブコマンドを実行する
+func runMigrate(args []string) error {
+	if len(args) == 0 {
+		return usageError(errors.New(migrateUsage))
+	}
+
+	fs, cfg, err := newFlagSet("migrate "+args[0], "")
+	if err != nil {
+		return err
+	}
+	to := fs.Int64("to", 0, "target version (up)")
+	steps := fs.Int("steps", 1, "number of migrations to revert (down)")
+	if err := parseFlags(fs, args[1:]); err != nil {
+		return err
+	}
+
+	var forceVersion int64
+	switch args[0] {
+	case "up", "down", "status":
+		if fs.NArg() > 0 {
+			return usageError(errors.New(migrateUsage))
+		}
+	case "force":
+		if fs.NArg() != 1 {
+			return usageError(errors.New(migrateUsage))
+		}
+		v, err := strconv.ParseInt(fs.Arg(0), 10, 64)
+		if err != nil {
+			return usageError(fmt.Errorf("invalid version %q", fs.Arg(0)))
+		}
+		forceVersion = v
+	default:
+		return usageError(errors.New(migrateUsage))
+	}
+
+	all, err := migrations.All()
+	if err != nil {
+		return err
+	}
+
+	db, err := connectToDSQL(*cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	m := newMigrator(db, all)
+
+	switch args[0] {
+	case "up":
+		n, err := m.Up(ctx, *to)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("✅ %d件のマイグレーションを適用しました\n", n)
+	case "down":
+		n, err := m.Down(ctx, *steps)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("✅ %d件のマイグレーションを戻しました\n", n)
+	case "status":
+		return printMigrationStatus(ctx, m)
+	case "force":
+		if err := m.Force(ctx, forceVersion); err != nil {
+			return err
+		}
+		fmt.Printf("✅ バージョン%dを適用済みとして記録しました\n", forceVersion)
+	}
+	return nil
+}
+
+// newMigrator はpoolを使うMigratorを返す
+func newMigrator(pool *pgxpool.Pool, all []migrations.Migration) *migrations.Migrator {
+	m := migrations.New(pool, all)
+	m.Logf = func(format string, args ...any) {
+		fmt.Printf("🛠️  "+format+"\n", args...)
+	}
+	return m
+}
+
+// printMigrationStatus はマイグレーションごとの適用状況を表示する
+func printMigrationStatus(ctx context.Context, m *migrations.Migrator) error {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%-40s %-10s %s\n", "MIGRATION", "STATE", "APPLIED AT")
+	for _, s := range statuses {
+		state, appliedAt := "pending", ""
+		if s.Applied != nil {
+			state = "applied"
+			appliedAt = s.Applied.AppliedAt.Format("2006-01-02 15:04:05")
+			switch {
+			case s.Applied.Dirty:
+				state = "dirty"
+			case s.Modified():
+				state = "modified"
+			}
+		}
+		fmt.Printf("%-40s %-10s %s\n", s.Migration, state, appliedAt)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+
+	"dsql-client/schemaport"
+)
+
+// runPortSchema はPostgreSQLのスキーマをDSQL向けに変換するport-schemaサブコマンドを実行する
+func runPortSchema(args []string) error {
+	fs := flag.NewFlagSet("port-schema", flag.ContinueOnError)
+	in := fs.String("in", "", "schema dump from pg_dump --schema-only (- for stdin)")
+	sourceURL := fs.String("source-url", "", "connection URL of the source PostgreSQL (runs pg_dump)")
+	ddlOut := fs.String("ddl", "", "write DSQL DDL to this file")
+	goOut := fs.String("go", "", "write referential-integrity checks (Go) to this file")
+	pkg := fs.String("package", "refcheck", "package name of the generated Go file")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "使用方法: dsql-client port-schema (-in dump.sql | -source-url URL) [-ddl out.sql] [-go out.go]")
+		fs.PrintDefaults()
+	}
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if (*in == "") == (*sourceURL == "") {
+		fs.Usage()
+		return usageError(errors.New("specify either -in or -source-url"))
+	}
+
+	script, err := readSchema(*in, *sourceURL)
+	if err != nil {
+		return err
+	}
+	schema := schemaport.Parse(string(script))
+
+	if *ddlOut != "" {
+		if err := writeFile(*ddlOut, schema.WriteDDL); err != nil {
+			return err
+		}
+		fmt.Printf("✅ DSQL用DDLを出力しました: %s\n", *ddlOut)
+	}
+	if *goOut != "" {
+		err := writeFile(*goOut, func(w io.Writer) error { return schema.WriteGo(w, *pkg) })
+		if err != nil {
+			return err
+		}
+		fmt.Printf("✅ 参照整合性チェックを出力しました: %s\n", *goOut)
+	}
+
+	fmt.Println("\n=== DSQL互換性レポート ===")
+	return schema.WriteReport(os.Stdout)
+}
+
+// readSchema はダンプファイル、標準入力、またはpg_dumpの出力からスキーマを読み込む
+func readSchema(in, sourceURL string) ([]byte, error) {
+	switch {
+	case sourceURL != "":
+		fmt.Println("📥 pg_dumpでスキーマを取得中...")
+		var stderr bytes.Buffer
+		cmd := exec.Command("pg_dump", "--schema-only", "--no-owner", "--no-privileges", "--dbname", sourceURL)
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if err != nil {
+			return nil, fmt.Errorf("pg_dump failed: %v: %s", err, bytes.TrimSpace(stderr.Bytes()))
+		}
+		return out, nil
+	case in == "-":
+		return io.ReadAll(os.Stdin)
+	default:
+		return os.ReadFile(in)
+	}
+}
+
+func writeFile(path string, write func(io.Writer) error) error {
+	var buf bytes.Buffer
+	if err := write(&buf); err != nil {
+		return err
+	}
+	return os.WriteFile(path, buf.Bytes(), 0o644)
+}
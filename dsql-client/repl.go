@@ -0,0 +1,523 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgconn/ctxwatch"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"dsql-client/resultfmt"
+
+	"dsql-shared/dsqltx"
+	"dsql-shared/sqlscript"
+)
+
+// historyFileName はホームディレクトリに作成する入力履歴のファイル名
+const historyFileName = ".dsql_client_history"
+
+// maxHistory は矢印キーで呼び出せる履歴の件数
+const maxHistory = 1000
+
+const replHelp = `SQLはセミコロン（;）で終わるまで複数行にわたって入力できます。
+
+メタコマンド:
+  \dt              テーブルの一覧を表示
+  \d [NAME]        テーブルの列とインデックスを表示（NAMEを省略すると\dtと同じ）
+  \timing [on|off] 実行時間の表示を切り替える
+  \x [on|off]      拡張表示（1列1行）を切り替える（-format table の場合）
+  \s               入力履歴を表示
+  \?               このヘルプを表示
+  \q               終了
+
+Ctrl-Cで実行中のクエリをキャンセルし、同じ行の残りの文は実行しません（入力中の場合は入力を破棄します）。`
+
+// shell は対話モードの状態
+type shell struct {
+	pool     *pgxpool.Pool
+	database string
+
+	// conn はトランザクション中だけ保持する接続
+	//
+	// トランザクションの外では文ごとにプールへ返し、DSQLの接続時間の上限に合わせた
+	// プールの作り直しに任せる。
+	conn *pgxpool.Conn
+
+	format   resultfmt.Format
+	timing   bool
+	expanded bool
+
+	input       lineReader
+	historyPath string
+	history     []string
+
+	mu          sync.Mutex
+	cancel      context.CancelFunc // 実行中のクエリをキャンセルする
+	interrupted bool               // Ctrl-Cが押された
+}
+
+// result は1つの文の実行結果
+type result struct {
+	columns []string
+	rows    [][]any
+	tag     pgconn.CommandTag
+}
+
+// runREPL は対話モードを実行する
+func runREPL(args []string) error {
+	home, _ := os.UserHomeDir()
+	defaultHistory := ""
+	if home != "" {
+		defaultHistory = filepath.Join(home, historyFileName)
+	}
+
+	fs, cfg, err := newFlagSet("repl", "")
+	if err != nil {
+		return err
+	}
+	historyPath := fs.String("history", defaultHistory, "history file (empty to disable)")
+	format := resultfmt.Table
+	fs.Var(&format, "format", resultfmt.Usage())
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return usageError(fmt.Errorf("unexpected argument %q", fs.Arg(0)))
+	}
+
+	// キャンセル時に接続を切断せず、サーバーにキャンセル要求を送る（応答がなければ5秒後に切断する）
+	pool, err := connectToDSQL(*cfg, func(c *pgxpool.Config) {
+		c.ConnConfig.BuildContextWatcherHandler = func(pgConn *pgconn.PgConn) ctxwatch.Handler {
+			return &pgconn.CancelRequestContextWatcherHandler{Conn: pgConn, DeadlineDelay: 5 * time.Second}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+
+	sh := &shell{pool: pool, database: cfg.Database, format: format}
+
+	interactive := false
+	if fi, err := os.Stdin.Stat(); err == nil {
+		interactive = fi.Mode()&os.ModeCharDevice != 0
+	}
+	if interactive {
+		editor, err := newEditorReader()
+		if err != nil {
+			return err
+		}
+		sh.input = editor
+		sh.historyPath = *historyPath
+		sh.loadHistory()
+		fmt.Println(`"\?" でヘルプ、"\q" で終了します。`)
+	} else {
+		sh.input = newScannerReader(os.Stdin)
+	}
+	defer sh.input.close()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	defer signal.Stop(sig)
+	go func() {
+		for range sig {
+			sh.interrupt()
+		}
+	}()
+
+	defer sh.close()
+	return sh.loop(interactive)
+}
+
+// loop は入力を1行ずつ読み、文が完結するたびに実行する
+func (sh *shell) loop(interactive bool) error {
+	var buf strings.Builder
+	for {
+		prompt := ""
+		if interactive {
+			prompt = sh.promptString(buf.Len() > 0)
+		}
+		line, err := sh.input.readLine(prompt)
+		switch {
+		case errors.Is(err, errInputInterrupted):
+			buf.Reset()
+			continue
+		case errors.Is(err, io.EOF):
+			return nil
+		case err != nil:
+			return err
+		}
+
+		// 端末でない入力の読み込み中にCtrl-Cが押された場合も入力途中の文を破棄する
+		sh.mu.Lock()
+		if sh.interrupted {
+			buf.Reset()
+			sh.interrupted = false
+		}
+		sh.mu.Unlock()
+
+		if buf.Len() == 0 && strings.HasPrefix(strings.TrimSpace(line), `\`) {
+			cmd := strings.TrimSpace(line)
+			sh.addHistory(cmd)
+			if quit := sh.meta(cmd); quit {
+				return nil
+			}
+			continue
+		}
+
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+		script := buf.String()
+		if strings.Trim(script, "; \t\r\n") == "" {
+			buf.Reset()
+			continue
+		}
+		if !sqlscript.Complete(script) {
+			continue
+		}
+		buf.Reset()
+
+		sh.addHistory(strings.TrimSpace(script))
+		sh.executeAll(sqlscript.Split(script))
+	}
+}
+
+// executeAll は文を順に実行する。Ctrl-Cが押されたら残りの文は実行しない
+func (sh *shell) executeAll(stmts []string) {
+	sh.mu.Lock()
+	sh.interrupted = false
+	sh.mu.Unlock()
+
+	for i, stmt := range stmts {
+		sh.execute(stmt)
+
+		sh.mu.Lock()
+		stop := sh.interrupted
+		sh.interrupted = false
+		sh.mu.Unlock()
+		if stop {
+			if rest := len(stmts) - i - 1; rest > 0 {
+				fmt.Printf("⏹️  残りの%d文は実行しませんでした\n", rest)
+			}
+			return
+		}
+	}
+}
+
+// promptString はトランザクションの状態を含むプロンプトを返す
+//
+// psqlと同じく、トランザクション中は「*」、エラーで中断したトランザクション中は「!」を付ける。
+func (sh *shell) promptString(continuation bool) string {
+	state := "="
+	if continuation {
+		state = "-"
+	}
+	if sh.conn != nil {
+		switch sh.conn.Conn().PgConn().TxStatus() {
+		case 'T':
+			state += "*"
+		case 'E':
+			state += "!"
+		}
+	}
+	return sh.database + state + "> "
+}
+
+// interrupt はCtrl-Cが押されたときに呼ばれる
+//
+// 実行中のクエリをキャンセルし、executeAllに残りの文を実行させない。端末からの入力中の
+// Ctrl-Cはシグナルにならず、readLineがerrInputInterruptedを返す。
+func (sh *shell) interrupt() {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	sh.interrupted = true
+	if sh.cancel != nil {
+		sh.cancel()
+	}
+}
+
+// execute は1つの文を実行して結果を表示する
+func (sh *shell) execute(stmt string, args ...any) *result {
+	ctx, cancel := context.WithCancel(context.Background())
+	sh.mu.Lock()
+	sh.cancel = cancel
+	sh.mu.Unlock()
+	defer func() {
+		sh.mu.Lock()
+		sh.cancel = nil
+		sh.mu.Unlock()
+		cancel()
+	}()
+
+	start := time.Now()
+	res, err := sh.run(ctx, stmt, args...)
+	elapsed := time.Since(start)
+
+	switch {
+	case err != nil && ctx.Err() != nil:
+		fmt.Println("⏹️  クエリをキャンセルしました")
+	case err != nil:
+		fmt.Printf("❌ エラー: %v\n", err)
+	case len(res.columns) > 0:
+		sh.printResult(res)
+	default:
+		fmt.Println(res.tag.String())
+	}
+	if sh.timing {
+		fmt.Printf("Time: %.3f ms\n", float64(elapsed.Microseconds())/1000)
+	}
+	if err != nil {
+		return nil
+	}
+	return res
+}
+
+// run は文を実行し、結果をすべて読み込む
+//
+// トランザクションの外ではOCC競合を再試行する。トランザクション中の文は、競合すると
+// トランザクション全体をやり直す必要があるため再試行しない。
+func (sh *shell) run(ctx context.Context, stmt string, args ...any) (*result, error) {
+	if sh.conn == nil {
+		conn, err := sh.pool.Acquire(ctx)
+		if err != nil {
+			return nil, err
+		}
+		sh.conn = conn
+	}
+	inTx := sh.conn.Conn().PgConn().TxStatus() != 'I'
+	defer sh.releaseIfIdle(inTx)
+
+	var res *result
+	query := func(ctx context.Context) error {
+		res = &result{}
+		rows, err := sh.conn.Query(ctx, stmt, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for _, fd := range rows.FieldDescriptions() {
+			res.columns = append(res.columns, fd.Name)
+		}
+		for rows.Next() {
+			values, err := rows.Values()
+			if err != nil {
+				return err
+			}
+			res.rows = append(res.rows, values)
+		}
+		rows.Close()
+		res.tag = rows.CommandTag()
+		return rows.Err()
+	}
+
+	if inTx {
+		return res, query(ctx)
+	}
+	attempts, err := dsqltx.Retry(ctx, query)
+	if attempts > 1 {
+		fmt.Printf("🔁 競合のため再試行しました (試行回数: %d)\n", attempts)
+	}
+	return res, err
+}
+
+// releaseIfIdle はトランザクション中でなければ接続をプールに返す
+//
+// inTxは文を実行する前にトランザクション中だったかどうか。
+func (sh *shell) releaseIfIdle(inTx bool) {
+	pgConn := sh.conn.Conn().PgConn()
+	switch {
+	case pgConn.IsClosed():
+		// キャンセル要求に応答がなかった場合やDSQLの接続時間の上限に達した場合
+		if inTx {
+			fmt.Println("⚠️  接続が切断されたため、トランザクションの変更は破棄されました")
+		}
+	case pgConn.TxStatus() != 'I':
+		return
+	}
+	sh.conn.Release()
+	sh.conn = nil
+}
+
+// close は終了時に接続を返す（トランザクション中の接続は破棄され、ロールバックされる）
+func (sh *shell) close() {
+	if sh.conn == nil {
+		return
+	}
+	if sh.conn.Conn().PgConn().TxStatus() != 'I' {
+		fmt.Println("⚠️  未完了のトランザクションをロールバックしました")
+	}
+	sh.conn.Release()
+	sh.conn = nil
+}
+
+// meta はメタコマンドを実行する。終了する場合はtrueを返す
+func (sh *shell) meta(cmd string) bool {
+	name, arg, _ := strings.Cut(cmd, " ")
+	arg = strings.TrimSpace(arg)
+
+	switch name {
+	case `\q`:
+		return true
+	case `\?`:
+		fmt.Println(replHelp)
+	case `\dt`:
+		sh.listTables()
+	case `\d`:
+		if arg == "" {
+			sh.listTables()
+		} else {
+			sh.describeTable(arg)
+		}
+	case `\timing`:
+		if err := toggle(&sh.timing, arg); err != nil {
+			fmt.Printf("❌ %v\n", err)
+			break
+		}
+		fmt.Printf("実行時間の表示: %s\n", onOff(sh.timing))
+	case `\x`:
+		if err := toggle(&sh.expanded, arg); err != nil {
+			fmt.Printf("❌ %v\n", err)
+			break
+		}
+		fmt.Printf("拡張表示: %s\n", onOff(sh.expanded))
+	case `\s`:
+		for _, h := range sh.history {
+			fmt.Println(h)
+		}
+	default:
+		fmt.Printf("❌ 不明なコマンド: %s（\\? でヘルプを表示）\n", name)
+	}
+	return false
+}
+
+func (sh *shell) listTables() {
+	sh.execute(`SELECT table_schema AS schema, table_name AS name, table_type AS type
+FROM information_schema.tables
+WHERE table_schema NOT IN ('pg_catalog', 'information_schema', 'sys')
+ORDER BY 1, 2`)
+}
+
+func (sh *shell) describeTable(name string) {
+	schema, table, ok := strings.Cut(name, ".")
+	if !ok {
+		schema, table = "public", name
+	}
+
+	res := sh.execute(`SELECT column_name AS "column", data_type AS type, is_nullable AS nullable, column_default AS "default"
+FROM information_schema.columns
+WHERE table_schema = $1 AND table_name = $2
+ORDER BY ordinal_position`, schema, table)
+	if res == nil || len(res.rows) == 0 {
+		if res != nil {
+			fmt.Printf("テーブル %q が見つかりません\n", name)
+		}
+		return
+	}
+
+	fmt.Println("インデックス:")
+	sh.execute(`SELECT indexname AS name, indexdef AS definition
+FROM pg_indexes
+WHERE schemaname = $1 AND tablename = $2
+ORDER BY 1`, schema, table)
+}
+
+// toggle はargが空なら値を反転し、on/offならその値にする
+func toggle(v *bool, arg string) error {
+	switch arg {
+	case "":
+		*v = !*v
+	case "on":
+		*v = true
+	case "off":
+		*v = false
+	default:
+		return fmt.Errorf("on または off を指定してください: %q", arg)
+	}
+	return nil
+}
+
+func onOff(v bool) string {
+	if v {
+		return "on"
+	}
+	return "off"
+}
+
+// printResult は結果を表示する（表形式で拡張表示がonの場合は1列1行で表示する）
+func (sh *shell) printResult(res *result) {
+	format := sh.format
+	if format == resultfmt.Table && sh.expanded {
+		format = resultfmt.Expanded
+	}
+	out := resultfmt.NewWriter(os.Stdout, format)
+	if err := out.WriteHeader(res.columns); err != nil {
+		fmt.Printf("❌ エラー: %v\n", err)
+		return
+	}
+	for _, row := range res.rows {
+		if err := out.WriteRow(row); err != nil {
+			fmt.Printf("❌ エラー: %v\n", err)
+			return
+		}
+	}
+	if err := out.Flush(); err != nil {
+		fmt.Printf("❌ エラー: %v\n", err)
+	}
+}
+
+// loadHistory は履歴ファイルを読み込む
+func (sh *shell) loadHistory() {
+	if sh.historyPath == "" {
+		return
+	}
+	data, err := os.ReadFile(sh.historyPath)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			fmt.Printf("⚠️  履歴ファイルを読み込めません: %v\n", err)
+		}
+		return
+	}
+	for _, line := range strings.Split(strings.TrimRight(string(data), "\n"), "\n") {
+		if line != "" {
+			sh.history = append(sh.history, line)
+			sh.input.addHistory(line)
+		}
+	}
+}
+
+// addHistory は入力を履歴に追加し、履歴ファイルに追記する
+//
+// 複数行の文は1行にまとめる。クエリに含まれる値が残るため、ファイルは所有者だけが読めるようにする。
+func (sh *shell) addHistory(entry string) {
+	lines := strings.Split(entry, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(line)
+	}
+	entry = strings.Join(lines, " ")
+	sh.input.addHistory(entry)
+	if sh.historyPath == "" {
+		return
+	}
+	sh.history = append(sh.history, entry)
+
+	f, err := os.OpenFile(sh.historyPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		fmt.Printf("⚠️  履歴ファイルに書き込めません: %v\n", err)
+		sh.historyPath = ""
+		return
+	}
+	defer f.Close()
+	fmt.Fprintln(f, entry)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"slices"
+	"testing"
+	"time"
+
+	"dsql-client/resultfmt"
+
+	"dsql-shared/testdb"
+)
+
+// fakeReader はlinesを順に返す。interruptLineの行では入力中のCtrl-Cを返す
+type fakeReader struct {
+	lines   []string
+	err     error // linesを読み終えたあとに返すエラー（nilならio.EOF）
+	prompts []string
+	history []string
+}
+
+const interruptLine = "<Ctrl-C>"
+
+func (r *fakeReader) readLine(prompt string) (string, error) {
+	r.prompts = append(r.prompts, prompt)
+	if len(r.lines) == 0 {
+		if r.err != nil {
+			return "", r.err
+		}
+		return "", io.EOF
+	}
+	line := r.lines[0]
+	r.lines = r.lines[1:]
+	if line == interruptLine {
+		return "", errInputInterrupted
+	}
+	return line, nil
+}
+
+func (r *fakeReader) addHistory(entry string) { r.history = append(r.history, entry) }
+
+func (r *fakeReader) close() error { return nil }
+
+func TestLoopDiscardsInterruptedInput(t *testing.T) {
+	in := &fakeReader{lines: []string{`\timing on`, "SELECT 1,", "  2", interruptLine, `\x`, `\q`, "SELECT 3;"}}
+	sh := &shell{database: "postgres", format: resultfmt.Table, input: in}
+
+	// 入力途中の文は破棄され、プールがなくても実行しようとしない
+	if err := sh.loop(true); err != nil {
+		t.Fatalf("loop: %v", err)
+	}
+	if !sh.timing || !sh.expanded {
+		t.Errorf("timing %v, expanded %v; want both on", sh.timing, sh.expanded)
+	}
+	wantPrompts := []string{"postgres=> ", "postgres=> ", "postgres-> ", "postgres-> ", "postgres=> ", "postgres=> "}
+	if !slices.Equal(in.prompts, wantPrompts) {
+		t.Errorf("prompts = %q, want %q", in.prompts, wantPrompts)
+	}
+	if want := []string{`\timing on`, `\x`, `\q`}; !slices.Equal(in.history, want) {
+		t.Errorf("history = %q, want %q", in.history, want)
+	}
+	if len(in.lines) != 1 {
+		t.Errorf("loop read past \\q: %q left", in.lines)
+	}
+}
+
+func TestLoopEndOfInput(t *testing.T) {
+	sh := &shell{database: "postgres", input: &fakeReader{lines: []string{`\timing`}}}
+	if err := sh.loop(false); err != nil {
+		t.Errorf("loop at EOF: %v", err)
+	}
+
+	readErr := errors.New("read failed")
+	sh = &shell{database: "postgres", input: &fakeReader{err: readErr}}
+	if err := sh.loop(false); !errors.Is(err, readErr) {
+		t.Errorf("loop error = %v, want %v", err, readErr)
+	}
+}
+
+// Ctrl-Cでキャンセルしたら同じ入力の残りの文は実行しない
+func TestExecuteAllStopsAfterInterrupt(t *testing.T) {
+	p := testdb.Pool(t, "test_dsql_client")
+	testdb.Exec(t, p, "CREATE TABLE IF NOT EXISTS repl_marker (n INT)")
+	testdb.Exec(t, p, "TRUNCATE repl_marker")
+
+	sh := &shell{pool: p, database: "postgres", format: resultfmt.Table, input: &fakeReader{}}
+	go func() {
+		for {
+			sh.mu.Lock()
+			running := sh.cancel != nil
+			sh.mu.Unlock()
+			if running {
+				sh.interrupt()
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+
+	start := time.Now()
+	sh.executeAll([]string{"SELECT pg_sleep(30)", "INSERT INTO repl_marker VALUES (1)", "INSERT INTO repl_marker VALUES (2)"})
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("executeAll took %s after the interrupt", elapsed)
+	}
+	sh.close()
+
+	var n int
+	if err := p.QueryRow(context.Background(), "SELECT count(*) FROM repl_marker").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("%d statements ran after the interrupt, want 0", n)
+	}
+}
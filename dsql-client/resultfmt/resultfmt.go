@@ -0,0 +1,173 @@
+// Package resultfmt はクエリ結果を表、CSV、TSV、JSONなどの形式で書き出す
+package resultfmt
+
+import (
+	"database/sql/driver"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+)
+
+// Format は出力形式
+type Format string
+
+const (
+	Table    Format = "table"    // 列幅をそろえた表（psqlと同じ見た目）
+	Expanded Format = "expanded" // 1列1行の表（psqlの\x）
+	CSV      Format = "csv"      // RFC 4180。NULLは空文字列
+	TSV      Format = "tsv"      // COPYのtext形式。NULLは\N、タブや改行は\t、\nにエスケープ
+	JSONL    Format = "jsonl"    // 1行1オブジェクトのJSON
+	JSON     Format = "json"     // オブジェクトの配列
+	Markdown Format = "markdown" // Markdownの表
+)
+
+// Formats は指定できる形式の一覧
+var Formats = []Format{Table, Expanded, CSV, TSV, JSONL, JSON, Markdown}
+
+// Parse は形式の名前をFormatにする
+func Parse(s string) (Format, error) {
+	for _, f := range Formats {
+		if string(f) == s {
+			return f, nil
+		}
+	}
+	return "", fmt.Errorf("unknown format %q (available: %s)", s, names())
+}
+
+func names() string {
+	var s []string
+	for _, f := range Formats {
+		s = append(s, string(f))
+	}
+	return strings.Join(s, ", ")
+}
+
+// String と Set はflag.Valueの実装（fs.Var(&format, "format", ...) で使う）
+func (f *Format) String() string { return string(*f) }
+
+func (f *Format) Set(s string) error {
+	v, err := Parse(s)
+	if err != nil {
+		return err
+	}
+	*f = v
+	return nil
+}
+
+// Usage はフラグの説明に使う形式の一覧
+func Usage() string {
+	return "output format: " + names()
+}
+
+// Writer は結果を1行ずつ書き出す
+//
+// WriteHeaderを1回呼んでからWriteRowを行の数だけ呼び、最後にFlushを呼ぶ。CSVやJSONLは
+// 行ごとに書き出すが、表形式は列幅を決めるためFlushまで行を保持する。
+type Writer interface {
+	WriteHeader(columns []string) error
+	// WriteRow はpgx.Rows.Valuesで取得した値を書き出す
+	WriteRow(values []any) error
+	Flush() error
+}
+
+// NewWriter はfの形式で書き出すWriterを返す
+func NewWriter(w io.Writer, f Format) Writer {
+	switch f {
+	case Expanded:
+		return &tableWriter{w: w, expanded: true}
+	case CSV:
+		return newCSVWriter(w, ',', "")
+	case TSV:
+		return newTSVWriter(w)
+	case JSONL:
+		return newJSONWriter(w, false)
+	case JSON:
+		return newJSONWriter(w, true)
+	case Markdown:
+		return &markdownWriter{w: w}
+	default:
+		return &tableWriter{w: w}
+	}
+}
+
+// NewCSVWriter はNULLをnullとして書き出すCSVのWriterを返す
+//
+// NewWriter(w, CSV) はNULLを空文字列にするため、空文字列と区別できない。
+func NewCSVWriter(w io.Writer, null string) Writer {
+	return newCSVWriter(w, ',', null)
+}
+
+// timeLayout は日時の表示形式（PostgreSQLのtimestamptzの出力に近い形）
+const timeLayout = "2006-01-02 15:04:05.999999Z07:00"
+
+// Text は値を文字列にする。NULLの扱いは形式ごとに異なるため、nilの場合はokがfalseになる
+func Text(v any) (s string, ok bool) {
+	switch v := v.(type) {
+	case nil:
+		return "", false
+	case string:
+		return v, true
+	case time.Time:
+		return v.Format(timeLayout), true
+	case []byte:
+		// byteaはPostgreSQLのhex形式で表示する
+		return `\x` + hex.EncodeToString(v), true
+	case [16]byte:
+		return uuidString(v), true
+	case driver.Valuer:
+		// pgtype.Numericなどは文字列に変換して表示
+		dv, err := v.Value()
+		if err != nil {
+			return fmt.Sprint(v), true
+		}
+		return Text(dv)
+	case map[string]any, []any:
+		// json、jsonbはJSONのまま表示する
+		b, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v), true
+		}
+		return string(b), true
+	default:
+		return fmt.Sprint(v), true
+	}
+}
+
+// jsonValue は値をencoding/jsonでそのまま書き出せる形にする
+func jsonValue(v any) any {
+	switch v := v.(type) {
+	case nil, bool, string, int8, int16, int32, int64, int, uint8, uint16, uint32, uint64, map[string]any, []any:
+		return v
+	case float32:
+		return jsonValue(float64(v))
+	case float64:
+		// NaNとInfinityはJSONの数値にできない
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			s, _ := Text(v)
+			return s
+		}
+		return v
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case json.Marshaler:
+		return v
+	case driver.Valuer:
+		dv, err := v.Value()
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return jsonValue(dv)
+	default:
+		s, _ := Text(v)
+		return s
+	}
+}
+
+func uuidString(u [16]byte) string {
+	h := hex.EncodeToString(u[:])
+	return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:32]
+}
@@ -0,0 +1,121 @@
+package resultfmt
+
+import (
+	"bytes"
+	"flag"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "update testdata/*.golden")
+
+var (
+	testColumns = []string{"id", "name", "note", "amount", "active", "created_at", "data", "payload"}
+	testRows    = [][]any{
+		{int64(1), "東京タワー", "複数行の\nメモ", 3.5, true, time.Date(2025, 1, 2, 3, 4, 5, 600000000, time.UTC), []byte{0xde, 0xad}, map[string]any{"b": 2.0, "a": "x"}},
+		{int64(2), "Café", nil, math.Inf(1), false, nil, nil, nil},
+		{int64(30), "tab\there", `quote " and pipe |`, -0.25, nil, time.Date(2025, 12, 31, 23, 59, 59, 0, time.FixedZone("JST", 9*60*60)), []byte{}, []any{1.0, "二"}},
+	}
+)
+
+// TestGolden は各形式の出力をtestdata/<形式>.goldenと比較する（-updateで更新する）
+func TestGolden(t *testing.T) {
+	for _, f := range Formats {
+		t.Run(string(f), func(t *testing.T) {
+			var buf bytes.Buffer
+			w := NewWriter(&buf, f)
+			if err := w.WriteHeader(testColumns); err != nil {
+				t.Fatalf("WriteHeader: %v", err)
+			}
+			for _, row := range testRows {
+				if err := w.WriteRow(row); err != nil {
+					t.Fatalf("WriteRow: %v", err)
+				}
+			}
+			if err := w.Flush(); err != nil {
+				t.Fatalf("Flush: %v", err)
+			}
+			golden(t, string(f)+".golden", buf.Bytes())
+		})
+	}
+}
+
+// TestGoldenEmpty は行がない結果の出力を確かめる
+func TestGoldenEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	for _, f := range Formats {
+		buf.WriteString("== " + string(f) + "\n")
+		w := NewWriter(&buf, f)
+		if err := w.WriteHeader([]string{"id", "名前"}); err != nil {
+			t.Fatalf("%s: WriteHeader: %v", f, err)
+		}
+		if err := w.Flush(); err != nil {
+			t.Fatalf("%s: Flush: %v", f, err)
+		}
+	}
+	golden(t, "empty.golden", buf.Bytes())
+}
+
+func golden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v (run go test -update to create it)", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("output does not match %s (run go test -update if the change is intended)\ngot:\n%s\nwant:\n%s", path, got, want)
+	}
+}
+
+func TestWidth(t *testing.T) {
+	tests := []struct {
+		s    string
+		want int
+	}{
+		{"abc", 3},
+		{"東京", 4},
+		{"ｶﾅ", 2},      // 半角カナ
+		{"ＡＢ", 4},      // 全角英字
+		{"e\u0301", 1}, // 結合文字
+		{"", 0},
+	}
+	for _, tt := range tests {
+		if got := Width(tt.s); got != tt.want {
+			t.Errorf("Width(%q) = %d, want %d", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	for _, f := range Formats {
+		if got, err := Parse(string(f)); err != nil || got != f {
+			t.Errorf("Parse(%q) = %q, %v", f, got, err)
+		}
+	}
+	if _, err := Parse("xml"); err == nil {
+		t.Error("Parse(xml) succeeded")
+	}
+}
+
+func TestNewCSVWriterNull(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewCSVWriter(&buf, `\N`)
+	w.WriteHeader([]string{"a", "b", "c"})
+	w.WriteRow([]any{nil, "", "x"})
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), "a,b,c\n\\N,,x\n"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,158 @@
+package resultfmt
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strings"
+)
+
+// csvWriter はRFC 4180のCSVで書き出す
+type csvWriter struct {
+	w      *csv.Writer
+	null   string // NULLの値として書き出す文字列
+	record []string
+}
+
+func newCSVWriter(w io.Writer, comma rune, null string) *csvWriter {
+	cw := csv.NewWriter(w)
+	cw.Comma = comma
+	return &csvWriter{w: cw, null: null}
+}
+
+func (c *csvWriter) WriteHeader(columns []string) error {
+	return c.w.Write(columns)
+}
+
+func (c *csvWriter) WriteRow(values []any) error {
+	c.record = c.record[:0]
+	for _, v := range values {
+		s, ok := Text(v)
+		if !ok {
+			s = c.null
+		}
+		c.record = append(c.record, s)
+	}
+	return c.w.Write(c.record)
+}
+
+func (c *csvWriter) Flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// tsvWriter はCOPY ... FROM のtext形式で読み込めるTSVで書き出す
+type tsvWriter struct {
+	w *bufio.Writer
+}
+
+var tsvEscaper = strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`)
+
+func newTSVWriter(w io.Writer) *tsvWriter {
+	return &tsvWriter{w: bufio.NewWriter(w)}
+}
+
+func (t *tsvWriter) WriteHeader(columns []string) error {
+	for i, c := range columns {
+		if i > 0 {
+			t.w.WriteByte('\t')
+		}
+		tsvEscaper.WriteString(t.w, c)
+	}
+	return t.w.WriteByte('\n')
+}
+
+func (t *tsvWriter) WriteRow(values []any) error {
+	for i, v := range values {
+		if i > 0 {
+			t.w.WriteByte('\t')
+		}
+		s, ok := Text(v)
+		if !ok {
+			t.w.WriteString(`\N`)
+			continue
+		}
+		tsvEscaper.WriteString(t.w, s)
+	}
+	return t.w.WriteByte('\n')
+}
+
+func (t *tsvWriter) Flush() error {
+	return t.w.Flush()
+}
+
+// jsonWriter は列名をキーにしたオブジェクトを、1行ずつ（JSONL）または配列として書き出す
+//
+// キーの順序を列の順に保つため、オブジェクトは自前で組み立てる。
+type jsonWriter struct {
+	w       *bufio.Writer
+	array   bool
+	keys    [][]byte
+	started bool
+	n       int
+}
+
+func newJSONWriter(w io.Writer, array bool) *jsonWriter {
+	return &jsonWriter{w: bufio.NewWriter(w), array: array}
+}
+
+func (j *jsonWriter) WriteHeader(columns []string) error {
+	j.keys = j.keys[:0]
+	for _, c := range columns {
+		k, err := json.Marshal(c)
+		if err != nil {
+			return err
+		}
+		j.keys = append(j.keys, k)
+	}
+	if j.array {
+		j.started = true
+		_, err := j.w.WriteString("[")
+		return err
+	}
+	return nil
+}
+
+func (j *jsonWriter) WriteRow(values []any) error {
+	if j.array {
+		if j.n > 0 {
+			j.w.WriteString(",")
+		}
+		j.w.WriteString("\n  ")
+	}
+	j.n++
+
+	j.w.WriteByte('{')
+	for i, v := range values {
+		if i > 0 {
+			j.w.WriteByte(',')
+		}
+		j.w.Write(j.keys[i])
+		j.w.WriteByte(':')
+		b, err := json.Marshal(jsonValue(v))
+		if err != nil {
+			return err
+		}
+		j.w.Write(b)
+	}
+	j.w.WriteByte('}')
+	if !j.array {
+		return j.w.WriteByte('\n')
+	}
+	return nil
+}
+
+func (j *jsonWriter) Flush() error {
+	if j.array {
+		if !j.started {
+			// 結果に列がない場合も空の配列を書く
+			j.w.WriteString("[")
+		}
+		if j.n > 0 {
+			j.w.WriteString("\n")
+		}
+		j.w.WriteString("]\n")
+	}
+	return j.w.Flush()
+}
@@ -0,0 +1,198 @@
+package resultfmt
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/width"
+)
+
+// cellEscaper は表のセルに含まれる改行やタブを1行に収める
+var cellEscaper = strings.NewReplacer("\r\n", `\n`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+
+// cell は表に表示する文字列を返す
+func cell(v any) string {
+	s, ok := Text(v)
+	if !ok {
+		return "NULL"
+	}
+	return cellEscaper.Replace(s)
+}
+
+// Width はsを端末に表示したときの幅を返す
+//
+// 東アジアの全角文字（漢字、かななど）は2、結合文字や制御文字は0として数える。
+func Width(s string) int {
+	n := 0
+	for _, r := range s {
+		n += runeWidth(r)
+	}
+	return n
+}
+
+func runeWidth(r rune) int {
+	if unicode.In(r, unicode.Mn, unicode.Me, unicode.Cf, unicode.Cc) {
+		return 0
+	}
+	switch width.LookupRune(r).Kind() {
+	case width.EastAsianWide, width.EastAsianFullwidth:
+		return 2
+	}
+	return 1
+}
+
+// pad はsの右側を空白で埋めて表示幅をwにする
+func pad(s string, w int) string {
+	return s + strings.Repeat(" ", max(w-Width(s), 0))
+}
+
+// tableWriter は列幅をそろえた表、または1列1行の表を書き出す
+type tableWriter struct {
+	w        io.Writer
+	expanded bool
+	columns  []string
+	rows     [][]string
+}
+
+func (t *tableWriter) WriteHeader(columns []string) error {
+	t.columns = columns
+	return nil
+}
+
+func (t *tableWriter) WriteRow(values []any) error {
+	row := make([]string, len(values))
+	for i, v := range values {
+		row[i] = cell(v)
+	}
+	t.rows = append(t.rows, row)
+	return nil
+}
+
+func (t *tableWriter) Flush() error {
+	var b strings.Builder
+	if t.expanded {
+		t.writeExpanded(&b)
+	} else {
+		t.writeAligned(&b)
+	}
+	if len(t.rows) == 1 {
+		b.WriteString("(1 row)\n")
+	} else {
+		fmt.Fprintf(&b, "(%d rows)\n", len(t.rows))
+	}
+	_, err := io.WriteString(t.w, b.String())
+	return err
+}
+
+func (t *tableWriter) writeAligned(b *strings.Builder) {
+	widths := columnWidths(t.columns, t.rows)
+
+	writeRow := func(values []string) {
+		for i, v := range values {
+			if i > 0 {
+				b.WriteString(" |")
+			}
+			if i == len(values)-1 {
+				// psqlと同じく最後の列は空白で埋めない
+				b.WriteString(" " + v)
+				continue
+			}
+			b.WriteString(" " + pad(v, widths[i]))
+		}
+		b.WriteString("\n")
+	}
+
+	writeRow(t.columns)
+	for i, w := range widths {
+		if i > 0 {
+			b.WriteString("+")
+		}
+		b.WriteString(strings.Repeat("-", w+2))
+	}
+	b.WriteString("\n")
+	for _, row := range t.rows {
+		writeRow(row)
+	}
+}
+
+func (t *tableWriter) writeExpanded(b *strings.Builder) {
+	w := 0
+	for _, col := range t.columns {
+		w = max(w, Width(col))
+	}
+	for n, row := range t.rows {
+		fmt.Fprintf(b, "-[ RECORD %d ]%s\n", n+1, strings.Repeat("-", w))
+		for i, v := range row {
+			fmt.Fprintf(b, "%s | %s\n", pad(t.columns[i], w), v)
+		}
+	}
+}
+
+// columnWidths は見出しと各行の値の表示幅の最大値を返す
+func columnWidths(columns []string, rows [][]string) []int {
+	widths := make([]int, len(columns))
+	for i, col := range columns {
+		widths[i] = Width(col)
+	}
+	for _, row := range rows {
+		for i, v := range row {
+			widths[i] = max(widths[i], Width(v))
+		}
+	}
+	return widths
+}
+
+// markdownWriter はMarkdown（GitHub Flavored Markdown）の表を書き出す
+type markdownWriter struct {
+	w       io.Writer
+	columns []string
+	rows    [][]string
+}
+
+var markdownEscaper = strings.NewReplacer(`|`, `\|`)
+
+func (m *markdownWriter) WriteHeader(columns []string) error {
+	for _, c := range columns {
+		m.columns = append(m.columns, markdownEscaper.Replace(cellEscaper.Replace(c)))
+	}
+	return nil
+}
+
+func (m *markdownWriter) WriteRow(values []any) error {
+	row := make([]string, len(values))
+	for i, v := range values {
+		row[i] = markdownEscaper.Replace(cell(v))
+	}
+	m.rows = append(m.rows, row)
+	return nil
+}
+
+func (m *markdownWriter) Flush() error {
+	widths := columnWidths(m.columns, m.rows)
+	for i := range widths {
+		widths[i] = max(widths[i], 3) // 区切り行の「---」
+	}
+
+	var b strings.Builder
+	writeRow := func(values []string) {
+		b.WriteString("|")
+		for i, v := range values {
+			b.WriteString(" " + pad(v, widths[i]) + " |")
+		}
+		b.WriteString("\n")
+	}
+
+	writeRow(m.columns)
+	b.WriteString("|")
+	for _, w := range widths {
+		b.WriteString(" " + strings.Repeat("-", w) + " |")
+	}
+	b.WriteString("\n")
+	for _, row := range m.rows {
+		writeRow(row)
+	}
+	_, err := io.WriteString(m.w, b.String())
+	return err
+}
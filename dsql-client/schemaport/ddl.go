@@ -0,0 +1,98 @@
+package schemaport
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// WriteDDL はDSQLで実行できるDDLを書き出す
+//
+// 出力は1文ずつ実行する前提で、BEGIN/COMMITを含まない（dsql-shared/migrations の
+// マイグレーションファイルとしてそのまま使える）。外部キーはコメントとして残す。
+func (s *Schema) WriteDDL(w io.Writer) error {
+	var b strings.Builder
+
+	b.WriteString("-- Generated by dsql-client port-schema\n")
+	if fks := s.ForeignKeys(); len(fks) > 0 {
+		b.WriteString("--\n-- 外部キーはDSQLで使えないため、生成したGoの参照整合性チェックで検証する:\n")
+		for _, fk := range fks {
+			fmt.Fprintf(&b, "--   %s\n", fk)
+		}
+	}
+
+	for _, name := range s.Schemas {
+		fmt.Fprintf(&b, "\nCREATE SCHEMA IF NOT EXISTS %s;\n", quoteIdent(name))
+	}
+
+	for _, t := range s.Tables {
+		var defs []string
+		for _, c := range t.Columns {
+			def := quoteIdent(c.Name) + " " + c.Type
+			if c.Default != "" {
+				def += " DEFAULT " + c.Default
+			}
+			if c.NotNull {
+				def += " NOT NULL"
+			}
+			defs = append(defs, def)
+		}
+		if len(t.PrimaryKey) > 0 {
+			defs = append(defs, "PRIMARY KEY ("+quoteIdents(t.PrimaryKey)+")")
+		}
+		for _, u := range t.Uniques {
+			defs = append(defs, "UNIQUE ("+quoteIdents(u)+")")
+		}
+		for _, check := range t.Checks {
+			defs = append(defs, "CHECK "+check)
+		}
+		fmt.Fprintf(&b, "\nCREATE TABLE IF NOT EXISTS %s (\n\t%s\n);\n", quoteIdent(t.Name), strings.Join(defs, ",\n\t"))
+	}
+
+	if len(s.Indexes) > 0 {
+		b.WriteString("\n")
+	}
+	for _, idx := range s.Indexes {
+		unique := ""
+		if idx.Unique {
+			unique = "UNIQUE "
+		}
+		name := ""
+		if idx.Name != "" {
+			name = "IF NOT EXISTS " + quoteIdent(idx.Name) + " "
+		}
+		fmt.Fprintf(&b, "CREATE %sINDEX ASYNC %sON %s %s;\n", unique, name, quoteIdent(idx.Table), idx.Body)
+	}
+
+	for _, stmt := range s.Statements {
+		fmt.Fprintf(&b, "\n%s;\n", stmt)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteReport は互換性のない構文の一覧を書き出す
+func (s *Schema) WriteReport(w io.Writer) error {
+	var errors, warnings int
+	for _, i := range s.Issues {
+		if i.Severity == SeverityError {
+			errors++
+		} else {
+			warnings++
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "tables: %d, indexes: %d, foreign keys: %d\n", len(s.Tables), len(s.Indexes), len(s.ForeignKeys()))
+	fmt.Fprintf(&b, "issues: %d errors, %d warnings\n", errors, warnings)
+	for _, sev := range []Severity{SeverityError, SeverityWarning} {
+		for _, i := range s.Issues {
+			if i.Severity == sev {
+				fmt.Fprintf(&b, "  %s\n", i)
+			}
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,123 @@
+package schemaport
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io"
+	"strings"
+	"text/template"
+)
+
+// goTable と goRelation は生成する refint.Table と refint.Relation
+type goTable struct{ Name, PrimaryKey string }
+
+type goRelation struct {
+	Child, Column, Parent, ParentColumn string
+	OnDelete                            string
+}
+
+var goTemplate = template.Must(template.New("refint").Parse(`// Code generated by dsql-client port-schema; DO NOT EDIT.
+
+// Package {{.Package}} は移行元の外部キーに対応する参照整合性の定義
+//
+// DSQLは外部キーを使えないため、行の挿入・更新・削除は Schema の Insert・Update・Delete
+// （またはCheckInsert・CheckUpdate・BeforeDelete）を同じトランザクション内で呼び出して行う。
+package {{.Package}}
+
+import "dsql-shared/refint"
+
+// Schema は移行元のスキーマの外部キーから生成した参照整合性の定義
+var Schema = refint.MustNew(
+	[]refint.Table{
+{{- range .Tables}}
+		{Name: {{printf "%q" .Name}}{{if .PrimaryKey}}, PrimaryKey: {{printf "%q" .PrimaryKey}}{{end}}},
+{{- end}}
+	},
+	[]refint.Relation{
+{{- range .Relations}}
+		{Child: {{printf "%q" .Child}}, Column: {{printf "%q" .Column}}, Parent: {{printf "%q" .Parent}}, ParentColumn: {{printf "%q" .ParentColumn}}, OnDelete: refint.{{.OnDelete}}},
+{{- end}}
+	},
+)
+`))
+
+// onDeleteActions は ON DELETE の動作に対応する refint の定数名
+var onDeleteActions = map[string]string{
+	"NO ACTION":   "Restrict",
+	"RESTRICT":    "Restrict",
+	"CASCADE":     "Cascade",
+	"SET NULL":    "SetNull",
+	"SET DEFAULT": "SetDefault",
+}
+
+// WriteGo は外部キーの代わりに使う参照整合性の定義（dsql-shared/refint）のGoコードを書き出す
+//
+// 単一列の外部キーだけを対象にする。複合キーなど生成できないものはParseの時点でIssueとして報告している。
+func (s *Schema) WriteGo(w io.Writer, pkg string) error {
+	data := struct {
+		Package   string
+		Tables    []goTable
+		Relations []goRelation
+	}{Package: pkg}
+
+	used := map[string]bool{}
+	for _, fk := range s.ForeignKeys() {
+		if !s.supported(fk) {
+			continue
+		}
+		used[fk.Table], used[fk.RefTable] = true, true
+		data.Relations = append(data.Relations, goRelation{
+			Child:        fk.Table,
+			Column:       fk.Columns[0],
+			Parent:       fk.RefTable,
+			ParentColumn: fk.RefColumns[0],
+			OnDelete:     onDeleteActions[fk.OnDelete],
+		})
+	}
+	for _, t := range s.Tables {
+		if !used[t.Name] {
+			continue
+		}
+		gt := goTable{Name: t.Name}
+		if len(t.PrimaryKey) == 1 {
+			gt.PrimaryKey = t.PrimaryKey[0]
+		}
+		data.Tables = append(data.Tables, gt)
+	}
+
+	var buf bytes.Buffer
+	if err := goTemplate.Execute(&buf, data); err != nil {
+		return err
+	}
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("failed to format generated code: %w", err)
+	}
+	_, err = w.Write(src)
+	return err
+}
+
+// supported はfkのチェックを生成できるかどうかを返す
+func (s *Schema) supported(fk ForeignKey) bool {
+	parent := s.tables[fk.RefTable]
+	return len(fk.Columns) == 1 && len(fk.RefColumns) == 1 && parent != nil && len(parent.PrimaryKey) == 1 &&
+		onDeleteActions[fk.OnDelete] != ""
+}
+
+// checkForeignKeys は参照整合性の定義を生成できない外部キーを報告する
+func (s *Schema) checkForeignKeys() {
+	for _, fk := range s.ForeignKeys() {
+		object := fk.Table + "." + strings.Join(fk.Columns, ",")
+		switch {
+		case len(fk.Columns) != 1 || len(fk.RefColumns) != 1:
+			s.issue(SeverityError, "foreign_key", object, "composite foreign keys are not generated; write the checks by hand")
+		case s.tables[fk.RefTable] == nil:
+			s.issue(SeverityError, "foreign_key", object, "referenced table %s is not in the schema", fk.RefTable)
+		case len(s.tables[fk.RefTable].PrimaryKey) != 1:
+			s.issue(SeverityError, "foreign_key", object, "not generated because %s has no single-column primary key", fk.RefTable)
+		case onDeleteActions[fk.OnDelete] == "":
+			s.issue(SeverityError, "foreign_key", object, "ON DELETE %s is not supported", fk.OnDelete)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package schemaport
+
+import (
+	"slices"
+	"strings"
+)
+
+// fields はsを空白で区切る。括弧の中と引用符の中の空白では区切らない
+func fields(s string) []string {
+	var out []string
+	var cur strings.Builder
+	depth := 0
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case depth == 0 && (c == ' ' || c == '\t' || c == '\n' || c == '\r'):
+			if cur.Len() > 0 {
+				out = append(out, cur.String())
+				cur.Reset()
+			}
+			continue
+		}
+		cur.WriteByte(c)
+	}
+	if cur.Len() > 0 {
+		out = append(out, cur.String())
+	}
+	return out
+}
+
+// splitTopLevel はsをsepで区切る。括弧の中と引用符の中のsepでは区切らない
+func splitTopLevel(s string, sep byte) []string {
+	var out []string
+	depth, start := 0, 0
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == sep && depth == 0:
+			out = append(out, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(out, s[start:])
+}
+
+// unwrap は外側の括弧を1組取り除く
+func unwrap(s string) string {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "(") && strings.HasSuffix(s, ")") {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+// keywordList は fields の結果を大文字にしたもの（引用符を含むトークンはそのまま）
+type keywordList []string
+
+func keywords(tok []string) keywordList {
+	kw := make(keywordList, len(tok))
+	for i, t := range tok {
+		if strings.ContainsAny(t, `'"`) {
+			kw[i] = t
+		} else {
+			kw[i] = strings.ToUpper(t)
+		}
+	}
+	return kw
+}
+
+// has はi番目から words が続いているかどうかを返す
+func (kw keywordList) has(i int, words ...string) bool {
+	if i < 0 || i+len(words) > len(kw) {
+		return false
+	}
+	return slices.Equal(kw[i:i+len(words)], words)
+}
+
+func (kw keywordList) index(word string) int {
+	return slices.Index(kw, word)
+}
+
+// after は words のうち後ろに指定したものから順に探し、最初に見つかった語の次のトークンを返す
+func (kw keywordList) after(words ...string) string {
+	for _, w := range slices.Backward(words) {
+		if i := kw.index(w); i >= 0 && i+1 < len(kw) {
+			return kw[i+1]
+		}
+	}
+	return ""
+}
+
+// createTable は CREATE [TEMP|UNLOGGED] TABLE の TABLE の1つ前の位置を返す
+func (kw keywordList) createTable() int {
+	switch {
+	case kw.has(0, "CREATE", "TABLE"):
+		return 0
+	case len(kw) > 2 && kw[0] == "CREATE" && kw[2] == "TABLE" &&
+		slices.Contains([]string{"TEMP", "TEMPORARY", "UNLOGGED", "GLOBAL", "LOCAL"}, kw[1]):
+		return 1
+	}
+	return -1
+}
+
+// ident は識別子を正規化する
+//
+// 引用符のない部分は小文字にし、引用符は外す。public スキーマの修飾は取り除く。
+func ident(s string) string {
+	s = strings.TrimSuffix(strings.TrimSpace(s), ";")
+	var parts []string
+	for _, p := range splitTopLevel(s, '.') {
+		if strings.HasPrefix(p, `"`) && strings.HasSuffix(p, `"`) && len(p) >= 2 {
+			parts = append(parts, strings.ReplaceAll(p[1:len(p)-1], `""`, `"`))
+		} else {
+			parts = append(parts, strings.ToLower(p))
+		}
+	}
+	if len(parts) > 1 && parts[0] == "public" {
+		parts = parts[1:]
+	}
+	return strings.Join(parts, ".")
+}
+
+// identList は "(a, b)" 形式の列リストを正規化する
+func identList(s string) []string {
+	var out []string
+	for _, p := range splitTopLevel(unwrap(s), ',') {
+		out = append(out, ident(p))
+	}
+	return out
+}
+
+// reserved は引用符で囲む必要があるPostgreSQLの予約語
+var reserved = []string{
+	"all", "analyse", "analyze", "and", "any", "array", "as", "asc", "asymmetric", "both", "case", "cast",
+	"check", "collate", "column", "constraint", "create", "current_date", "current_role", "current_time",
+	"current_timestamp", "current_user", "default", "deferrable", "desc", "distinct", "do", "else", "end",
+	"except", "false", "fetch", "for", "foreign", "from", "grant", "group", "having", "in", "initially",
+	"intersect", "into", "lateral", "leading", "limit", "localtime", "localtimestamp", "not", "null",
+	"offset", "on", "only", "or", "order", "placing", "primary", "references", "returning", "select",
+	"session_user", "some", "symmetric", "table", "then", "to", "trailing", "true", "union", "unique",
+	"user", "using", "variadic", "when", "where", "window", "with",
+}
+
+// quoteIdent は必要な場合だけ識別子を引用符で囲む
+func quoteIdent(name string) string {
+	parts := strings.Split(name, ".")
+	for i, p := range parts {
+		if needsQuote(p) {
+			parts[i] = `"` + strings.ReplaceAll(p, `"`, `""`) + `"`
+		}
+	}
+	return strings.Join(parts, ".")
+}
+
+func needsQuote(s string) bool {
+	if s == "" || slices.Contains(reserved, s) {
+		return true
+	}
+	for i, c := range s {
+		switch {
+		case c == '_', c >= 'a' && c <= 'z':
+		case i > 0 && (c >= '0' && c <= '9' || c == '$'):
+		default:
+			return true
+		}
+	}
+	return false
+}
+
+func quoteIdents(names []string) string {
+	quoted := make([]string, len(names))
+	for i, n := range names {
+		quoted[i] = quoteIdent(n)
+	}
+	return strings.Join(quoted, ", ")
+}
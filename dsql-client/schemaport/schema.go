@@ -0,0 +1,579 @@
+// Package schemaport はPostgreSQLのスキーマ（pg_dump --schema-only の出力）を解析し、
+// Aurora DSQLで使えない機能を報告して、DSQL用のDDLと参照整合性チェックのGoコードを生成する
+package schemaport
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"dsql-shared/sqlscript"
+)
+
+// Severity は問題の重大度
+type Severity string
+
+const (
+	// SeverityError はDSQLに変換できず、アプリケーション側の対応が必要
+	SeverityError Severity = "error"
+	// SeverityWarning は変換したが動作が変わる
+	SeverityWarning Severity = "warning"
+)
+
+// Issue はDSQLと互換性のない構文1つ分の報告
+type Issue struct {
+	Severity Severity
+	Kind     string // foreign_key, sequence, trigger, extension, type など
+	Object   string
+	Message  string
+}
+
+func (i Issue) String() string {
+	return fmt.Sprintf("[%s] %s %s: %s", i.Severity, i.Kind, i.Object, i.Message)
+}
+
+// Column はテーブルの列（DSQL向けに変換済み）
+type Column struct {
+	Name    string
+	Type    string
+	NotNull bool
+	Default string
+}
+
+// ForeignKey は外部キー制約
+type ForeignKey struct {
+	Name       string
+	Table      string
+	Columns    []string
+	RefTable   string
+	RefColumns []string
+	OnDelete   string // NO ACTION, RESTRICT, CASCADE, SET NULL, SET DEFAULT
+	OnUpdate   string
+}
+
+func (fk ForeignKey) String() string {
+	return fmt.Sprintf("%s(%s) -> %s(%s) ON DELETE %s",
+		fk.Table, strings.Join(fk.Columns, ", "), fk.RefTable, strings.Join(fk.RefColumns, ", "), fk.OnDelete)
+}
+
+// Table はテーブル定義
+type Table struct {
+	Name        string
+	Columns     []*Column
+	PrimaryKey  []string
+	Uniques     [][]string
+	Checks      []string
+	ForeignKeys []ForeignKey
+}
+
+// Column は名前が一致する列を返す
+func (t *Table) Column(name string) *Column {
+	for _, c := range t.Columns {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// Index はインデックス定義
+type Index struct {
+	Name   string
+	Table  string
+	Unique bool
+	Body   string // 列リスト以降（INCLUDE、WHEREを含む）
+}
+
+// Schema は解析したスキーマ
+type Schema struct {
+	Schemas []string
+	Tables  []*Table
+	Indexes []Index
+	// Statements はそのままDSQLで実行できるビュー・SQL関数の定義（出現順）
+	Statements []string
+	Issues     []Issue
+
+	tables    map[string]*Table
+	enums     map[string][]string
+	sequences map[string]bool
+}
+
+// Table は名前が一致するテーブルを返す
+func (s *Schema) Table(name string) *Table {
+	return s.tables[name]
+}
+
+// ForeignKeys は全テーブルの外部キーを返す
+func (s *Schema) ForeignKeys() []ForeignKey {
+	var fks []ForeignKey
+	for _, t := range s.Tables {
+		fks = append(fks, t.ForeignKeys...)
+	}
+	return fks
+}
+
+func (s *Schema) issue(sev Severity, kind, object, format string, args ...any) {
+	s.Issues = append(s.Issues, Issue{Severity: sev, Kind: kind, Object: object, Message: fmt.Sprintf(format, args...)})
+}
+
+// Parse はpg_dump --schema-only の出力を解析する
+//
+// 解析できない文はIssueとして報告し、処理を続ける。
+func Parse(script string) *Schema {
+	s := &Schema{
+		tables:    map[string]*Table{},
+		enums:     map[string][]string{},
+		sequences: map[string]bool{},
+	}
+	for _, stmt := range sqlscript.Split(script) {
+		s.parseStatement(stmt)
+	}
+	s.convert()
+	return s
+}
+
+func (s *Schema) parseStatement(stmt string) {
+	tok := fields(stmt)
+	kw := keywords(tok)
+	object := summary(stmt)
+
+	switch {
+	case kw.has(0, "SET"), kw.has(0, "SELECT"), kw.has(0, "COMMENT"), kw.has(0, "GRANT"), kw.has(0, "REVOKE"),
+		kw.has(0, "ALTER", "DEFAULT", "PRIVILEGES"), kw.has(0, "ALTER", "SEQUENCE"):
+		// 設定・コメント・権限・シーケンスの値は移行対象外
+	case kw.has(0, "ALTER") && kw.index("OWNER") >= 0:
+	case kw.has(0, "CREATE", "SCHEMA"):
+		if name := ident(kw.after("SCHEMA", "EXISTS")); name != "" && name != "public" {
+			s.Schemas = append(s.Schemas, name)
+		}
+	case kw.has(0, "CREATE", "EXTENSION"):
+		s.issue(SeverityError, "extension", ident(kw.after("EXTENSION", "EXISTS")), "extensions are not supported")
+	case kw.has(0, "CREATE", "TYPE"):
+		s.parseType(tok, kw)
+	case kw.has(0, "CREATE", "DOMAIN"):
+		s.issue(SeverityError, "type", ident(tok[2]), "domains are not supported")
+	case kw.has(0, "CREATE", "SEQUENCE"):
+		s.sequences[ident(kw.after("SEQUENCE", "EXISTS"))] = false
+	case kw.createTable() >= 0:
+		s.parseTable(tok, kw, kw.createTable())
+	case kw.has(0, "ALTER", "TABLE"):
+		s.parseAlterTable(tok, kw, stmt)
+	case kw.has(0, "CREATE", "INDEX"), kw.has(0, "CREATE", "UNIQUE", "INDEX"):
+		s.parseIndex(tok, kw)
+	case kw.has(0, "CREATE", "TRIGGER"), kw.has(0, "CREATE", "CONSTRAINT", "TRIGGER"), kw.has(0, "CREATE", "EVENT", "TRIGGER"):
+		s.issue(SeverityError, "trigger", object, "triggers are not supported; move the logic into the application")
+	case kw.has(0, "CREATE", "FUNCTION"), kw.has(0, "CREATE", "OR", "REPLACE", "FUNCTION"),
+		kw.has(0, "CREATE", "PROCEDURE"), kw.has(0, "CREATE", "OR", "REPLACE", "PROCEDURE"):
+		lang := strings.ToLower(kw.after("LANGUAGE"))
+		if lang == "sql" {
+			s.Statements = append(s.Statements, stmt)
+		} else {
+			s.issue(SeverityError, "function", object, "LANGUAGE %s is not supported; only SQL functions can be created", lang)
+		}
+	case kw.has(0, "CREATE", "VIEW"), kw.has(0, "CREATE", "OR", "REPLACE", "VIEW"):
+		s.Statements = append(s.Statements, stmt)
+		s.issue(SeverityWarning, "view", object, "copied as is; check that it does not use removed types or functions")
+	case kw.has(0, "CREATE", "MATERIALIZED", "VIEW"):
+		s.issue(SeverityError, "view", object, "materialized views are not supported")
+	case kw.has(0, "CREATE", "POLICY"):
+		s.issue(SeverityError, "policy", object, "row level security is not supported")
+	default:
+		s.issue(SeverityWarning, "unknown", object, "statement was not converted")
+	}
+}
+
+func (s *Schema) parseType(tok []string, kw keywordList) {
+	name := ident(tok[2])
+	if !kw.has(3, "AS", "ENUM") || len(tok) < 6 {
+		s.issue(SeverityError, "type", name, "user-defined types are not supported")
+		return
+	}
+	var labels []string
+	for _, l := range splitTopLevel(unwrap(tok[5]), ',') {
+		labels = append(labels, strings.TrimSpace(l))
+	}
+	s.enums[name] = labels
+}
+
+func (s *Schema) parseTable(tok []string, kw keywordList, start int) {
+	i := start + 2
+	if kw.has(i, "IF", "NOT", "EXISTS") {
+		i += 3
+	}
+	if i >= len(tok) {
+		return
+	}
+
+	// pg_dump は "CREATE TABLE public.t (" のように名前と括弧の間に空白を入れる
+	name, body, _ := strings.Cut(tok[i], "(")
+	rest := kw[i+1:]
+	if body != "" {
+		body = "(" + body
+	} else if i+1 < len(tok) {
+		body = tok[i+1]
+		rest = kw[i+2:]
+	}
+	name = ident(name)
+
+	if kw.has(1, "TEMP") || kw.has(1, "TEMPORARY") || kw.has(1, "UNLOGGED") {
+		s.issue(SeverityWarning, "table", name, "%s tables are not supported; created as a regular table", kw[1])
+	}
+	if slices.Contains(rest, "PARTITION") || slices.Contains(rest, "INHERITS") || strings.EqualFold(body, "PARTITION") {
+		s.issue(SeverityError, "table", name, "partitioning and inheritance are not supported")
+		if strings.EqualFold(body, "PARTITION") {
+			return
+		}
+	}
+
+	t := &Table{Name: name}
+	s.Tables = append(s.Tables, t)
+	s.tables[name] = t
+
+	for _, def := range splitTopLevel(unwrap(body), ',') {
+		def = strings.TrimSpace(def)
+		if def == "" {
+			continue
+		}
+		dtok := fields(def)
+		dkw := keywords(dtok)
+		if dkw.has(0, "CONSTRAINT") || dkw.has(0, "PRIMARY") || dkw.has(0, "UNIQUE") || dkw.has(0, "FOREIGN") ||
+			dkw.has(0, "CHECK") || dkw.has(0, "EXCLUDE") {
+			s.parseTableConstraint(t, dtok, dkw)
+			continue
+		}
+		s.parseColumn(t, dtok, dkw)
+	}
+}
+
+// columnKeywords は列の型の後に続く句の始まり
+var columnKeywords = []string{"NOT", "NULL", "DEFAULT", "CONSTRAINT", "PRIMARY", "UNIQUE", "REFERENCES", "CHECK", "COLLATE", "GENERATED"}
+
+func (s *Schema) parseColumn(t *Table, tok []string, kw keywordList) {
+	c := &Column{Name: ident(tok[0])}
+	t.Columns = append(t.Columns, c)
+	object := t.Name + "." + c.Name
+
+	i := 1
+	var typ []string
+	for ; i < len(tok) && !slices.Contains(columnKeywords, kw[i]); i++ {
+		typ = append(typ, tok[i])
+	}
+	c.Type = strings.Join(typ, " ")
+
+	for i < len(tok) {
+		switch {
+		case kw.has(i, "NOT", "NULL"):
+			c.NotNull = true
+			i += 2
+		case kw.has(i, "NULL"):
+			i++
+		case kw.has(i, "CONSTRAINT"):
+			i += 2
+		case kw.has(i, "DEFAULT"):
+			j := i + 1
+			for j < len(tok) && !slices.Contains(columnKeywords, kw[j]) {
+				j++
+			}
+			c.Default = strings.Join(tok[i+1:j], " ")
+			i = j
+		case kw.has(i, "PRIMARY", "KEY"):
+			t.PrimaryKey = []string{c.Name}
+			i += 2
+		case kw.has(i, "UNIQUE"):
+			t.Uniques = append(t.Uniques, []string{c.Name})
+			i++
+		case kw.has(i, "CHECK"):
+			if i+1 < len(tok) {
+				t.Checks = append(t.Checks, tok[i+1])
+			}
+			i += 2
+		case kw.has(i, "REFERENCES"):
+			fk := ForeignKey{Table: t.Name, Columns: []string{c.Name}}
+			i = parseReferences(&fk, tok, kw, i)
+			t.ForeignKeys = append(t.ForeignKeys, fk)
+		case kw.has(i, "COLLATE"):
+			s.issue(SeverityWarning, "collation", object, "COLLATE %s removed; DSQL only supports the C collation", tok[i+1])
+			i += 2
+		case kw.has(i, "GENERATED"):
+			j := i + 1
+			for j < len(tok) && !slices.Contains(columnKeywords, kw[j]) {
+				j++
+			}
+			if slices.Contains(kw[i:j], "IDENTITY") {
+				s.issue(SeverityWarning, "sequence", object, "identity column removed; assign IDs in the application (dsql-shared/idgen)")
+			} else {
+				s.issue(SeverityError, "column", object, "generated column expression removed; compute the value in the application")
+			}
+			i = j
+		default:
+			s.issue(SeverityWarning, "column", object, "ignored %q in column definition", tok[i])
+			i++
+		}
+	}
+}
+
+// parseReferences はREFERENCES句を解析し、次のトークンの位置を返す
+func parseReferences(fk *ForeignKey, tok []string, kw keywordList, i int) int {
+	i++ // REFERENCES
+	if i < len(tok) {
+		ref, cols, _ := strings.Cut(tok[i], "(")
+		fk.RefTable = ident(ref)
+		if cols != "" {
+			fk.RefColumns = identList("(" + cols)
+		} else if i+1 < len(tok) && strings.HasPrefix(tok[i+1], "(") {
+			i++
+			fk.RefColumns = identList(tok[i])
+		}
+		i++
+	}
+	fk.OnDelete, fk.OnUpdate = "NO ACTION", "NO ACTION"
+	for i < len(tok) {
+		switch {
+		case (kw.has(i, "ON", "DELETE") || kw.has(i, "ON", "UPDATE")) && i+2 < len(kw):
+			action := kw[i+2]
+			n := 3
+			if (action == "SET" || action == "NO") && i+3 < len(kw) {
+				action += " " + kw[i+3]
+				n = 4
+			}
+			if kw[i+1] == "DELETE" {
+				fk.OnDelete = action
+			} else {
+				fk.OnUpdate = action
+			}
+			i += n
+		case kw.has(i, "MATCH"):
+			i += 2
+		case kw.has(i, "NOT", "DEFERRABLE"), kw.has(i, "INITIALLY"), kw.has(i, "NOT", "VALID"):
+			i += 2
+		case kw.has(i, "DEFERRABLE"):
+			i++
+		default:
+			return i
+		}
+	}
+	return i
+}
+
+func (s *Schema) parseTableConstraint(t *Table, tok []string, kw keywordList) {
+	i := 0
+	name := ""
+	if kw.has(0, "CONSTRAINT") && len(tok) > 1 {
+		name = ident(tok[1])
+		i = 2
+	}
+	switch {
+	case kw.has(i, "PRIMARY", "KEY") && i+2 < len(tok):
+		t.PrimaryKey = identList(tok[i+2])
+	case kw.has(i, "UNIQUE") && i+1 < len(tok):
+		j := i + 1
+		if kw.has(j, "NULLS") {
+			j += 3
+		}
+		if j < len(tok) {
+			t.Uniques = append(t.Uniques, identList(tok[j]))
+		}
+	case kw.has(i, "CHECK") && i+1 < len(tok):
+		check := tok[i+1]
+		if kw.has(len(tok)-2, "NOT", "VALID") {
+			s.issue(SeverityWarning, "constraint", t.Name+"."+name, "NOT VALID check constraint will validate existing rows")
+		}
+		t.Checks = append(t.Checks, check)
+	case kw.has(i, "FOREIGN", "KEY") && i+2 < len(tok):
+		fk := ForeignKey{Name: name, Table: t.Name, Columns: identList(tok[i+2])}
+		parseReferences(&fk, tok, kw, i+3)
+		t.ForeignKeys = append(t.ForeignKeys, fk)
+	case kw.has(i, "EXCLUDE"):
+		s.issue(SeverityError, "constraint", t.Name+"."+name, "exclusion constraints are not supported")
+	default:
+		s.issue(SeverityWarning, "constraint", t.Name+"."+name, "constraint was not converted")
+	}
+}
+
+func (s *Schema) parseAlterTable(tok []string, kw keywordList, stmt string) {
+	i := 2
+	for kw.has(i, "ONLY") || kw.has(i, "IF", "EXISTS") {
+		if kw[i] == "IF" {
+			i++
+		}
+		i++
+	}
+	if i >= len(tok) {
+		return
+	}
+	name := ident(tok[i])
+	t := s.tables[name]
+	i++
+	if t == nil {
+		s.issue(SeverityWarning, "table", name, "ALTER TABLE for an unknown table was not converted")
+		return
+	}
+
+	switch {
+	case kw.has(i, "ADD", "CONSTRAINT"):
+		s.parseTableConstraint(t, tok[i+1:], kw[i+1:])
+	case kw.has(i, "ALTER", "COLUMN") && kw.has(i+3, "SET", "DEFAULT"):
+		if c := t.Column(ident(tok[i+2])); c != nil {
+			c.Default = strings.Join(tok[i+5:], " ")
+		}
+	case kw.has(i, "ALTER", "COLUMN") && kw.has(i+3, "ADD", "GENERATED"):
+		s.issue(SeverityWarning, "sequence", name+"."+ident(tok[i+2]), "identity column removed; assign IDs in the application (dsql-shared/idgen)")
+	case kw.has(i, "ENABLE", "ROW", "LEVEL", "SECURITY"), kw.has(i, "FORCE", "ROW", "LEVEL", "SECURITY"):
+		s.issue(SeverityError, "policy", name, "row level security is not supported")
+	case kw.has(i, "ATTACH", "PARTITION"):
+		s.issue(SeverityError, "table", name, "partitioning is not supported")
+	case kw.has(i, "REPLICA", "IDENTITY"), kw.has(i, "CLUSTER", "ON"), kw.has(i, "SET"):
+		// 物理的な設定は移行対象外
+	default:
+		s.issue(SeverityWarning, "unknown", summary(stmt), "ALTER TABLE was not converted")
+	}
+}
+
+func (s *Schema) parseIndex(tok []string, kw keywordList) {
+	idx := Index{Unique: kw.has(1, "UNIQUE")}
+	i := 2
+	if idx.Unique {
+		i = 3
+	}
+	if kw.has(i, "CONCURRENTLY") || kw.has(i, "ASYNC") {
+		i++
+	}
+	if kw.has(i, "IF", "NOT", "EXISTS") {
+		i += 3
+	}
+	if !kw.has(i, "ON") && i < len(tok) {
+		idx.Name = ident(tok[i])
+		i++
+	}
+	i++ // ON
+	if kw.has(i, "ONLY") {
+		i++
+	}
+	if i >= len(tok) {
+		return
+	}
+	table, cols, _ := strings.Cut(tok[i], "(")
+	idx.Table = ident(table)
+	i++
+	if kw.has(i, "USING") {
+		if method := strings.ToLower(tok[i+1]); method != "btree" {
+			s.issue(SeverityError, "index", idx.Name, "USING %s indexes are not supported; only btree can be created", method)
+			return
+		}
+		i += 2
+	}
+	body := strings.Join(tok[i:], " ")
+	if cols != "" {
+		body = "(" + cols + " " + body
+	}
+	if slices.Contains(kw[i:], "WHERE") {
+		s.issue(SeverityWarning, "index", idx.Name, "partial index; check that DSQL accepts the predicate")
+	}
+	idx.Body = strings.TrimSpace(body)
+	s.Indexes = append(s.Indexes, idx)
+}
+
+// arrayOrTextTypes はDSQLで列の型として使えないため text として保存する型
+var arrayOrTextTypes = []string{
+	"json", "jsonb", "xml", "tsvector", "tsquery", "hstore", "money",
+	"point", "line", "lseg", "box", "path", "polygon", "circle",
+	"int4range", "int8range", "numrange", "tsrange", "tstzrange", "daterange",
+}
+
+var serialTypes = map[string]string{
+	"smallserial": "smallint", "serial2": "smallint",
+	"serial": "integer", "serial4": "integer",
+	"bigserial": "bigint", "serial8": "bigint",
+}
+
+// convert は解析した列定義をDSQLで使える形に変換し、外部キーを報告する
+func (s *Schema) convert() {
+	for _, t := range s.Tables {
+		for _, c := range t.Columns {
+			object := t.Name + "." + c.Name
+			typ := strings.ToLower(c.Type)
+
+			switch {
+			case serialTypes[typ] != "":
+				c.Type = serialTypes[typ]
+				s.issue(SeverityWarning, "sequence", object, "%s replaced with %s; assign IDs in the application (dsql-shared/idgen)", typ, c.Type)
+			case strings.HasSuffix(typ, "[]") || strings.HasPrefix(typ, "_"):
+				s.issue(SeverityWarning, "type", object, "array type %s stored as text; encode the value in the application", c.Type)
+				c.Type = "text"
+			case slices.Contains(arrayOrTextTypes, typ):
+				s.issue(SeverityWarning, "type", object, "type %s is not supported as a column type; stored as text", typ)
+				c.Type = "text"
+			case s.enums[ident(c.Type)] != nil:
+				labels := s.enums[ident(c.Type)]
+				s.issue(SeverityWarning, "type", object, "enum %s replaced with varchar and a CHECK constraint", ident(c.Type))
+				c.Type = "varchar(255)"
+				t.Checks = append(t.Checks, fmt.Sprintf("(%s IN (%s))", quoteIdent(c.Name), strings.Join(labels, ", ")))
+			}
+
+			// 'happy'::public.mood のような既定値の型変換は、型を置き換えた場合は外す
+			if !strings.EqualFold(c.Type, typ) {
+				c.Default = stripCast(c.Default)
+			}
+
+			if seq, ok := nextvalSequence(c.Default); ok {
+				s.sequences[seq] = true
+				s.issue(SeverityWarning, "sequence", object, "DEFAULT nextval('%s') removed; assign IDs in the application (dsql-shared/idgen)", seq)
+				c.Default = ""
+			}
+		}
+
+		for i := range t.ForeignKeys {
+			fk := &t.ForeignKeys[i]
+			// REFERENCES parent のように列を省略した場合は参照先の主キー
+			if parent := s.tables[fk.RefTable]; len(fk.RefColumns) == 0 && parent != nil {
+				fk.RefColumns = parent.PrimaryKey
+			}
+			s.issue(SeverityWarning, "foreign_key", fk.Table+"."+strings.Join(fk.Columns, ","),
+				"foreign key to %s removed; enforce it with the generated referential-integrity checks", fk.RefTable)
+			if fk.OnUpdate != "NO ACTION" && fk.OnUpdate != "RESTRICT" {
+				s.issue(SeverityWarning, "foreign_key", fk.Table+"."+strings.Join(fk.Columns, ","),
+					"ON UPDATE %s is not emulated; avoid updating referenced keys", fk.OnUpdate)
+			}
+		}
+	}
+
+	s.checkForeignKeys()
+
+	var standalone []string
+	for seq, used := range s.sequences {
+		if !used {
+			standalone = append(standalone, seq)
+		}
+	}
+	slices.Sort(standalone)
+	for _, seq := range standalone {
+		s.issue(SeverityError, "sequence", seq, "sequences are not supported; generate values in the application (dsql-shared/idgen)")
+	}
+}
+
+// stripCast は文字列リテラルの既定値から型変換（::type）を外す
+func stripCast(def string) string {
+	if i := strings.LastIndex(def, "::"); i > 0 && strings.HasPrefix(def, "'") && strings.HasSuffix(def[:i], "'") {
+		return def[:i]
+	}
+	return def
+}
+
+// nextvalSequence はnextval('seq'::regclass) 形式の既定値からシーケンス名を返す
+func nextvalSequence(def string) (string, bool) {
+	rest, ok := strings.CutPrefix(strings.ToLower(def), "nextval(")
+	if !ok {
+		return "", false
+	}
+	name, _, _ := strings.Cut(strings.Trim(rest, "'"), "'")
+	return ident(name), true
+}
+
+// summary はIssueに表示する文の先頭部分を返す
+func summary(stmt string) string {
+	line, _, _ := strings.Cut(strings.Join(strings.Fields(stmt), " "), " (")
+	if len(line) > 80 {
+		line = line[:77] + "..."
+	}
+	return line
+}
@@ -0,0 +1,230 @@
+package schemaport
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update testdata/*.golden")
+
+// parseFile はtestdata/<name>を解析する
+func parseFile(t *testing.T, name string) *Schema {
+	t.Helper()
+	b, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Parse(string(b))
+}
+
+// TestGolden はpg_dumpの出力から生成したDDL・レポート・Goのコードをtestdata/shop.*.goldenと比較する（-updateで更新する）
+func TestGolden(t *testing.T) {
+	s := parseFile(t, "shop.sql")
+	tests := []struct {
+		name  string
+		write func(*bytes.Buffer) error
+	}{
+		{"shop.sql.golden", func(b *bytes.Buffer) error { return s.WriteDDL(b) }},
+		{"shop.report.golden", func(b *bytes.Buffer) error { return s.WriteReport(b) }},
+		{"shop.go.golden", func(b *bytes.Buffer) error { return s.WriteGo(b, "refcheck") }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := tt.write(&buf); err != nil {
+				t.Fatal(err)
+			}
+			golden(t, tt.name, buf.Bytes())
+		})
+	}
+}
+
+func golden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v (run go test -update to create it)", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("output does not match %s (run go test -update if the change is intended)\ngot:\n%s\nwant:\n%s", path, got, want)
+	}
+}
+
+// TestIssues はDSQLで使えない構文がそれぞれ報告されることを確かめる
+func TestIssues(t *testing.T) {
+	s := parseFile(t, "shop.sql")
+	tests := []struct {
+		severity Severity
+		kind     string
+		object   string
+		message  string // メッセージに含まれる文字列
+	}{
+		{SeverityError, "extension", "pgcrypto", "extensions are not supported"},
+		{SeverityError, "type", "address", "user-defined types"},
+		{SeverityError, "function", "CREATE FUNCTION public.touch_updated_at()", "LANGUAGE plpgsql"},
+		{SeverityError, "trigger", "CREATE TRIGGER orders_touch", "triggers are not supported"},
+		{SeverityError, "sequence", "invoice_numbers", "sequences are not supported"},
+		{SeverityError, "index", "reviews_search_idx", "USING gin"},
+		{SeverityError, "foreign_key", "inventory.stock.sku,warehouse", "composite foreign keys"},
+		{SeverityWarning, "sequence", "orders.id", "bigserial replaced with bigint"},
+		{SeverityWarning, "sequence", "customers.id", "DEFAULT nextval('customers_id_seq') removed"},
+		{SeverityWarning, "sequence", "order_items.id", "identity column removed"},
+		{SeverityWarning, "type", "orders.status", "enum order_status replaced with varchar"},
+		{SeverityWarning, "type", "customers.tags", "array type text[] stored as text"},
+		{SeverityWarning, "type", "customers.profile", "type jsonb is not supported"},
+		{SeverityWarning, "type", "reviews.search", "type tsvector is not supported"},
+		{SeverityWarning, "collation", "customers.email", "COLLATE"},
+		{SeverityWarning, "index", "orders_pending_idx", "partial index"},
+		{SeverityWarning, "foreign_key", "orders.customer_id", "foreign key to customers removed"},
+		{SeverityWarning, "foreign_key", "reviews.order_id", "ON UPDATE CASCADE is not emulated"},
+		{SeverityWarning, "view", "CREATE VIEW public.customer_orders", "copied as is"},
+	}
+	for _, tt := range tests {
+		found := slices.ContainsFunc(s.Issues, func(i Issue) bool {
+			return i.Severity == tt.severity && i.Kind == tt.kind &&
+				strings.HasPrefix(i.Object, tt.object) && strings.Contains(i.Message, tt.message)
+		})
+		if !found {
+			t.Errorf("no [%s] %s %s issue containing %q in:\n%s", tt.severity, tt.kind, tt.object, tt.message, issues(s))
+		}
+	}
+	// SQLの関数は移行先でも作れるため報告しない
+	for _, i := range s.Issues {
+		if strings.Contains(i.Object, "order_total") {
+			t.Errorf("SQL function was reported: %s", i)
+		}
+	}
+}
+
+func issues(s *Schema) string {
+	var b strings.Builder
+	for _, i := range s.Issues {
+		b.WriteString(i.String() + "\n")
+	}
+	return b.String()
+}
+
+// TestColumns は列の型と既定値がDSQL向けに変換されることを確かめる
+func TestColumns(t *testing.T) {
+	tests := []struct {
+		ddl         string
+		column      string
+		wantType    string
+		wantDefault string
+	}{
+		{"CREATE TABLE t (id serial PRIMARY KEY);", "id", "integer", ""},
+		{"CREATE TABLE t (id smallserial);", "id", "smallint", ""},
+		{"CREATE TABLE t (id BIGSERIAL NOT NULL);", "id", "bigint", ""},
+		{"CREATE TABLE t (id bigint DEFAULT nextval('t_id_seq'::regclass));", "id", "bigint", ""},
+		{"CREATE TABLE t (data jsonb DEFAULT '{}'::jsonb);", "data", "text", "'{}'"},
+		{"CREATE TABLE t (tags varchar(20)[]);", "tags", "text", ""},
+		{"CREATE TABLE t (at timestamptz DEFAULT now());", "at", "timestamptz", "now()"},
+		{"CREATE TYPE mood AS ENUM ('ok', 'sad'); CREATE TABLE t (m mood DEFAULT 'ok'::mood);", "m", "varchar(255)", "'ok'"},
+	}
+	for _, tt := range tests {
+		s := Parse(tt.ddl)
+		table := s.Table("t")
+		if table == nil {
+			t.Errorf("%s: table t was not parsed", tt.ddl)
+			continue
+		}
+		c := table.Column(tt.column)
+		if c == nil || c.Type != tt.wantType || c.Default != tt.wantDefault {
+			t.Errorf("%s: column = %+v, want type %q default %q", tt.ddl, c, tt.wantType, tt.wantDefault)
+		}
+	}
+}
+
+// TestIndexAsync はCREATE INDEXがCREATE INDEX ASYNCに書き換えられることを確かめる
+func TestIndexAsync(t *testing.T) {
+	tests := []struct {
+		ddl  string
+		want string
+	}{
+		{"CREATE INDEX t_a_idx ON public.t USING btree (a);", "CREATE INDEX ASYNC IF NOT EXISTS t_a_idx ON t (a);"},
+		{"CREATE UNIQUE INDEX CONCURRENTLY t_ab_idx ON t (a, b DESC);", "CREATE UNIQUE INDEX ASYNC IF NOT EXISTS t_ab_idx ON t (a, b DESC);"},
+		{"CREATE INDEX IF NOT EXISTS t_b_idx ON ONLY t(b) INCLUDE (c);", "CREATE INDEX ASYNC IF NOT EXISTS t_b_idx ON t (b) INCLUDE (c);"},
+	}
+	for _, tt := range tests {
+		s := Parse("CREATE TABLE t (a int, b int, c int);\n" + tt.ddl)
+		var buf bytes.Buffer
+		if err := s.WriteDDL(&buf); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(buf.String(), tt.want+"\n") {
+			t.Errorf("%s:\n%s\nwant %s", tt.ddl, buf.String(), tt.want)
+		}
+		if strings.Contains(buf.String(), "CONCURRENTLY") {
+			t.Errorf("%s: CONCURRENTLY was kept:\n%s", tt.ddl, buf.String())
+		}
+	}
+}
+
+// TestForeignKeys は外部キーが参照整合性チェックの定義になることを確かめる
+func TestForeignKeys(t *testing.T) {
+	const ddl = `
+CREATE TABLE parents (id bigint PRIMARY KEY);
+CREATE TABLE children (
+    id bigint PRIMARY KEY,
+    parent_id bigint REFERENCES parents (id) ON DELETE CASCADE,
+    other_id bigint
+);
+ALTER TABLE ONLY children ADD CONSTRAINT children_other_fkey FOREIGN KEY (other_id) REFERENCES parents(id) ON DELETE SET DEFAULT;
+`
+	s := Parse(ddl)
+	fks := s.ForeignKeys()
+	if len(fks) != 2 {
+		t.Fatalf("foreign keys = %v, want 2", fks)
+	}
+
+	var buf bytes.Buffer
+	if err := s.WriteDDL(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), "REFERENCES") {
+		t.Errorf("DDL keeps a foreign key:\n%s", buf.String())
+	}
+
+	buf.Reset()
+	if err := s.WriteGo(&buf, "refs"); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"package refs\n",
+		`{Child: "children", Column: "parent_id", Parent: "parents", ParentColumn: "id", OnDelete: refint.Cascade}`,
+		`{Child: "children", Column: "other_id", Parent: "parents", ParentColumn: "id", OnDelete: refint.SetDefault}`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("generated code does not contain %s:\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestIdent(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"public.orders", "orders"},
+		{"Orders", "orders"},
+		{`"Order Items"`, "Order Items"},
+		{`public."user"`, "user"},
+		{"inventory.stock", "inventory.stock"},
+	}
+	for _, tt := range tests {
+		if got := ident(tt.in); got != tt.want {
+			t.Errorf("ident(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,286 @@
+--
+-- PostgreSQL database dump
+--
+
+-- Dumped from database version 16.4
+-- Dumped by pg_dump version 16.4
+
+SET statement_timeout = 0;
+SET lock_timeout = 0;
+SET client_encoding = 'UTF8';
+SET standard_conforming_strings = on;
+SELECT pg_catalog.set_config('search_path', '', false);
+SET check_function_bodies = false;
+
+--
+-- Name: inventory; Type: SCHEMA; Schema: -; Owner: shop
+--
+
+CREATE SCHEMA inventory;
+
+
+ALTER SCHEMA inventory OWNER TO shop;
+
+--
+-- Name: pgcrypto; Type: EXTENSION; Schema: -; Owner: -
+--
+
+CREATE EXTENSION IF NOT EXISTS pgcrypto WITH SCHEMA public;
+
+
+--
+-- Name: EXTENSION pgcrypto; Type: COMMENT; Schema: -; Owner:
+--
+
+COMMENT ON EXTENSION pgcrypto IS 'cryptographic functions';
+
+
+--
+-- Name: order_status; Type: TYPE; Schema: public; Owner: shop
+--
+
+CREATE TYPE public.order_status AS ENUM (
+    'pending',
+    'paid',
+    'shipped'
+);
+
+
+ALTER TYPE public.order_status OWNER TO shop;
+
+--
+-- Name: address; Type: TYPE; Schema: public; Owner: shop
+--
+
+CREATE TYPE public.address AS (
+	street text,
+	city text
+);
+
+
+--
+-- Name: touch_updated_at(); Type: FUNCTION; Schema: public; Owner: shop
+--
+
+CREATE FUNCTION public.touch_updated_at() RETURNS trigger
+    LANGUAGE plpgsql
+    AS $$
+BEGIN
+    NEW.updated_at := now();
+    RETURN NEW;
+END;
+$$;
+
+
+--
+-- Name: order_total(bigint); Type: FUNCTION; Schema: public; Owner: shop
+--
+
+CREATE FUNCTION public.order_total(order_id bigint) RETURNS numeric
+    LANGUAGE sql STABLE
+    AS $_$
+    SELECT sum(quantity * unit_price) FROM public.order_items WHERE order_items.order_id = $1;
+$_$;
+
+
+SET default_tablespace = '';
+
+SET default_table_access_method = heap;
+
+--
+-- Name: customers; Type: TABLE; Schema: public; Owner: shop
+--
+
+CREATE TABLE public.customers (
+    id bigint NOT NULL,
+    email character varying(255) NOT NULL COLLATE pg_catalog."en_US",
+    name text,
+    tags text[],
+    profile jsonb DEFAULT '{}'::jsonb,
+    created_at timestamp with time zone DEFAULT now() NOT NULL
+);
+
+
+--
+-- Name: customers_id_seq; Type: SEQUENCE; Schema: public; Owner: shop
+--
+
+CREATE SEQUENCE public.customers_id_seq
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+ALTER SEQUENCE public.customers_id_seq OWNED BY public.customers.id;
+
+--
+-- Name: invoice_numbers; Type: SEQUENCE; Schema: public; Owner: shop
+--
+
+CREATE SEQUENCE public.invoice_numbers
+    START WITH 1000
+    INCREMENT BY 1;
+
+
+--
+-- Name: orders; Type: TABLE; Schema: public; Owner: shop
+--
+
+CREATE TABLE public.orders (
+    id bigserial NOT NULL,
+    customer_id bigint NOT NULL,
+    status public.order_status DEFAULT 'pending'::public.order_status NOT NULL,
+    "user" text,
+    placed_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP
+);
+
+
+--
+-- Name: order_items; Type: TABLE; Schema: public; Owner: shop
+--
+
+CREATE TABLE public.order_items (
+    id integer NOT NULL,
+    order_id bigint NOT NULL,
+    sku text NOT NULL,
+    quantity integer DEFAULT 1 NOT NULL,
+    unit_price numeric(10,2) NOT NULL,
+    CONSTRAINT order_items_quantity_check CHECK ((quantity > 0))
+);
+
+
+ALTER TABLE public.order_items ALTER COLUMN id ADD GENERATED ALWAYS AS IDENTITY (
+    SEQUENCE NAME public.order_items_id_seq
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1
+);
+
+
+--
+-- Name: reviews; Type: TABLE; Schema: public; Owner: shop
+--
+
+CREATE TABLE public.reviews (
+    id bigint NOT NULL,
+    customer_id bigint,
+    order_id bigint,
+    rating smallint,
+    body text,
+    search tsvector
+);
+
+
+--
+-- Name: stock; Type: TABLE; Schema: inventory; Owner: shop
+--
+
+CREATE TABLE inventory.stock (
+    sku text NOT NULL,
+    warehouse text NOT NULL,
+    quantity integer DEFAULT 0 NOT NULL
+);
+
+
+--
+-- Name: customers id; Type: DEFAULT; Schema: public; Owner: shop
+--
+
+ALTER TABLE ONLY public.customers ALTER COLUMN id SET DEFAULT nextval('public.customers_id_seq'::regclass);
+
+
+--
+-- Name: customers customers_pkey; Type: CONSTRAINT; Schema: public; Owner: shop
+--
+
+ALTER TABLE ONLY public.customers
+    ADD CONSTRAINT customers_pkey PRIMARY KEY (id);
+
+
+ALTER TABLE ONLY public.customers
+    ADD CONSTRAINT customers_email_key UNIQUE (email);
+
+
+ALTER TABLE ONLY public.orders
+    ADD CONSTRAINT orders_pkey PRIMARY KEY (id);
+
+
+ALTER TABLE ONLY public.order_items
+    ADD CONSTRAINT order_items_pkey PRIMARY KEY (id);
+
+
+ALTER TABLE ONLY public.reviews
+    ADD CONSTRAINT reviews_pkey PRIMARY KEY (id);
+
+
+ALTER TABLE ONLY inventory.stock
+    ADD CONSTRAINT stock_pkey PRIMARY KEY (sku, warehouse);
+
+
+--
+-- Name: orders_customer_id_idx; Type: INDEX; Schema: public; Owner: shop
+--
+
+CREATE INDEX orders_customer_id_idx ON public.orders USING btree (customer_id);
+
+
+CREATE UNIQUE INDEX order_items_order_sku_idx ON public.order_items USING btree (order_id, sku);
+
+
+CREATE INDEX orders_pending_idx ON public.orders USING btree (placed_at) WHERE (status = 'pending'::public.order_status);
+
+
+CREATE INDEX reviews_search_idx ON public.reviews USING gin (search);
+
+
+--
+-- Name: orders orders_touch; Type: TRIGGER; Schema: public; Owner: shop
+--
+
+CREATE TRIGGER orders_touch BEFORE UPDATE ON public.orders FOR EACH ROW EXECUTE FUNCTION public.touch_updated_at();
+
+
+--
+-- Name: orders orders_customer_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: shop
+--
+
+ALTER TABLE ONLY public.orders
+    ADD CONSTRAINT orders_customer_id_fkey FOREIGN KEY (customer_id) REFERENCES public.customers(id) ON DELETE RESTRICT;
+
+
+ALTER TABLE ONLY public.order_items
+    ADD CONSTRAINT order_items_order_id_fkey FOREIGN KEY (order_id) REFERENCES public.orders(id) ON DELETE CASCADE;
+
+
+ALTER TABLE ONLY public.reviews
+    ADD CONSTRAINT reviews_customer_id_fkey FOREIGN KEY (customer_id) REFERENCES public.customers(id) ON DELETE SET NULL;
+
+
+ALTER TABLE ONLY public.reviews
+    ADD CONSTRAINT reviews_order_id_fkey FOREIGN KEY (order_id) REFERENCES public.orders(id) ON UPDATE CASCADE;
+
+
+ALTER TABLE ONLY inventory.stock
+    ADD CONSTRAINT stock_sku_fkey FOREIGN KEY (sku, warehouse) REFERENCES public.order_items(sku, order_id);
+
+
+--
+-- Name: customer_orders; Type: VIEW; Schema: public; Owner: shop
+--
+
+CREATE VIEW public.customer_orders AS
+ SELECT c.id AS customer_id,
+    count(o.id) AS orders
+   FROM (public.customers c
+     LEFT JOIN public.orders o ON ((o.customer_id = c.id)))
+  GROUP BY c.id;
+
+
+--
+-- PostgreSQL database dump complete
+--
+
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"dsql-client/resultfmt"
+
+	"dsql-shared/dsqltx"
+	"dsql-shared/sqlscript"
+)
+
+// execの実行モード
+const (
+	modeAutocommit = "autocommit" // 文ごとにコミットする
+	modeSingle     = "single"     // ファイル全体を1つのトランザクションで実行する
+)
+
+// dsqlMaxRowsPerTx はDSQLの1トランザクションで変更できる行数の上限
+const dsqlMaxRowsPerTx = 3000
+
+// codeProgramLimitExceeded はトランザクションの行数などの上限を超えたときのSQLSTATE
+const codeProgramLimitExceeded = "54000"
+
+// batch は1つのトランザクションで実行する文のまとまり
+//
+// txがfalseの場合は1文だけを含み、トランザクションを開始せずに実行する。
+type batch struct {
+	tx    bool
+	stmts []int // 文の添字
+}
+
+// 文の実行結果の状態
+const (
+	statusOK         = "ok"
+	statusError      = "error"
+	statusRolledBack = "rolled back" // 成功したが、同じトランザクションの別の文が失敗した
+	statusSkipped    = "skipped"     // エラーで中断したため実行していない
+)
+
+// stmtResult は文ごとの実行結果
+type stmtResult struct {
+	status  string
+	rows    int64 // 返した行数、または影響を受けた行数
+	elapsed time.Duration
+	err     error
+}
+
+// runQuery は引数のSQLを実行する（セミコロンで区切った複数の文も順に実行する）
+func runQuery(args []string) error {
+	fs, cfg, err := newFlagSet("query", "SQL")
+	if err != nil {
+		return err
+	}
+	format := resultfmt.Table
+	fs.Var(&format, "format", resultfmt.Usage())
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return usageError(errors.New("specify one SQL argument (quote it in the shell)"))
+	}
+	stmts := sqlscript.Statements(fs.Arg(0))
+	if len(stmts) == 0 {
+		return usageError(errors.New("empty SQL"))
+	}
+
+	db, err := connectToDSQL(*cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	conn, err := db.Acquire(ctx)
+	if err != nil {
+		return connectError(err)
+	}
+	defer conn.Release()
+
+	results := runScript(ctx, conn, stmts, planBatches(stmts, modeAutocommit), format, false, os.Stdout)
+	for _, r := range results {
+		if r.err != nil {
+			return r.err
+		}
+	}
+	return nil
+}
+
+// runExec はSQLファイルの文を順に実行し、文ごとの結果を報告する
+func runExec(args []string) error {
+	fs, cfg, err := newFlagSet("exec", "-f FILE")
+	if err != nil {
+		return err
+	}
+	file := fs.String("f", "", "SQL file to execute (- for stdin)")
+	format := resultfmt.Table
+	fs.Var(&format, "format", resultfmt.Usage())
+	mode := fs.String("mode", modeAutocommit, "transaction mode: autocommit (commit each statement) or single (one transaction; DDL is split into its own transactions)")
+	continueOnError := fs.Bool("continue", false, "continue with the next statement after an error")
+	dryRun := fs.Bool("dry-run", false, "only parse and list the statements")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *file == "" || fs.NArg() > 0 {
+		fs.Usage()
+		return usageError(errors.New("specify the SQL file with -f"))
+	}
+	if *mode != modeAutocommit && *mode != modeSingle {
+		return usageError(fmt.Errorf("invalid -mode %q (autocommit or single)", *mode))
+	}
+
+	var script []byte
+	if *file == "-" {
+		script, err = io.ReadAll(os.Stdin)
+	} else {
+		script, err = os.ReadFile(*file)
+	}
+	if err != nil {
+		return err
+	}
+	stmts := sqlscript.Statements(string(script))
+	if len(stmts) == 0 {
+		return usageError(fmt.Errorf("%s contains no statements", *file))
+	}
+
+	if *mode == modeSingle {
+		for i, st := range stmts {
+			if st.Kind == sqlscript.KindTransaction {
+				return usageError(fmt.Errorf("statement %d (%s) controls transactions itself; use -mode autocommit", i+1, st.Command))
+			}
+		}
+	}
+	batches := planBatches(stmts, *mode)
+	if *mode == modeSingle && len(batches) > 1 {
+		fmt.Fprintf(os.Stderr, "⚠️  DSQLは1つのトランザクションでDDLを1文しか実行できず、DDLと他の文を混在できないため、%d個のトランザクションに分けて実行します\n", len(batches))
+	}
+
+	if *dryRun {
+		return printPlan(stmts, batches, format)
+	}
+
+	db, err := connectToDSQL(*cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	// スクリプト内のBEGIN/COMMITが有効になるよう、すべての文を同じ接続で実行する
+	ctx := context.Background()
+	conn, err := db.Acquire(ctx)
+	if err != nil {
+		return connectError(err)
+	}
+	defer conn.Release()
+
+	results := runScript(ctx, conn, stmts, batches, format, *continueOnError, os.Stdout)
+	failed := printReport(stmts, results)
+	if failed > 0 {
+		return fmt.Errorf("%d of %d statements failed", failed, len(stmts))
+	}
+	return nil
+}
+
+// planBatches は文をトランザクションごとのまとまりに分ける
+//
+// singleでは連続するDDL以外の文を1つのトランザクションにまとめ、DDLは1文ずつ別の
+// トランザクションにする（DSQLはDDLを1トランザクションに1文しか実行できず、DMLと混在できない）。
+func planBatches(stmts []sqlscript.Statement, mode string) []batch {
+	var batches []batch
+	if mode != modeSingle {
+		for i := range stmts {
+			batches = append(batches, batch{stmts: []int{i}})
+		}
+		return batches
+	}
+
+	cur := batch{tx: true}
+	flush := func() {
+		if len(cur.stmts) > 0 {
+			batches = append(batches, cur)
+		}
+		cur = batch{tx: true}
+	}
+	for i, st := range stmts {
+		if st.Kind == sqlscript.KindDDL {
+			flush()
+			batches = append(batches, batch{tx: true, stmts: []int{i}})
+			continue
+		}
+		cur.stmts = append(cur.stmts, i)
+	}
+	flush()
+	return batches
+}
+
+// runScript はbatchesの順に文を実行し、結果の行をwに書き出す
+//
+// エラーが起きるとcontinueOnErrorでない限り残りの文を実行しない。トランザクション内の文が
+// 失敗した場合は、そのトランザクションの文はすべてロールバックされる。
+func runScript(ctx context.Context, conn *pgxpool.Conn, stmts []sqlscript.Statement, batches []batch, format resultfmt.Format, continueOnError bool, w io.Writer) []stmtResult {
+	results := make([]stmtResult, len(stmts))
+	for i := range results {
+		results[i].status = statusSkipped
+	}
+
+	for _, b := range batches {
+		var err error
+		if !b.tx {
+			i := b.stmts[0]
+			results[i] = runStatement(ctx, conn, stmts[i], format, w)
+			err = results[i].err
+		} else {
+			err = runBatch(ctx, conn, stmts, b, results, format, w)
+		}
+		if err != nil && !continueOnError {
+			break
+		}
+	}
+	return results
+}
+
+// runBatch はbの文を1つのトランザクションで実行する。OCC競合の場合はトランザクションごとやり直す
+//
+// 結果の行は試行ごとにバッファに書き、コミットに成功した試行の分だけをwに書き出す
+// （再試行で同じ結果を重ねて表示したり、ロールバックされた結果を表示したりしないため）。
+func runBatch(ctx context.Context, conn *pgxpool.Conn, stmts []sqlscript.Statement, b batch, results []stmtResult, format resultfmt.Format, w io.Writer) error {
+	var out bytes.Buffer
+	var affected int64
+	warned := false
+	attempts, err := dsqltx.RunInTx(ctx, conn, func(tx pgx.Tx) error {
+		out.Reset()
+		affected = 0
+		for _, i := range b.stmts {
+			results[i] = stmtResult{status: statusSkipped}
+		}
+		for _, i := range b.stmts {
+			results[i] = runStatement(ctx, tx, stmts[i], format, &out)
+			if results[i].err != nil {
+				return results[i].err
+			}
+			if stmts[i].Kind == sqlscript.KindDML {
+				affected += results[i].rows
+			}
+			// コミットする前に、DSQLの上限を超えて失敗しそうなことを知らせる
+			if affected > dsqlMaxRowsPerTx && !warned {
+				warned = true
+				fmt.Fprintf(os.Stderr, "⚠️  このトランザクションで%d行を変更しました。DSQLの1トランザクションで変更できる行数（%d行）を超えるため、コミットに失敗する可能性があります。-mode autocommitで実行するか、文を分割してください\n", affected, dsqlMaxRowsPerTx)
+			}
+		}
+		return nil
+	})
+	if attempts > 1 {
+		fmt.Fprintf(os.Stderr, "🔁 競合のためトランザクションを再試行しました (試行回数: %d)\n", attempts)
+	}
+	if err == nil {
+		_, err := out.WriteTo(w)
+		return err
+	}
+
+	// 成功していた文もロールバックされている。文が失敗していなければCOMMITが失敗した
+	failed := false
+	for _, i := range b.stmts {
+		switch results[i].status {
+		case statusOK:
+			results[i].status = statusRolledBack
+		case statusError:
+			failed = true
+		}
+	}
+	if !failed {
+		last := b.stmts[len(b.stmts)-1]
+		results[last].status = statusError
+		results[last].err = fmt.Errorf("commit failed: %w", err)
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == codeProgramLimitExceeded && !warned {
+		fmt.Fprintf(os.Stderr, "⚠️  DSQLの1トランザクションで変更できる行数（%d行）などの上限を超えた可能性があります。-mode autocommitで実行するか、文を分割してください\n", dsqlMaxRowsPerTx)
+	}
+	return err
+}
+
+// runStatement は1つの文を実行して結果を返す（結果の行はwに書き出す）
+func runStatement(ctx context.Context, db querier, st sqlscript.Statement, format resultfmt.Format, w io.Writer) stmtResult {
+	start := time.Now()
+	rows, err := executeQuery(ctx, db, st.Text, format, w)
+	r := stmtResult{status: statusOK, rows: rows, elapsed: time.Since(start), err: err}
+	if err != nil {
+		r.status = statusError
+	}
+	return r
+}
+
+// printPlan はdry-runで実行する文の一覧を標準出力に書き出す
+func printPlan(stmts []sqlscript.Statement, batches []batch, format resultfmt.Format) error {
+	out := resultfmt.NewWriter(os.Stdout, format)
+	if err := out.WriteHeader([]string{"#", "transaction", "kind", "command", "returns_rows", "statement"}); err != nil {
+		return err
+	}
+	for n, b := range batches {
+		tx := "autocommit"
+		if b.tx {
+			tx = fmt.Sprintf("tx %d", n+1)
+		}
+		for _, i := range b.stmts {
+			st := stmts[i]
+			err := out.WriteRow([]any{i + 1, tx, st.Kind.String(), st.Command, st.ReturnsRows, summarize(st.Text, 80)})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return out.Flush()
+}
+
+// printReport は文ごとの結果を標準エラー出力に表示し、失敗した文の数を返す
+func printReport(stmts []sqlscript.Statement, results []stmtResult) int {
+	fmt.Fprintln(os.Stderr, "\n=== 実行結果 ===")
+	out := resultfmt.NewWriter(os.Stderr, resultfmt.Table)
+	out.WriteHeader([]string{"#", "command", "rows", "time_ms", "result", "statement"})
+
+	var failed int
+	var total time.Duration
+	for i, r := range results {
+		result := r.status
+		if r.err != nil {
+			failed++
+			result += ": " + r.err.Error()
+		}
+		total += r.elapsed
+
+		var rows, elapsed any
+		if r.status != statusSkipped {
+			rows = r.rows
+			elapsed = fmt.Sprintf("%.3f", float64(r.elapsed.Microseconds())/1000)
+		}
+		out.WriteRow([]any{i + 1, stmts[i].Command, rows, elapsed, result, summarize(stmts[i].Text, 40)})
+	}
+	out.Flush()
+
+	if failed > 0 {
+		fmt.Fprintf(os.Stderr, "❌ %d件中%d件の文が失敗しました (合計 %.3f ms)\n", len(results), failed, float64(total.Microseconds())/1000)
+	} else {
+		fmt.Fprintf(os.Stderr, "✅ %d件の文を実行しました (合計 %.3f ms)\n", len(results), float64(total.Microseconds())/1000)
+	}
+	return failed
+}
+
+// summarize は文を1行にまとめ、n文字を超える部分を省略する
+func summarize(stmt string, n int) string {
+	s := strings.Join(strings.Fields(stmt), " ")
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n-1]) + "…"
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"dsql-client/resultfmt"
+
+	"dsql-shared/sqlscript"
+	"dsql-shared/testdb"
+)
+
+func TestPlanBatches(t *testing.T) {
+	stmts := sqlscript.Statements(`
+INSERT INTO t VALUES (1);
+SELECT * FROM t;
+CREATE TABLE u (id INT);
+CREATE INDEX ASYNC i ON u (id);
+UPDATE t SET a = 2;
+DELETE FROM t;`)
+
+	autocommit := planBatches(stmts, modeAutocommit)
+	for i, b := range autocommit {
+		if b.tx || !reflect.DeepEqual(b.stmts, []int{i}) {
+			t.Errorf("autocommit batch %d = %+v, want statement %d without a transaction", i, b, i)
+		}
+	}
+
+	// DDLは1文ずつ、それ以外の連続する文はまとめる
+	want := []batch{
+		{tx: true, stmts: []int{0, 1}},
+		{tx: true, stmts: []int{2}},
+		{tx: true, stmts: []int{3}},
+		{tx: true, stmts: []int{4, 5}},
+	}
+	if got := planBatches(stmts, modeSingle); !reflect.DeepEqual(got, want) {
+		t.Errorf("single batches = %+v, want %+v", got, want)
+	}
+}
+
+// runSingle はscriptを-mode singleで実行し、結果と標準出力に書き出す内容を返す
+func runSingle(t *testing.T, script string) ([]stmtResult, string) {
+	t.Helper()
+	p := testdb.Pool(t, "test_dsql_client")
+	testdb.Exec(t, p, "CREATE TABLE IF NOT EXISTS script_rows (n INT)")
+	testdb.Exec(t, p, "TRUNCATE script_rows")
+
+	ctx := context.Background()
+	conn, err := p.Acquire(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Release()
+
+	stmts := sqlscript.Statements(script)
+	var out bytes.Buffer
+	results := runScript(ctx, conn, stmts, planBatches(stmts, modeSingle), resultfmt.CSV, false, &out)
+	return results, out.String()
+}
+
+func TestRunBatchWritesRowsAfterCommit(t *testing.T) {
+	results, out := runSingle(t, "INSERT INTO script_rows VALUES (1), (2); SELECT n FROM script_rows ORDER BY n;")
+	for i, r := range results {
+		if r.status != statusOK {
+			t.Fatalf("statement %d: %s %v", i+1, r.status, r.err)
+		}
+	}
+	if out != "n\n1\n2\n" {
+		t.Errorf("output = %q, want the committed rows", out)
+	}
+}
+
+func TestRunBatchDiscardsRolledBackRows(t *testing.T) {
+	results, out := runSingle(t, "INSERT INTO script_rows VALUES (1); SELECT n FROM script_rows; SELECT 1 / 0;")
+	if out != "" {
+		t.Errorf("output of the rolled back transaction = %q, want nothing", out)
+	}
+	var statuses []string
+	for _, r := range results {
+		statuses = append(statuses, r.status)
+	}
+	if want := []string{statusRolledBack, statusRolledBack, statusError}; !reflect.DeepEqual(statuses, want) {
+		t.Errorf("statuses = %q, want %q", statuses, want)
+	}
+	if err := results[2].err; err == nil || !strings.Contains(err.Error(), "division by zero") {
+		t.Errorf("error = %v, want division by zero", err)
+	}
+
+	p := testdb.Pool(t, "test_dsql_client")
+	var n int
+	if err := p.QueryRow(context.Background(), "SELECT count(*) FROM script_rows").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("%d rows were committed, want 0", n)
+	}
+}
@@ -0,0 +1,97 @@
+package transfer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+)
+
+// Checkpoint は取り込みの進み具合
+//
+// バッチは入力の先頭から番号を振る。同じ入力と同じバッチの設定なら同じ分け方になるため、
+// 再開時は完了したバッチを読み飛ばす。並列に実行するので、完了したバッチは連続しているとは限らない。
+type Checkpoint struct {
+	Source     string   `json:"source"`
+	Table      string   `json:"table"`
+	Columns    []string `json:"columns"`
+	BatchRows  int      `json:"batch_rows"`
+	BatchBytes int      `json:"batch_bytes"`
+	// DoneThrough 以下の番号のバッチはすべて完了している
+	DoneThrough int64 `json:"done_through"`
+	// Done はDoneThroughより後に完了したバッチの番号（昇順）
+	Done []int64 `json:"done,omitempty"`
+	// Rows は完了したバッチで挿入した行数
+	Rows      int64     `json:"rows"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// LoadCheckpoint はpathのチェックポイントを読む。ファイルがなければnilを返す
+func LoadCheckpoint(path string) (*Checkpoint, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var cp Checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, fmt.Errorf("invalid checkpoint %s: %w", path, err)
+	}
+	return &cp, nil
+}
+
+// Save はチェックポイントをpathに書き込む（一時ファイルに書いてから置き換える）
+func (cp *Checkpoint) Save(path string) error {
+	cp.UpdatedAt = time.Now().UTC()
+	data, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// matches はcpが同じ取り込みのものかどうかを確認する
+func (cp *Checkpoint) matches(want *Checkpoint) error {
+	switch {
+	case cp.Source != want.Source:
+		return fmt.Errorf("it is for %s, not %s", cp.Source, want.Source)
+	case cp.Table != want.Table:
+		return fmt.Errorf("it is for table %s, not %s", cp.Table, want.Table)
+	case !slices.Equal(cp.Columns, want.Columns):
+		return errors.New("the columns differ")
+	case cp.BatchRows != want.BatchRows || cp.BatchBytes != want.BatchBytes:
+		return fmt.Errorf("it was made with -batch-rows %d -batch-bytes %d", cp.BatchRows, cp.BatchBytes)
+	}
+	return nil
+}
+
+// isDone はseq番目のバッチが完了しているかどうかを返す
+func (cp *Checkpoint) isDone(seq int64) bool {
+	if seq <= cp.DoneThrough {
+		return true
+	}
+	_, found := slices.BinarySearch(cp.Done, seq)
+	return found
+}
+
+// markDone はseq番目のバッチを完了にする
+func (cp *Checkpoint) markDone(seq, rows int64) {
+	cp.Rows += rows
+	if i, found := slices.BinarySearch(cp.Done, seq); !found {
+		cp.Done = slices.Insert(cp.Done, i, seq)
+	}
+	n := 0
+	for n < len(cp.Done) && cp.Done[n] == cp.DoneThrough+1 {
+		cp.DoneThrough++
+		n++
+	}
+	cp.Done = cp.Done[n:]
+}
@@ -0,0 +1,228 @@
+package transfer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"dsql-client/resultfmt"
+
+	"dsql-shared/dsqlconn"
+)
+
+// pageAttempts は接続が切れたときに1ページを問い合わせる最大回数
+const pageAttempts = 3
+
+// Exporter はテーブルまたは問い合わせの結果を書き出す
+//
+// Keyの列の順にPageSize行ずつ問い合わせる（キーセット方式のページ分割）。ページごとに別の文
+// （トランザクション）になるため、DSQLのトランザクションの時間制限を超える大きさでも書き出せる。
+// ただし全体は1つのスナップショットにならず、書き出し中の変更は反映されないことがある。
+type Exporter struct {
+	Pool  *pgxpool.Pool
+	Table string // Table と Query のどちらか
+	Query string
+	// Key はページ分割に使う一意な列。Tableの場合は省略すると主キーになる。
+	// Queryの場合は省略するとページに分けず1つの文で問い合わせる
+	Key      []string
+	PageSize int
+	Format   Format
+	// Null はCSVでNULLとして書き出す値
+	Null string
+	// Logf は進み具合を表示する
+	Logf func(format string, args ...any)
+}
+
+// ExportStats は書き出しの結果
+type ExportStats struct {
+	Columns []string
+	Key     []string
+	Rows    int64
+	Pages   int64
+	Elapsed time.Duration
+}
+
+// Run は結果をwに書き出す
+func (ex *Exporter) Run(ctx context.Context, w io.Writer) (ExportStats, error) {
+	start := time.Now()
+	var stats ExportStats
+
+	base := strings.TrimRight(strings.TrimSpace(ex.Query), "; \t\n")
+	key := ex.Key
+	if ex.Table != "" {
+		base = "SELECT * FROM " + identifier(ex.Table).Sanitize()
+		if len(key) == 0 {
+			var err error
+			if key, err = PrimaryKey(ctx, ex.Pool, ex.Table); err != nil {
+				return stats, err
+			}
+			if len(key) == 0 {
+				return stats, fmt.Errorf("table %s has no primary key; specify the key columns", ex.Table)
+			}
+		}
+	}
+	if base == "" {
+		return stats, errors.New("no table or query to export")
+	}
+	stats.Key = key
+
+	var out resultfmt.Writer
+	var keyIndex []int // 結果の中のKeyの列の位置
+	var last []any     // 直前のページの最後の行のKeyの値
+	lastLog := time.Now()
+
+	for {
+		sql, args := base, []any(nil)
+		if len(key) > 0 {
+			sql, args = pageQuery(base, key, last, ex.PageSize)
+		}
+		fields, rows, err := ex.fetch(ctx, sql, args)
+		if err != nil {
+			return stats, fmt.Errorf("page %d: %w", stats.Pages+1, err)
+		}
+		stats.Pages++
+
+		if stats.Columns == nil {
+			var columns []string
+			var oids []uint32
+			for _, f := range fields {
+				columns = append(columns, f.Name)
+				oids = append(oids, f.DataTypeOID)
+			}
+			stats.Columns = columns
+			out = ex.newWriter(w, oids)
+			if err := out.WriteHeader(columns); err != nil {
+				return stats, err
+			}
+			for _, k := range key {
+				i := slices.Index(columns, k)
+				if i < 0 {
+					return stats, fmt.Errorf("key column %s is not in the result", k)
+				}
+				keyIndex = append(keyIndex, i)
+			}
+		}
+		for _, row := range rows {
+			if err := out.WriteRow(row); err != nil {
+				return stats, err
+			}
+		}
+		stats.Rows += int64(len(rows))
+
+		if len(key) == 0 || len(rows) < ex.PageSize {
+			break
+		}
+		lastRow := rows[len(rows)-1]
+		last = last[:0]
+		for _, i := range keyIndex {
+			if lastRow[i] == nil {
+				return stats, fmt.Errorf("key column %s has NULL; use unique non-null key columns", stats.Columns[i])
+			}
+			last = append(last, lastRow[i])
+		}
+		if time.Since(lastLog) >= 5*time.Second {
+			lastLog = time.Now()
+			ex.logf("📦 %d行を書き出しました (%.0f行/秒)", stats.Rows, float64(stats.Rows)/time.Since(start).Seconds())
+		}
+	}
+
+	stats.Elapsed = time.Since(start)
+	return stats, out.Flush()
+}
+
+// newWriter はFormatの形式で書き出すWriterを返す（oidsは列の型。Parquetで使う）
+func (ex *Exporter) newWriter(w io.Writer, oids []uint32) resultfmt.Writer {
+	switch ex.Format {
+	case Parquet:
+		return newParquetWriter(w, oids)
+	case CSV:
+		return resultfmt.NewCSVWriter(w, ex.Null)
+	}
+	return resultfmt.NewWriter(w, resultfmt.Format(ex.Format))
+}
+
+// fetch はsqlの結果をすべて読み込む。接続が切れた場合は問い合わせをやり直す
+//
+// 1時間で接続が切断されるため、ページの途中で失敗しても書き出した行と重複しないよう
+// ページ単位で読み込んでから書き出す。
+func (ex *Exporter) fetch(ctx context.Context, sql string, args []any) ([]pgconn.FieldDescription, [][]any, error) {
+	for attempt := 1; ; attempt++ {
+		fields, rows, err := ex.query(ctx, sql, args)
+		if err == nil || !dsqlconn.IsConnError(err) || ctx.Err() != nil || attempt >= pageAttempts {
+			return fields, rows, err
+		}
+		ex.logf("🔁 接続エラーのため問い合わせをやり直します: %v", err)
+	}
+}
+
+func (ex *Exporter) query(ctx context.Context, sql string, args []any) ([]pgconn.FieldDescription, [][]any, error) {
+	rs, err := ex.Pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rs.Close()
+
+	// 接続は列の情報の領域を次の問い合わせで使い回すため、複製しておく
+	fields := slices.Clone(rs.FieldDescriptions())
+	var rows [][]any
+	for rs.Next() {
+		values, err := rs.Values()
+		if err != nil {
+			return nil, nil, err
+		}
+		rows = append(rows, values)
+	}
+	return fields, rows, rs.Err()
+}
+
+func (ex *Exporter) logf(format string, args ...any) {
+	if ex.Logf != nil {
+		ex.Logf(format, args...)
+	}
+}
+
+// pageQuery はlastより後のpageSize行を返す問い合わせを組み立てる（lastがnilなら先頭から）
+func pageQuery(base string, key []string, last []any, pageSize int) (string, []any) {
+	cols := make([]string, len(key))
+	params := make([]string, len(key))
+	for i, k := range key {
+		cols[i] = "page." + pgx.Identifier{k}.Sanitize()
+		params[i] = "$" + strconv.Itoa(i+1)
+	}
+	var sql strings.Builder
+	sql.WriteString("SELECT * FROM (" + base + ") AS page")
+	if last != nil {
+		sql.WriteString(" WHERE (" + strings.Join(cols, ", ") + ") > (" + strings.Join(params, ", ") + ")")
+	}
+	sql.WriteString(" ORDER BY " + strings.Join(cols, ", ") + " LIMIT " + strconv.Itoa(pageSize))
+	return sql.String(), last
+}
+
+// PrimaryKey はテーブルの主キーの列を順に返す（主キーがなければ空）
+func PrimaryKey(ctx context.Context, db interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}, table string) ([]string, error) {
+	id := identifier(table)
+	rows, err := db.Query(ctx, `
+		SELECT k.column_name
+		FROM information_schema.table_constraints c
+		JOIN information_schema.key_column_usage k
+		  ON k.constraint_schema = c.constraint_schema
+		 AND k.constraint_name = c.constraint_name
+		 AND k.table_name = c.table_name
+		WHERE c.constraint_type = 'PRIMARY KEY' AND c.table_schema = $1 AND c.table_name = $2
+		ORDER BY k.ordinal_position`, id[0], id[1])
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowTo[string])
+}
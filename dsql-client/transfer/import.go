@@ -0,0 +1,339 @@
+package transfer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"golang.org/x/sync/errgroup"
+
+	"dsql-shared/dsqltx"
+)
+
+// DSQLの1トランザクションあたりの上限
+const (
+	MaxRowsPerTx  = 3000             // 変更できる行数
+	MaxBytesPerTx = 10 * 1024 * 1024 // 変更できるデータの大きさ
+)
+
+// maxParams は1つの文に渡せるパラメータの数の上限（PostgreSQLのプロトコルの制限）
+const maxParams = 65535
+
+// Importer はSourceのレコードをテーブルに挿入する
+//
+// レコードをバッチにまとめ、バッチごとに1つの複数行INSERT（1トランザクション）を実行する。
+// バッチは最大Workers個を並列に実行し、OCC競合はバッチごとに再試行する。
+type Importer struct {
+	Pool  *pgxpool.Pool
+	Table string // テーブル名（schema.table も可）
+	// BatchRows と BatchBytes は1バッチの行数と値の大きさの上限
+	BatchRows  int
+	BatchBytes int
+	Workers    int
+	// SkipConflicts がtrueなら主キーなどが重複する行を挿入しない（ON CONFLICT DO NOTHING）
+	SkipConflicts bool
+	// Checkpoint はチェックポイントのファイル（空なら保存しない）。ファイルがあれば続きから取り込む
+	Checkpoint string
+	// SourceName はチェックポイントに記録する入力の名前
+	SourceName string
+	// Logf は進み具合を表示する
+	Logf func(format string, args ...any)
+}
+
+// Stats は取り込みの結果
+type Stats struct {
+	Records  int64 // 読んだレコード数
+	Inserted int64 // 挿入した行数（SkipConflictsで飛ばした行は含まない）
+	Batches  int64 // 実行したバッチ数
+	Resumed  int64 // チェックポイントで完了していたため読み飛ばしたレコード数
+	Elapsed  time.Duration
+}
+
+// importBatch はまとめて挿入するレコード
+type importBatch struct {
+	seq       int64
+	firstLine int
+	lastLine  int
+	rows      [][]any
+}
+
+// Run はsrcのレコードをすべて挿入する
+//
+// 失敗した場合もチェックポイントには完了したバッチが記録されているため、同じ設定で
+// もう一度実行すれば続きから取り込める。すべて完了したらチェックポイントを削除する。
+func (im *Importer) Run(ctx context.Context, src Source) (Stats, error) {
+	start := time.Now()
+	var stats Stats
+
+	columns, err := im.mapColumns(ctx, src.Columns())
+	if err != nil {
+		return stats, err
+	}
+	batchRows := min(im.BatchRows, MaxRowsPerTx, maxParams/len(columns))
+	batchBytes := min(im.BatchBytes, MaxBytesPerTx)
+	if batchRows < 1 || batchBytes < 1 {
+		return stats, errors.New("batch size must be positive")
+	}
+
+	cp := &Checkpoint{Source: im.SourceName, Table: im.Table, Columns: columns, BatchRows: batchRows, BatchBytes: batchBytes}
+	if im.Checkpoint != "" {
+		saved, err := LoadCheckpoint(im.Checkpoint)
+		if err != nil {
+			return stats, err
+		}
+		if saved != nil {
+			if err := saved.matches(cp); err != nil {
+				return stats, fmt.Errorf("checkpoint %s does not match this import (%v); delete it to start over", im.Checkpoint, err)
+			}
+			cp = saved
+			im.logf("⏩ チェックポイント %s から再開します (完了済み: %d行)", im.Checkpoint, cp.Rows)
+		}
+	}
+
+	// 前回までに完了したバッチ（cpは実行中に更新するため別に持つ）
+	resumed := &Checkpoint{DoneThrough: cp.DoneThrough, Done: slices.Clone(cp.Done)}
+
+	var mu sync.Mutex // cp、stats.Inserted、stats.Batchesとチェックポイントのファイル
+	lastLog := time.Now()
+	done := func(b *importBatch, inserted int64) error {
+		mu.Lock()
+		defer mu.Unlock()
+		stats.Inserted += inserted
+		stats.Batches++
+		cp.markDone(b.seq, inserted)
+		if time.Since(lastLog) >= 5*time.Second {
+			lastLog = time.Now()
+			im.logf("📦 %d行を挿入しました (%.0f行/秒)", stats.Inserted, float64(stats.Inserted)/time.Since(start).Seconds())
+		}
+		if im.Checkpoint == "" {
+			return nil
+		}
+		return cp.Save(im.Checkpoint)
+	}
+
+	table := identifier(im.Table).Sanitize()
+	g, gctx := errgroup.WithContext(ctx)
+	batches := make(chan *importBatch, max(im.Workers, 1))
+
+	g.Go(func() error {
+		defer close(batches)
+		var seq int64
+		var b *importBatch
+		size := 0
+		send := func() error {
+			seq++
+			b.seq = seq
+			if resumed.isDone(seq) {
+				stats.Resumed += int64(len(b.rows))
+			} else {
+				select {
+				case batches <- b:
+				case <-gctx.Done():
+					return gctx.Err()
+				}
+			}
+			b, size = nil, 0
+			return nil
+		}
+		for {
+			record, err := src.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			stats.Records++
+			if len(record) != len(columns) {
+				return fmt.Errorf("line %d: %d values for %d columns", src.Line(), len(record), len(columns))
+			}
+			n := recordSize(record)
+			if b != nil && (len(b.rows) >= batchRows || size+n > batchBytes) {
+				if err := send(); err != nil {
+					return err
+				}
+			}
+			if b == nil {
+				b = &importBatch{firstLine: src.Line()}
+			}
+			b.rows = append(b.rows, record)
+			b.lastLine = src.Line()
+			size += n
+		}
+		if b != nil {
+			return send()
+		}
+		return nil
+	})
+
+	for range max(im.Workers, 1) {
+		g.Go(func() error {
+			for b := range batches {
+				n, err := im.insert(gctx, table, columns, b.rows)
+				if err != nil {
+					return fmt.Errorf("batch %d (lines %d-%d): %w", b.seq, b.firstLine, b.lastLine, err)
+				}
+				if err := done(b, n); err != nil {
+					return fmt.Errorf("save checkpoint: %w", err)
+				}
+			}
+			return nil
+		})
+	}
+
+	err = g.Wait()
+	stats.Elapsed = time.Since(start)
+	if err != nil {
+		if im.Checkpoint != "" && (stats.Batches > 0 || cp.DoneThrough > 0) {
+			err = fmt.Errorf("%w (progress is saved in %s; run the same command again to resume)", err, im.Checkpoint)
+		}
+		return stats, err
+	}
+	if im.Checkpoint != "" {
+		if err := os.Remove(im.Checkpoint); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return stats, err
+		}
+	}
+	return stats, nil
+}
+
+// insert はrowsを1つのINSERT文で挿入し、挿入した行数を返す
+func (im *Importer) insert(ctx context.Context, table string, columns []string, rows [][]any) (int64, error) {
+	var sql strings.Builder
+	sql.WriteString("INSERT INTO " + table + " (")
+	for i, c := range columns {
+		if i > 0 {
+			sql.WriteString(", ")
+		}
+		sql.WriteString(pgx.Identifier{c}.Sanitize())
+	}
+	sql.WriteString(") VALUES ")
+
+	args := make([]any, 0, len(rows)*len(columns))
+	for i, row := range rows {
+		if i > 0 {
+			sql.WriteString(", ")
+		}
+		sql.WriteByte('(')
+		for j, v := range row {
+			if j > 0 {
+				sql.WriteString(", ")
+			}
+			if v == Default {
+				sql.WriteString("DEFAULT")
+				continue
+			}
+			// 値はすべて文字列（またはNULL）で、テキスト形式で送るため列の型への変換はサーバーが行う
+			args = append(args, v)
+			sql.WriteString("$" + strconv.Itoa(len(args)))
+		}
+		sql.WriteByte(')')
+	}
+	if im.SkipConflicts {
+		sql.WriteString(" ON CONFLICT DO NOTHING")
+	}
+
+	var inserted int64
+	attempts, err := dsqltx.Retry(ctx, func(ctx context.Context) error {
+		tag, err := im.Pool.Exec(ctx, sql.String(), args...)
+		inserted = tag.RowsAffected()
+		return err
+	})
+	if attempts > 1 && err == nil {
+		im.logf("🔁 競合のためバッチを再試行しました (試行回数: %d)", attempts)
+	}
+	return inserted, err
+}
+
+// mapColumns は入力の列名をテーブルの列名に対応付ける
+//
+// 一致する列がなければ大文字小文字を区別せずに探す（見出しが User_ID でも user_id 列に入る）。
+func (im *Importer) mapColumns(ctx context.Context, names []string) ([]string, error) {
+	if len(names) == 0 {
+		return nil, errors.New("no columns in the input")
+	}
+	tableColumns, err := TableColumns(ctx, im.Pool, im.Table)
+	if err != nil {
+		return nil, err
+	}
+	columns := make([]string, len(names))
+	var unknown []string
+	for i, name := range names {
+		if name == "" {
+			return nil, fmt.Errorf("column %d of the input has no name", i+1)
+		}
+		j := slices.Index(tableColumns, name)
+		if j < 0 {
+			j = slices.IndexFunc(tableColumns, func(c string) bool { return strings.EqualFold(c, name) })
+		}
+		if j < 0 {
+			unknown = append(unknown, name)
+			continue
+		}
+		if slices.Contains(columns, tableColumns[j]) {
+			return nil, fmt.Errorf("column %s appears twice in the input", tableColumns[j])
+		}
+		columns[i] = tableColumns[j]
+	}
+	if len(unknown) > 0 {
+		return nil, fmt.Errorf("table %s has no column %s (columns: %s)", im.Table, strings.Join(unknown, ", "), strings.Join(tableColumns, ", "))
+	}
+	return columns, nil
+}
+
+func (im *Importer) logf(format string, args ...any) {
+	if im.Logf != nil {
+		im.Logf(format, args...)
+	}
+}
+
+// TableColumns はテーブルの列名を定義順に返す
+func TableColumns(ctx context.Context, db interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}, table string) ([]string, error) {
+	id := identifier(table)
+	rows, err := db.Query(ctx, `
+		SELECT column_name FROM information_schema.columns
+		WHERE table_schema = $1 AND table_name = $2
+		ORDER BY ordinal_position`, id[0], id[1])
+	if err != nil {
+		return nil, err
+	}
+	columns, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return nil, err
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("table %s not found", table)
+	}
+	return columns, nil
+}
+
+// identifier はschema.table の形式のテーブル名を分ける（スキーマを省略するとpublic）
+func identifier(table string) pgx.Identifier {
+	if schema, name, ok := strings.Cut(table, "."); ok {
+		return pgx.Identifier{schema, name}
+	}
+	return pgx.Identifier{"public", table}
+}
+
+// recordSize はレコードの値の大きさの目安を返す
+func recordSize(record []any) int {
+	n := 0
+	for _, v := range record {
+		if s, ok := v.(string); ok {
+			n += len(s)
+		}
+		n += 8 // NULLなどを含む列ごとのおおよその負担
+	}
+	return n
+}
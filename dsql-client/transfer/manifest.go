@@ -0,0 +1,166 @@
+package transfer
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Compression はデータファイルの圧縮形式
+type Compression string
+
+const (
+	NoCompression Compression = "none"
+	Gzip          Compression = "gzip"
+)
+
+// CompressionFromPath は拡張子から圧縮形式を推測する
+func CompressionFromPath(path string) Compression {
+	if strings.EqualFold(filepath.Ext(path), ".gz") {
+		return Gzip
+	}
+	return NoCompression
+}
+
+// ParseCompression はフラグの値をCompressionに変換する
+func ParseCompression(s string) (Compression, error) {
+	switch c := Compression(strings.ToLower(s)); c {
+	case NoCompression, Gzip:
+		return c, nil
+	}
+	return "", fmt.Errorf("unknown compression %q (none or gzip)", s)
+}
+
+// Decompress はrがgzipで圧縮されていれば展開するReaderを返す（先頭のバイトで判定する）
+func Decompress(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(2)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		return gzip.NewReader(br)
+	}
+	return br, nil
+}
+
+// Manifest はexportで書き出したデータファイルの内容と検査用の情報
+type Manifest struct {
+	// File はデータファイルのパス（相対パスの場合はマニフェストのディレクトリから）。
+	// 標準出力に書き出した場合は空
+	File        string      `json:"file,omitempty"`
+	Format      Format      `json:"format"`
+	Compression Compression `json:"compression"`
+	Table       string      `json:"table,omitempty"`
+	Query       string      `json:"query,omitempty"`
+	Columns     []string    `json:"columns"`
+	// Key はページ分割に使った列
+	Key []string `json:"key,omitempty"`
+	// Null はCSVでNULLを表す値。空の場合は空のフィールドがNULL
+	Null string `json:"null,omitempty"`
+	Rows int64  `json:"rows"`
+	// Bytes と SHA256 はデータファイル（圧縮後）の大きさとSHA-256
+	Bytes     int64     `json:"bytes"`
+	SHA256    string    `json:"sha256"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// LoadManifest はpathのマニフェストを読む
+func LoadManifest(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("invalid manifest %s: %w", path, err)
+	}
+	return &m, nil
+}
+
+// Save はマニフェストをpathに書き込む
+func (m *Manifest) Save(path string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// DataPath はマニフェストがpathにある場合のデータファイルのパスを返す
+func (m *Manifest) DataPath(path string) string {
+	if filepath.IsAbs(m.File) {
+		return m.File
+	}
+	return filepath.Join(filepath.Dir(path), m.File)
+}
+
+// SetDataPath はデータファイルのパスを、マニフェストのディレクトリからの相対パスで記録する
+func (m *Manifest) SetDataPath(manifestPath, dataPath string) {
+	m.File = dataPath
+	dir, err := filepath.Abs(filepath.Dir(manifestPath))
+	if err != nil {
+		return
+	}
+	abs, err := filepath.Abs(dataPath)
+	if err != nil {
+		return
+	}
+	m.File = abs
+	if rel, err := filepath.Rel(dir, abs); err == nil {
+		m.File = rel
+	}
+}
+
+// Verify はファイルの大きさとSHA-256がマニフェストと一致するかどうかを確認する
+func (m *Manifest) Verify(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	c := NewChecksum(io.Discard)
+	if _, err := io.Copy(c, f); err != nil {
+		return err
+	}
+	if c.Bytes() != m.Bytes {
+		return fmt.Errorf("%s is %d bytes, but the manifest says %d", path, c.Bytes(), m.Bytes)
+	}
+	if c.Sum() != m.SHA256 {
+		return fmt.Errorf("%s has SHA-256 %s, but the manifest says %s", path, c.Sum(), m.SHA256)
+	}
+	return nil
+}
+
+// Checksum は書き込んだバイト数とSHA-256を数えながらwに書き込む
+type Checksum struct {
+	w io.Writer
+	h hash.Hash
+	n int64
+}
+
+func NewChecksum(w io.Writer) *Checksum {
+	return &Checksum{w: w, h: sha256.New()}
+}
+
+func (c *Checksum) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.h.Write(p[:n])
+	c.n += int64(n)
+	return n, err
+}
+
+// Bytes は書き込んだバイト数を返す
+func (c *Checksum) Bytes() int64 { return c.n }
+
+// Sum はSHA-256を16進数で返す
+func (c *Checksum) Sum() string { return hex.EncodeToString(c.h.Sum(nil)) }
@@ -0,0 +1,390 @@
+package transfer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"dsql-client/resultfmt"
+)
+
+// Parquetの形式の定数（parquet.thriftの列挙型の値）
+const (
+	parquetBoolean   = 0
+	parquetInt32     = 1
+	parquetInt64     = 2
+	parquetFloat     = 4
+	parquetDouble    = 5
+	parquetByteArray = 6
+
+	parquetUTF8            = 0
+	parquetDate            = 6
+	parquetTimestampMicros = 10
+	parquetInt16           = 16
+
+	parquetOptional    = 1
+	parquetPlain       = 0
+	parquetRLE         = 3
+	parquetGzip        = 2
+	parquetDataPage    = 0
+	parquetNoConverted = -1
+)
+
+// parquetMagic はParquetのファイルの先頭と末尾に置く印
+const parquetMagic = "PAR1"
+
+// parquetRowGroupSize は1つの行グループに溜める値の大きさの目安
+const parquetRowGroupSize = 64 << 20
+
+// parquetType はParquetの列の型と、pgxの値をその型のPLAIN形式にする関数
+type parquetType struct {
+	physical  int32
+	converted int32                                // ConvertedType（なければparquetNoConverted）
+	logical   func(t *thriftWriter)                // LogicalTypeの中身を書く（なければnil）
+	append    func(b []byte, v any) ([]byte, bool) // 変換できなければfalse
+}
+
+// parquetTypeFor は型OIDの列をParquetで表す型を返す
+//
+// 整数・浮動小数点数・真偽値・日付・日時は対応する型に、byteaはバイト列にする。それ以外
+// （numeric、uuid、json、intervalなど）はresultfmt.Textの文字列にする。
+func parquetTypeFor(oid uint32) parquetType {
+	switch oid {
+	case pgtype.Int2OID:
+		return parquetType{parquetInt32, parquetInt16, func(t *thriftWriter) {
+			t.begin(10) // INTEGER
+			t.i8(1, 16)
+			t.bool(2, true)
+			t.end()
+		}, func(b []byte, v any) ([]byte, bool) {
+			i, ok := v.(int16)
+			return binary.LittleEndian.AppendUint32(b, uint32(int32(i))), ok
+		}}
+	case pgtype.Int4OID:
+		return parquetType{parquetInt32, parquetNoConverted, nil, func(b []byte, v any) ([]byte, bool) {
+			i, ok := v.(int32)
+			return binary.LittleEndian.AppendUint32(b, uint32(i)), ok
+		}}
+	case pgtype.Int8OID:
+		return parquetType{parquetInt64, parquetNoConverted, nil, func(b []byte, v any) ([]byte, bool) {
+			i, ok := v.(int64)
+			return binary.LittleEndian.AppendUint64(b, uint64(i)), ok
+		}}
+	case pgtype.Float4OID:
+		return parquetType{parquetFloat, parquetNoConverted, nil, func(b []byte, v any) ([]byte, bool) {
+			f, ok := v.(float32)
+			return binary.LittleEndian.AppendUint32(b, math.Float32bits(f)), ok
+		}}
+	case pgtype.Float8OID:
+		return parquetType{parquetDouble, parquetNoConverted, nil, func(b []byte, v any) ([]byte, bool) {
+			f, ok := v.(float64)
+			return binary.LittleEndian.AppendUint64(b, math.Float64bits(f)), ok
+		}}
+	case pgtype.BoolOID:
+		// 1値1バイトで溜め、ページを書くときにビットに詰める
+		return parquetType{parquetBoolean, parquetNoConverted, nil, func(b []byte, v any) ([]byte, bool) {
+			x, ok := v.(bool)
+			if x {
+				return append(b, 1), ok
+			}
+			return append(b, 0), ok
+		}}
+	case pgtype.DateOID:
+		// 1970-01-01からの日数。infinityはtime.Timeにならないため書き出せない
+		return parquetType{parquetInt32, parquetDate, func(t *thriftWriter) {
+			t.begin(6) // DATE
+			t.end()
+		}, func(b []byte, v any) ([]byte, bool) {
+			d, ok := v.(time.Time)
+			return binary.LittleEndian.AppendUint32(b, uint32(int32(d.Unix()/(24*60*60)))), ok
+		}}
+	case pgtype.TimestamptzOID, pgtype.TimestampOID:
+		// timestampはタイムゾーンなしの壁時計の時刻をUTCとして数える（isAdjustedToUTC=false）。
+		// ConvertedTypeのTIMESTAMP_MICROSはUTCの時刻を表すため、timestamptzだけに付ける
+		utc := oid == pgtype.TimestamptzOID
+		converted := int32(parquetNoConverted)
+		if utc {
+			converted = parquetTimestampMicros
+		}
+		return parquetType{parquetInt64, converted, func(t *thriftWriter) {
+			t.begin(8) // TIMESTAMP
+			t.bool(1, utc)
+			t.begin(2) // unit
+			t.begin(2) // MICROS
+			t.end()
+			t.end()
+			t.end()
+		}, func(b []byte, v any) ([]byte, bool) {
+			ts, ok := v.(time.Time)
+			return binary.LittleEndian.AppendUint64(b, uint64(ts.UnixMicro())), ok
+		}}
+	case pgtype.ByteaOID:
+		return parquetType{parquetByteArray, parquetNoConverted, nil, func(b []byte, v any) ([]byte, bool) {
+			x, ok := v.([]byte)
+			b = binary.LittleEndian.AppendUint32(b, uint32(len(x)))
+			return append(b, x...), ok
+		}}
+	}
+	return parquetType{parquetByteArray, parquetUTF8, func(t *thriftWriter) {
+		t.begin(1) // STRING
+		t.end()
+	}, func(b []byte, v any) ([]byte, bool) {
+		s, ok := resultfmt.Text(v)
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(s)))
+		return append(b, s...), ok
+	}}
+}
+
+// parquetColumn は行グループに溜めている列の値
+type parquetColumn struct {
+	name    string
+	typ     parquetType
+	defined []bool // 行ごとにNULLでなければtrue
+	values  []byte // NULLでない値のPLAIN形式
+}
+
+// parquetChunk は書き出した列チャンクの位置と大きさ
+type parquetChunk struct {
+	offset       int64
+	values       int64
+	uncompressed int64
+	compressed   int64
+}
+
+// parquetRowGroup は書き出した行グループ
+type parquetRowGroup struct {
+	rows   int64
+	chunks []parquetChunk
+}
+
+// parquetWriter はresultfmt.WriterとしてParquetのファイルを書き出す
+//
+// 列はすべてNULLを許し、行グループの列ごとに1つのデータページ（PLAIN形式、gzipで圧縮）を書く。
+// 値はrowGroupSizeまでメモリーに溜めてから書き出す。ファイルはFlushでフッターを書いて完成する。
+type parquetWriter struct {
+	w            io.Writer
+	offset       int64
+	oids         []uint32
+	columns      []*parquetColumn
+	rows         int64 // 溜めている行数
+	size         int   // 溜めている値の大きさ
+	rowGroupSize int
+	groups       []parquetRowGroup
+}
+
+// newParquetWriter は型OIDがoidsの列を書き出すparquetWriterを返す
+func newParquetWriter(w io.Writer, oids []uint32) *parquetWriter {
+	return &parquetWriter{w: w, oids: oids, rowGroupSize: parquetRowGroupSize}
+}
+
+func (p *parquetWriter) WriteHeader(columns []string) error {
+	if len(columns) != len(p.oids) {
+		return fmt.Errorf("%d columns for %d types", len(columns), len(p.oids))
+	}
+	for i, name := range columns {
+		if slices.Index(columns, name) != i {
+			return fmt.Errorf("duplicate column %q cannot be written to parquet; rename it with AS in -query", name)
+		}
+		p.columns = append(p.columns, &parquetColumn{name: name, typ: parquetTypeFor(p.oids[i])})
+	}
+	return p.write([]byte(parquetMagic))
+}
+
+func (p *parquetWriter) WriteRow(values []any) error {
+	for i, v := range values {
+		c := p.columns[i]
+		c.defined = append(c.defined, v != nil)
+		if v == nil {
+			continue
+		}
+		n := len(c.values)
+		var ok bool
+		if c.values, ok = c.typ.append(c.values, v); !ok {
+			return fmt.Errorf("column %s: value %v (%T) cannot be written to parquet", c.name, v, v)
+		}
+		p.size += len(c.values) - n
+	}
+	p.rows++
+	if p.size >= p.rowGroupSize {
+		return p.writeRowGroup()
+	}
+	return nil
+}
+
+// Flush は残りの行とフッターを書き出す
+func (p *parquetWriter) Flush() error {
+	if err := p.writeRowGroup(); err != nil {
+		return err
+	}
+	footer := p.footer()
+	footer = binary.LittleEndian.AppendUint32(footer, uint32(len(footer)))
+	return p.write(append(footer, parquetMagic...))
+}
+
+func (p *parquetWriter) write(b []byte) error {
+	n, err := p.w.Write(b)
+	p.offset += int64(n)
+	return err
+}
+
+// writeRowGroup は溜めている行を1つの行グループとして書き出す
+func (p *parquetWriter) writeRowGroup() error {
+	if p.rows == 0 {
+		return nil
+	}
+	g := parquetRowGroup{rows: p.rows}
+	for _, c := range p.columns {
+		chunk, err := p.writeChunk(c)
+		if err != nil {
+			return err
+		}
+		g.chunks = append(g.chunks, chunk)
+		c.defined, c.values = c.defined[:0], c.values[:0]
+	}
+	p.groups = append(p.groups, g)
+	p.rows, p.size = 0, 0
+	return nil
+}
+
+// writeChunk は列の値を1つのデータページとして書き出す
+func (p *parquetWriter) writeChunk(c *parquetColumn) (parquetChunk, error) {
+	// 定義レベル（NULLでなければ1）はRLEとビットパックの混合形式で、長さを前に付ける
+	levels := binary.AppendUvarint(nil, uint64((len(c.defined)+7)/8)<<1|1)
+	levels = appendBits(levels, c.defined)
+	data := binary.LittleEndian.AppendUint32(nil, uint32(len(levels)))
+	data = append(data, levels...)
+	if c.typ.physical == parquetBoolean {
+		data = appendBits(data, byteBools(c.values))
+	} else {
+		data = append(data, c.values...)
+	}
+
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	if _, err := zw.Write(data); err != nil {
+		return parquetChunk{}, err
+	}
+	if err := zw.Close(); err != nil {
+		return parquetChunk{}, err
+	}
+
+	var h thriftWriter
+	h.push()
+	h.i32(1, parquetDataPage)
+	h.i32(2, int32(len(data)))
+	h.i32(3, int32(compressed.Len()))
+	h.begin(5) // data_page_header
+	h.i32(1, int32(len(c.defined)))
+	h.i32(2, parquetPlain)
+	h.i32(3, parquetRLE)
+	h.i32(4, parquetRLE)
+	h.end()
+	h.end()
+
+	chunk := parquetChunk{
+		offset:       p.offset,
+		values:       int64(len(c.defined)),
+		uncompressed: int64(len(h.b) + len(data)),
+		compressed:   int64(len(h.b) + compressed.Len()),
+	}
+	if err := p.write(h.b); err != nil {
+		return chunk, err
+	}
+	return chunk, p.write(compressed.Bytes())
+}
+
+// footer はFileMetaDataを返す
+func (p *parquetWriter) footer() []byte {
+	var t thriftWriter
+	t.push()
+	t.i32(1, 1) // version
+
+	t.list(2, thriftStruct, len(p.columns)+1) // schema
+	t.push()
+	t.string(4, "schema")
+	t.i32(5, int32(len(p.columns)))
+	t.end()
+	for _, c := range p.columns {
+		t.push()
+		t.i32(1, c.typ.physical)
+		t.i32(3, parquetOptional)
+		t.string(4, c.name)
+		if c.typ.converted != parquetNoConverted {
+			t.i32(6, c.typ.converted)
+		}
+		if c.typ.logical != nil {
+			t.begin(10)
+			c.typ.logical(&t)
+			t.end()
+		}
+		t.end()
+	}
+
+	var rows int64
+	for _, g := range p.groups {
+		rows += g.rows
+	}
+	t.i64(3, rows)
+
+	t.list(4, thriftStruct, len(p.groups)) // row_groups
+	for _, g := range p.groups {
+		t.push()
+		var size int64
+		t.list(1, thriftStruct, len(g.chunks))
+		for i, chunk := range g.chunks {
+			c := p.columns[i]
+			size += chunk.uncompressed
+			t.push()
+			t.i64(2, chunk.offset) // file_offset
+			t.begin(3)             // meta_data
+			t.i32(1, c.typ.physical)
+			t.list(2, thriftI32, 2)
+			t.listI32(parquetPlain)
+			t.listI32(parquetRLE)
+			t.list(3, thriftBinary, 1)
+			t.listString(c.name)
+			t.i32(4, parquetGzip)
+			t.i64(5, chunk.values)
+			t.i64(6, chunk.uncompressed)
+			t.i64(7, chunk.compressed)
+			t.i64(9, chunk.offset) // data_page_offset
+			t.end()
+			t.end()
+		}
+		t.i64(2, size)
+		t.i64(3, g.rows)
+		t.end()
+	}
+	t.string(6, "dsql-client")
+	t.end()
+	return t.b
+}
+
+// appendBits はbitsを下位ビットから順に8個ずつ1バイトに詰める
+func appendBits(b []byte, bits []bool) []byte {
+	for i := 0; i < len(bits); i += 8 {
+		var x byte
+		for j := i; j < i+8 && j < len(bits); j++ {
+			if bits[j] {
+				x |= 1 << (j - i)
+			}
+		}
+		b = append(b, x)
+	}
+	return b
+}
+
+func byteBools(b []byte) []bool {
+	bools := make([]bool, len(b))
+	for i, x := range b {
+		bools[i] = x != 0
+	}
+	return bools
+}
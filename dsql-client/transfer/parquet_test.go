@@ -0,0 +1,311 @@
+package transfer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// thriftReader はテストで書き出したメタデータを読む。構造体はフィールドIDごとの値、
+// リストは[]any、整数はint64、文字列は[]byteになる
+type thriftReader struct {
+	b []byte
+}
+
+func (r *thriftReader) byte() byte {
+	c := r.b[0]
+	r.b = r.b[1:]
+	return c
+}
+
+func (r *thriftReader) uvarint() uint64 {
+	v, n := binary.Uvarint(r.b)
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *thriftReader) varint() int64 {
+	v, n := binary.Varint(r.b)
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *thriftReader) value(typ byte) any {
+	switch typ {
+	case thriftTrue:
+		return true
+	case thriftFalse:
+		return false
+	case thriftByte:
+		return int64(int8(r.byte()))
+	case thriftI32, thriftI64:
+		return r.varint()
+	case thriftBinary:
+		n := r.uvarint()
+		s := r.b[:n]
+		r.b = r.b[n:]
+		return s
+	case thriftList:
+		h := r.byte()
+		n, elem := uint64(h>>4), h&0x0f
+		if n == 15 {
+			n = r.uvarint()
+		}
+		list := make([]any, n)
+		for i := range list {
+			if elem == thriftTrue {
+				list[i] = r.byte() == thriftTrue
+			} else {
+				list[i] = r.value(elem)
+			}
+		}
+		return list
+	case thriftStruct:
+		return r.structure()
+	}
+	panic(fmt.Sprintf("thrift type %d", typ))
+}
+
+func (r *thriftReader) structure() map[int16]any {
+	s := map[int16]any{}
+	var last int16
+	for {
+		h := r.byte()
+		if h == 0 {
+			return s
+		}
+		typ := h & 0x0f
+		if d := int16(h >> 4); d != 0 {
+			last += d
+		} else {
+			last = int16(r.varint())
+		}
+		s[last] = r.value(typ)
+	}
+}
+
+// readParquet はparquetWriterが書き出したファイルを列名ごとの値（NULLはnil）にする
+func readParquet(t *testing.T, file []byte) (meta map[int16]any, columns map[string][]any) {
+	t.Helper()
+	if !bytes.HasPrefix(file, []byte(parquetMagic)) || !bytes.HasSuffix(file, []byte(parquetMagic)) {
+		t.Fatalf("file does not start and end with %s", parquetMagic)
+	}
+	n := binary.LittleEndian.Uint32(file[len(file)-8:])
+	footer := &thriftReader{file[len(file)-8-int(n) : len(file)-8]}
+	meta = footer.structure()
+	if len(footer.b) != 0 {
+		t.Fatalf("%d bytes left after the footer", len(footer.b))
+	}
+
+	schema := meta[2].([]any)[1:]
+	columns = map[string][]any{}
+	for _, g := range meta[4].([]any) {
+		for i, c := range g.(map[int16]any)[1].([]any) {
+			cm := c.(map[int16]any)[3].(map[int16]any)
+			name := string(cm[3].([]any)[0].([]byte))
+			if got := string(schema[i].(map[int16]any)[4].([]byte)); got != name {
+				t.Fatalf("column chunk %d is %s, schema has %s", i, name, got)
+			}
+			page := &thriftReader{file[cm[9].(int64):]}
+			h := page.structure()
+			size := int(h[3].(int64))
+			if int64(len(file)-len(page.b)+size) != cm[9].(int64)+cm[7].(int64) {
+				t.Fatalf("%s: total_compressed_size does not match the page", name)
+			}
+			zr, err := gzip.NewReader(bytes.NewReader(page.b[:size]))
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := io.ReadAll(zr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(data) != int(h[2].(int64)) {
+				t.Fatalf("%s: uncompressed_page_size %d, got %d bytes", name, h[2], len(data))
+			}
+			columns[name] = append(columns[name], decodePage(t, cm[1].(int64), h[5].(map[int16]any)[1].(int64), data)...)
+		}
+	}
+	return meta, columns
+}
+
+// decodePage はPLAIN形式のデータページの値を読む
+func decodePage(t *testing.T, physical, count int64, data []byte) []any {
+	t.Helper()
+	n := binary.LittleEndian.Uint32(data)
+	levels := &thriftReader{data[4 : 4+n]}
+	header := levels.uvarint()
+	if header&1 != 1 || header>>1 != uint64(count+7)/8 {
+		t.Fatalf("definition levels header %d for %d values", header, count)
+	}
+	bit := func(b []byte, i int) bool { return b[i/8]&(1<<(i%8)) != 0 }
+	values := data[4+n:]
+	var out []any
+	var j int // NULLでない値の番号
+	for i := 0; i < int(count); i++ {
+		if !bit(levels.b, i) {
+			out = append(out, nil)
+			continue
+		}
+		switch physical {
+		case parquetBoolean:
+			out = append(out, bit(values, j))
+		case parquetInt32:
+			out = append(out, int32(binary.LittleEndian.Uint32(values)))
+			values = values[4:]
+		case parquetInt64:
+			out = append(out, int64(binary.LittleEndian.Uint64(values)))
+			values = values[8:]
+		case parquetFloat:
+			out = append(out, math.Float32frombits(binary.LittleEndian.Uint32(values)))
+			values = values[4:]
+		case parquetDouble:
+			out = append(out, math.Float64frombits(binary.LittleEndian.Uint64(values)))
+			values = values[8:]
+		case parquetByteArray:
+			l := binary.LittleEndian.Uint32(values)
+			out = append(out, string(values[4:4+l]))
+			values = values[4+l:]
+		}
+		j++
+	}
+	return out
+}
+
+func TestParquetWriter(t *testing.T) {
+	columns := []string{"id", "small", "price", "ratio", "active", "day", "created_at", "local_at", "data", "note", "amount"}
+	oids := []uint32{pgtype.Int8OID, pgtype.Int2OID, pgtype.Float8OID, pgtype.Float4OID, pgtype.BoolOID, pgtype.DateOID,
+		pgtype.TimestamptzOID, pgtype.TimestampOID, pgtype.ByteaOID, pgtype.TextOID, pgtype.NumericOID}
+	created := time.Date(2025, 1, 2, 3, 4, 5, 600000000, time.UTC)
+	var amount pgtype.Numeric
+	if err := amount.Scan("12.50"); err != nil {
+		t.Fatal(err)
+	}
+	rows := [][]any{
+		{int64(9007199254740993), int16(-2), 3.5, float32(0.25), true, time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC),
+			created, created, []byte{0xde, 0xad}, "東京", amount},
+		{int64(2), nil, nil, nil, false, time.Date(1969, 12, 31, 0, 0, 0, 0, time.UTC), nil, nil, nil, "", nil},
+		{int64(3), int16(7), -1.0, float32(2), nil, nil, created.In(time.FixedZone("JST", 9*60*60)), nil, []byte{}, nil, nil},
+	}
+
+	// 行グループの大きさを小さくして、複数の行グループに分かれる場合も確かめる
+	for _, size := range []int{parquetRowGroupSize, 1} {
+		var buf bytes.Buffer
+		w := newParquetWriter(&buf, oids)
+		w.rowGroupSize = size
+		if err := w.WriteHeader(columns); err != nil {
+			t.Fatal(err)
+		}
+		for _, row := range rows {
+			if err := w.WriteRow(row); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := w.Flush(); err != nil {
+			t.Fatal(err)
+		}
+
+		meta, got := readParquet(t, buf.Bytes())
+		if meta[3] != int64(len(rows)) {
+			t.Errorf("num_rows = %v, want %d", meta[3], len(rows))
+		}
+		wantGroups := 1
+		if size == 1 {
+			wantGroups = len(rows)
+		}
+		if groups := len(meta[4].([]any)); groups != wantGroups {
+			t.Errorf("row group size %d: %d row groups, want %d", size, groups, wantGroups)
+		}
+		want := map[string][]any{
+			"id":         {int64(9007199254740993), int64(2), int64(3)},
+			"small":      {int32(-2), nil, int32(7)},
+			"price":      {3.5, nil, -1.0},
+			"ratio":      {float32(0.25), nil, float32(2)},
+			"active":     {true, false, nil},
+			"day":        {int32(20090), int32(-1), nil},
+			"created_at": {created.UnixMicro(), nil, created.UnixMicro()},
+			"local_at":   {created.UnixMicro(), nil, nil},
+			"data":       {"\xde\xad", nil, ""},
+			"note":       {"東京", "", nil},
+			"amount":     {"12.50", nil, nil},
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("row group size %d: values\n got %v\nwant %v", size, got, want)
+		}
+	}
+}
+
+func TestParquetSchema(t *testing.T) {
+	var buf bytes.Buffer
+	w := newParquetWriter(&buf, []uint32{pgtype.Int2OID, pgtype.TextOID, pgtype.DateOID, pgtype.TimestamptzOID, pgtype.TimestampOID, pgtype.UUIDOID})
+	if err := w.WriteHeader([]string{"a", "b", "c", "d", "e", "f"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	meta, got := readParquet(t, buf.Bytes())
+	if meta[3] != int64(0) || len(meta[4].([]any)) != 0 || len(got) != 0 {
+		t.Errorf("empty result: num_rows %v, row groups %v", meta[3], meta[4])
+	}
+
+	schema := meta[2].([]any)
+	if root := schema[0].(map[int16]any); string(root[4].([]byte)) != "schema" || root[5] != int64(6) {
+		t.Errorf("root = %v", root)
+	}
+	tests := []struct {
+		physical  int64
+		converted any           // nilならConvertedTypeなし
+		logical   map[int16]any // LogicalTypeの共用体
+	}{
+		{parquetInt32, int64(parquetInt16), map[int16]any{10: map[int16]any{1: int64(16), 2: true}}},
+		{parquetByteArray, int64(parquetUTF8), map[int16]any{1: map[int16]any{}}},
+		{parquetInt32, int64(parquetDate), map[int16]any{6: map[int16]any{}}},
+		{parquetInt64, int64(parquetTimestampMicros), map[int16]any{8: map[int16]any{1: true, 2: map[int16]any{2: map[int16]any{}}}}},
+		{parquetInt64, nil, map[int16]any{8: map[int16]any{1: false, 2: map[int16]any{2: map[int16]any{}}}}},
+		{parquetByteArray, int64(parquetUTF8), map[int16]any{1: map[int16]any{}}},
+	}
+	for i, tt := range tests {
+		e := schema[i+1].(map[int16]any)
+		if e[1] != tt.physical || e[3] != int64(parquetOptional) || e[6] != tt.converted || !reflect.DeepEqual(e[10], tt.logical) {
+			t.Errorf("schema element %s = %v, want type %d converted %v logical %v", e[4], e, tt.physical, tt.converted, tt.logical)
+		}
+	}
+}
+
+func TestParquetWriterErrors(t *testing.T) {
+	w := newParquetWriter(io.Discard, []uint32{pgtype.Int8OID, pgtype.TextOID, pgtype.Int8OID})
+	if err := w.WriteHeader([]string{"id", "name", "id"}); err == nil || !strings.Contains(err.Error(), `duplicate column "id"`) {
+		t.Errorf("duplicate columns: %v", err)
+	}
+
+	// infinityはtime.Timeにならないため書き出せない
+	w = newParquetWriter(io.Discard, []uint32{pgtype.TimestamptzOID})
+	if err := w.WriteHeader([]string{"at"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteRow([]any{pgtype.Infinity}); err == nil || !strings.Contains(err.Error(), "column at") {
+		t.Errorf("infinity: %v", err)
+	}
+}
+
+func TestFormatFromPathParquet(t *testing.T) {
+	if f := FormatFromPath("out/clicks.parquet"); f != Parquet {
+		t.Errorf("FormatFromPath = %q, want parquet", f)
+	}
+	if f, err := ParseFormat("Parquet"); f != Parquet || err != nil {
+		t.Errorf("ParseFormat = %q, %v", f, err)
+	}
+	if _, err := NewSource(strings.NewReader(""), Parquet, ""); err == nil {
+		t.Error("NewSource accepted parquet")
+	}
+}
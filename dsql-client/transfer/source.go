@@ -0,0 +1,282 @@
+// Package transfer はCSVやJSON LinesのファイルとDSQLのテーブルの間でデータを移す
+package transfer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// Format はファイルの形式
+type Format string
+
+const (
+	CSV     Format = "csv"
+	JSONL   Format = "jsonl"
+	Parquet Format = "parquet" // 書き出し専用
+)
+
+// DefaultNull はexportとimportがCSVでNULLを表す既定の値（COPYのtext形式と同じ）
+//
+// 空のフィールドは空文字列になるため、NULLと空文字列を区別できる。
+const DefaultNull = `\N`
+
+// FormatFromPath は拡張子からファイルの形式を推測する（.jsonl、.ndjson はJSONL、.parquet はParquet、それ以外はCSV）
+//
+// 圧縮の拡張子（.gz）は無視する。
+func FormatFromPath(path string) Format {
+	if CompressionFromPath(path) == Gzip {
+		path = path[:len(path)-len(filepath.Ext(path))]
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".jsonl", ".ndjson":
+		return JSONL
+	case ".parquet":
+		return Parquet
+	}
+	return CSV
+}
+
+// ParseFormat はフラグの値をFormatに変換する
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(strings.ToLower(s)); f {
+	case CSV, JSONL, Parquet:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown format %q (csv, jsonl or parquet)", s)
+}
+
+// Default は列の既定値（DEFAULT）を使うことを表す値
+//
+// JSON Linesで、最初のレコードにあるキーが後のレコードにない場合に使う。
+var Default any = defaultValue{}
+
+type defaultValue struct{}
+
+// Source は取り込むレコードを先頭から1件ずつ返す
+type Source interface {
+	// Columns は列名を返す
+	Columns() []string
+	// Next は次のレコードを返す。値はstring、nil（NULL）、Defaultのいずれか。
+	// 終わりに達したらio.EOFを返す
+	Next() ([]any, error)
+	// Line は直前に読んだレコードの行番号を返す
+	Line() int
+}
+
+// NewSource はformatのSourceを返す
+//
+// nullはCSVでNULLとして扱う値（空文字列なら空のフィールドがNULLになる）。
+func NewSource(r io.Reader, format Format, null string) (Source, error) {
+	switch format {
+	case JSONL:
+		return newJSONLSource(r)
+	case Parquet:
+		return nil, errors.New("parquet files cannot be imported; export the data as csv or jsonl")
+	default:
+		return newCSVSource(r, null)
+	}
+}
+
+// csvSource は1行目を見出しとするCSVを読む
+type csvSource struct {
+	r       *csv.Reader
+	null    string
+	columns []string
+	line    int
+}
+
+func newCSVSource(r io.Reader, null string) (*csvSource, error) {
+	cr := csv.NewReader(r)
+	cr.ReuseRecord = true
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, errors.New("empty CSV: the first line must be a header")
+	}
+	if err != nil {
+		return nil, err
+	}
+	columns := make([]string, len(header))
+	for i, h := range header {
+		columns[i] = strings.TrimSpace(h)
+	}
+	columns[0] = strings.TrimPrefix(columns[0], "\ufeff") // Excelが付けるBOM
+	return &csvSource{r: cr, null: null, columns: columns, line: 1}, nil
+}
+
+func (s *csvSource) Columns() []string { return s.columns }
+
+func (s *csvSource) Line() int { return s.line }
+
+func (s *csvSource) Next() ([]any, error) {
+	record, err := s.r.Read()
+	if err != nil {
+		return nil, err
+	}
+	s.line, _ = s.r.FieldPos(0)
+	values := make([]any, len(record))
+	for i, v := range record {
+		if v == s.null {
+			values[i] = nil
+		} else {
+			values[i] = v
+		}
+	}
+	return values, nil
+}
+
+// jsonlSource は1行に1つのJSONオブジェクトを読む
+//
+// 列は最初のオブジェクトのキーの順になる。値はすべてテキストとして渡し、型の変換は
+// データベースに任せる（数値や真偽値はJSONの表記のまま、オブジェクトや配列はJSONの文字列）。
+type jsonlSource struct {
+	r       *bufio.Reader
+	columns []string
+	index   map[string]int
+	line    int
+	first   []any // 列を決めるために先に読んだ最初のレコード
+}
+
+func newJSONLSource(r io.Reader) (*jsonlSource, error) {
+	s := &jsonlSource{r: bufio.NewReaderSize(r, 1<<20), index: map[string]int{}}
+	for {
+		data, err := s.readLine()
+		if err == io.EOF {
+			return nil, errors.New("empty JSON Lines input")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(data) == 0 {
+			continue
+		}
+		keys, values, err := decodeObject(data)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", s.line, err)
+		}
+		for i, k := range keys {
+			s.index[k] = i
+		}
+		s.columns, s.first = keys, values
+		return s, nil
+	}
+}
+
+func (s *jsonlSource) Columns() []string { return s.columns }
+
+func (s *jsonlSource) Line() int { return s.line }
+
+func (s *jsonlSource) Next() ([]any, error) {
+	if s.first != nil {
+		values := s.first
+		s.first = nil
+		return values, nil
+	}
+	for {
+		data, err := s.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if len(data) == 0 {
+			continue
+		}
+		keys, values, err := decodeObject(data)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", s.line, err)
+		}
+		record := make([]any, len(s.columns))
+		for i := range record {
+			record[i] = Default
+		}
+		for i, k := range keys {
+			j, ok := s.index[k]
+			if !ok {
+				return nil, fmt.Errorf("line %d: key %q is not in the first record (the first record decides the columns)", s.line, k)
+			}
+			record[j] = values[i]
+		}
+		return record, nil
+	}
+}
+
+// readLine は次の行を読み、前後の空白を除いて返す
+func (s *jsonlSource) readLine() ([]byte, error) {
+	data, err := s.r.ReadBytes('\n')
+	if err == io.EOF && len(data) > 0 {
+		err = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.line++
+	return bytes.TrimSpace(data), nil
+}
+
+// decodeObject はJSONオブジェクトのキーと値をキーの出現順に返す
+func decodeObject(data []byte) ([]string, []any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if t, err := dec.Token(); err != nil || t != json.Delim('{') {
+		return nil, nil, errors.New("each line must be a JSON object")
+	}
+	var keys []string
+	var values []any
+	seen := map[string]bool{}
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return nil, nil, err
+		}
+		key := t.(string)
+		if seen[key] {
+			return nil, nil, fmt.Errorf("duplicate key %q", key)
+		}
+		seen[key] = true
+
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return nil, nil, err
+		}
+		v, err := jsonText(raw)
+		if err != nil {
+			return nil, nil, err
+		}
+		keys = append(keys, key)
+		values = append(values, v)
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, nil, err
+	}
+	if dec.More() {
+		return nil, nil, errors.New("extra data after the JSON object")
+	}
+	return keys, values, nil
+}
+
+// jsonText はJSONの値をデータベースに渡すテキストに変換する（nullはnil）
+func jsonText(raw json.RawMessage) (any, error) {
+	switch raw[0] {
+	case 'n':
+		return nil, nil
+	case '"':
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return nil, err
+		}
+		return s, nil
+	case '{', '[':
+		var b bytes.Buffer
+		if err := json.Compact(&b, raw); err != nil {
+			return nil, err
+		}
+		return b.String(), nil
+	default:
+		return string(raw), nil
+	}
+}
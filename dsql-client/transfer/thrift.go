@@ -0,0 +1,99 @@
+package transfer
+
+import "encoding/binary"
+
+// ThriftのCompactProtocolの型
+const (
+	thriftTrue   = 1
+	thriftFalse  = 2
+	thriftByte   = 3
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter はThriftのCompactProtocolで構造体を書く（Parquetのメタデータに使う）
+//
+// 構造体はbeginまたはpushで始めてendで終える。フィールドはID順でなくてもよい。
+type thriftWriter struct {
+	b    []byte
+	last []int16 // 書いている構造体ごとの直前のフィールドID
+}
+
+func (t *thriftWriter) field(id int16, typ byte) {
+	last := &t.last[len(t.last)-1]
+	if d := id - *last; d > 0 && d <= 15 {
+		t.b = append(t.b, byte(d)<<4|typ)
+	} else {
+		t.b = append(t.b, typ)
+		t.b = binary.AppendVarint(t.b, int64(id)) // zigzag
+	}
+	*last = id
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.field(id, thriftI32)
+	t.b = binary.AppendVarint(t.b, int64(v))
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.field(id, thriftI64)
+	t.b = binary.AppendVarint(t.b, v)
+}
+
+func (t *thriftWriter) i8(id int16, v int8) {
+	t.field(id, thriftByte)
+	t.b = append(t.b, byte(v))
+}
+
+func (t *thriftWriter) bool(id int16, v bool) {
+	if v {
+		t.field(id, thriftTrue)
+	} else {
+		t.field(id, thriftFalse)
+	}
+}
+
+func (t *thriftWriter) string(id int16, s string) {
+	t.field(id, thriftBinary)
+	t.listString(s)
+}
+
+// begin は構造体のフィールドを始める
+func (t *thriftWriter) begin(id int16) {
+	t.field(id, thriftStruct)
+	t.push()
+}
+
+// push は構造体を始める（最上位の構造体やリストの要素に使う）
+func (t *thriftWriter) push() {
+	t.last = append(t.last, 0)
+}
+
+// end は構造体を終える
+func (t *thriftWriter) end() {
+	t.b = append(t.b, 0)
+	t.last = t.last[:len(t.last)-1]
+}
+
+// list はn個の要素のリストのフィールドを始める。要素はlistI32、listString、pushとendで書く
+func (t *thriftWriter) list(id int16, typ byte, n int) {
+	t.field(id, thriftList)
+	if n < 15 {
+		t.b = append(t.b, byte(n)<<4|typ)
+	} else {
+		t.b = append(t.b, 0xf0|typ)
+		t.b = binary.AppendUvarint(t.b, uint64(n))
+	}
+}
+
+func (t *thriftWriter) listI32(v int32) {
+	t.b = binary.AppendVarint(t.b, int64(v))
+}
+
+func (t *thriftWriter) listString(s string) {
+	t.b = binary.AppendUvarint(t.b, uint64(len(s)))
+	t.b = append(t.b, s...)
+}
@@ -0,0 +1,74 @@
+package transfer
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"dsql-client/resultfmt"
+)
+
+// exportで書き出したCSVをimportで読むと、NULLと空文字列を区別できる
+func TestCSVNullRoundTrip(t *testing.T) {
+	rows := [][]any{
+		{"1", nil, ""},
+		{"2", "", nil},
+		{"3", "text", "N"},
+	}
+	var buf bytes.Buffer
+	w := resultfmt.NewCSVWriter(&buf, DefaultNull)
+	w.WriteHeader([]string{"id", "a", "b"})
+	for _, r := range rows {
+		w.WriteRow(r)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	src, err := NewSource(&buf, CSV, DefaultNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got [][]any
+	for {
+		values, err := src.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, append([]any(nil), values...))
+	}
+	if !reflect.DeepEqual(got, rows) {
+		t.Errorf("read %v, want %v", got, rows)
+	}
+}
+
+func TestManifestNull(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "m.json")
+	m := &Manifest{Format: CSV, Null: DefaultNull}
+	if err := m.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	if !strings.Contains(string(data), `"null": "\\N"`) {
+		t.Errorf("manifest does not record the NULL marker:\n%s", data)
+	}
+
+	// NULLの値を記録していない古いマニフェストは、空のフィールドをNULLとして扱う
+	if err := os.WriteFile(path, []byte(`{"format":"csv","columns":["id"],"rows":1}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	old, err := LoadManifest(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if old.Null != "" {
+		t.Errorf("Null = %q, want empty", old.Null)
+	}
+}
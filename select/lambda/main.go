@@ -4,26 +4,50 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/feature/dsql/auth"
-	"github.com/jackc/pgx/v5"
+	"github.com/aws/aws-lambda-go/lambdacontext"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"go.opentelemetry.io/otel/attribute"
+
+	"dsql-shared/buttonclick"
+	"dsql-shared/dsqlconn"
+	"dsql-shared/emf"
+	"dsql-shared/logging"
+	"dsql-shared/tracing"
+	"dsql-shared/tracing/provider"
 )
 
 // グローバル変数でプールを保持（Lambda実行間で再利用）
 var pool *pgxpool.Pool
 
+// metricsNamespace はメトリクスの名前空間（METRICS_NAMESPACEで上書きできる）
+const metricsNamespace = "DSQLButtonClicks"
+
+// metrics はEMFのメトリクスの書き出し先（nilなら書かない）
+var metrics *emf.Emitter
+
+// traceFlushTimeout は呼び出しごとにスパンを送る時間の上限
+const traceFlushTimeout = 2 * time.Second
+
+// flushSpans は送信待ちのスパンを送る（トレースを設定しなければ何もしない）
+var flushSpans = func(context.Context) error { return nil }
+
 // Response はAPI Gatewayへのレスポンス構造体
+//
+// Versionはbuttonclick.SchemaVersionで、フィールドの互換性が崩れる変更時に上がる。
 type Response struct {
-	ButtonClicks []map[string]interface{} `json:"button_clicks"`
-	Count        int                      `json:"count"`
-	Message      string                   `json:"message"`
-	Timestamp    string                   `json:"timestamp"`
+	Version      string                    `json:"version"`
+	ButtonClicks []buttonclick.ButtonClick `json:"button_clicks"`
+	Count        int                       `json:"count"`
+	NextCursor   string                    `json:"next_cursor,omitempty"`
+	Message      string                    `json:"message"`
+	Timestamp    string                    `json:"timestamp"`
 }
 
 // ErrorResponse はエラーレスポンス構造体
@@ -34,260 +58,225 @@ type ErrorResponse struct {
 }
 
 func init() {
+	// ログはJSONで標準出力へ（レベルはLOG_LEVEL）
+	slog.SetDefault(logging.FromEnv(os.Stdout, os.Getenv))
+
+	// メトリクスはEMFで標準出力へ（CloudWatch Logsがメトリクスとして取り込む）
+	metrics = emf.FromEnv(os.Stdout, metricsNamespace, os.Getenv, emf.Dimension{Name: "Service", Value: "dsql-version-function"})
+
+	// トレースの送信先（OTEL_TRACES_EXPORTER）。プールの作成より前に設定する
+	var err error
+	flushSpans, err = provider.Setup(context.Background(), "dsql-version-function", os.Getenv)
+	if err != nil {
+		slog.Error("failed to set up tracing, traces are disabled", "error", err)
+	}
+
 	// Lambda初期化時にプールを作成
 	ctx := context.Background()
-	var err error
 	pool, err = createPool(ctx)
 	if err != nil {
-		log.Printf("Failed to create connection pool during init: %v", err)
+		slog.Error("failed to create connection pool during init", "error", err)
 	}
 }
 
+// defaultConfig はLambda用の接続プール設定（控えめに設定）
+//
+// 接続先は環境変数（DSQL_ENDPOINT または DSQL_CLUSTER_IDENTIFIER + AWS_REGION）で指定する。
+var defaultConfig = dsqlconn.Config{
+	User:              dsqlconn.AdminUser,
+	Database:          dsqlconn.DefaultDatabase,
+	MaxConns:          5,
+	MinConns:          1,
+	MaxConnLifetime:   5 * time.Minute,
+	MaxConnIdleTime:   1 * time.Minute,
+	HealthCheckPeriod: 30 * time.Second,
+	TokenTTL:          30 * time.Second,
+}
+
 func createPool(ctx context.Context) (*pgxpool.Pool, error) {
-	// Aurora DSQLクラスタ情報
-	hostname := "guabumyfv3jxv2ymjmqtbjqmjq.dsql.ap-northeast-1.on.aws"
-	database := "postgres"
-	username := "admin"
-	region := "ap-northeast-1"
-	port := "5432"
-
-	// 接続URLを構築（パスワードなし）
-	connectionURL := fmt.Sprintf("postgres://%s@%s:%s/%s?sslmode=verify-full&sslnegotiation=direct",
-		username,
-		hostname,
-		port,
-		database,
-	)
+	cfg, err := dsqlconn.FromEnv(defaultConfig, os.Getenv)
+	if err != nil {
+		return nil, fmt.Errorf("invalid environment: %w", err)
+	}
 
-	// プール設定をパース
-	poolConfig, err := pgxpool.ParseConfig(connectionURL)
+	start := time.Now()
+	newPool, err := dsqlconn.New(ctx, cfg)
 	if err != nil {
-		return nil, fmt.Errorf("unable to parse pool config: %v", err)
+		return nil, err
 	}
+	// コールドスタート（またはプールの作り直し）で接続までにかかった時間
+	emitMetrics(ctx, nil, emf.Metric{Name: "PoolCreationTime", Unit: emf.Milliseconds, Value: emf.Ms(time.Since(start))})
 
-	// 接続前のフックを設定（トークン生成）
-	poolConfig.BeforeConnect = func(ctx context.Context, cfg *pgx.ConnConfig) error {
-		// AWS設定をロード
-		awsCfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(region))
-		if err != nil {
-			return fmt.Errorf("failed to load AWS config: %w", err)
-		}
-
-		// 認証トークンを生成（有効期限30秒）
-		tokenOptions := func(options *auth.TokenOptions) {
-			options.ExpiresIn = 30 * time.Second
-		}
-
-		// adminユーザーの場合はAdmin用トークンを生成
-		var token string
-		if username == "admin" {
-			token, err = auth.GenerateDBConnectAdminAuthToken(
-				ctx,
-				hostname,
-				region,
-				awsCfg.Credentials,
-				tokenOptions,
-			)
-		} else {
-			token, err = auth.GenerateDbConnectAuthToken(
-				ctx,
-				hostname,
-				region,
-				awsCfg.Credentials,
-				tokenOptions,
-			)
-		}
-
-		if err != nil {
-			return fmt.Errorf("failed to generate auth token: %w", err)
-		}
-
-		// トークンをパスワードとして設定
-		cfg.Password = token
-		log.Printf("Auth token generated (length: %d)", len(token))
+	logging.FromContext(ctx).Info("connected to Aurora DSQL", "host", cfg.Host())
+	return newPool, nil
+}
 
-		return nil
+// measureAcquireWait はpの累計の接続取得時間を記録し、呼ぶとその後に増えた分を返す関数を返す
+//
+// Lambdaの実行環境は一度に1つのリクエストしか処理しないため、増えた分はこのリクエストのもの。
+func measureAcquireWait(p *pgxpool.Pool) func() time.Duration {
+	before := p.Stat().AcquireDuration()
+	return func() time.Duration {
+		return p.Stat().AcquireDuration() - before
 	}
+}
 
-	// Lambda用の接続プール設定（控えめに設定）
-	poolConfig.MaxConns = 5
-	poolConfig.MinConns = 1
-	poolConfig.MaxConnLifetime = 5 * time.Minute
-	poolConfig.MaxConnIdleTime = 1 * time.Minute
-	poolConfig.HealthCheckPeriod = 30 * time.Second
+// emitQueryMetrics は返した行数と接続の取得待ち時間をrouteの次元でEMFに書く
+func emitQueryMetrics(ctx context.Context, route string, rows int, acquireWait time.Duration, err error) {
+	values := []emf.Metric{{Name: "PoolAcquireWait", Unit: emf.Milliseconds, Value: emf.Ms(acquireWait)}}
+	if err == nil {
+		values = append(values, emf.Metric{Name: "RowsReturned", Unit: emf.Count, Value: float64(rows)})
+	}
+	emitMetrics(ctx, []emf.Dimension{{Name: "Route", Value: route}}, values...)
+}
 
-	// 接続プールを作成
-	newPool, err := pgxpool.NewWithConfig(ctx, poolConfig)
-	if err != nil {
-		return nil, fmt.Errorf("unable to create connection pool: %v", err)
+// emitMetrics はメトリクスをEMFで書く（書けなくても応答には影響させない）
+func emitMetrics(ctx context.Context, dims []emf.Dimension, values ...emf.Metric) {
+	if err := metrics.Emit(dims, values...); err != nil {
+		logging.FromContext(ctx).Warn("failed to emit metrics", "error", err)
 	}
+}
 
-	// 接続をテスト
-	err = newPool.Ping(ctx)
-	if err != nil {
-		newPool.Close()
-		return nil, fmt.Errorf("failed to ping database: %v", err)
+// jsonHeaders はAPIレスポンスの共通ヘッダー
+var jsonHeaders = map[string]string{
+	"Content-Type":                "application/json",
+	"Access-Control-Allow-Origin": "*",
+}
+
+// errorResponse はエラーレスポンスを作成する
+func errorResponse(statusCode int, title, message string) events.APIGatewayProxyResponse {
+	body, _ := json.Marshal(ErrorResponse{
+		Error:     title,
+		Message:   message,
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+	})
+	return events.APIGatewayProxyResponse{
+		StatusCode: statusCode,
+		Headers:    jsonHeaders,
+		Body:       string(body),
 	}
+}
 
-	log.Println("Successfully connected to Aurora DSQL!")
-	return newPool, nil
+// ensurePool はプールが初期化されていない場合に作成する
+func ensurePool(ctx context.Context) error {
+	if pool != nil {
+		return nil
+	}
+	var err error
+	pool, err = createPool(ctx)
+	return err
+}
+
+// resetPoolOnConnError は接続が壊れている場合にプールをリセットする
+func resetPoolOnConnError(err error) {
+	if pool != nil && dsqlconn.IsConnError(err) {
+		pool.Close()
+		pool = nil
+	}
 }
 
 func handler(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
-	log.Printf("Received request: Method=%s, Path=%s", request.HTTPMethod, request.Path)
-
-	// プールが初期化されていない場合は作成
-	if pool == nil {
-		var err error
-		pool, err = createPool(ctx)
-		if err != nil {
-			errorResponse := ErrorResponse{
-				Error:     "Database Connection Error",
-				Message:   fmt.Sprintf("Failed to connect to database: %v", err),
-				Timestamp: time.Now().UTC().Format(time.RFC3339),
-			}
-			body, _ := json.Marshal(errorResponse)
-			return events.APIGatewayProxyResponse{
-				StatusCode: 500,
-				Headers: map[string]string{
-					"Content-Type":                "application/json",
-					"Access-Control-Allow-Origin": "*",
-				},
-				Body: string(body),
-			}, nil
-		}
+	logReq := logRequest(ctx, request)
+	ctx, span := tracing.StartInvocation(ctx, logReq.Route, request.Headers,
+		attribute.String("faas.invocation_id", logReq.LambdaRequestID),
+		attribute.String("http.request.method", request.HTTPMethod),
+		attribute.String("http.route", request.Resource),
+	)
+	logReq.TraceID = tracing.TraceID(ctx)
+	ctx, finish := logging.Start(ctx, slog.Default(), logReq)
+
+	var response events.APIGatewayProxyResponse
+	var err error
+	if strings.HasSuffix(request.Path, "/stats") {
+		response, err = statsHandler(ctx, request)
+	} else {
+		response, err = listHandler(ctx, request)
 	}
+	finish(response.StatusCode, err)
+	tracing.EndInvocation(span, response.StatusCode, err)
+	flushTraces(ctx)
+	return response, err
+}
 
-	// SELECT * FROM button_clicks を実行
-	rows, err := pool.Query(ctx, "SELECT * FROM button_clicks ORDER BY id")
+// flushTraces は応答前にスパンを送る（応答後はLambdaの実行環境が凍結されるため）
+func flushTraces(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), traceFlushTimeout)
+	defer cancel()
+	if err := flushSpans(ctx); err != nil {
+		slog.Warn("failed to flush traces", "error", err)
+	}
+}
+
+// logRequest はリクエストのログに付ける識別情報を返す
+func logRequest(ctx context.Context, request events.APIGatewayProxyRequest) logging.Request {
+	r := logging.Request{
+		APIRequestID: request.RequestContext.RequestID,
+		Route:        request.HTTPMethod + " " + request.Path,
+	}
+	if request.Resource != "" {
+		r.Route = request.HTTPMethod + " " + request.Resource
+	}
+	if lc, ok := lambdacontext.FromContext(ctx); ok {
+		r.LambdaRequestID = lc.AwsRequestID
+	}
+	return r
+}
+
+// listHandler はbutton_clicksの一覧を返す
+func listHandler(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	// クエリ文字列を検証
+	params, err := parseListParams(request.QueryStringParameters)
 	if err != nil {
-		// エラーの場合、プールをリセット
-		if pool != nil {
-			pool.Close()
-			pool = nil
-		}
-
-		errorResponse := ErrorResponse{
-			Error:     "Query Execution Error",
-			Message:   fmt.Sprintf("Failed to execute query: %v", err),
-			Timestamp: time.Now().UTC().Format(time.RFC3339),
-		}
-		body, _ := json.Marshal(errorResponse)
-		return events.APIGatewayProxyResponse{
-			StatusCode: 500,
-			Headers: map[string]string{
-				"Content-Type":                "application/json",
-				"Access-Control-Allow-Origin": "*",
-			},
-			Body: string(body),
-		}, nil
+		return errorResponse(400, "Invalid Parameter", err.Error()), nil
+	}
+
+	if err := ensurePool(ctx); err != nil {
+		logging.FromContext(ctx).Error("failed to connect to database", "error", err)
+		return errorResponse(500, "Database Connection Error", fmt.Sprintf("Failed to connect to database: %v", err)), nil
 	}
-	defer rows.Close()
-
-	// 列の情報を取得
-	fieldDescriptions := rows.FieldDescriptions()
-	columnCount := len(fieldDescriptions)
-
-	// 結果を格納するスライス
-	var buttonClicks []map[string]interface{}
-
-	// 各行を処理
-	for rows.Next() {
-		// 動的に値を格納するスライスを作成
-		values := make([]interface{}, columnCount)
-		valuePtrs := make([]interface{}, columnCount)
-		for i := range values {
-			valuePtrs[i] = &values[i]
-		}
-
-		// 行をスキャン
-		err := rows.Scan(valuePtrs...)
-		if err != nil {
-			log.Printf("Error scanning row: %v", err)
-			continue
-		}
-
-		// 結果をマップに変換
-		rowMap := make(map[string]interface{})
-		for i, fieldDesc := range fieldDescriptions {
-			columnName := fieldDesc.Name
-			value := values[i]
-
-			// null値の処理
-			if value == nil {
-				rowMap[columnName] = nil
-			} else {
-				// 値の型に応じて適切に設定
-				switch v := value.(type) {
-				case []byte:
-					// bytea型やtext型の場合
-					rowMap[columnName] = string(v)
-				case time.Time:
-					// timestamp型の場合
-					rowMap[columnName] = v.Format(time.RFC3339)
-				default:
-					rowMap[columnName] = value
-				}
-			}
-		}
-
-		buttonClicks = append(buttonClicks, rowMap)
+
+	// 条件に合うbutton_clicksをid順に取得（キーセットページネーション）
+	wait := measureAcquireWait(pool)
+	done := logging.TimeDB(ctx)
+	page, err := buttonclick.NewPgxRepository(pool).List(ctx, params.options())
+	done()
+	emitQueryMetrics(ctx, "list", len(page.Clicks), wait(), err)
+	if err != nil {
+		logging.FromContext(ctx).Error("failed to list button clicks", "error", err)
+		resetPoolOnConnError(err)
+		return errorResponse(500, "Query Execution Error", fmt.Sprintf("Failed to execute query: %v", err)), nil
 	}
+	buttonClicks := page.Clicks
 
-	// 読み取りエラーをチェック
-	if err = rows.Err(); err != nil {
-		errorResponse := ErrorResponse{
-			Error:     "Row Reading Error",
-			Message:   fmt.Sprintf("Failed to read rows: %v", err),
-			Timestamp: time.Now().UTC().Format(time.RFC3339),
-		}
-		body, _ := json.Marshal(errorResponse)
-		return events.APIGatewayProxyResponse{
-			StatusCode: 500,
-			Headers: map[string]string{
-				"Content-Type":                "application/json",
-				"Access-Control-Allow-Origin": "*",
-			},
-			Body: string(body),
-		}, nil
+	// 続きがあれば次ページのカーソルを返す
+	var nextCursor string
+	if page.More {
+		nextCursor = encodeCursor(cursor{ID: buttonClicks[len(buttonClicks)-1].ID, Order: params.Order})
 	}
+	logging.FromContext(ctx).Debug("listed button clicks", "rows", len(buttonClicks), "more", page.More)
 
 	// 成功レスポンスを作成
 	response := Response{
+		Version:      buttonclick.SchemaVersion,
 		ButtonClicks: buttonClicks,
 		Count:        len(buttonClicks),
+		NextCursor:   nextCursor,
 		Message:      "Successfully retrieved button clicks",
 		Timestamp:    time.Now().UTC().Format(time.RFC3339),
 	}
 
 	body, err := json.Marshal(response)
 	if err != nil {
-		errorResponse := ErrorResponse{
-			Error:     "Response Serialization Error",
-			Message:   fmt.Sprintf("Failed to serialize response: %v", err),
-			Timestamp: time.Now().UTC().Format(time.RFC3339),
-		}
-		errorBody, _ := json.Marshal(errorResponse)
-		return events.APIGatewayProxyResponse{
-			StatusCode: 500,
-			Headers: map[string]string{
-				"Content-Type":                "application/json",
-				"Access-Control-Allow-Origin": "*",
-			},
-			Body: string(errorBody),
-		}, nil
+		return errorResponse(500, "Response Serialization Error", fmt.Sprintf("Failed to serialize response: %v", err)), nil
 	}
 
 	return events.APIGatewayProxyResponse{
 		StatusCode: 200,
-		Headers: map[string]string{
-			"Content-Type":                "application/json",
-			"Access-Control-Allow-Origin": "*",
-		},
-		Body: string(body),
+		Headers:    jsonHeaders,
+		Body:       string(body),
 	}, nil
 }
 
 func main() {
 	lambda.Start(handler)
-}
\ No newline at end of file
+}
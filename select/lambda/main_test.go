@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"dsql-shared/buttonclick"
+	"dsql-shared/testdb"
+)
+
+// useTestPool はpoolをTEST_DATABASE_URLのプールに差し替え、button_clicksを空にする
+func useTestPool(t *testing.T) *pgxpool.Pool {
+	t.Helper()
+	p := testdb.Pool(t, "test_select_lambda")
+	testdb.ResetButtonClicks(t, p)
+
+	orig := pool
+	pool = p
+	t.Cleanup(func() { pool = orig })
+	return p
+}
+
+// seed はIDが1からnの行を挿入する（偶数はview、奇数はrecord）
+func seed(t *testing.T, p *pgxpool.Pool, n int) []int64 {
+	t.Helper()
+	repo := buttonclick.NewPgxRepository(p)
+	var ids []int64
+	for i := 1; i <= n; i++ {
+		action := "record"
+		if i%2 == 0 {
+			action = "view"
+		}
+		c, err := repo.Insert(context.Background(), buttonclick.NewClick{
+			ID:        int64(i),
+			Action:    action,
+			UserAgent: "Mozilla/5.0 Firefox/121.0",
+			IPAddress: "192.0.2." + strconv.Itoa(i),
+		})
+		if err != nil {
+			t.Fatalf("Insert(%d): %v", i, err)
+		}
+		ids = append(ids, c.ID)
+	}
+	return ids
+}
+
+func invoke(t *testing.T, path string, q map[string]string) events.APIGatewayProxyResponse {
+	t.Helper()
+	resp, err := handler(context.Background(), events.APIGatewayProxyRequest{
+		HTTPMethod:            "GET",
+		Path:                  path,
+		QueryStringParameters: q,
+	})
+	if err != nil {
+		t.Fatalf("handler returned error: %v", err)
+	}
+	return resp
+}
+
+// listAll はnext_cursorがなくなるまで一覧を取得し、ページごとの件数とすべてのIDを返す
+func listAll(t *testing.T, q map[string]string) (pageSizes []int, ids []int64) {
+	t.Helper()
+	for range 100 {
+		resp := invoke(t, "/button-clicks", q)
+		if resp.StatusCode != 200 {
+			t.Fatalf("status %d: %s", resp.StatusCode, resp.Body)
+		}
+		var body Response
+		if err := json.Unmarshal([]byte(resp.Body), &body); err != nil {
+			t.Fatalf("invalid body %q: %v", resp.Body, err)
+		}
+		if body.Count != len(body.ButtonClicks) {
+			t.Errorf("count %d does not match %d rows", body.Count, len(body.ButtonClicks))
+		}
+		pageSizes = append(pageSizes, body.Count)
+		for _, c := range body.ButtonClicks {
+			ids = append(ids, c.ID)
+		}
+		if body.NextCursor == "" {
+			if strings.Contains(resp.Body, "next_cursor") {
+				t.Errorf("last page has a next_cursor field: %s", resp.Body)
+			}
+			return pageSizes, ids
+		}
+		q = cloneWith(q, "after", body.NextCursor)
+	}
+	t.Fatal("pagination did not end")
+	return nil, nil
+}
+
+func cloneWith(q map[string]string, key, value string) map[string]string {
+	c := map[string]string{key: value}
+	for k, v := range q {
+		if k != key {
+			c[k] = v
+		}
+	}
+	return c
+}
+
+func TestListCursorRoundTrip(t *testing.T) {
+	p := useTestPool(t)
+	all := seed(t, p, 7)
+	desc := slices.Clone(all)
+	slices.Reverse(desc)
+
+	tests := []struct {
+		name      string
+		q         map[string]string
+		wantPages []int
+		wantIDs   []int64
+	}{
+		{"asc", map[string]string{"limit": "3"}, []int{3, 3, 1}, all},
+		{"desc", map[string]string{"limit": "3", "order": "desc"}, []int{3, 3, 1}, desc},
+		{"exact last page", map[string]string{"limit": "7"}, []int{7}, all},
+		{"filtered", map[string]string{"limit": "2", "action": "view"}, []int{2, 1}, []int64{2, 4, 6}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pages, ids := listAll(t, tt.q)
+			if !slices.Equal(pages, tt.wantPages) {
+				t.Errorf("page sizes = %v, want %v", pages, tt.wantPages)
+			}
+			if !slices.Equal(ids, tt.wantIDs) {
+				t.Errorf("ids = %v, want %v", ids, tt.wantIDs)
+			}
+		})
+	}
+}
+
+func TestListEmptyHasNoCursor(t *testing.T) {
+	useTestPool(t)
+	pages, ids := listAll(t, map[string]string{"limit": "5"})
+	if !slices.Equal(pages, []int{0}) || len(ids) != 0 {
+		t.Errorf("pages %v, ids %v; want one empty page", pages, ids)
+	}
+}
+
+// 不正なカーソルはデータベースに問い合わせる前に400にする
+func TestListInvalidCursor(t *testing.T) {
+	valid := encodeCursor(cursor{ID: 3, Order: "asc"})
+	raw := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+
+	tests := []struct {
+		name   string
+		cursor string
+		order  string
+	}{
+		{"not base64", "!!!not-a-cursor", ""},
+		{"truncated", valid[:len(valid)-4], ""},
+		{"tampered byte", "A" + valid[1:], ""},
+		{"not json", raw("id=3"), ""},
+		{"unknown order", raw(`{"id":3,"order":"sideways"}`), ""},
+		{"missing order", raw(`{"id":3}`), ""},
+		{"order mismatch", valid, "desc"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := map[string]string{"after": tt.cursor}
+			if tt.order != "" {
+				q["order"] = tt.order
+			}
+			resp := invoke(t, "/button-clicks", q)
+			if resp.StatusCode != 400 {
+				t.Fatalf("status = %d, want 400: %s", resp.StatusCode, resp.Body)
+			}
+			var body ErrorResponse
+			if err := json.Unmarshal([]byte(resp.Body), &body); err != nil || body.Error != "Invalid Parameter" {
+				t.Errorf("body = %s (%v), want an Invalid Parameter error", resp.Body, err)
+			}
+		})
+	}
+}
+
+func TestCursorEncoding(t *testing.T) {
+	c := cursor{ID: 1234567890123, Order: "desc"}
+	got, err := decodeCursor(encodeCursor(c))
+	if err != nil || *got != c {
+		t.Errorf("decodeCursor(encodeCursor(%+v)) = %+v, %v", c, got, err)
+	}
+}
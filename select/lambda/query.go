@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"dsql-shared/buttonclick"
+)
+
+const (
+	// defaultLimit は limit 未指定時の1ページの件数
+	defaultLimit = 100
+	// maxLimit は1ページの最大件数（API Gatewayの6MB制限に収まるように制限）
+	maxLimit = 1000
+)
+
+// timeRange は期間指定のクエリ文字列パラメータ（time_field, from, to）
+type timeRange struct {
+	Field buttonclick.TimeField
+	From  *time.Time
+	To    *time.Time
+}
+
+// parseTimeRange はtime_field, from, to（RFC3339）を検証する
+func parseTimeRange(q map[string]string) (timeRange, error) {
+	r := timeRange{Field: buttonclick.TimeFieldTimestamp}
+
+	if v := q["time_field"]; v != "" {
+		switch f := buttonclick.TimeField(v); f {
+		case buttonclick.TimeFieldTimestamp, buttonclick.TimeFieldCreatedAt:
+			r.Field = f
+		default:
+			return r, fmt.Errorf("time_field must be timestamp or created_at")
+		}
+	}
+
+	for _, name := range []string{"from", "to"} {
+		v := q[name]
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return r, fmt.Errorf("%s must be an RFC3339 timestamp (e.g. 2025-01-01T00:00:00+09:00)", name)
+		}
+		if name == "from" {
+			r.From = &t
+		} else {
+			r.To = &t
+		}
+	}
+	if r.From != nil && r.To != nil && !r.From.Before(*r.To) {
+		return r, fmt.Errorf("from must be before to")
+	}
+
+	return r, nil
+}
+
+// filter は期間の条件をbuttonclick.Filterにする
+func (r timeRange) filter() buttonclick.Filter {
+	return buttonclick.Filter{TimeField: r.Field, From: r.From, To: r.To}
+}
+
+// listParams はbutton_clicks一覧取得のクエリ文字列パラメータ
+type listParams struct {
+	timeRange
+	Limit     int
+	After     *cursor
+	Action    string
+	IPAddress string
+	Order     string
+}
+
+// cursor は次ページの開始位置（レスポンスではBase64エンコードした不透明な文字列）
+type cursor struct {
+	ID    int64  `json:"id"`
+	Order string `json:"order"`
+}
+
+func encodeCursor(c cursor) string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (*cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	var c cursor
+	if err := json.Unmarshal(b, &c); err != nil || (c.Order != "asc" && c.Order != "desc") {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	return &c, nil
+}
+
+// parseListParams はクエリ文字列を検証してlistParamsにする
+//
+// 対応パラメータ: limit, after, time_field (timestamp|created_at), from, to (RFC3339),
+// action, ip_address, order (asc|desc)
+func parseListParams(q map[string]string) (listParams, error) {
+	p := listParams{
+		Limit:     defaultLimit,
+		Order:     "asc",
+		Action:    q["action"],
+		IPAddress: q["ip_address"],
+	}
+
+	var err error
+	if p.timeRange, err = parseTimeRange(q); err != nil {
+		return p, err
+	}
+
+	if v := q["limit"]; v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxLimit {
+			return p, fmt.Errorf("limit must be an integer between 1 and %d", maxLimit)
+		}
+		p.Limit = n
+	}
+
+	if v := q["order"]; v != "" {
+		v = strings.ToLower(v)
+		if v != "asc" && v != "desc" {
+			return p, fmt.Errorf("order must be asc or desc")
+		}
+		p.Order = v
+	}
+
+	if v := q["after"]; v != "" {
+		c, err := decodeCursor(v)
+		if err != nil {
+			return p, err
+		}
+		if c.Order != p.Order {
+			return p, fmt.Errorf("cursor was issued for order=%s", c.Order)
+		}
+		p.After = c
+	}
+
+	return p, nil
+}
+
+// options はリポジトリの一覧の条件を返す
+func (p listParams) options() buttonclick.ListOptions {
+	opts := buttonclick.ListOptions{
+		Filter: p.filter(),
+		Order:  buttonclick.Order(p.Order),
+		Limit:  p.Limit,
+	}
+	opts.Action = p.Action
+	opts.IPAddress = p.IPAddress
+	if p.After != nil {
+		opts.AfterID = &p.After.ID
+	}
+	return opts
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	// Lambdaのランタイムにはタイムゾーンデータがないため埋め込む
+	_ "time/tzdata"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/jackc/pgx/v5"
+
+	"dsql-shared/buttonclick"
+	"dsql-shared/dsqltx"
+	"dsql-shared/logging"
+)
+
+const (
+	// defaultTimeZone は時間・日単位の集計で使うタイムゾーン（記録側と同じJST）
+	defaultTimeZone = "Asia/Tokyo"
+	// defaultStatsLimit は集計結果の最大件数の既定値
+	defaultStatsLimit = 100
+)
+
+// StatsBucket は集計結果の1グループ
+type StatsBucket struct {
+	Key   string `json:"key"`
+	Count int64  `json:"count"`
+}
+
+// StatsResponse は集計APIのレスポンス構造体
+type StatsResponse struct {
+	Version   string        `json:"version"`
+	GroupBy   string        `json:"group_by"`
+	TimeZone  string        `json:"time_zone,omitempty"`
+	Buckets   []StatsBucket `json:"buckets"`
+	Total     int64         `json:"total"` // 条件に合う全行の件数（truncatedでも全体。時間・日単位ではNULLの時刻の行も含む）
+	Truncated bool          `json:"truncated"`
+	Message   string        `json:"message"`
+	Timestamp string        `json:"timestamp"`
+}
+
+// statsParams は集計APIのクエリ文字列パラメータ
+type statsParams struct {
+	timeRange
+	GroupBy  string
+	Location *time.Location
+	Limit    int
+}
+
+// parseStatsParams はクエリ文字列を検証してstatsParamsにする
+//
+// 対応パラメータ: group_by (action|hour|day|user_agent|ip_address), tz (IANAタイムゾーン名),
+// limit, time_field, from, to
+func parseStatsParams(q map[string]string) (statsParams, error) {
+	p := statsParams{
+		GroupBy: "action",
+		Limit:   defaultStatsLimit,
+	}
+
+	var err error
+	if p.timeRange, err = parseTimeRange(q); err != nil {
+		return p, err
+	}
+
+	if v := q["group_by"]; v != "" {
+		if !slices.Contains(buttonclick.GroupByValues, buttonclick.GroupBy(v)) {
+			var values []string
+			for _, g := range buttonclick.GroupByValues {
+				values = append(values, string(g))
+			}
+			return p, fmt.Errorf("group_by must be one of %s", strings.Join(values, ", "))
+		}
+		p.GroupBy = v
+	}
+
+	tz := defaultTimeZone
+	if v := q["tz"]; v != "" {
+		tz = v
+	}
+	// "Local"は実行環境のタイムゾーン（LambdaではUTC）になり、結果が環境に依存するため受け付けない
+	if tz == "Local" {
+		return p, fmt.Errorf("tz must be an IANA time zone name such as Asia/Tokyo, not Local")
+	}
+	if p.Location, err = time.LoadLocation(tz); err != nil {
+		return p, fmt.Errorf("unknown time zone %q", tz)
+	}
+
+	if v := q["limit"]; v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxLimit {
+			return p, fmt.Errorf("limit must be an integer between 1 and %d", maxLimit)
+		}
+		p.Limit = n
+	}
+
+	return p, nil
+}
+
+// options はリポジトリの集計の条件を返す
+func (p statsParams) options() buttonclick.AggregateOptions {
+	return buttonclick.AggregateOptions{
+		Filter:   p.filter(),
+		GroupBy:  buttonclick.GroupBy(p.GroupBy),
+		Location: p.Location,
+		Limit:    p.Limit,
+	}
+}
+
+// statsHandler はbutton_clicksの件数をgroup_byごとに集計して返す
+func statsHandler(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	params, err := parseStatsParams(request.QueryStringParameters)
+	if err != nil {
+		return errorResponse(400, "Invalid Parameter", err.Error()), nil
+	}
+
+	if err := ensurePool(ctx); err != nil {
+		logging.FromContext(ctx).Error("failed to connect to database", "error", err)
+		return errorResponse(500, "Database Connection Error", fmt.Sprintf("Failed to connect to database: %v", err)), nil
+	}
+
+	opts := params.options()
+	wait := measureAcquireWait(pool)
+	done := logging.TimeDB(ctx)
+	// limitで切り捨てたグループも含めるため合計は別に数える。同時に挿入されても
+	// 集計とずれないよう、両方を1つの読み取り専用トランザクションで実行する
+	var agg buttonclick.Aggregation
+	var total int64
+	_, err = dsqltx.RunInTx(ctx, pool, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, "SET TRANSACTION READ ONLY"); err != nil {
+			return err
+		}
+		repo := buttonclick.NewPgxRepository(tx)
+		var err error
+		if agg, err = repo.Aggregate(ctx, opts); err != nil {
+			return err
+		}
+		total, err = repo.Count(ctx, opts.Filter)
+		return err
+	})
+	done()
+	emitQueryMetrics(ctx, "stats", len(agg.Buckets), wait(), err)
+	if err != nil {
+		logging.FromContext(ctx).Error("failed to aggregate button clicks", "group_by", params.GroupBy, "error", err)
+		resetPoolOnConnError(err)
+		return errorResponse(500, "Query Execution Error", fmt.Sprintf("Failed to execute query: %v", err)), nil
+	}
+
+	buckets := []StatsBucket{}
+	for _, b := range agg.Buckets {
+		buckets = append(buckets, StatsBucket{Key: b.Key, Count: b.Count})
+	}
+
+	response := StatsResponse{
+		Version:   buttonclick.SchemaVersion,
+		GroupBy:   params.GroupBy,
+		Buckets:   buckets,
+		Total:     total,
+		Truncated: agg.Truncated,
+		Message:   "Successfully aggregated button clicks",
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+	}
+	if opts.IsTimeBucket() {
+		response.TimeZone = params.Location.String()
+	}
+
+	body, err := json.Marshal(response)
+	if err != nil {
+		return errorResponse(500, "Response Serialization Error", fmt.Sprintf("Failed to serialize response: %v", err)), nil
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers:    jsonHeaders,
+		Body:       string(body),
+	}, nil
+}
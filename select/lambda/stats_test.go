@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func invokeStats(t *testing.T, q map[string]string) (int, StatsResponse, string) {
+	t.Helper()
+	resp := invoke(t, "/button-clicks/stats", q)
+	var body StatsResponse
+	if resp.StatusCode == 200 {
+		if err := json.Unmarshal([]byte(resp.Body), &body); err != nil {
+			t.Fatalf("invalid body %q: %v", resp.Body, err)
+		}
+	}
+	return resp.StatusCode, body, resp.Body
+}
+
+func TestStatsInvalidParams(t *testing.T) {
+	tests := []struct {
+		name string
+		q    map[string]string
+		want string
+	}{
+		{"local time zone", map[string]string{"group_by": "day", "tz": "Local"}, "not Local"},
+		{"unknown time zone", map[string]string{"group_by": "hour", "tz": "Mars/Olympus"}, "unknown time zone"},
+		{"unknown group", map[string]string{"group_by": "country"}, "group_by must be one of"},
+		{"limit too large", map[string]string{"limit": "100000"}, "limit must be"},
+		{"reversed range", map[string]string{"from": "2025-02-01T00:00:00Z", "to": "2025-01-01T00:00:00Z"}, "from must be before to"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, _, body := invokeStats(t, tt.q)
+			if status != 400 || !strings.Contains(body, tt.want) {
+				t.Errorf("status %d, body %s; want 400 containing %q", status, body, tt.want)
+			}
+		})
+	}
+}
+
+func TestStatsTotal(t *testing.T) {
+	p := useTestPool(t)
+	seed(t, p, 7)
+	future := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+
+	tests := []struct {
+		name          string
+		q             map[string]string
+		wantBuckets   []StatsBucket
+		wantTotal     int64
+		wantTruncated bool
+		wantTimeZone  string
+	}{
+		{
+			name:        "by action",
+			q:           map[string]string{"group_by": "action"},
+			wantBuckets: []StatsBucket{{Key: "record", Count: 4}, {Key: "view", Count: 3}},
+			wantTotal:   7,
+		},
+		{
+			// 切り捨てたグループも合計に含める
+			name:          "truncated",
+			q:             map[string]string{"group_by": "action", "limit": "1"},
+			wantBuckets:   []StatsBucket{{Key: "record", Count: 4}},
+			wantTotal:     7,
+			wantTruncated: true,
+		},
+		{
+			name:          "truncated ip addresses",
+			q:             map[string]string{"group_by": "ip_address", "limit": "2"},
+			wantTotal:     7,
+			wantTruncated: true,
+		},
+		{
+			name:         "by day",
+			q:            map[string]string{"group_by": "day", "tz": "UTC"},
+			wantTotal:    7,
+			wantTimeZone: "UTC",
+		},
+		{
+			name:        "no rows in range",
+			q:           map[string]string{"group_by": "action", "from": future},
+			wantBuckets: []StatsBucket{},
+			wantTotal:   0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, got, body := invokeStats(t, tt.q)
+			if status != 200 {
+				t.Fatalf("status %d: %s", status, body)
+			}
+			if got.Total != tt.wantTotal || got.Truncated != tt.wantTruncated || got.TimeZone != tt.wantTimeZone {
+				t.Errorf("total %d, truncated %v, time zone %q; want %d, %v, %q",
+					got.Total, got.Truncated, got.TimeZone, tt.wantTotal, tt.wantTruncated, tt.wantTimeZone)
+			}
+			if tt.wantBuckets != nil {
+				if len(got.Buckets) != len(tt.wantBuckets) {
+					t.Fatalf("buckets = %+v, want %+v", got.Buckets, tt.wantBuckets)
+				}
+				for i := range got.Buckets {
+					if got.Buckets[i] != tt.wantBuckets[i] {
+						t.Errorf("buckets = %+v, want %+v", got.Buckets, tt.wantBuckets)
+						break
+					}
+				}
+			}
+			var sum int64
+			for _, b := range got.Buckets {
+				sum += b.Count
+			}
+			if tt.wantTruncated && sum >= got.Total {
+				t.Errorf("truncated buckets sum to %d, want less than the total %d", sum, got.Total)
+			}
+			if !tt.wantTruncated && sum != got.Total {
+				t.Errorf("buckets sum to %d, want the total %d", sum, got.Total)
+			}
+		})
+	}
+}
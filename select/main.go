@@ -2,108 +2,50 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"log"
+	"os"
 	"strings"
 	"time"
 
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/feature/dsql/auth"
-	"github.com/jackc/pgx/v5"
-	"github.com/jackc/pgx/v5/pgxpool"
+	"dsql-shared/dsqlconn"
 )
 
 func main() {
-	// Aurora DSQLクラスタ情報
-	hostname := "guabumyfv3jxv2ymjmqtbjqmjq.dsql.ap-northeast-1.on.aws"
-	database := "postgres"
-	username := "admin"
-	region := "ap-northeast-1"
-	port := "5432"
-
-	// 接続URLを構築（パスワードなし）
-	connectionURL := fmt.Sprintf("postgres://%s@%s:%s/%s?sslmode=verify-full&sslnegotiation=direct",
-		username,
-		hostname,
-		port,
-		database,
-	)
-
-	fmt.Printf("Connecting to Aurora DSQL at %s\n", hostname)
-
-	// プール設定をパース
-	poolConfig, err := pgxpool.ParseConfig(connectionURL)
+	// Aurora DSQLクラスタ情報（環境変数 → フラグの順に上書き）
+	cfg, err := dsqlconn.FromEnv(dsqlconn.Config{
+		User:              dsqlconn.AdminUser,
+		Database:          dsqlconn.DefaultDatabase,
+		MaxConns:          10,
+		MinConns:          2,
+		MaxConnLifetime:   1 * time.Hour,
+		MaxConnIdleTime:   30 * time.Minute,
+		HealthCheckPeriod: 1 * time.Minute,
+		TokenTTL:          30 * time.Second,
+	}, os.Getenv)
 	if err != nil {
-		log.Fatalf("Unable to parse pool config: %v", err)
+		log.Fatalf("Invalid environment: %v", err)
 	}
+	cfg.RegisterFlags(flag.CommandLine)
+	flag.Parse()
 
-	// 接続前のフックを設定（トークン生成）
-	poolConfig.BeforeConnect = func(ctx context.Context, cfg *pgx.ConnConfig) error {
-		// AWS設定をロード
-		awsCfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(region))
-		if err != nil {
-			return fmt.Errorf("failed to load AWS config: %w", err)
-		}
-
-		// 認証トークンを生成（有効期限30秒）
-		tokenOptions := func(options *auth.TokenOptions) {
-			options.ExpiresIn = 30 * time.Second
-		}
-
-		// adminユーザーの場合はAdmin用トークンを生成
-		var token string
-		if username == "admin" {
-			token, err = auth.GenerateDBConnectAdminAuthToken(
-				ctx,
-				hostname,
-				region,
-				awsCfg.Credentials,
-				tokenOptions,
-			)
-		} else {
-			token, err = auth.GenerateDbConnectAuthToken(
-				ctx,
-				hostname,
-				region,
-				awsCfg.Credentials,
-				tokenOptions,
-			)
-		}
-
-		if err != nil {
-			return fmt.Errorf("failed to generate auth token: %w", err)
-		}
-
-		// トークンをパスワードとして設定
-		cfg.Password = token
-		fmt.Printf("Auth token generated (length: %d)\n", len(token))
-
-		return nil
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
 	}
 
-	// 接続プールの設定
-	poolConfig.MaxConns = 10
-	poolConfig.MinConns = 2
-	poolConfig.MaxConnLifetime = 1 * time.Hour
-	poolConfig.MaxConnIdleTime = 30 * time.Minute
-	poolConfig.HealthCheckPeriod = 1 * time.Minute
+	fmt.Printf("Connecting to Aurora DSQL at %s\n", cfg.Host())
 
 	// コンテキストを作成
 	ctx := context.Background()
 
 	// 接続プールを作成
-	pool, err := pgxpool.NewWithConfig(ctx, poolConfig)
+	pool, err := dsqlconn.New(ctx, cfg)
 	if err != nil {
-		log.Fatalf("Unable to create connection pool: %v", err)
+		log.Fatalf("Unable to connect to Aurora DSQL: %v", err)
 	}
 	defer pool.Close()
 
-	// 接続をテスト
-	err = pool.Ping(ctx)
-	if err != nil {
-		log.Fatalf("Failed to ping database: %v", err)
-	}
-
 	fmt.Println("Successfully connected to Aurora DSQL!")
 
 	// SELECT version()を実行
@@ -123,4 +65,4 @@ func main() {
 			fmt.Printf("\nVersion summary: %s %s\n", parts[0], parts[1])
 		}
 	}
-}
\ No newline at end of file
+}
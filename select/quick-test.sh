@@ -1,6 +1,7 @@
 #!/bin/bash
 
 echo "Aurora DSQL Lambda Quick Test - Button Clicks"
+: "${DSQL_ENDPOINT:?DSQL_ENDPOINT is required (e.g. <cluster-id>.dsql.ap-northeast-1.on.aws)}"
 echo "============================================="
 echo ""
 
@@ -10,6 +11,7 @@ echo "-------------------------------------"
 sam local invoke DSQLVersionFunction \
   --event events/test-event.json \
   --region ap-northeast-1 \
+  --parameter-overrides "DSQLEndpoint=$DSQL_ENDPOINT" \
   2>/dev/null | jq '.body | fromjson' | jq '.'
 
 echo ""
@@ -18,7 +20,7 @@ echo "--------------------------------------"
 echo "Run the following command after starting local API:"
 echo ""
 echo "# Start local API (in another terminal):"
-echo "sam local start-api --region ap-northeast-1 --port 3000"
+echo "sam local start-api --region ap-northeast-1 --port 3000 --parameter-overrides DSQLEndpoint=$DSQL_ENDPOINT"
 echo ""
 echo "# Test with curl:"
 echo "curl -X GET http://localhost:3000/version | jq '.'"
@@ -18,6 +18,12 @@ if [ ! -f "template.yaml" ]; then
     exit 1
 fi
 
+# The DSQLEndpoint parameter has no default
+if [ -z "$DSQL_ENDPOINT" ]; then
+    echo "❌ Error: DSQL_ENDPOINT is not set (e.g. export DSQL_ENDPOINT=<cluster-id>.dsql.ap-northeast-1.on.aws)"
+    exit 1
+fi
+
 echo "✅ Prerequisites check passed"
 echo ""
 
@@ -34,10 +40,10 @@ echo ""
 
 # Test the function
 echo "🧪 Testing Lambda function..."
-echo "Executing: sam local invoke DSQLVersionFunction --event events/test-event.json --region ap-northeast-1"
+echo "Executing: sam local invoke DSQLVersionFunction --event events/test-event.json --region ap-northeast-1 --parameter-overrides "DSQLEndpoint=$DSQL_ENDPOINT""
 echo ""
 
-RESULT=$(sam local invoke DSQLVersionFunction --event events/test-event.json --region ap-northeast-1 2>/dev/null)
+RESULT=$(sam local invoke DSQLVersionFunction --event events/test-event.json --region ap-northeast-1 --parameter-overrides "DSQLEndpoint=$DSQL_ENDPOINT" 2>/dev/null)
 
 if [ $? -eq 0 ]; then
     echo "✅ Lambda function executed successfully"
@@ -56,7 +62,7 @@ else
     echo "❌ Lambda function execution failed"
     echo ""
     echo "🔍 Debug output:"
-    sam local invoke DSQLVersionFunction --event events/test-event.json --region ap-northeast-1
+    sam local invoke DSQLVersionFunction --event events/test-event.json --region ap-northeast-1 --parameter-overrides "DSQLEndpoint=$DSQL_ENDPOINT"
     exit 1
 fi
 
@@ -64,6 +70,6 @@ echo ""
 echo "✨ Test completed successfully!"
 echo ""
 echo "💡 Next steps:"
-echo "   • Start local API: sam local start-api --region ap-northeast-1 --port 3000"
+echo "   • Start local API: sam local start-api --region ap-northeast-1 --port 3000 --parameter-overrides DSQLEndpoint=$DSQL_ENDPOINT"
 echo "   • Test API: curl -X GET http://localhost:3000/version | jq '.'"
 echo "   • Deploy to AWS: make deploy"
\ No newline at end of file
@@ -0,0 +1,33 @@
+// Package buttonclick はbutton_clicksテーブルの行とAPIで返すJSONの型を定義する
+//
+// 記録側（button-timestamp-recorder）と参照側（select/lambda）で同じ型を使い、
+// JSONの形をここで固定する。
+package buttonclick
+
+import (
+	"time"
+)
+
+// SchemaVersion はAPIレスポンスのJSONスキーマのバージョン
+//
+// フィールドの削除や意味の変更を行う場合は値を上げる（フィールド追加では上げない）。
+const SchemaVersion = "2"
+
+// Table はテーブル名
+const Table = "button_clicks"
+
+// Columns はSELECT/RETURNINGで使う列（ButtonClickのdbタグと一致させる）
+const Columns = `id, "timestamp", action, user_agent, ip_address, created_at`
+
+// ButtonClick はbutton_clicksの1行
+//
+// NULLになりうる列はポインタにしており、JSONではnullになる。時刻はRFC3339Nano形式。
+// IDはSnowflake形式で2^53を超えるため、JavaScriptで丸められないようJSONでは文字列にする。
+type ButtonClick struct {
+	ID        int64      `db:"id" json:"id,string"`
+	Timestamp *time.Time `db:"timestamp" json:"timestamp"`
+	Action    *string    `db:"action" json:"action"`
+	UserAgent *string    `db:"user_agent" json:"user_agent"`
+	IPAddress *string    `db:"ip_address" json:"ip_address"`
+	CreatedAt *time.Time `db:"created_at" json:"created_at"`
+}
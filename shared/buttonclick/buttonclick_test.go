@@ -0,0 +1,43 @@
+package buttonclick_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"dsql-shared/buttonclick"
+)
+
+// 2^53を超えるIDもJSONの往復で変わらない
+func TestButtonClickJSONRoundTrip(t *testing.T) {
+	const id = int64(1)<<53 + 1 // float64にすると1<<53に丸められる
+	ts := time.Date(2025, 1, 2, 3, 4, 5, 600, time.UTC)
+	action := "record"
+	in := buttonclick.ButtonClick{ID: id, Timestamp: &ts, Action: &action}
+
+	b, err := json.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `"id":"9007199254740993"`) {
+		t.Errorf("JSON = %s, want the id as a string", b)
+	}
+
+	var out buttonclick.ButtonClick
+	if err := json.Unmarshal(b, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.ID != id || !out.Timestamp.Equal(ts) || *out.Action != action || out.UserAgent != nil {
+		t.Errorf("round trip = %+v, want %+v", out, in)
+	}
+
+	// JavaScriptと同じくfloat64で読むと丸められる（文字列にする理由）
+	var js map[string]any
+	if err := json.Unmarshal(b, &js); err != nil {
+		t.Fatal(err)
+	}
+	if js["id"] != "9007199254740993" {
+		t.Errorf("id decoded as %T %v, want the exact string", js["id"], js["id"])
+	}
+}
@@ -0,0 +1,227 @@
+package buttonclick
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// MemoryRepository はメモリ上にbutton_clicksを持つRepository（テストとローカル開発用）
+//
+// timestampとcreated_atは挿入した時刻になる。ゼロ値は使えないためNewMemoryRepositoryで作る。
+type MemoryRepository struct {
+	mu     sync.Mutex
+	clicks map[int64]ButtonClick
+	// Now は挿入時刻を返す（テストで差し替える）
+	Now func() time.Time
+}
+
+// NewMemoryRepository は空のMemoryRepositoryを返す
+func NewMemoryRepository() *MemoryRepository {
+	return &MemoryRepository{clicks: map[int64]ButtonClick{}, Now: time.Now}
+}
+
+func (r *MemoryRepository) Insert(ctx context.Context, c NewClick) (ButtonClick, error) {
+	if err := ctx.Err(); err != nil {
+		return ButtonClick{}, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.clicks[c.ID]; ok {
+		return ButtonClick{}, fmt.Errorf("%w: %d", ErrDuplicateID, c.ID)
+	}
+	now := r.Now()
+	click := ButtonClick{
+		ID:        c.ID,
+		Timestamp: &now,
+		Action:    &c.Action,
+		UserAgent: &c.UserAgent,
+		IPAddress: &c.IPAddress,
+		CreatedAt: &now,
+	}
+	r.clicks[c.ID] = click
+	return click, nil
+}
+
+// Put はcをそのまま保存する。Insertでは作れない行（timestampなどがNULLの行）をテストで入れるために使う
+//
+// IDが重複する場合はErrDuplicateID。
+func (r *MemoryRepository) Put(c ButtonClick) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.clicks[c.ID]; ok {
+		return fmt.Errorf("%w: %d", ErrDuplicateID, c.ID)
+	}
+	r.clicks[c.ID] = c
+	return nil
+}
+
+func (r *MemoryRepository) Get(ctx context.Context, id int64) (ButtonClick, error) {
+	if err := ctx.Err(); err != nil {
+		return ButtonClick{}, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	click, ok := r.clicks[id]
+	if !ok {
+		return ButtonClick{}, ErrNotFound
+	}
+	return click, nil
+}
+
+func (r *MemoryRepository) List(ctx context.Context, opts ListOptions) (Page, error) {
+	clicks, err := r.matching(ctx, opts.Filter)
+	if err != nil {
+		return Page{}, err
+	}
+	slices.SortFunc(clicks, func(a, b ButtonClick) int {
+		if opts.Order == Desc {
+			return cmp.Compare(b.ID, a.ID)
+		}
+		return cmp.Compare(a.ID, b.ID)
+	})
+	if opts.AfterID != nil {
+		clicks = slices.DeleteFunc(clicks, func(c ButtonClick) bool {
+			if opts.Order == Desc {
+				return c.ID >= *opts.AfterID
+			}
+			return c.ID <= *opts.AfterID
+		})
+	}
+
+	page := Page{Clicks: clicks}
+	if opts.Limit > 0 && len(clicks) > opts.Limit {
+		page.Clicks, page.More = clicks[:opts.Limit], true
+	}
+	return page, nil
+}
+
+func (r *MemoryRepository) Count(ctx context.Context, f Filter) (int64, error) {
+	clicks, err := r.matching(ctx, f)
+	return int64(len(clicks)), err
+}
+
+func (r *MemoryRepository) Aggregate(ctx context.Context, opts AggregateOptions) (Aggregation, error) {
+	loc := opts.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+	clicks, err := r.matching(ctx, opts.Filter)
+	if err != nil {
+		return Aggregation{}, err
+	}
+
+	counts := map[string]int64{}
+	for _, c := range clicks {
+		var key string
+		switch opts.GroupBy {
+		case GroupByHour, GroupByDay:
+			t := timeValue(c, opts.TimeField)
+			if t == nil {
+				continue // SQLのGROUP BYではNULLの区間になるが、時刻の区間として表せない
+			}
+			local := t.In(loc)
+			hour := local.Hour()
+			if opts.GroupBy == GroupByDay {
+				hour = 0
+			}
+			key = time.Date(local.Year(), local.Month(), local.Day(), hour, 0, 0, 0, loc).Format(time.RFC3339)
+		case GroupByUserAgent:
+			key = UserAgentFamily(c.UserAgent)
+		case GroupByIPAddress:
+			key = deref(c.IPAddress)
+		case GroupByAction, "":
+			key = deref(c.Action)
+		default:
+			return Aggregation{}, fmt.Errorf("unknown group by %q", opts.GroupBy)
+		}
+		counts[key]++
+	}
+
+	var agg Aggregation
+	for k, n := range counts {
+		agg.Buckets = append(agg.Buckets, Bucket{Key: k, Count: n})
+	}
+	// PgxRepositoryと同じ順（時間・日単位は新しい順、それ以外は件数の多い順）
+	slices.SortFunc(agg.Buckets, func(a, b Bucket) int {
+		if opts.IsTimeBucket() {
+			return cmp.Compare(bucketTime(b.Key), bucketTime(a.Key))
+		}
+		return cmp.Or(cmp.Compare(b.Count, a.Count), cmp.Compare(a.Key, b.Key))
+	})
+	if opts.Limit > 0 && len(agg.Buckets) > opts.Limit {
+		agg.Buckets, agg.Truncated = agg.Buckets[:opts.Limit], true
+	}
+	if opts.IsTimeBucket() {
+		slices.Reverse(agg.Buckets)
+	}
+	return agg, nil
+}
+
+func (r *MemoryRepository) Delete(ctx context.Context, id int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.clicks[id]; !ok {
+		return ErrNotFound
+	}
+	delete(r.clicks, id)
+	return nil
+}
+
+// matching はfの条件に合う行を返す（順序は不定）
+func (r *MemoryRepository) matching(ctx context.Context, f Filter) ([]ButtonClick, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var clicks []ButtonClick
+	for _, c := range r.clicks {
+		if f.From != nil || f.To != nil {
+			// SQLと同じく、NULLは期間の条件に合わない
+			t := timeValue(c, f.TimeField)
+			if t == nil || f.From != nil && t.Before(*f.From) || f.To != nil && !t.Before(*f.To) {
+				continue
+			}
+		}
+		if f.Action != "" && deref(c.Action) != f.Action {
+			continue
+		}
+		if f.IPAddress != "" && deref(c.IPAddress) != f.IPAddress {
+			continue
+		}
+		clicks = append(clicks, c)
+	}
+	return clicks, nil
+}
+
+func timeValue(c ButtonClick, f TimeField) *time.Time {
+	if f == TimeFieldCreatedAt {
+		return c.CreatedAt
+	}
+	return c.Timestamp
+}
+
+// bucketTime は集計のKey（RFC3339）を時刻に戻す
+func bucketTime(key string) int64 {
+	t, _ := time.Parse(time.RFC3339, key)
+	return t.Unix()
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -0,0 +1,21 @@
+package buttonclick_test
+
+import (
+	"testing"
+
+	"dsql-shared/buttonclick"
+	"dsql-shared/buttonclick/repotest"
+)
+
+func TestMemoryRepository(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) buttonclick.Repository {
+		return buttonclick.NewMemoryRepository()
+	}, func(t *testing.T, repo buttonclick.Repository, c buttonclick.NewClick) {
+		err := repo.(*buttonclick.MemoryRepository).Put(buttonclick.ButtonClick{
+			ID: c.ID, Action: &c.Action, UserAgent: &c.UserAgent, IPAddress: &c.IPAddress,
+		})
+		if err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+	})
+}
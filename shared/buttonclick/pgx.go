@@ -0,0 +1,258 @@
+package buttonclick
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Querier はpgxで文を実行できるもの（*pgxpool.Pool、*pgx.Conn、pgx.Txなど）
+type Querier interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}
+
+// PgxRepository はpgxでbutton_clicksを読み書きする（Aurora DSQLとPostgreSQLで共通）
+//
+// 1つのメソッドが1つの文を実行する。OCC競合の再試行や接続の作り直しは呼び出し側で行う
+// （トランザクション内で使う場合はdbにpgx.Txを渡す）。
+type PgxRepository struct {
+	db Querier
+}
+
+// NewPgxRepository はdbで文を実行するPgxRepositoryを返す
+func NewPgxRepository(db Querier) *PgxRepository {
+	return &PgxRepository{db: db}
+}
+
+// codeUniqueViolation は一意制約違反のSQLSTATE
+const codeUniqueViolation = "23505"
+
+func (r *PgxRepository) Insert(ctx context.Context, c NewClick) (ButtonClick, error) {
+	rows, err := r.db.Query(ctx, `
+		INSERT INTO `+Table+` (id, action, user_agent, ip_address)
+		VALUES ($1, $2, $3, $4)
+		RETURNING `+Columns, c.ID, c.Action, c.UserAgent, c.IPAddress)
+	if err != nil {
+		return ButtonClick{}, insertError(err)
+	}
+	click, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[ButtonClick])
+	if err != nil {
+		return ButtonClick{}, insertError(err)
+	}
+	return click, nil
+}
+
+// insertError は一意制約違反をErrDuplicateIDとしても判定できるようにする（元のPgErrorも残す）
+func insertError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == codeUniqueViolation {
+		return fmt.Errorf("%w: %w", ErrDuplicateID, err)
+	}
+	return err
+}
+
+func (r *PgxRepository) Get(ctx context.Context, id int64) (ButtonClick, error) {
+	rows, err := r.db.Query(ctx, "SELECT "+Columns+" FROM "+Table+" WHERE id = $1", id)
+	if err != nil {
+		return ButtonClick{}, err
+	}
+	click, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[ButtonClick])
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ButtonClick{}, ErrNotFound
+	}
+	return click, err
+}
+
+func (r *PgxRepository) List(ctx context.Context, opts ListOptions) (Page, error) {
+	var w where
+	if opts.AfterID != nil {
+		if opts.Order == Desc {
+			w.add("id < $%d", *opts.AfterID)
+		} else {
+			w.add("id > $%d", *opts.AfterID)
+		}
+	}
+	w.filter(opts.Filter)
+
+	order := "ASC"
+	if opts.Order == Desc {
+		order = "DESC"
+	}
+	query := "SELECT " + Columns + " FROM " + Table + w.String() + " ORDER BY id " + order
+	// 次のページの有無を判定するためにLimit+1件を取得する
+	if opts.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT $%d", w.arg(opts.Limit+1))
+	}
+
+	rows, err := r.db.Query(ctx, query, w.args...)
+	if err != nil {
+		return Page{}, err
+	}
+	clicks, err := pgx.CollectRows(rows, pgx.RowToStructByName[ButtonClick])
+	if err != nil {
+		return Page{}, err
+	}
+	page := Page{Clicks: clicks}
+	if opts.Limit > 0 && len(clicks) > opts.Limit {
+		page.Clicks, page.More = clicks[:opts.Limit], true
+	}
+	return page, nil
+}
+
+func (r *PgxRepository) Count(ctx context.Context, f Filter) (int64, error) {
+	var w where
+	w.filter(f)
+	rows, err := r.db.Query(ctx, "SELECT COUNT(*) FROM "+Table+w.String(), w.args...)
+	if err != nil {
+		return 0, err
+	}
+	return pgx.CollectOneRow(rows, pgx.RowTo[int64])
+}
+
+func (r *PgxRepository) Aggregate(ctx context.Context, opts AggregateOptions) (Aggregation, error) {
+	loc := opts.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+	var w where
+	w.filter(opts.Filter)
+
+	var keyExpr, orderBy string
+	switch opts.GroupBy {
+	case GroupByHour, GroupByDay:
+		// NULLの時刻は区間として表せないため数えない（MemoryRepositoryと同じ）
+		w.conditions = append(w.conditions, timeColumn(opts.TimeField)+" IS NOT NULL")
+		keyExpr = fmt.Sprintf("date_trunc('%s', %s AT TIME ZONE $%d)", opts.GroupBy, timeColumn(opts.TimeField), w.arg(loc.String()))
+		orderBy = "1 DESC"
+	case GroupByUserAgent:
+		keyExpr = userAgentFamilyExpr()
+		orderBy = "2 DESC, 1"
+	case GroupByIPAddress:
+		keyExpr = "COALESCE(ip_address, '')"
+		orderBy = "2 DESC, 1"
+	case GroupByAction, "":
+		keyExpr = "COALESCE(action, '')"
+		orderBy = "2 DESC, 1"
+	default:
+		return Aggregation{}, fmt.Errorf("unknown group by %q", opts.GroupBy)
+	}
+
+	query := "SELECT " + keyExpr + " AS key, COUNT(*) AS count FROM " + Table + w.String() + " GROUP BY 1 ORDER BY " + orderBy
+	if opts.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT $%d", w.arg(opts.Limit+1))
+	}
+	rows, err := r.db.Query(ctx, query, w.args...)
+	if err != nil {
+		return Aggregation{}, err
+	}
+	defer rows.Close()
+
+	var agg Aggregation
+	for rows.Next() {
+		var b Bucket
+		if opts.IsTimeBucket() {
+			// AT TIME ZONE の結果はタイムゾーンなしの壁時計の時刻
+			var wall time.Time
+			if err := rows.Scan(&wall, &b.Count); err != nil {
+				return Aggregation{}, err
+			}
+			local := time.Date(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), 0, 0, 0, loc)
+			b.Key = local.Format(time.RFC3339)
+		} else if err := rows.Scan(&b.Key, &b.Count); err != nil {
+			return Aggregation{}, err
+		}
+		agg.Buckets = append(agg.Buckets, b)
+	}
+	if err := rows.Err(); err != nil {
+		return Aggregation{}, err
+	}
+
+	if opts.Limit > 0 && len(agg.Buckets) > opts.Limit {
+		agg.Buckets, agg.Truncated = agg.Buckets[:opts.Limit], true
+	}
+	// 時間・日単位は古い順に並べ直す
+	if opts.IsTimeBucket() {
+		slices.Reverse(agg.Buckets)
+	}
+	return agg, nil
+}
+
+func (r *PgxRepository) Delete(ctx context.Context, id int64) error {
+	tag, err := r.db.Exec(ctx, "DELETE FROM "+Table+" WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// timeColumn は期間の条件に使うSQLの列名を返す
+func timeColumn(f TimeField) string {
+	if f == TimeFieldCreatedAt {
+		return "created_at"
+	}
+	return `"timestamp"`
+}
+
+// where はパラメータ化したWHERE句を組み立てる
+type where struct {
+	conditions []string
+	args       []any
+}
+
+// arg は引数を追加し、その番号を返す
+func (w *where) arg(v any) int {
+	w.args = append(w.args, v)
+	return len(w.args)
+}
+
+// add は条件を追加する。condの %d は引数の番号になる
+func (w *where) add(cond string, v any) {
+	w.conditions = append(w.conditions, fmt.Sprintf(cond, w.arg(v)))
+}
+
+func (w *where) filter(f Filter) {
+	if f.From != nil {
+		w.add(timeColumn(f.TimeField)+" >= $%d", *f.From)
+	}
+	if f.To != nil {
+		w.add(timeColumn(f.TimeField)+" < $%d", *f.To)
+	}
+	if f.Action != "" {
+		w.add("action = $%d", f.Action)
+	}
+	if f.IPAddress != "" {
+		w.add("ip_address = $%d", f.IPAddress)
+	}
+}
+
+func (w *where) String() string {
+	if len(w.conditions) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(w.conditions, " AND ")
+}
+
+// userAgentFamilyExpr はUserAgentFamilyと同じ判定をするCASE式を返す
+func userAgentFamilyExpr() string {
+	var b strings.Builder
+	b.WriteString("CASE WHEN user_agent IS NULL OR user_agent IN ('', 'Unknown') THEN 'Unknown'")
+	for _, f := range userAgentFamilies {
+		var likes []string
+		for _, pattern := range f.Patterns {
+			likes = append(likes, fmt.Sprintf("user_agent ILIKE '%s'", pattern))
+		}
+		fmt.Fprintf(&b, " WHEN %s THEN '%s'", strings.Join(likes, " OR "), f.Family)
+	}
+	b.WriteString(" ELSE 'Other' END")
+	return b.String()
+}
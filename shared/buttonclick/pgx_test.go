@@ -0,0 +1,21 @@
+package buttonclick_test
+
+import (
+	"testing"
+
+	"dsql-shared/buttonclick"
+	"dsql-shared/buttonclick/repotest"
+	"dsql-shared/testdb"
+)
+
+// TestPgxRepository はTEST_DATABASE_URLのPostgreSQLで実行する（未設定ならスキップ）
+func TestPgxRepository(t *testing.T) {
+	pool := testdb.Pool(t, "test_buttonclick")
+	repotest.Run(t, func(t *testing.T) buttonclick.Repository {
+		testdb.ResetButtonClicks(t, pool)
+		return buttonclick.NewPgxRepository(pool)
+	}, func(t *testing.T, _ buttonclick.Repository, c buttonclick.NewClick) {
+		testdb.Exec(t, pool, `INSERT INTO button_clicks (id, "timestamp", action, user_agent, ip_address, created_at)
+			VALUES ($1, NULL, $2, $3, $4, NULL)`, c.ID, c.Action, c.UserAgent, c.IPAddress)
+	})
+}
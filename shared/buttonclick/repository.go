@@ -0,0 +1,183 @@
+package buttonclick
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrNotFound は指定したIDの行がないことを表す
+	ErrNotFound = errors.New("button click not found")
+	// ErrDuplicateID は挿入しようとしたIDの行がすでにあることを表す
+	ErrDuplicateID = errors.New("duplicate button click id")
+)
+
+// Repository はbutton_clicksの読み書き
+//
+// 実装はpgx（DSQLとPostgreSQL）とメモリ上のもの。どの実装も repotest.Run の
+// テストに通ること。
+type Repository interface {
+	// Insert は行を挿入し、データベースが設定した列を含む行を返す。IDが重複する場合はErrDuplicateID
+	Insert(ctx context.Context, c NewClick) (ButtonClick, error)
+	// Get はIDの行を返す。なければErrNotFound
+	Get(ctx context.Context, id int64) (ButtonClick, error)
+	// List は条件に合う行をIDの順に返す
+	List(ctx context.Context, opts ListOptions) (Page, error)
+	// Count は条件に合う行数を返す
+	Count(ctx context.Context, f Filter) (int64, error)
+	// Aggregate は条件に合う行数をグループごとに数える
+	Aggregate(ctx context.Context, opts AggregateOptions) (Aggregation, error)
+	// Delete はIDの行を削除する。なければErrNotFound
+	Delete(ctx context.Context, id int64) error
+}
+
+// NewClick は挿入する行。IDは呼び出し側で採番する（dsql-shared/idgen）
+type NewClick struct {
+	ID        int64
+	Action    string
+	UserAgent string
+	IPAddress string
+}
+
+// TimeField は期間の条件に使う列
+type TimeField string
+
+const (
+	TimeFieldTimestamp TimeField = "timestamp"
+	TimeFieldCreatedAt TimeField = "created_at"
+)
+
+// Filter は一覧・件数・集計の条件。空のフィールドは条件にしない
+type Filter struct {
+	// TimeField はFromとToを比べる列（空ならtimestamp）
+	TimeField TimeField
+	From      *time.Time // この時刻以降
+	To        *time.Time // この時刻より前
+	Action    string
+	IPAddress string
+}
+
+// Order はIDの並び順
+type Order string
+
+const (
+	Asc  Order = "asc"
+	Desc Order = "desc"
+)
+
+// ListOptions は一覧の条件
+type ListOptions struct {
+	Filter
+	Order Order // 空ならAsc
+	// AfterID があれば、Orderの順でこのIDより後の行を返す（キーセット方式のページ分割）
+	AfterID *int64
+	// Limit は最大件数（0以下なら制限しない）
+	Limit int
+}
+
+// Page は一覧の結果
+type Page struct {
+	Clicks []ButtonClick
+	// More はLimitより後にも行があるかどうか
+	More bool
+}
+
+// GroupBy は集計の単位
+type GroupBy string
+
+const (
+	GroupByAction    GroupBy = "action"
+	GroupByHour      GroupBy = "hour"
+	GroupByDay       GroupBy = "day"
+	GroupByUserAgent GroupBy = "user_agent" // UserAgentFamilyの種類ごと
+	GroupByIPAddress GroupBy = "ip_address"
+)
+
+// GroupByValues は指定できる集計の単位
+var GroupByValues = []GroupBy{GroupByAction, GroupByHour, GroupByDay, GroupByUserAgent, GroupByIPAddress}
+
+// AggregateOptions は集計の条件
+type AggregateOptions struct {
+	Filter
+	GroupBy GroupBy
+	// Location は時間・日単位で区切るタイムゾーン（nilならUTC）
+	Location *time.Location
+	// Limit は最大のグループ数（0以下なら制限しない）
+	Limit int
+}
+
+// IsTimeBucket は時間・日単位の集計かどうかを返す
+func (o AggregateOptions) IsTimeBucket() bool {
+	return o.GroupBy == GroupByHour || o.GroupBy == GroupByDay
+}
+
+// Bucket は集計結果の1グループ
+type Bucket struct {
+	// Key はグループの値。時間・日単位ではLocationでの区間の始まり（RFC3339）。
+	// NULLは空文字列になる
+	Key   string
+	Count int64
+}
+
+// Aggregation は集計の結果
+//
+// 件数の多い順（同数はKeyの順）に並ぶ。時間・日単位は新しい方からLimit個の区間を古い順に並べる。
+type Aggregation struct {
+	Buckets []Bucket
+	// Truncated はLimitを超えるグループがあったかどうか
+	Truncated bool
+}
+
+// userAgentFamilies はuser_agentをブラウザなどの種類にまとめるための判定（上から順に評価）
+var userAgentFamilies = []struct {
+	Family   string
+	Patterns []string // ILIKEのパターン
+}{
+	{"Bot", []string{"%bot%", "%spider%", "%crawl%"}},
+	{"Edge", []string{"%Edg/%"}},
+	{"Opera", []string{"%OPR/%"}},
+	{"Firefox", []string{"%Firefox/%"}},
+	{"Chrome", []string{"%Chrome/%", "%CriOS/%"}},
+	{"Safari", []string{"%Safari/%"}},
+	{"curl", []string{"curl/%"}},
+	{"Go", []string{"Go-http-client/%", "Go DSQL Client%"}},
+}
+
+// UserAgentFamily はuser_agentをブラウザなどの種類名にする（空や不明はUnknown、どれにも当たらなければOther）
+func UserAgentFamily(userAgent *string) string {
+	if userAgent == nil || *userAgent == "" || *userAgent == "Unknown" {
+		return "Unknown"
+	}
+	for _, f := range userAgentFamilies {
+		for _, pattern := range f.Patterns {
+			if ilike(*userAgent, pattern) {
+				return f.Family
+			}
+		}
+	}
+	return "Other"
+}
+
+// ilike は大文字小文字を区別せずにsがpattern（%だけを使うLIKEのパターン）に一致するかどうかを返す
+func ilike(s, pattern string) bool {
+	s, pattern = strings.ToLower(s), strings.ToLower(pattern)
+	parts := strings.Split(pattern, "%")
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	s = s[len(parts[0]):]
+	last := len(parts) - 1
+	for _, p := range parts[1:last] {
+		i := strings.Index(s, p)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(p):]
+	}
+	if last == 0 {
+		return s == ""
+	}
+	return strings.HasSuffix(s, parts[last])
+}
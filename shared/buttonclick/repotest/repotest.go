@@ -0,0 +1,359 @@
+// Package repotest はbuttonclick.Repositoryの実装が満たすべき動作を確かめるテスト
+//
+// 実装ごとのテストから次のように呼ぶ。newRepoは空のRepositoryを返すこと
+// （PgxRepositoryではテスト用のデータベースのbutton_clicksを空にしてから返す）。
+// insertNullTimesはtimestampとcreated_atがNULLの行をrepoに入れる（Insertでは作れないため）。
+//
+//	func TestMemoryRepository(t *testing.T) {
+//		repotest.Run(t, func(t *testing.T) buttonclick.Repository {
+//			return buttonclick.NewMemoryRepository()
+//		}, func(t *testing.T, repo buttonclick.Repository, c buttonclick.NewClick) {
+//			repo.(*buttonclick.MemoryRepository).Put(buttonclick.ButtonClick{ID: c.ID, Action: &c.Action})
+//		})
+//	}
+package repotest
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"dsql-shared/buttonclick"
+)
+
+// InsertNullTimes はtimestampとcreated_atがNULLで、それ以外がcの値の行をrepoに挿入する
+type InsertNullTimes func(t *testing.T, repo buttonclick.Repository, c buttonclick.NewClick)
+
+// Run はnewRepoが返すRepositoryに対してすべてのテストを実行する
+func Run(t *testing.T, newRepo func(t *testing.T) buttonclick.Repository, insertNullTimes InsertNullTimes) {
+	// 時刻がNULLの行を使うテスト
+	withNullTimes := func(fn func(*testing.T, buttonclick.Repository, InsertNullTimes)) func(*testing.T, buttonclick.Repository) {
+		return func(t *testing.T, repo buttonclick.Repository) { fn(t, repo, insertNullTimes) }
+	}
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, repo buttonclick.Repository)
+	}{
+		{"InsertAndGet", testInsertAndGet},
+		{"DuplicateID", testDuplicateID},
+		{"GetMissing", testGetMissing},
+		{"ListOrderAndPages", testListOrderAndPages},
+		{"ListFilter", withNullTimes(testListFilter)},
+		{"Count", withNullTimes(testCount)},
+		{"AggregateByAction", testAggregateByAction},
+		{"AggregateByUserAgent", testAggregateByUserAgent},
+		{"AggregateByDay", withNullTimes(testAggregateByDay)},
+		{"AggregateByHour", withNullTimes(testAggregateByHour)},
+		{"AggregateNullTimes", withNullTimes(testAggregateNullTimes)},
+		{"Delete", testDelete},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, newRepo(t))
+		})
+	}
+}
+
+// 挿入する行（IDの順に並べてある）
+var clicks = []buttonclick.NewClick{
+	{ID: 101, Action: "record", UserAgent: "Mozilla/5.0 Chrome/120.0 Safari/537.36", IPAddress: "192.0.2.1"},
+	{ID: 102, Action: "record", UserAgent: "Mozilla/5.0 Firefox/121.0", IPAddress: "192.0.2.2"},
+	{ID: 103, Action: "view", UserAgent: "curl/8.4.0", IPAddress: "192.0.2.1"},
+	{ID: 104, Action: "record", UserAgent: "Googlebot/2.1", IPAddress: "192.0.2.3"},
+	{ID: 105, Action: "view", UserAgent: "Unknown", IPAddress: "192.0.2.1"},
+}
+
+// nullTimes はtimestampとcreated_atがNULLの行（記録側以外から入った行を想定）
+var nullTimes = buttonclick.NewClick{ID: 106, Action: "view", UserAgent: "curl/8.4.0", IPAddress: "192.0.2.1"}
+
+func insertAll(t *testing.T, repo buttonclick.Repository) {
+	t.Helper()
+	for _, c := range clicks {
+		if _, err := repo.Insert(context.Background(), c); err != nil {
+			t.Fatalf("Insert(%d): %v", c.ID, err)
+		}
+	}
+}
+
+func ids(cs []buttonclick.ButtonClick) []int64 {
+	var ids []int64
+	for _, c := range cs {
+		ids = append(ids, c.ID)
+	}
+	return ids
+}
+
+func testInsertAndGet(t *testing.T, repo buttonclick.Repository) {
+	ctx := context.Background()
+	before := time.Now().Add(-time.Hour) // データベースとの時計のずれを許す
+	want := clicks[0]
+	inserted, err := repo.Insert(ctx, want)
+	if err != nil {
+		t.Fatalf("Insert: %v", err)
+	}
+	got, err := repo.Get(ctx, want.ID)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+
+	for _, c := range []buttonclick.ButtonClick{inserted, got} {
+		if c.ID != want.ID || deref(c.Action) != want.Action || deref(c.UserAgent) != want.UserAgent || deref(c.IPAddress) != want.IPAddress {
+			t.Errorf("got %+v, want the values of %+v", c, want)
+		}
+		if c.Timestamp == nil || c.CreatedAt == nil || c.Timestamp.Before(before) {
+			t.Errorf("timestamp and created_at must be set by the repository: %v, %v", c.Timestamp, c.CreatedAt)
+		}
+	}
+	if !inserted.Timestamp.Equal(*got.Timestamp) {
+		t.Errorf("Insert returned timestamp %v, Get returned %v", inserted.Timestamp, got.Timestamp)
+	}
+}
+
+func testDuplicateID(t *testing.T, repo buttonclick.Repository) {
+	ctx := context.Background()
+	if _, err := repo.Insert(ctx, clicks[0]); err != nil {
+		t.Fatalf("Insert: %v", err)
+	}
+	_, err := repo.Insert(ctx, clicks[0])
+	if !errors.Is(err, buttonclick.ErrDuplicateID) {
+		t.Fatalf("second Insert: got %v, want ErrDuplicateID", err)
+	}
+}
+
+func testGetMissing(t *testing.T, repo buttonclick.Repository) {
+	_, err := repo.Get(context.Background(), 999)
+	if !errors.Is(err, buttonclick.ErrNotFound) {
+		t.Fatalf("got %v, want ErrNotFound", err)
+	}
+}
+
+func testListOrderAndPages(t *testing.T, repo buttonclick.Repository) {
+	ctx := context.Background()
+	insertAll(t, repo)
+
+	tests := []struct {
+		name string
+		opts buttonclick.ListOptions
+		want []int64
+		more bool
+	}{
+		{"all asc", buttonclick.ListOptions{}, []int64{101, 102, 103, 104, 105}, false},
+		{"all desc", buttonclick.ListOptions{Order: buttonclick.Desc}, []int64{105, 104, 103, 102, 101}, false},
+		{"first page", buttonclick.ListOptions{Limit: 2}, []int64{101, 102}, true},
+		{"middle page", buttonclick.ListOptions{Limit: 2, AfterID: ptr(int64(102))}, []int64{103, 104}, true},
+		{"last page", buttonclick.ListOptions{Limit: 2, AfterID: ptr(int64(104))}, []int64{105}, false},
+		{"exact last page", buttonclick.ListOptions{Limit: 2, AfterID: ptr(int64(103))}, []int64{104, 105}, false},
+		{"desc page", buttonclick.ListOptions{Order: buttonclick.Desc, Limit: 2, AfterID: ptr(int64(104))}, []int64{103, 102}, true},
+		{"after the end", buttonclick.ListOptions{AfterID: ptr(int64(105))}, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, err := repo.List(ctx, tt.opts)
+			if err != nil {
+				t.Fatalf("List: %v", err)
+			}
+			if got := ids(page.Clicks); !slices.Equal(got, tt.want) || page.More != tt.more {
+				t.Errorf("got %v (more=%v), want %v (more=%v)", got, page.More, tt.want, tt.more)
+			}
+		})
+	}
+}
+
+func testListFilter(t *testing.T, repo buttonclick.Repository, insertNullTimes InsertNullTimes) {
+	ctx := context.Background()
+	insertAll(t, repo)
+	insertNullTimes(t, repo, nullTimes)
+	past, future := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+
+	tests := []struct {
+		name   string
+		filter buttonclick.Filter
+		want   []int64
+	}{
+		{"action", buttonclick.Filter{Action: "view"}, []int64{103, 105, 106}},
+		{"ip address", buttonclick.Filter{IPAddress: "192.0.2.1"}, []int64{101, 103, 105, 106}},
+		{"action and ip address", buttonclick.Filter{Action: "record", IPAddress: "192.0.2.1"}, []int64{101}},
+		{"no match", buttonclick.Filter{Action: "none"}, nil},
+		// NULLの時刻は期間の条件に合わない
+		{"from the past", buttonclick.Filter{From: &past}, []int64{101, 102, 103, 104, 105}},
+		{"from the future", buttonclick.Filter{From: &future}, nil},
+		{"until the past", buttonclick.Filter{To: &past}, nil},
+		{"created_at range", buttonclick.Filter{TimeField: buttonclick.TimeFieldCreatedAt, From: &past, To: &future}, []int64{101, 102, 103, 104, 105}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, err := repo.List(ctx, buttonclick.ListOptions{Filter: tt.filter})
+			if err != nil {
+				t.Fatalf("List: %v", err)
+			}
+			if got := ids(page.Clicks); !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func testCount(t *testing.T, repo buttonclick.Repository, insertNullTimes InsertNullTimes) {
+	ctx := context.Background()
+	n, err := repo.Count(ctx, buttonclick.Filter{})
+	if err != nil || n != 0 {
+		t.Fatalf("Count of an empty repository = %d, %v", n, err)
+	}
+	insertAll(t, repo)
+	insertNullTimes(t, repo, nullTimes)
+	past := time.Now().Add(-time.Hour)
+	for _, tt := range []struct {
+		filter buttonclick.Filter
+		want   int64
+	}{
+		{buttonclick.Filter{}, 6},
+		{buttonclick.Filter{Action: "record"}, 3},
+		{buttonclick.Filter{From: &past}, 5},
+		{buttonclick.Filter{IPAddress: "192.0.2.9"}, 0},
+	} {
+		n, err := repo.Count(ctx, tt.filter)
+		if err != nil || n != tt.want {
+			t.Errorf("Count(%+v) = %d, %v; want %d", tt.filter, n, err, tt.want)
+		}
+	}
+}
+
+func testAggregateByAction(t *testing.T, repo buttonclick.Repository) {
+	insertAll(t, repo)
+	agg, err := repo.Aggregate(context.Background(), buttonclick.AggregateOptions{GroupBy: buttonclick.GroupByAction})
+	if err != nil {
+		t.Fatalf("Aggregate: %v", err)
+	}
+	want := []buttonclick.Bucket{{Key: "record", Count: 3}, {Key: "view", Count: 2}}
+	if !slices.Equal(agg.Buckets, want) || agg.Truncated {
+		t.Errorf("got %+v (truncated=%v), want %+v", agg.Buckets, agg.Truncated, want)
+	}
+
+	agg, err = repo.Aggregate(context.Background(), buttonclick.AggregateOptions{GroupBy: buttonclick.GroupByIPAddress, Limit: 1})
+	if err != nil {
+		t.Fatalf("Aggregate: %v", err)
+	}
+	want = []buttonclick.Bucket{{Key: "192.0.2.1", Count: 3}}
+	if !slices.Equal(agg.Buckets, want) || !agg.Truncated {
+		t.Errorf("got %+v (truncated=%v), want %+v (truncated)", agg.Buckets, agg.Truncated, want)
+	}
+}
+
+func testAggregateByUserAgent(t *testing.T, repo buttonclick.Repository) {
+	insertAll(t, repo)
+	agg, err := repo.Aggregate(context.Background(), buttonclick.AggregateOptions{GroupBy: buttonclick.GroupByUserAgent})
+	if err != nil {
+		t.Fatalf("Aggregate: %v", err)
+	}
+	// 同数はKeyの順
+	want := []buttonclick.Bucket{{Key: "Bot", Count: 1}, {Key: "Chrome", Count: 1}, {Key: "Firefox", Count: 1}, {Key: "Unknown", Count: 1}, {Key: "curl", Count: 1}}
+	if !slices.Equal(agg.Buckets, want) {
+		t.Errorf("got %+v, want %+v", agg.Buckets, want)
+	}
+}
+
+func testAggregateByDay(t *testing.T, repo buttonclick.Repository, insertNullTimes InsertNullTimes) {
+	insertAll(t, repo)
+	insertNullTimes(t, repo, nullTimes)
+	loc := time.FixedZone("JST", 9*60*60)
+	agg, err := repo.Aggregate(context.Background(), buttonclick.AggregateOptions{GroupBy: buttonclick.GroupByDay, Location: loc})
+	if err != nil {
+		t.Fatalf("Aggregate: %v", err)
+	}
+	var total int64
+	for _, b := range agg.Buckets {
+		start, err := time.Parse(time.RFC3339, b.Key)
+		if err != nil {
+			t.Fatalf("bucket key %q is not RFC3339: %v", b.Key, err)
+		}
+		if _, offset := start.Zone(); offset != 9*60*60 || start.Hour() != 0 || start.Minute() != 0 {
+			t.Errorf("bucket key %q is not the start of a day in %s", b.Key, loc)
+		}
+		total += b.Count
+	}
+	// 日付をまたいで挿入した場合は2つに分かれる。時刻がNULLの行は数えない
+	if total != int64(len(clicks)) || len(agg.Buckets) == 0 || len(agg.Buckets) > 2 {
+		t.Errorf("got %+v, want all %d clicks in one or two days", agg.Buckets, len(clicks))
+	}
+}
+
+func testAggregateByHour(t *testing.T, repo buttonclick.Repository, insertNullTimes InsertNullTimes) {
+	insertAll(t, repo)
+	insertNullTimes(t, repo, nullTimes)
+	for _, field := range []buttonclick.TimeField{buttonclick.TimeFieldTimestamp, buttonclick.TimeFieldCreatedAt} {
+		agg, err := repo.Aggregate(context.Background(), buttonclick.AggregateOptions{
+			Filter:  buttonclick.Filter{TimeField: field},
+			GroupBy: buttonclick.GroupByHour,
+		})
+		if err != nil {
+			t.Fatalf("Aggregate by %s: %v", field, err)
+		}
+		var total int64
+		for i, b := range agg.Buckets {
+			start, err := time.Parse(time.RFC3339, b.Key)
+			if err != nil || start.Minute() != 0 || start.Second() != 0 {
+				t.Errorf("bucket key %q is not the start of an hour", b.Key)
+			}
+			if i > 0 && b.Key <= agg.Buckets[i-1].Key {
+				t.Errorf("buckets %+v are not in ascending order", agg.Buckets)
+			}
+			total += b.Count
+		}
+		// 時間をまたいで挿入した場合は2つに分かれる。時刻がNULLの行は数えない
+		if total != int64(len(clicks)) || len(agg.Buckets) == 0 || len(agg.Buckets) > 2 {
+			t.Errorf("by %s: got %+v, want all %d clicks in one or two hours", field, agg.Buckets, len(clicks))
+		}
+	}
+}
+
+// 時刻がNULLの行は時間・日単位の集計から除き、それ以外の集計では数える
+func testAggregateNullTimes(t *testing.T, repo buttonclick.Repository, insertNullTimes InsertNullTimes) {
+	ctx := context.Background()
+	insertNullTimes(t, repo, nullTimes)
+	for _, g := range []buttonclick.GroupBy{buttonclick.GroupByHour, buttonclick.GroupByDay} {
+		agg, err := repo.Aggregate(ctx, buttonclick.AggregateOptions{GroupBy: g, Limit: 10})
+		if err != nil {
+			t.Fatalf("Aggregate by %s: %v", g, err)
+		}
+		if len(agg.Buckets) != 0 || agg.Truncated {
+			t.Errorf("by %s: got %+v (truncated=%v), want no buckets", g, agg.Buckets, agg.Truncated)
+		}
+	}
+
+	agg, err := repo.Aggregate(ctx, buttonclick.AggregateOptions{GroupBy: buttonclick.GroupByAction})
+	if err != nil {
+		t.Fatalf("Aggregate by action: %v", err)
+	}
+	want := []buttonclick.Bucket{{Key: "view", Count: 1}}
+	if !slices.Equal(agg.Buckets, want) {
+		t.Errorf("by action: got %+v, want %+v", agg.Buckets, want)
+	}
+}
+
+func testDelete(t *testing.T, repo buttonclick.Repository) {
+	ctx := context.Background()
+	insertAll(t, repo)
+	if err := repo.Delete(ctx, 103); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := repo.Get(ctx, 103); !errors.Is(err, buttonclick.ErrNotFound) {
+		t.Errorf("Get after Delete: got %v, want ErrNotFound", err)
+	}
+	if err := repo.Delete(ctx, 103); !errors.Is(err, buttonclick.ErrNotFound) {
+		t.Errorf("second Delete: got %v, want ErrNotFound", err)
+	}
+	if n, err := repo.Count(ctx, buttonclick.Filter{}); err != nil || n != 4 {
+		t.Errorf("Count after Delete = %d, %v; want 4", n, err)
+	}
+}
+
+func ptr[T any](v T) *T { return &v }
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -0,0 +1,220 @@
+// Package dsqlconn はAurora DSQLへのIAM認証付き接続プールを作成する
+package dsqlconn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.opentelemetry.io/otel/attribute"
+
+	"dsql-shared/tracing"
+)
+
+const (
+	// DefaultPort はDSQLのポート番号
+	DefaultPort = 5432
+	// DefaultDatabase はDSQLのデフォルトデータベース名
+	DefaultDatabase = "postgres"
+	// DefaultTokenTTL は認証トークンの有効期限
+	DefaultTokenTTL = 30 * time.Second
+	// DefaultSSLMode はDSQL接続時のSSLモード
+	DefaultSSLMode = "verify-full"
+	// MaxTokenTTL はDSQLが受け付ける認証トークンの最大有効期限
+	MaxTokenTTL = 7 * 24 * time.Hour
+)
+
+// Config は接続プールの設定
+//
+// ゼロ値のプール設定はpgxpoolのデフォルトのままになる。
+type Config struct {
+	Endpoint  string // クラスタのホスト名
+	ClusterID string // Endpointが空の場合にRegionと組み合わせてホスト名を組み立てる
+	Port      int
+	Region    string
+	User      string
+	Database  string
+
+	// SSLMode は空の場合verify-full（sslnegotiation=direct）になる
+	SSLMode string
+
+	MaxConns          int32
+	MinConns          int32
+	MaxConnLifetime   time.Duration
+	MaxConnIdleTime   time.Duration
+	HealthCheckPeriod time.Duration
+
+	// TokenTTL は生成する認証トークンの有効期限
+	TokenTTL time.Duration
+
+	// TokenProvider がnilの場合はリージョンごとに共有するIAMTokenProviderを使う
+	TokenProvider TokenProvider
+}
+
+// Hostname はクラスタIDとリージョンからDSQLのホスト名を組み立てる
+func Hostname(clusterID, region string) string {
+	return fmt.Sprintf("%s.dsql.%s.on.aws", clusterID, region)
+}
+
+// Host は接続先のホスト名を返す
+func (c Config) Host() string {
+	if c.Endpoint != "" {
+		return c.Endpoint
+	}
+	if c.ClusterID != "" && c.Region != "" {
+		return Hostname(c.ClusterID, c.Region)
+	}
+	return ""
+}
+
+// Validate は設定の不備をまとめて返す
+func (c Config) Validate() error {
+	var errs []error
+	if c.Endpoint == "" && c.ClusterID == "" {
+		errs = append(errs, fmt.Errorf("endpoint is required: set %s or %s", EnvEndpoint, EnvClusterID))
+	}
+	if c.Region == "" && (c.Endpoint == "" || c.TokenProvider == nil) {
+		errs = append(errs, fmt.Errorf("region is required: set %s or %s", EnvRegion, EnvAWSRegion))
+	}
+	if c.User == "" {
+		errs = append(errs, fmt.Errorf("user is required: set %s", EnvUser))
+	}
+	if c.Port < 0 || c.Port > 65535 {
+		errs = append(errs, fmt.Errorf("port %d is out of range", c.Port))
+	}
+	if c.MaxConns < 0 || c.MinConns < 0 {
+		errs = append(errs, errors.New("pool sizes must not be negative"))
+	}
+	if c.MaxConns > 0 && c.MinConns > c.MaxConns {
+		errs = append(errs, fmt.Errorf("min conns (%d) must not exceed max conns (%d)", c.MinConns, c.MaxConns))
+	}
+	if c.TokenTTL < 0 || c.TokenTTL > MaxTokenTTL {
+		errs = append(errs, fmt.Errorf("token TTL %s must be between 0 and %s", c.TokenTTL, MaxTokenTTL))
+	}
+	return errors.Join(errs...)
+}
+
+// ConnString はパスワードを含まない接続URLを返す
+func (c Config) ConnString() string {
+	port := c.Port
+	if port == 0 {
+		port = DefaultPort
+	}
+	database := c.Database
+	if database == "" {
+		database = DefaultDatabase
+	}
+	sslMode := c.SSLMode
+	if sslMode == "" {
+		sslMode = DefaultSSLMode
+	}
+
+	connectionURL := fmt.Sprintf("postgres://%s@%s:%d/%s?sslmode=%s",
+		c.User,
+		c.Host(),
+		port,
+		database,
+		sslMode,
+	)
+	// DSQLはTLSの直接ネゴシエーションに対応している
+	if sslMode == DefaultSSLMode {
+		connectionURL += "&sslnegotiation=direct"
+	}
+	return connectionURL
+}
+
+// PoolConfig は認証トークンを設定するBeforeConnectフック付きのプール設定を返す
+func PoolConfig(ctx context.Context, cfg Config) (*pgxpool.Config, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid DSQL config: %w", err)
+	}
+
+	poolConfig, err := pgxpool.ParseConfig(cfg.ConnString())
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse pool config: %w", err)
+	}
+
+	provider := cfg.TokenProvider
+	if provider == nil {
+		p, err := defaultTokenProvider(ctx, cfg.Region)
+		if err != nil {
+			return nil, err
+		}
+		provider = p
+	}
+	ttl := cfg.TokenTTL
+	if ttl == 0 {
+		ttl = DefaultTokenTTL
+	}
+	req := TokenRequest{
+		Host:      cfg.Host(),
+		Region:    cfg.Region,
+		User:      cfg.User,
+		ExpiresIn: ttl,
+	}
+
+	// 接続前のフックを設定（トークン生成）
+	poolConfig.BeforeConnect = func(ctx context.Context, connCfg *pgx.ConnConfig) error {
+		ctx, span := tracing.Start(ctx, "dsqlconn.token", attribute.String("server.address", req.Host))
+		token, err := provider.Token(ctx, req)
+		tracing.End(span, err)
+		if err != nil {
+			return &TokenError{Err: err}
+		}
+
+		// トークンをパスワードとして設定
+		connCfg.Password = token
+		// トークンそのものは書かない
+		slog.DebugContext(ctx, "auth token generated", "host", req.Host, "user", req.User, "expires_in", req.ExpiresIn.String())
+		return nil
+	}
+
+	// クエリ・接続・プールからの取得をスパンにする（TracerProviderを設定しなければno-op）
+	poolConfig.ConnConfig.Tracer = tracing.PgxTracer{}
+
+	if cfg.MaxConns > 0 {
+		poolConfig.MaxConns = cfg.MaxConns
+	}
+	if cfg.MinConns > 0 {
+		poolConfig.MinConns = cfg.MinConns
+	}
+	if cfg.MaxConnLifetime > 0 {
+		poolConfig.MaxConnLifetime = cfg.MaxConnLifetime
+	}
+	if cfg.MaxConnIdleTime > 0 {
+		poolConfig.MaxConnIdleTime = cfg.MaxConnIdleTime
+	}
+	if cfg.HealthCheckPeriod > 0 {
+		poolConfig.HealthCheckPeriod = cfg.HealthCheckPeriod
+	}
+
+	return poolConfig, nil
+}
+
+// New は接続プールを作成し、疎通確認まで行う
+func New(ctx context.Context, cfg Config) (pool *pgxpool.Pool, err error) {
+	ctx, span := tracing.Start(ctx, "dsqlconn.New", attribute.String("server.address", cfg.Host()))
+	defer func() { tracing.End(span, err) }()
+
+	poolConfig, err := PoolConfig(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	pool, err = pgxpool.NewWithConfig(ctx, poolConfig)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create connection pool: %w", err)
+	}
+
+	// 接続をテスト
+	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("failed to ping database: %w", err)
+	}
+
+	return pool, nil
+}
@@ -0,0 +1,137 @@
+package dsqlconn
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"dsql-shared/tracing"
+)
+
+// envTestDatabaseURL はローカルPostgreSQLに接続するテストの接続先（未設定ならスキップ）
+const envTestDatabaseURL = "TEST_DATABASE_URL"
+
+func TestPoolConfigStaticToken(t *testing.T) {
+	cfg := Config{
+		Endpoint:        "db.example.internal",
+		Port:            15432,
+		User:            "app",
+		Database:        "clicks",
+		SSLMode:         "disable",
+		MaxConns:        4,
+		MinConns:        1,
+		MaxConnLifetime: time.Minute,
+		TokenProvider:   StaticToken("secret"),
+	}
+	poolConfig, err := PoolConfig(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("PoolConfig: %v", err)
+	}
+
+	cc := poolConfig.ConnConfig
+	if cc.Host != cfg.Endpoint || cc.Port != 15432 || cc.User != "app" || cc.Database != "clicks" {
+		t.Errorf("conn config = %s:%d %s/%s", cc.Host, cc.Port, cc.User, cc.Database)
+	}
+	if cc.TLSConfig != nil {
+		t.Errorf("sslmode=disable must not set TLS config")
+	}
+	if cc.Password != "" {
+		t.Errorf("password must be set by BeforeConnect, got %q", cc.Password)
+	}
+	if _, ok := cc.Tracer.(tracing.PgxTracer); !ok {
+		t.Errorf("tracer = %T, want tracing.PgxTracer", cc.Tracer)
+	}
+	if poolConfig.MaxConns != 4 || poolConfig.MinConns != 1 || poolConfig.MaxConnLifetime != time.Minute {
+		t.Errorf("pool sizes = max %d min %d lifetime %s", poolConfig.MaxConns, poolConfig.MinConns, poolConfig.MaxConnLifetime)
+	}
+
+	connCfg := cc.Copy()
+	if err := poolConfig.BeforeConnect(context.Background(), connCfg); err != nil {
+		t.Fatalf("BeforeConnect: %v", err)
+	}
+	if connCfg.Password != "secret" {
+		t.Errorf("password = %q, want the static token", connCfg.Password)
+	}
+}
+
+func TestPoolConfigInvalid(t *testing.T) {
+	_, err := PoolConfig(context.Background(), Config{TokenProvider: StaticToken("x")})
+	if err == nil {
+		t.Fatal("PoolConfig succeeded without endpoint and user")
+	}
+	for _, want := range []string{"endpoint is required", "user is required"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not contain %q", err, want)
+		}
+	}
+}
+
+func TestConnString(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+		want string
+	}{
+		{
+			name: "dsql defaults",
+			cfg:  Config{ClusterID: "abc", Region: "us-east-1", User: "admin"},
+			want: "postgres://admin@abc.dsql.us-east-1.on.aws:5432/postgres?sslmode=verify-full&sslnegotiation=direct",
+		},
+		{
+			name: "local postgres",
+			cfg:  Config{Endpoint: "localhost", Port: 5433, User: "app", Database: "clicks", SSLMode: "disable"},
+			want: "postgres://app@localhost:5433/clicks?sslmode=disable",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.cfg.ConnString(); got != tt.want {
+				t.Errorf("ConnString() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestNewLocalPostgres はStaticTokenでローカルPostgreSQLに接続できることを確かめる
+func TestNewLocalPostgres(t *testing.T) {
+	dsn := os.Getenv(envTestDatabaseURL)
+	if dsn == "" {
+		t.Skipf("%s is not set", envTestDatabaseURL)
+	}
+	parsed, err := pgx.ParseConfig(dsn)
+	if err != nil {
+		t.Fatalf("invalid %s: %v", envTestDatabaseURL, err)
+	}
+	sslMode := "disable"
+	if parsed.TLSConfig != nil {
+		sslMode = "require"
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	pool, err := New(ctx, Config{
+		Endpoint:      parsed.Host,
+		Port:          int(parsed.Port),
+		User:          parsed.User,
+		Database:      parsed.Database,
+		SSLMode:       sslMode,
+		MaxConns:      2,
+		TokenProvider: StaticToken(parsed.Password),
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer pool.Close()
+
+	var user string
+	if err := pool.QueryRow(ctx, "SELECT current_user").Scan(&user); err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	if user != parsed.User {
+		t.Errorf("current_user = %q, want %q", user, parsed.User)
+	}
+}
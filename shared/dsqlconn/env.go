@@ -0,0 +1,116 @@
+package dsqlconn
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// 設定を読み込む環境変数名
+const (
+	EnvEndpoint          = "DSQL_ENDPOINT"
+	EnvClusterID         = "DSQL_CLUSTER_IDENTIFIER"
+	EnvRegion            = "DSQL_REGION"
+	EnvAWSRegion         = "AWS_REGION"
+	EnvAWSDefaultRegion  = "AWS_DEFAULT_REGION"
+	EnvPort              = "DSQL_PORT"
+	EnvUser              = "DSQL_USER"
+	EnvDatabase          = "DATABASE_NAME"
+	EnvDatabaseLegacy    = "DSQL_DATABASE"
+	EnvMaxConns          = "DSQL_MAX_CONNS"
+	EnvMinConns          = "DSQL_MIN_CONNS"
+	EnvMaxConnLifetime   = "DSQL_MAX_CONN_LIFETIME"
+	EnvMaxConnIdleTime   = "DSQL_MAX_CONN_IDLE_TIME"
+	EnvHealthCheckPeriod = "DSQL_HEALTH_CHECK_PERIOD"
+	EnvTokenTTL          = "DSQL_TOKEN_TTL"
+)
+
+// FromEnv はdefaultsに環境変数の値を上書きした設定を返す
+//
+// getenvには通常os.Getenvを渡す。値の形式が不正な環境変数はまとめてエラーにする。
+// 必須項目のチェックはValidateで行う。
+func FromEnv(defaults Config, getenv func(string) string) (Config, error) {
+	cfg := defaults
+	var errs []error
+
+	setString := func(dst *string, keys ...string) {
+		for _, key := range keys {
+			if v := getenv(key); v != "" {
+				*dst = v
+				return
+			}
+		}
+	}
+	setInt32 := func(dst *int32, key string) {
+		if v := getenv(key); v != "" {
+			n, err := strconv.ParseInt(v, 10, 32)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: invalid integer %q", key, v))
+				return
+			}
+			*dst = int32(n)
+		}
+	}
+	setDuration := func(dst *time.Duration, key string) {
+		if v := getenv(key); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: invalid duration %q (e.g. 30s, 5m)", key, v))
+				return
+			}
+			*dst = d
+		}
+	}
+
+	setString(&cfg.Endpoint, EnvEndpoint)
+	setString(&cfg.ClusterID, EnvClusterID)
+	setString(&cfg.Region, EnvRegion, EnvAWSRegion, EnvAWSDefaultRegion)
+	setString(&cfg.User, EnvUser)
+	setString(&cfg.Database, EnvDatabase, EnvDatabaseLegacy)
+
+	if v := getenv(EnvPort); v != "" {
+		port, err := strconv.Atoi(v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: invalid port %q", EnvPort, v))
+		} else {
+			cfg.Port = port
+		}
+	}
+
+	setInt32(&cfg.MaxConns, EnvMaxConns)
+	setInt32(&cfg.MinConns, EnvMinConns)
+	setDuration(&cfg.MaxConnLifetime, EnvMaxConnLifetime)
+	setDuration(&cfg.MaxConnIdleTime, EnvMaxConnIdleTime)
+	setDuration(&cfg.HealthCheckPeriod, EnvHealthCheckPeriod)
+	setDuration(&cfg.TokenTTL, EnvTokenTTL)
+
+	return cfg, errors.Join(errs...)
+}
+
+// RegisterFlags は設定を上書きするフラグをfsに登録する
+//
+// 現在の値がフラグのデフォルトになるため、FromEnvの後に呼び出す。
+func (c *Config) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.Endpoint, "endpoint", c.Endpoint, "DSQL cluster endpoint ("+EnvEndpoint+")")
+	fs.StringVar(&c.ClusterID, "cluster-id", c.ClusterID, "DSQL cluster identifier, used with -region when -endpoint is empty ("+EnvClusterID+")")
+	fs.StringVar(&c.Region, "region", c.Region, "AWS region of the cluster ("+EnvRegion+", "+EnvAWSRegion+")")
+	fs.IntVar(&c.Port, "port", c.Port, "port number ("+EnvPort+")")
+	fs.StringVar(&c.User, "user", c.User, "database user ("+EnvUser+")")
+	fs.StringVar(&c.Database, "database", c.Database, "database name ("+EnvDatabase+")")
+	fs.Func("max-conns", fmt.Sprintf("maximum pool size (%s, default %d)", EnvMaxConns, c.MaxConns), int32Flag(&c.MaxConns))
+	fs.Func("min-conns", fmt.Sprintf("minimum pool size (%s, default %d)", EnvMinConns, c.MinConns), int32Flag(&c.MinConns))
+	fs.DurationVar(&c.TokenTTL, "token-ttl", c.TokenTTL, "auth token lifetime ("+EnvTokenTTL+")")
+}
+
+func int32Flag(dst *int32) func(string) error {
+	return func(s string) error {
+		n, err := strconv.ParseInt(s, 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", s)
+		}
+		*dst = int32(n)
+		return nil
+	}
+}
@@ -0,0 +1,61 @@
+package dsqlconn
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// TokenError は接続前の認証トークンの取得に失敗したエラー（PoolConfigのBeforeConnectが返す）
+type TokenError struct {
+	Err error
+}
+
+func (e *TokenError) Error() string { return e.Err.Error() }
+
+func (e *TokenError) Unwrap() error { return e.Err }
+
+// IsConnError は接続自体が壊れている（プールを作り直すべき）エラーかどうかを返す
+//
+// 接続の確立の失敗（*pgconn.ConnectError）、ネットワークのエラー（net.Error）、
+// 通信の途中での切断（io.EOF）、送信前に失敗したエラー（pgconn.SafeToRetry）、
+// 認証トークンの取得の失敗（*TokenError）だけをtrueにする。サーバーが返したSQLエラーは
+// 接続が生きているためfalseになる。ただし接続例外（SQLSTATE 08xxx）やサーバー側からの
+// 切断（57P01など）はtrueになる。キャンセルやタイムアウト、スキャンの失敗などそれ以外のエラーはfalse。
+func IsConnError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	// 接続時の認証エラー（28P01など）もPgErrorとして返るため先に判定する
+	var connectErr *pgconn.ConnectError
+	if errors.As(err, &connectErr) {
+		return true
+	}
+	// context.DeadlineExceededはnet.Errorでもあるため、クエリのタイムアウトは先に除く
+	if errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return strings.HasPrefix(pgErr.Code, "08") ||
+			pgErr.Code == "57P01" || // admin_shutdown
+			pgErr.Code == "57P02" || // crash_shutdown
+			pgErr.Code == "57P03" // cannot_connect_now
+	}
+
+	var netErr net.Error
+	var tokenErr *TokenError
+	return errors.As(err, &tokenErr) ||
+		errors.As(err, &netErr) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		pgconn.SafeToRetry(err)
+}
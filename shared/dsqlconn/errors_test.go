@@ -0,0 +1,102 @@
+package dsqlconn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// safeToRetryError は送信前に失敗したpgconnのエラーを模す
+type safeToRetryError struct{}
+
+func (safeToRetryError) Error() string     { return "write failed before sending" }
+func (safeToRetryError) SafeToRetry() bool { return true }
+
+// connectError は接続できないポートに接続して本物の*pgconn.ConnectErrorを作る
+func connectError(t *testing.T) error {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	conn, err := pgconn.Connect(ctx, "postgres://app@"+addr+"/postgres?sslmode=disable")
+	if err == nil {
+		conn.Close(ctx)
+		t.Fatal("connected to a closed port")
+	}
+	var connectErr *pgconn.ConnectError
+	if !errors.As(err, &connectErr) {
+		t.Fatalf("error is %T, want *pgconn.ConnectError", err)
+	}
+	return err
+}
+
+func TestIsConnError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"connect error", connectError(t), true},
+		{"net error", &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}, true},
+		{"wrapped net error", fmt.Errorf("query: %w", &net.OpError{Op: "write", Net: "tcp", Err: syscall.EPIPE}), true},
+		{"EOF", fmt.Errorf("receive message: %w", io.EOF), true},
+		{"unexpected EOF", io.ErrUnexpectedEOF, true},
+		{"safe to retry", safeToRetryError{}, true},
+		{"token error", &TokenError{Err: errors.New("failed to generate auth token: no credentials")}, true},
+		{"connection exception", &pgconn.PgError{Code: "08006"}, true},
+		{"admin shutdown", &pgconn.PgError{Code: "57P01"}, true},
+		{"cannot connect now", &pgconn.PgError{Code: "57P03"}, true},
+		{"unique violation", &pgconn.PgError{Code: "23505"}, false},
+		{"serialization failure", fmt.Errorf("commit: %w", &pgconn.PgError{Code: "40001"}), false},
+		{"dsql occ", &pgconn.PgError{Code: "OC000"}, false},
+		{"canceled", context.Canceled, false},
+		{"canceled net error", fmt.Errorf("%w: %w", context.Canceled, &net.OpError{Op: "read", Err: syscall.ECONNRESET}), false},
+		{"deadline exceeded", fmt.Errorf("timeout: %w", context.DeadlineExceeded), false},
+		{"scan error", errors.New("can't scan into dest[0]: cannot scan NULL into *int64"), false},
+		{"no rows", fmt.Errorf("get: %w", errors.New("no rows in result set")), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsConnError(tt.err); got != tt.want {
+				t.Errorf("IsConnError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBeforeConnectReturnsTokenError(t *testing.T) {
+	poolConfig, err := PoolConfig(context.Background(), Config{
+		Endpoint:      "localhost",
+		User:          "app",
+		SSLMode:       "disable",
+		TokenProvider: failingToken{},
+	})
+	if err != nil {
+		t.Fatalf("PoolConfig: %v", err)
+	}
+	err = poolConfig.BeforeConnect(context.Background(), poolConfig.ConnConfig.Copy())
+	var tokenErr *TokenError
+	if !errors.As(err, &tokenErr) || !IsConnError(err) {
+		t.Errorf("BeforeConnect error = %v (%T), want *TokenError", err, err)
+	}
+}
+
+type failingToken struct{}
+
+func (failingToken) Token(context.Context, TokenRequest) (string, error) {
+	return "", errors.New("credentials unavailable")
+}
@@ -0,0 +1,272 @@
+package dsqlconn
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/dsql/auth"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"dsql-shared/tracing"
+)
+
+// AdminUser はAdmin用トークンで接続するユーザー名
+const AdminUser = "admin"
+
+const (
+	// DefaultRefreshFraction は有効期限のうちこの割合が経過したら裏で再生成する
+	DefaultRefreshFraction = 0.5
+	// DefaultRefreshTimeout は裏で行う再生成のタイムアウト
+	DefaultRefreshTimeout = 10 * time.Second
+)
+
+// TokenRequest はトークン生成に必要な接続先情報
+type TokenRequest struct {
+	Host      string
+	Region    string
+	User      string
+	ExpiresIn time.Duration
+}
+
+// Admin はAdmin用トークンが必要かどうかを返す
+func (r TokenRequest) Admin() bool {
+	return r.User == AdminUser
+}
+
+// TokenProvider は接続ごとのパスワード（認証トークン）を返す
+//
+// AWSに接続しないテストではStaticTokenなどに差し替える。
+type TokenProvider interface {
+	Token(ctx context.Context, req TokenRequest) (string, error)
+}
+
+// TokenStats はIAMTokenProviderのキャッシュ統計
+type TokenStats struct {
+	Hits          int64 // キャッシュから返した回数
+	Misses        int64 // キャッシュがなく同期的に生成した回数
+	Refreshes     int64 // 裏で再生成した回数
+	RefreshErrors int64 // 裏での再生成に失敗した回数
+}
+
+// IAMTokenProviderOptions はIAMTokenProviderの設定
+type IAMTokenProviderOptions struct {
+	// RefreshFraction は有効期限のうち再生成を始めるまでの割合（0より大きく1未満）
+	RefreshFraction float64
+	// RefreshTimeout は裏で行う再生成のタイムアウト
+	RefreshTimeout time.Duration
+	// Now は現在時刻を返す（テストで差し替える）
+	Now func() time.Time
+}
+
+type tokenKey struct {
+	host   string
+	region string
+	user   string
+	admin  bool
+}
+
+type cachedToken struct {
+	token      string
+	issuedAt   time.Time
+	expiresIn  time.Duration
+	refreshing bool
+}
+
+// IAMTokenProvider はAWS認証情報からIAM認証トークンを生成し、有効期限内はキャッシュする
+//
+// 有効期限のRefreshFractionが経過したトークンはキャッシュから返しつつ裏で再生成する。
+type IAMTokenProvider struct {
+	credentials aws.CredentialsProvider
+	opts        IAMTokenProviderOptions
+
+	mu     sync.Mutex
+	tokens map[tokenKey]*cachedToken
+
+	hits          atomic.Int64
+	misses        atomic.Int64
+	refreshes     atomic.Int64
+	refreshErrors atomic.Int64
+}
+
+// NewIAMTokenProvider は指定した認証情報でトークンを生成するIAMTokenProviderを作成する
+func NewIAMTokenProvider(credentials aws.CredentialsProvider, optFns ...func(*IAMTokenProviderOptions)) *IAMTokenProvider {
+	opts := IAMTokenProviderOptions{
+		RefreshFraction: DefaultRefreshFraction,
+		RefreshTimeout:  DefaultRefreshTimeout,
+		Now:             time.Now,
+	}
+	for _, fn := range optFns {
+		fn(&opts)
+	}
+	if opts.RefreshFraction <= 0 || opts.RefreshFraction >= 1 {
+		opts.RefreshFraction = DefaultRefreshFraction
+	}
+	if opts.RefreshTimeout <= 0 {
+		opts.RefreshTimeout = DefaultRefreshTimeout
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+
+	return &IAMTokenProvider{
+		credentials: credentials,
+		opts:        opts,
+		tokens:      make(map[tokenKey]*cachedToken),
+	}
+}
+
+// LoadIAMTokenProvider はAWSのデフォルト設定を一度だけロードしてIAMTokenProviderを作成する
+func LoadIAMTokenProvider(ctx context.Context, region string, optFns ...func(*IAMTokenProviderOptions)) (*IAMTokenProvider, error) {
+	ctx, span := tracing.Start(ctx, "dsqlconn.LoadAWSConfig", attribute.String("cloud.region", region))
+	awsCfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(region))
+	tracing.End(span, err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+	return NewIAMTokenProvider(awsCfg.Credentials, optFns...), nil
+}
+
+var (
+	defaultProvidersMu sync.Mutex
+	defaultProviders   = make(map[string]*defaultProvider)
+
+	// loadDefaultProvider はリージョンのIAMTokenProviderを作る（テストで差し替える）
+	loadDefaultProvider = func(ctx context.Context, region string) (*IAMTokenProvider, error) {
+		return LoadIAMTokenProvider(ctx, region)
+	}
+)
+
+// defaultProvider はリージョンのIAMTokenProviderを一度だけロードする
+type defaultProvider struct {
+	once     sync.Once
+	provider *IAMTokenProvider
+	err      error
+}
+
+// defaultTokenProvider はリージョンごとにプロセス内で共有するIAMTokenProviderを返す
+//
+// Lambdaでプールを作り直してもAWS設定のロードとトークンのキャッシュを再利用できる。
+// ロードはグローバルのロックの外で行うため、他のリージョンの呼び出しを待たせない。
+// 同じリージョンの呼び出しは最初のロードの完了を待ち、失敗した場合は次の呼び出しで再試行する。
+func defaultTokenProvider(ctx context.Context, region string) (*IAMTokenProvider, error) {
+	defaultProvidersMu.Lock()
+	d, ok := defaultProviders[region]
+	if !ok {
+		d = &defaultProvider{}
+		defaultProviders[region] = d
+	}
+	defaultProvidersMu.Unlock()
+
+	d.once.Do(func() {
+		d.provider, d.err = loadDefaultProvider(ctx, region)
+	})
+	if d.err != nil {
+		defaultProvidersMu.Lock()
+		if defaultProviders[region] == d {
+			delete(defaultProviders, region)
+		}
+		defaultProvidersMu.Unlock()
+		return nil, d.err
+	}
+	return d.provider, nil
+}
+
+// Token はキャッシュ済みのトークンを返し、なければ生成する
+func (p *IAMTokenProvider) Token(ctx context.Context, req TokenRequest) (string, error) {
+	key := tokenKey{host: req.Host, region: req.Region, user: req.User, admin: req.Admin()}
+	now := p.opts.Now()
+
+	p.mu.Lock()
+	if t, ok := p.tokens[key]; ok && now.Before(t.issuedAt.Add(t.expiresIn)) {
+		token := t.token
+		refreshAt := t.issuedAt.Add(time.Duration(float64(t.expiresIn) * p.opts.RefreshFraction))
+		if !t.refreshing && !now.Before(refreshAt) {
+			t.refreshing = true
+			go p.refresh(context.WithoutCancel(ctx), key, req)
+		}
+		p.mu.Unlock()
+		p.hits.Add(1)
+		trace.SpanFromContext(ctx).SetAttributes(attribute.Bool("dsql.token.cached", true))
+		return token, nil
+	}
+	p.mu.Unlock()
+
+	p.misses.Add(1)
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Bool("dsql.token.cached", false))
+	return p.generate(ctx, key, req)
+}
+
+// refresh は裏でトークンを再生成する
+func (p *IAMTokenProvider) refresh(ctx context.Context, key tokenKey, req TokenRequest) {
+	ctx, cancel := context.WithTimeout(ctx, p.opts.RefreshTimeout)
+	defer cancel()
+
+	p.refreshes.Add(1)
+	if _, err := p.generate(ctx, key, req); err != nil {
+		p.refreshErrors.Add(1)
+		slog.WarnContext(ctx, "background auth token refresh failed", "host", req.Host, "error", err)
+
+		// 次の接続時に再試行できるようにする
+		p.mu.Lock()
+		if t, ok := p.tokens[key]; ok {
+			t.refreshing = false
+		}
+		p.mu.Unlock()
+	}
+}
+
+// generate はトークンに署名してキャッシュに保存する
+func (p *IAMTokenProvider) generate(ctx context.Context, key tokenKey, req TokenRequest) (string, error) {
+	issuedAt := p.opts.Now()
+
+	tokenOptions := func(options *auth.TokenOptions) {
+		options.ExpiresIn = req.ExpiresIn
+	}
+
+	// adminユーザーの場合はAdmin用トークンを生成
+	var token string
+	var err error
+	if key.admin {
+		token, err = auth.GenerateDBConnectAdminAuthToken(ctx, req.Host, req.Region, p.credentials, tokenOptions)
+	} else {
+		token, err = auth.GenerateDbConnectAuthToken(ctx, req.Host, req.Region, p.credentials, tokenOptions)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to generate auth token: %w", err)
+	}
+
+	p.mu.Lock()
+	p.tokens[key] = &cachedToken{
+		token:     token,
+		issuedAt:  issuedAt,
+		expiresIn: req.ExpiresIn,
+	}
+	p.mu.Unlock()
+
+	return token, nil
+}
+
+// Stats はキャッシュ統計を返す
+func (p *IAMTokenProvider) Stats() TokenStats {
+	return TokenStats{
+		Hits:          p.hits.Load(),
+		Misses:        p.misses.Load(),
+		Refreshes:     p.refreshes.Load(),
+		RefreshErrors: p.refreshErrors.Load(),
+	}
+}
+
+// StaticToken は固定のパスワードを返すTokenProvider（ローカルPostgreSQL用）
+type StaticToken string
+
+// Token は固定値をそのまま返す
+func (s StaticToken) Token(context.Context, TokenRequest) (string, error) {
+	return string(s), nil
+}
@@ -0,0 +1,346 @@
+package dsqlconn
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// fakeCredentials は固定の認証情報を返し、Retrieveの回数を数える
+type fakeCredentials struct {
+	mu    sync.Mutex
+	err   error
+	calls int
+}
+
+func (c *fakeCredentials) Retrieve(context.Context) (aws.Credentials, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls++
+	if c.err != nil {
+		return aws.Credentials{}, c.err
+	}
+	return aws.Credentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "secret", Source: "fake"}, nil
+}
+
+func (c *fakeCredentials) setErr(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.err = err
+}
+
+func (c *fakeCredentials) count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.calls
+}
+
+// fakeClock は進めたときだけ進む時計
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func newTestProvider(creds *fakeCredentials) (*IAMTokenProvider, *fakeClock) {
+	clock := &fakeClock{now: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+	p := NewIAMTokenProvider(creds, func(o *IAMTokenProviderOptions) {
+		o.RefreshFraction = 0.5
+		o.Now = clock.Now
+	})
+	return p, clock
+}
+
+var testRequest = TokenRequest{
+	Host:      "abc.dsql.us-east-1.on.aws",
+	Region:    "us-east-1",
+	User:      "app",
+	ExpiresIn: 10 * time.Minute,
+}
+
+// waitFor はcondが成り立つまで待つ（裏での再生成の完了待ち）
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// cached はreqのキャッシュのissuedAtとrefreshingを返す
+func cached(p *IAMTokenProvider, req TokenRequest) (issuedAt time.Time, refreshing, ok bool) {
+	key := tokenKey{host: req.Host, region: req.Region, user: req.User, admin: req.Admin()}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	t, ok := p.tokens[key]
+	if !ok {
+		return time.Time{}, false, false
+	}
+	return t.issuedAt, t.refreshing, true
+}
+
+func TestIAMTokenProviderCacheKey(t *testing.T) {
+	creds := &fakeCredentials{}
+	p, _ := newTestProvider(creds)
+	ctx := context.Background()
+
+	first, err := p.Token(ctx, testRequest)
+	if err != nil {
+		t.Fatalf("Token: %v", err)
+	}
+	again, err := p.Token(ctx, testRequest)
+	if err != nil {
+		t.Fatalf("Token: %v", err)
+	}
+	if again != first {
+		t.Errorf("second Token did not return the cached token")
+	}
+
+	otherHost := testRequest
+	otherHost.Host = "def.dsql.us-east-1.on.aws"
+	otherUser := testRequest
+	otherUser.User = "reader"
+	admin := testRequest
+	admin.User = AdminUser
+	for _, req := range []TokenRequest{otherHost, otherUser, admin} {
+		if _, err := p.Token(ctx, req); err != nil {
+			t.Fatalf("Token(%+v): %v", req, err)
+		}
+	}
+
+	want := TokenStats{Hits: 1, Misses: 4}
+	if got := p.Stats(); got != want {
+		t.Errorf("Stats() = %+v, want %+v", got, want)
+	}
+	if got := creds.count(); got != 4 {
+		t.Errorf("credentials retrieved %d times, want 4", got)
+	}
+}
+
+func TestIAMTokenProviderExpiry(t *testing.T) {
+	creds := &fakeCredentials{}
+	p, clock := newTestProvider(creds)
+	ctx := context.Background()
+
+	if _, err := p.Token(ctx, testRequest); err != nil {
+		t.Fatalf("Token: %v", err)
+	}
+	clock.Advance(testRequest.ExpiresIn)
+	if _, err := p.Token(ctx, testRequest); err != nil {
+		t.Fatalf("Token after expiry: %v", err)
+	}
+
+	want := TokenStats{Misses: 2}
+	if got := p.Stats(); got != want {
+		t.Errorf("Stats() = %+v, want %+v", got, want)
+	}
+	if issuedAt, _, _ := cached(p, testRequest); !issuedAt.Equal(clock.Now()) {
+		t.Errorf("expired token was not replaced: issued at %s", issuedAt)
+	}
+}
+
+func TestIAMTokenProviderRefreshAhead(t *testing.T) {
+	creds := &fakeCredentials{}
+	p, clock := newTestProvider(creds)
+	ctx := context.Background()
+
+	if _, err := p.Token(ctx, testRequest); err != nil {
+		t.Fatalf("Token: %v", err)
+	}
+
+	// RefreshFraction（有効期限の半分）より前は再生成しない
+	clock.Advance(testRequest.ExpiresIn/2 - time.Second)
+	if _, err := p.Token(ctx, testRequest); err != nil {
+		t.Fatalf("Token: %v", err)
+	}
+	if got := p.Stats().Refreshes; got != 0 {
+		t.Fatalf("refreshed %d times before RefreshFraction", got)
+	}
+
+	clock.Advance(time.Second)
+	if _, err := p.Token(ctx, testRequest); err != nil {
+		t.Fatalf("Token: %v", err)
+	}
+	waitFor(t, "background refresh", func() bool {
+		issuedAt, _, _ := cached(p, testRequest)
+		return issuedAt.Equal(clock.Now())
+	})
+
+	// 再生成したトークンは新しい有効期限の半分まで再生成しない
+	clock.Advance(testRequest.ExpiresIn/2 - time.Second)
+	if _, err := p.Token(ctx, testRequest); err != nil {
+		t.Fatalf("Token: %v", err)
+	}
+
+	want := TokenStats{Hits: 3, Misses: 1, Refreshes: 1}
+	if got := p.Stats(); got != want {
+		t.Errorf("Stats() = %+v, want %+v", got, want)
+	}
+	if got := creds.count(); got != 2 {
+		t.Errorf("credentials retrieved %d times, want 2", got)
+	}
+}
+
+func TestIAMTokenProviderRefreshErrorFallback(t *testing.T) {
+	creds := &fakeCredentials{}
+	p, clock := newTestProvider(creds)
+	ctx := context.Background()
+
+	token, err := p.Token(ctx, testRequest)
+	if err != nil {
+		t.Fatalf("Token: %v", err)
+	}
+
+	creds.setErr(errors.New("credentials unavailable"))
+	clock.Advance(testRequest.ExpiresIn / 2)
+
+	// 再生成に失敗しても有効期限内はキャッシュを返す
+	for i := 1; i <= 2; i++ {
+		got, err := p.Token(ctx, testRequest)
+		if err != nil {
+			t.Fatalf("Token during failing refresh: %v", err)
+		}
+		if got != token {
+			t.Errorf("Token did not fall back to the cached token")
+		}
+		waitFor(t, "failed refresh", func() bool {
+			_, refreshing, _ := cached(p, testRequest)
+			return p.Stats().RefreshErrors == int64(i) && !refreshing
+		})
+	}
+
+	// 有効期限が切れたら同期的に生成してエラーを返す
+	clock.Advance(testRequest.ExpiresIn / 2)
+	if _, err := p.Token(ctx, testRequest); err == nil {
+		t.Fatal("Token succeeded with an expired token and failing credentials")
+	}
+
+	creds.setErr(nil)
+	if _, err := p.Token(ctx, testRequest); err != nil {
+		t.Fatalf("Token after credentials recovered: %v", err)
+	}
+
+	want := TokenStats{Hits: 2, Misses: 3, Refreshes: 2, RefreshErrors: 2}
+	if got := p.Stats(); got != want {
+		t.Errorf("Stats() = %+v, want %+v", got, want)
+	}
+}
+
+func TestNewIAMTokenProviderDefaults(t *testing.T) {
+	p := NewIAMTokenProvider(&fakeCredentials{}, func(o *IAMTokenProviderOptions) {
+		o.RefreshFraction = 1.5
+		o.RefreshTimeout = -1
+		o.Now = nil
+	})
+	if p.opts.RefreshFraction != DefaultRefreshFraction || p.opts.RefreshTimeout != DefaultRefreshTimeout || p.opts.Now == nil {
+		t.Errorf("invalid options were not replaced by defaults: %+v", p.opts)
+	}
+}
+
+// stubDefaultProvider はloadDefaultProviderをloadに差し替え、共有のプロバイダーを空にする
+func stubDefaultProvider(t *testing.T, load func(context.Context, string) (*IAMTokenProvider, error)) {
+	t.Helper()
+	orig := loadDefaultProvider
+	loadDefaultProvider = load
+	defaultProvidersMu.Lock()
+	defaultProviders = make(map[string]*defaultProvider)
+	defaultProvidersMu.Unlock()
+	t.Cleanup(func() {
+		loadDefaultProvider = orig
+		defaultProvidersMu.Lock()
+		defaultProviders = make(map[string]*defaultProvider)
+		defaultProvidersMu.Unlock()
+	})
+}
+
+func TestDefaultTokenProviderLoadsOutsideLock(t *testing.T) {
+	release := make(chan struct{})
+	var loads atomic.Int64
+	stubDefaultProvider(t, func(_ context.Context, region string) (*IAMTokenProvider, error) {
+		loads.Add(1)
+		if region == "slow-region" {
+			<-release
+		}
+		return NewIAMTokenProvider(&fakeCredentials{}), nil
+	})
+	ctx := context.Background()
+
+	// 同じリージョンの呼び出しは最初のロードを待ち、同じプロバイダーを受け取る
+	results := make(chan *IAMTokenProvider, 2)
+	for range 2 {
+		go func() {
+			p, err := defaultTokenProvider(ctx, "slow-region")
+			if err != nil {
+				t.Error(err)
+			}
+			results <- p
+		}()
+	}
+
+	// ロード中でも他のリージョンは待たされない
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if _, err := defaultTokenProvider(ctx, "fast-region"); err != nil {
+			t.Error(err)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("another region was blocked by a slow config load")
+	}
+
+	close(release)
+	a, b := <-results, <-results
+	if a == nil || a != b {
+		t.Errorf("concurrent callers got different providers: %p, %p", a, b)
+	}
+	if got := loads.Load(); got != 2 {
+		t.Errorf("loaded %d times, want once per region", got)
+	}
+}
+
+func TestDefaultTokenProviderRetriesAfterError(t *testing.T) {
+	var fail atomic.Bool
+	fail.Store(true)
+	stubDefaultProvider(t, func(context.Context, string) (*IAMTokenProvider, error) {
+		if fail.Load() {
+			return nil, errors.New("no config")
+		}
+		return NewIAMTokenProvider(&fakeCredentials{}), nil
+	})
+	ctx := context.Background()
+
+	if _, err := defaultTokenProvider(ctx, "us-east-1"); err == nil {
+		t.Fatal("defaultTokenProvider succeeded with a failing load")
+	}
+	fail.Store(false)
+	first, err := defaultTokenProvider(ctx, "us-east-1")
+	if err != nil {
+		t.Fatalf("defaultTokenProvider did not retry the load: %v", err)
+	}
+	second, err := defaultTokenProvider(ctx, "us-east-1")
+	if err != nil || second != first {
+		t.Errorf("provider was not shared after a successful load: %p, %p, %v", first, second, err)
+	}
+}
@@ -0,0 +1,146 @@
+// Package dsqltx はAurora DSQLの楽観的同時実行制御（OCC）による競合を再試行する
+//
+// DSQLは競合したトランザクションをコミット時に中断するため、トランザクション全体を
+// やり直す必要がある。
+package dsqltx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// 再試行の対象になるSQLSTATE
+const (
+	CodeSerializationFailure = "40001" // serialization_failure
+	CodeOCCDataConflict      = "OC000" // DSQL: 他のトランザクションとデータが競合した
+	CodeOCCSchemaConflict    = "OC001" // DSQL: 他のトランザクションがスキーマを更新した
+)
+
+const (
+	// DefaultMaxAttempts は初回を含めた最大試行回数
+	DefaultMaxAttempts = 5
+	// DefaultBaseDelay は1回目の再試行前の待ち時間の上限
+	DefaultBaseDelay = 20 * time.Millisecond
+	// DefaultMaxDelay は待ち時間の上限
+	DefaultMaxDelay = 1 * time.Second
+)
+
+// Options は再試行の設定
+type Options struct {
+	// MaxAttempts は初回を含めた最大試行回数
+	MaxAttempts int
+	// BaseDelay と MaxDelay で指数バックオフの待ち時間を決める（フルジッター）
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// MaxElapsed が正の場合、初回からの経過時間がこれを超える再試行は行わない
+	MaxElapsed time.Duration
+	// Sleep は待機処理（テストで差し替える）。ctxが終了したらエラーを返す
+	Sleep func(ctx context.Context, d time.Duration) error
+	// Now は現在時刻を返す（テストで差し替える）。MaxElapsedとctxの期限の判定に使う
+	Now func() time.Time
+}
+
+// Beginner はトランザクションを開始できるもの（*pgxpool.Pool、*pgx.Connなど）
+type Beginner interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
+
+// IsRetryable はOCC競合など、トランザクションをやり直せば成功しうるエラーかどうかを返す
+//
+// SQLState() を持つエラー（pgconnのPgError、lib/pqのErrorなど）を判定する。
+func IsRetryable(err error) bool {
+	var sqlErr interface{ SQLState() string }
+	if !errors.As(err, &sqlErr) {
+		return false
+	}
+	switch sqlErr.SQLState() {
+	case CodeSerializationFailure, CodeOCCDataConflict, CodeOCCSchemaConflict:
+		return true
+	}
+	return false
+}
+
+// Retry はfnが成功するか再試行できないエラーを返すまで実行し、試行回数を返す
+//
+// 再試行の上限に達した場合やctxが終了した場合は最後のエラーを返す。
+func Retry(ctx context.Context, fn func(ctx context.Context) error, optFns ...func(*Options)) (int, error) {
+	opts := Options{
+		MaxAttempts: DefaultMaxAttempts,
+		BaseDelay:   DefaultBaseDelay,
+		MaxDelay:    DefaultMaxDelay,
+		Sleep:       sleep,
+		Now:         time.Now,
+	}
+	for _, fn := range optFns {
+		fn(&opts)
+	}
+	if opts.MaxAttempts < 1 {
+		opts.MaxAttempts = 1
+	}
+	if opts.Sleep == nil {
+		opts.Sleep = sleep
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+
+	start := opts.Now()
+	for attempt := 1; ; attempt++ {
+		err := fn(ctx)
+		if err == nil || !IsRetryable(err) || attempt >= opts.MaxAttempts {
+			return attempt, err
+		}
+
+		delay := backoff(attempt, opts.BaseDelay, opts.MaxDelay)
+		now := opts.Now()
+		if opts.MaxElapsed > 0 && now.Sub(start)+delay > opts.MaxElapsed {
+			return attempt, err
+		}
+		if deadline, ok := ctx.Deadline(); ok && now.Add(delay).After(deadline) {
+			return attempt, err
+		}
+		if sleepErr := opts.Sleep(ctx, delay); sleepErr != nil {
+			return attempt, fmt.Errorf("%w (retry aborted: %v)", err, sleepErr)
+		}
+	}
+}
+
+// RunInTx はdbでトランザクションを開始してfnを実行し、OCC競合時はトランザクションごとやり直す
+//
+// fnは複数回呼ばれることがあるため、トランザクション外に副作用を持たないこと。
+func RunInTx(ctx context.Context, db Beginner, fn func(tx pgx.Tx) error, optFns ...func(*Options)) (int, error) {
+	return Retry(ctx, func(ctx context.Context) error {
+		return pgx.BeginFunc(ctx, db, fn)
+	}, optFns...)
+}
+
+// backoff はattempt回目の失敗後の待ち時間を返す（0〜min(MaxDelay, BaseDelay*2^(attempt-1))）
+func backoff(attempt int, base, maxDelay time.Duration) time.Duration {
+	if base <= 0 {
+		return 0
+	}
+	limit := base
+	for i := 1; i < attempt && limit < maxDelay; i++ {
+		limit *= 2
+	}
+	if maxDelay > 0 && limit > maxDelay {
+		limit = maxDelay
+	}
+	return rand.N(limit + 1)
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,278 @@
+package dsqltx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// fakeDB はCommitで順にcommitErrsを返すトランザクションを開始する
+type fakeDB struct {
+	commitErrs []error
+	begins     int
+	commits    int
+	rollbacks  int
+}
+
+func (db *fakeDB) Begin(context.Context) (pgx.Tx, error) {
+	db.begins++
+	return &fakeTx{db: db}, nil
+}
+
+// fakeTx はCommitとRollbackだけを実装したpgx.Tx
+type fakeTx struct {
+	pgx.Tx
+	db     *fakeDB
+	closed bool
+}
+
+func (tx *fakeTx) Commit(context.Context) error {
+	if tx.closed {
+		return pgx.ErrTxClosed
+	}
+	tx.closed = true
+	tx.db.commits++
+	if len(tx.db.commitErrs) == 0 {
+		return nil
+	}
+	err := tx.db.commitErrs[0]
+	tx.db.commitErrs = tx.db.commitErrs[1:]
+	return err
+}
+
+func (tx *fakeTx) Rollback(context.Context) error {
+	if tx.closed {
+		return pgx.ErrTxClosed
+	}
+	tx.closed = true
+	tx.db.rollbacks++
+	return nil
+}
+
+func pgError(code string) error {
+	return &pgconn.PgError{Severity: "ERROR", Code: code, Message: "change conflicts with another transaction"}
+}
+
+func sqlState(err error) string {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code
+	}
+	return ""
+}
+
+// fakeClock はSleepとfnで進める時計。Sleepに渡された待ち時間を記録する
+type fakeClock struct {
+	now    time.Time
+	sleeps []time.Duration
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) options(o *Options) {
+	o.Now = func() time.Time { return c.now }
+	o.Sleep = func(_ context.Context, d time.Duration) error {
+		c.sleeps = append(c.sleeps, d)
+		c.now = c.now.Add(d)
+		return nil
+	}
+}
+
+func TestRunInTxRetriesOCC(t *testing.T) {
+	for _, code := range []string{CodeSerializationFailure, CodeOCCDataConflict, CodeOCCSchemaConflict} {
+		t.Run(code, func(t *testing.T) {
+			db := &fakeDB{commitErrs: []error{pgError(code), pgError(code)}}
+			clock := newFakeClock()
+			calls := 0
+
+			attempts, err := RunInTx(context.Background(), db, func(pgx.Tx) error {
+				calls++
+				return nil
+			}, clock.options)
+			if err != nil {
+				t.Fatalf("RunInTx: %v", err)
+			}
+			if attempts != 3 || calls != 3 || db.begins != 3 || db.commits != 3 {
+				t.Errorf("attempts %d, fn calls %d, begins %d, commits %d; want 3 each", attempts, calls, db.begins, db.commits)
+			}
+			if len(clock.sleeps) != 2 {
+				t.Errorf("slept %d times, want 2", len(clock.sleeps))
+			}
+		})
+	}
+}
+
+func TestRunInTxMaxAttempts(t *testing.T) {
+	db := &fakeDB{}
+	for range 10 {
+		db.commitErrs = append(db.commitErrs, pgError(CodeOCCDataConflict))
+	}
+	clock := newFakeClock()
+
+	attempts, err := RunInTx(context.Background(), db, func(pgx.Tx) error { return nil },
+		clock.options, func(o *Options) { o.MaxAttempts = 3 })
+	if attempts != 3 || db.begins != 3 {
+		t.Errorf("attempts %d, begins %d; want 3", attempts, db.begins)
+	}
+	if sqlState(err) != CodeOCCDataConflict {
+		t.Errorf("error = %v, want the last OC000", err)
+	}
+}
+
+func TestRunInTxNonRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+	}{
+		{"unique violation", pgError("23505")},
+		{"plain error", errors.New("validation failed")},
+		{"wrapped undefined table", fmt.Errorf("insert: %w", pgError("42P01"))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &fakeDB{}
+			clock := newFakeClock()
+
+			attempts, err := RunInTx(context.Background(), db, func(pgx.Tx) error { return tt.err }, clock.options)
+			if !errors.Is(err, tt.err) {
+				t.Errorf("error = %v, want %v unchanged", err, tt.err)
+			}
+			if attempts != 1 || db.begins != 1 || db.commits != 0 || db.rollbacks != 1 || len(clock.sleeps) != 0 {
+				t.Errorf("attempts %d, begins %d, commits %d, rollbacks %d, sleeps %d; want 1, 1, 0, 1, 0",
+					attempts, db.begins, db.commits, db.rollbacks, len(clock.sleeps))
+			}
+		})
+	}
+}
+
+func TestRetryMaxElapsed(t *testing.T) {
+	clock := newFakeClock()
+	attempts, err := Retry(context.Background(), func(context.Context) error {
+		clock.now = clock.now.Add(100 * time.Millisecond)
+		return pgError(CodeOCCDataConflict)
+	}, clock.options, func(o *Options) {
+		o.MaxAttempts = 10
+		o.BaseDelay = 0
+		o.MaxElapsed = 250 * time.Millisecond
+	})
+	// 100ms、200msの時点では再試行し、300msの時点でMaxElapsedを超える
+	if attempts != 3 {
+		t.Errorf("attempts = %d, want 3", attempts)
+	}
+	if sqlState(err) != CodeOCCDataConflict {
+		t.Errorf("error = %v, want OC000", err)
+	}
+}
+
+func TestRetryContextDeadline(t *testing.T) {
+	deadline := time.Now().Add(time.Hour)
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+
+	// 期限までに待ち時間を確保できなければ待たずに最後のエラーを返す
+	clock := newFakeClock()
+	clock.now = deadline
+	attempts, err := Retry(ctx, func(context.Context) error {
+		return pgError(CodeSerializationFailure)
+	}, clock.options, func(o *Options) {
+		o.BaseDelay = time.Second
+		o.MaxDelay = time.Second
+	})
+	if attempts != 1 || len(clock.sleeps) != 0 {
+		t.Errorf("attempts %d, sleeps %v; want 1 attempt without sleeping", attempts, clock.sleeps)
+	}
+	if sqlState(err) != CodeSerializationFailure {
+		t.Errorf("error = %v, want 40001", err)
+	}
+}
+
+func TestRetrySleepAborted(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	attempts, err := Retry(ctx, func(context.Context) error {
+		calls++
+		cancel()
+		return pgError(CodeOCCDataConflict)
+	})
+	if attempts != 1 || calls != 1 {
+		t.Errorf("attempts %d, calls %d; want 1", attempts, calls)
+	}
+	if sqlState(err) != CodeOCCDataConflict || !strings.Contains(err.Error(), "retry aborted") {
+		t.Errorf("error = %v, want OC000 with the abort reason", err)
+	}
+}
+
+func TestRetryBackoffBounds(t *testing.T) {
+	const (
+		base     = 10 * time.Millisecond
+		maxDelay = 200 * time.Millisecond
+	)
+	for range 50 {
+		clock := newFakeClock()
+		attempts, _ := Retry(context.Background(), func(context.Context) error {
+			return pgError(CodeOCCDataConflict)
+		}, clock.options, func(o *Options) {
+			o.MaxAttempts = 8
+			o.BaseDelay = base
+			o.MaxDelay = maxDelay
+		})
+		if attempts != 8 || len(clock.sleeps) != 7 {
+			t.Fatalf("attempts %d, sleeps %d; want 8 and 7", attempts, len(clock.sleeps))
+		}
+		// n回目の待ち時間は0〜min(MaxDelay, BaseDelay*2^(n-1))
+		limit := base
+		for i, d := range clock.sleeps {
+			if d < 0 || d > limit {
+				t.Fatalf("sleep #%d = %s, want 0..%s", i+1, d, limit)
+			}
+			limit = min(limit*2, maxDelay)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	if d := backoff(3, 0, time.Second); d != 0 {
+		t.Errorf("backoff without base delay = %s, want 0", d)
+	}
+	// 上限を超えないことと、ジッターで上限付近の値も出ることを確かめる
+	var largest time.Duration
+	for range 1000 {
+		d := backoff(10, time.Millisecond, 50*time.Millisecond)
+		if d < 0 || d > 50*time.Millisecond {
+			t.Fatalf("backoff = %s, want 0..50ms", d)
+		}
+		largest = max(largest, d)
+	}
+	if largest < 25*time.Millisecond {
+		t.Errorf("largest of 1000 delays is %s, want jitter over the whole range", largest)
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{pgError(CodeSerializationFailure), true},
+		{pgError(CodeOCCDataConflict), true},
+		{fmt.Errorf("commit: %w", pgError(CodeOCCSchemaConflict)), true},
+		{pgError("23505"), false},
+		{pgError("40P01"), false},
+		{errors.New("OC000"), false},
+		{context.DeadlineExceeded, false},
+	}
+	for _, tt := range tests {
+		if got := IsRetryable(tt.err); got != tt.want {
+			t.Errorf("IsRetryable(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
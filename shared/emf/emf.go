@@ -0,0 +1,164 @@
+// Package emf はCloudWatch Embedded Metric Format（EMF）のメトリクスを書く
+//
+// LambdaではEMFのJSONを1行ずつ標準出力に書くと、CloudWatch Logsがメトリクスとして取り込む。
+// PutMetricDataのAPI呼び出しは不要。形式は
+// https://docs.aws.amazon.com/AmazonCloudWatch/latest/monitoring/CloudWatch_Embedded_Metric_Format_Specification.html
+package emf
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Unit はメトリクスの単位（CloudWatchの単位名）
+type Unit string
+
+const (
+	Count        Unit = "Count"
+	Milliseconds Unit = "Milliseconds"
+	Seconds      Unit = "Seconds"
+	Bytes        Unit = "Bytes"
+	None         Unit = "None"
+)
+
+// 1つのドキュメントに含められる上限（EMFの仕様）
+const (
+	maxDimensions = 30
+	maxMetrics    = 100
+)
+
+// Dimension はメトリクスを分ける次元
+type Dimension struct {
+	Name  string
+	Value string
+}
+
+// Metric は1つのメトリクスの値
+type Metric struct {
+	Name  string
+	Unit  Unit
+	Value float64
+}
+
+// Ms は時間をMillisecondsの値にする
+func Ms(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// Emitter はEMFのドキュメントをwに書く。複数のgoroutineから使える
+//
+// nilのEmitterは何も書かない（メトリクスを使わないテストやCLI向け）。
+type Emitter struct {
+	namespace  string
+	dimensions []Dimension
+
+	mu sync.Mutex
+	w  io.Writer
+	// Now はドキュメントのTimestampを返す（テストで差し替える）
+	Now func() time.Time
+}
+
+// New はnamespaceのメトリクスを書くEmitterを返す。dimensionsはすべてのメトリクスに付く
+func New(w io.Writer, namespace string, dimensions ...Dimension) *Emitter {
+	return &Emitter{namespace: namespace, dimensions: dimensions, w: w, Now: time.Now}
+}
+
+// FromEnv はMETRICS_NAMESPACEがあればそれを名前空間にしてNewを呼ぶ
+func FromEnv(w io.Writer, namespace string, getenv func(string) string, dimensions ...Dimension) *Emitter {
+	if v := strings.TrimSpace(getenv("METRICS_NAMESPACE")); v != "" {
+		namespace = v
+	}
+	return New(w, namespace, dimensions...)
+}
+
+// Emit はmetricsを1つのドキュメントとして書く
+//
+// dimensionsはEmitterの次元に追加する（同じ名前なら上書き）。metricsが空なら何もしない。
+func (e *Emitter) Emit(dimensions []Dimension, metrics ...Metric) error {
+	if e == nil || len(metrics) == 0 {
+		return nil
+	}
+	doc, err := e.document(dimensions, metrics)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+
+	// 他のログと混ざらないように1回のWriteで1行を書く
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, err = e.w.Write(append(b, '\n'))
+	return err
+}
+
+type metadata struct {
+	Timestamp         int64       `json:"Timestamp"`
+	CloudWatchMetrics []directive `json:"CloudWatchMetrics"`
+}
+
+type directive struct {
+	Namespace  string       `json:"Namespace"`
+	Dimensions [][]string   `json:"Dimensions"`
+	Metrics    []definition `json:"Metrics"`
+}
+
+type definition struct {
+	Name string `json:"Name"`
+	Unit Unit   `json:"Unit,omitempty"`
+}
+
+// document はEMFのドキュメント（_awsのメタデータと、次元・メトリクスの値のトップレベルのメンバー）を返す
+func (e *Emitter) document(dimensions []Dimension, metrics []Metric) (map[string]any, error) {
+	if len(metrics) > maxMetrics {
+		return nil, fmt.Errorf("too many metrics in one document: %d (max %d)", len(metrics), maxMetrics)
+	}
+
+	doc := map[string]any{}
+	keys := []string{} // 次元がなくても[[]]にする（nullはEMFとして無効）
+	for _, d := range append(append([]Dimension{}, e.dimensions...), dimensions...) {
+		if d.Name == "" {
+			return nil, errors.New("empty dimension name")
+		}
+		if _, ok := doc[d.Name]; !ok {
+			keys = append(keys, d.Name)
+		}
+		doc[d.Name] = d.Value
+	}
+	if len(keys) > maxDimensions {
+		return nil, fmt.Errorf("too many dimensions: %d (max %d)", len(keys), maxDimensions)
+	}
+
+	defs := make([]definition, 0, len(metrics))
+	for _, m := range metrics {
+		if m.Name == "" || m.Name == "_aws" {
+			return nil, fmt.Errorf("invalid metric name %q", m.Name)
+		}
+		if _, ok := doc[m.Name]; ok {
+			return nil, fmt.Errorf("metric %q conflicts with a dimension or another metric", m.Name)
+		}
+		if math.IsNaN(m.Value) || math.IsInf(m.Value, 0) {
+			return nil, fmt.Errorf("metric %q has a non-finite value", m.Name)
+		}
+		doc[m.Name] = m.Value
+		defs = append(defs, definition{Name: m.Name, Unit: m.Unit})
+	}
+
+	doc["_aws"] = metadata{
+		Timestamp: e.Now().UnixMilli(),
+		CloudWatchMetrics: []directive{{
+			Namespace:  e.namespace,
+			Dimensions: [][]string{keys},
+			Metrics:    defs,
+		}},
+	}
+	return doc, nil
+}
@@ -0,0 +1,175 @@
+package emf
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestEmitter は時刻を固定したEmitterと書き出し先を返す
+func newTestEmitter(namespace string, dimensions ...Dimension) (*Emitter, *bytes.Buffer) {
+	var buf bytes.Buffer
+	e := New(&buf, namespace, dimensions...)
+	e.Now = func() time.Time { return time.UnixMilli(1735689600123) }
+	return e, &buf
+}
+
+func TestEmit(t *testing.T) {
+	e, buf := newTestEmitter("DSQLButtonClicks", Dimension{"Service", "button-timestamp-recorder"})
+	err := e.Emit(nil,
+		Metric{Name: "ClicksRecorded", Unit: Count, Value: 1},
+		Metric{Name: "PoolAcquireWait", Unit: Milliseconds, Value: Ms(1500 * time.Microsecond)},
+		Metric{Name: "Ratio", Value: 0.25},
+	)
+	if err != nil {
+		t.Fatalf("Emit: %v", err)
+	}
+	want := `{"ClicksRecorded":1,"PoolAcquireWait":1.5,"Ratio":0.25,"Service":"button-timestamp-recorder",` +
+		`"_aws":{"Timestamp":1735689600123,"CloudWatchMetrics":[{"Namespace":"DSQLButtonClicks",` +
+		`"Dimensions":[["Service"]],` +
+		`"Metrics":[{"Name":"ClicksRecorded","Unit":"Count"},{"Name":"PoolAcquireWait","Unit":"Milliseconds"},{"Name":"Ratio"}]}]}}` + "\n"
+	if got := buf.String(); got != want {
+		t.Errorf("Emit wrote\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestEmitDimensions(t *testing.T) {
+	e, buf := newTestEmitter("NS", Dimension{"Service", "svc"}, Dimension{"Stage", "dev"})
+	// 追加の次元は後ろに並び、同じ名前の次元は値だけを上書きする
+	err := e.Emit([]Dimension{{"SQLSTATE", "40001"}, {"Stage", "prod"}}, Metric{Name: "InsertFailures", Unit: Count, Value: 1})
+	if err != nil {
+		t.Fatalf("Emit: %v", err)
+	}
+	want := `{"InsertFailures":1,"SQLSTATE":"40001","Service":"svc","Stage":"prod",` +
+		`"_aws":{"Timestamp":1735689600123,"CloudWatchMetrics":[{"Namespace":"NS",` +
+		`"Dimensions":[["Service","Stage","SQLSTATE"]],` +
+		`"Metrics":[{"Name":"InsertFailures","Unit":"Count"}]}]}}` + "\n"
+	if got := buf.String(); got != want {
+		t.Errorf("Emit wrote\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestEmitWithoutDimensions(t *testing.T) {
+	e, buf := newTestEmitter("NS")
+	if err := e.Emit(nil, Metric{Name: "Bytes", Unit: Bytes, Value: 2048}); err != nil {
+		t.Fatalf("Emit: %v", err)
+	}
+	want := `{"Bytes":2048,"_aws":{"Timestamp":1735689600123,"CloudWatchMetrics":[{"Namespace":"NS",` +
+		`"Dimensions":[[]],"Metrics":[{"Name":"Bytes","Unit":"Bytes"}]}]}}` + "\n"
+	if got := buf.String(); got != want {
+		t.Errorf("Emit wrote\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	tests := []struct {
+		env  string
+		want string
+	}{
+		{"", "Default"},
+		{"  ", "Default"},
+		{"Custom/Namespace", "Custom/Namespace"},
+		{" Padded ", "Padded"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		e := FromEnv(&buf, "Default", func(key string) string {
+			if key == "METRICS_NAMESPACE" {
+				return tt.env
+			}
+			return ""
+		}, Dimension{"Service", "svc"})
+		e.Now = func() time.Time { return time.UnixMilli(0) }
+		if err := e.Emit(nil, Metric{Name: "M", Unit: None, Value: 1}); err != nil {
+			t.Fatalf("Emit: %v", err)
+		}
+		want := `{"M":1,"Service":"svc","_aws":{"Timestamp":0,"CloudWatchMetrics":[{"Namespace":"` + tt.want + `",` +
+			`"Dimensions":[["Service"]],"Metrics":[{"Name":"M","Unit":"None"}]}]}}` + "\n"
+		if got := buf.String(); got != want {
+			t.Errorf("METRICS_NAMESPACE=%q wrote\n%s\nwant\n%s", tt.env, got, want)
+		}
+	}
+}
+
+func TestEmitErrors(t *testing.T) {
+	many := make([]Metric, maxMetrics+1)
+	for i := range many {
+		many[i] = Metric{Name: "M" + strings.Repeat("x", i), Value: 1}
+	}
+	manyDims := make([]Dimension, maxDimensions+1)
+	for i := range manyDims {
+		manyDims[i] = Dimension{Name: "D" + strings.Repeat("x", i)}
+	}
+	tests := []struct {
+		name       string
+		dimensions []Dimension
+		metrics    []Metric
+	}{
+		{"too many metrics", nil, many},
+		{"too many dimensions", manyDims, []Metric{{Name: "M", Value: 1}}},
+		{"empty dimension name", []Dimension{{"", "v"}}, []Metric{{Name: "M", Value: 1}}},
+		{"empty metric name", nil, []Metric{{Name: "", Value: 1}}},
+		{"reserved metric name", nil, []Metric{{Name: "_aws", Value: 1}}},
+		{"metric named like a dimension", nil, []Metric{{Name: "Service", Value: 1}}},
+		{"duplicate metric", nil, []Metric{{Name: "M", Value: 1}, {Name: "M", Value: 2}}},
+		{"NaN", nil, []Metric{{Name: "M", Value: math.NaN()}}},
+		{"infinity", nil, []Metric{{Name: "M", Value: math.Inf(1)}}},
+	}
+	for _, tt := range tests {
+		e, buf := newTestEmitter("NS", Dimension{"Service", "svc"})
+		if err := e.Emit(tt.dimensions, tt.metrics...); err == nil {
+			t.Errorf("%s: Emit succeeded", tt.name)
+		}
+		if buf.Len() != 0 {
+			t.Errorf("%s: Emit wrote %q", tt.name, buf.String())
+		}
+	}
+}
+
+func TestEmitNothing(t *testing.T) {
+	var nilEmitter *Emitter
+	if err := nilEmitter.Emit(nil, Metric{Name: "M", Value: 1}); err != nil {
+		t.Errorf("nil Emitter: %v", err)
+	}
+	e, buf := newTestEmitter("NS")
+	if err := e.Emit([]Dimension{{"D", "v"}}); err != nil || buf.Len() != 0 {
+		t.Errorf("Emit without metrics wrote %q, %v", buf.String(), err)
+	}
+}
+
+type errWriter struct{}
+
+func (errWriter) Write([]byte) (int, error) { return 0, errors.New("closed") }
+
+func TestEmitWriteError(t *testing.T) {
+	if err := New(errWriter{}, "NS").Emit(nil, Metric{Name: "M", Value: 1}); err == nil {
+		t.Error("Emit did not return the write error")
+	}
+}
+
+// 並行に書いても1行が1つのドキュメントになる
+func TestEmitConcurrent(t *testing.T) {
+	e, buf := newTestEmitter("NS", Dimension{"Service", "svc"})
+	var wg sync.WaitGroup
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			e.Emit(nil, Metric{Name: "M", Unit: Count, Value: 1})
+		}()
+	}
+	wg.Wait()
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 50 {
+		t.Fatalf("%d lines, want 50", len(lines))
+	}
+	for _, l := range lines {
+		if l != lines[0] {
+			t.Errorf("line %q differs from %q", l, lines[0])
+		}
+	}
+}
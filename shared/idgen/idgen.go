@@ -0,0 +1,111 @@
+// Package idgen はbutton_clicksなどのBIGINT主キーに使うIDを生成する
+//
+// DSQLはSERIAL/IDENTITYをサポートしないため、アプリケーション側で衝突しない
+// IDを採番する。
+package idgen
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Generator はIDの採番方法
+type Generator interface {
+	// NextID は新しいIDを返す。同じGeneratorから返るIDは単調増加する
+	NextID() int64
+}
+
+// Snowflake形式のビット配分（符号1bit + 時刻41bit + ワーカー10bit + シーケンス12bit）
+const (
+	workerBits   = 10
+	sequenceBits = 12
+
+	// MaxWorkerID は指定できるワーカーIDの最大値
+	MaxWorkerID  = 1<<workerBits - 1
+	sequenceMask = 1<<sequenceBits - 1
+)
+
+// EnvWorkerID はワーカーIDを指定する環境変数名
+const EnvWorkerID = "ID_WORKER_ID"
+
+// Epoch はSnowflakeの時刻部分の起点
+//
+// 起点を最近にすることで、以前のUnix秒のIDより大きな値になり ORDER BY id の順序が保たれる。
+var Epoch = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Snowflake はミリ秒時刻・ワーカーID・シーケンスからIDを組み立てるGenerator
+//
+// 同じミリ秒内では4096件まで採番でき、使い切った場合や時計が戻った場合は
+// 時刻部分を前の値から進めて単調増加を保つ。
+type Snowflake struct {
+	workerID int64
+	now      func() time.Time
+
+	mu       sync.Mutex
+	lastMs   int64
+	sequence int64
+}
+
+// NewSnowflake は指定したワーカーIDのSnowflakeを作成する
+//
+// 同時に動くプロセス（Lambdaの実行環境など）同士でワーカーIDが重複しないようにする。
+func NewSnowflake(workerID int64) (*Snowflake, error) {
+	if workerID < 0 || workerID > MaxWorkerID {
+		return nil, fmt.Errorf("worker ID %d is out of range (0-%d)", workerID, MaxWorkerID)
+	}
+	return &Snowflake{workerID: workerID, now: time.Now, lastMs: -1}, nil
+}
+
+// NextID は新しいIDを返す
+func (s *Snowflake) NextID() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ms := s.now().Sub(Epoch).Milliseconds()
+	if ms < s.lastMs {
+		// 時計が戻った場合は前回の時刻を使い続ける
+		ms = s.lastMs
+	}
+
+	if ms == s.lastMs {
+		s.sequence = (s.sequence + 1) & sequenceMask
+		if s.sequence == 0 {
+			// 同じミリ秒のシーケンスを使い切ったので次のミリ秒を前借りする
+			ms++
+		}
+	} else {
+		s.sequence = 0
+	}
+	s.lastMs = ms
+
+	return ms<<(workerBits+sequenceBits) | s.workerID<<sequenceBits | s.sequence
+}
+
+// Time はIDに含まれる採番時刻を返す
+func Time(id int64) time.Time {
+	return Epoch.Add(time.Duration(id>>(workerBits+sequenceBits)) * time.Millisecond)
+}
+
+// WorkerID は環境変数ID_WORKER_IDからワーカーIDを返す
+//
+// 未設定の場合はランダムな値を返す。Lambdaのように実行環境が動的に増える場合は
+// ランダム値でも衝突の確率は低いが、挿入側で一意制約違反時に再採番すること。
+func WorkerID(getenv func(string) string) (int64, error) {
+	if v := getenv(EnvWorkerID); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || id < 0 || id > MaxWorkerID {
+			return 0, fmt.Errorf("%s: invalid worker ID %q (0-%d)", EnvWorkerID, v, MaxWorkerID)
+		}
+		return id, nil
+	}
+
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return 0, fmt.Errorf("failed to generate random worker ID: %w", err)
+	}
+	return int64(binary.BigEndian.Uint64(b[:]) & MaxWorkerID), nil
+}
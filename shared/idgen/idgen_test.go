@@ -0,0 +1,179 @@
+package idgen
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// fixedClock はsetで指定した時刻を返す
+type fixedClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func (c *fixedClock) now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+func (c *fixedClock) set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.t = t
+}
+
+func newTestSnowflake(t *testing.T, workerID int64, clock *fixedClock) *Snowflake {
+	t.Helper()
+	s, err := NewSnowflake(workerID)
+	if err != nil {
+		t.Fatalf("NewSnowflake(%d): %v", workerID, err)
+	}
+	if clock != nil {
+		s.now = clock.now
+	}
+	return s
+}
+
+// TestNextIDConcurrent は複数のワーカーを多数のgoroutineから同時に使っても
+// IDが重複せず、各goroutineが受け取るIDが単調増加することを確かめる
+func TestNextIDConcurrent(t *testing.T) {
+	const (
+		workers    = 4
+		goroutines = 2000
+		perRoutine = 50
+	)
+	gens := make([]*Snowflake, workers)
+	for i := range gens {
+		gens[i] = newTestSnowflake(t, int64(i*300), nil)
+	}
+
+	results := make([][]int64, goroutines)
+	var wg sync.WaitGroup
+	for g := range goroutines {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			gen := gens[g%workers]
+			ids := make([]int64, perRoutine)
+			for i := range ids {
+				ids[i] = gen.NextID()
+			}
+			results[g] = ids
+		}()
+	}
+	wg.Wait()
+
+	seen := make(map[int64]bool, goroutines*perRoutine)
+	for g, ids := range results {
+		worker := gens[g%workers].workerID
+		for i, id := range ids {
+			if seen[id] {
+				t.Fatalf("duplicate ID %d", id)
+			}
+			seen[id] = true
+			if got := id >> sequenceBits & MaxWorkerID; got != worker {
+				t.Fatalf("ID %d has worker %d, want %d", id, got, worker)
+			}
+			if i > 0 && id <= ids[i-1] {
+				t.Fatalf("goroutine %d: ID %d is not greater than the previous %d", g, id, ids[i-1])
+			}
+		}
+	}
+}
+
+func TestNextIDClockRollback(t *testing.T) {
+	start := Epoch.Add(time.Hour)
+	clock := &fixedClock{t: start}
+	s := newTestSnowflake(t, 1, clock)
+
+	before := s.NextID()
+	clock.set(start.Add(-10 * time.Second))
+	var ids []int64
+	for range 3 {
+		ids = append(ids, s.NextID())
+	}
+	prev := before
+	for _, id := range ids {
+		if id <= prev {
+			t.Fatalf("ID %d after clock rollback is not greater than %d", id, prev)
+		}
+		prev = id
+	}
+	if got := Time(ids[len(ids)-1]); !got.Equal(start) {
+		t.Errorf("Time() after rollback = %s, want the last time %s", got, start)
+	}
+
+	// 時計が追いつけば実際の時刻に戻る
+	later := start.Add(time.Second)
+	clock.set(later)
+	id := s.NextID()
+	if id <= prev || !Time(id).Equal(later) {
+		t.Errorf("ID after clock recovered: %d at %s, want > %d at %s", id, Time(id), prev, later)
+	}
+}
+
+func TestNextIDSequenceOverflow(t *testing.T) {
+	start := Epoch.Add(time.Hour)
+	clock := &fixedClock{t: start}
+	s := newTestSnowflake(t, 7, clock)
+
+	// 同じミリ秒で4096件を超えたら次のミリ秒を前借りする
+	n := 2*(sequenceMask+1) + 10
+	prev := int64(-1)
+	for i := range n {
+		id := s.NextID()
+		if id <= prev {
+			t.Fatalf("ID #%d %d is not greater than %d", i, id, prev)
+		}
+		prev = id
+
+		wantTime := start.Add(time.Duration(i/(sequenceMask+1)) * time.Millisecond)
+		if got := Time(id); !got.Equal(wantTime) {
+			t.Fatalf("ID #%d time = %s, want %s", i, got, wantTime)
+		}
+		if got := id & sequenceMask; got != int64(i%(sequenceMask+1)) {
+			t.Fatalf("ID #%d sequence = %d, want %d", i, got, i%(sequenceMask+1))
+		}
+	}
+
+	// 前借りした時刻に実際の時刻が追いついても重複しない
+	clock.set(start.Add(2 * time.Millisecond))
+	if id := s.NextID(); id <= prev {
+		t.Errorf("ID %d after the clock caught up is not greater than %d", id, prev)
+	}
+}
+
+func TestNewSnowflakeWorkerRange(t *testing.T) {
+	for _, id := range []int64{-1, MaxWorkerID + 1} {
+		if _, err := NewSnowflake(id); err == nil {
+			t.Errorf("NewSnowflake(%d) succeeded", id)
+		}
+	}
+}
+
+func TestWorkerID(t *testing.T) {
+	tests := []struct {
+		env     string
+		want    int64
+		wantErr bool
+	}{
+		{env: "0", want: 0},
+		{env: "1023", want: 1023},
+		{env: "1024", wantErr: true},
+		{env: "-1", wantErr: true},
+		{env: "abc", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := WorkerID(func(string) string { return tt.env })
+		if (err != nil) != tt.wantErr || (!tt.wantErr && got != tt.want) {
+			t.Errorf("WorkerID(%q) = %d, %v", tt.env, got, err)
+		}
+	}
+
+	random, err := WorkerID(func(string) string { return "" })
+	if err != nil || random < 0 || random > MaxWorkerID {
+		t.Errorf("random WorkerID = %d, %v", random, err)
+	}
+}
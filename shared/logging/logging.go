@@ -0,0 +1,159 @@
+// Package logging はLambdaとCLIで共通のlog/slogによる構造化ログ
+//
+// ログはJSONで1行ずつ書き、LOG_LEVEL（debug / info / warn / error）以上のものだけを出す。
+// 認証トークン・パスワード・接続文字列は、キーと値の両方から判定して必ず伏せる（redact.go）。
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ParseLevel はLOG_LEVELの値をslog.Levelにする（空ならinfo）
+func ParseLevel(s string) (slog.Level, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "debug":
+		return slog.LevelDebug, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	default:
+		return slog.LevelInfo, fmt.Errorf("LOG_LEVEL must be debug, info, warn or error: %q", s)
+	}
+}
+
+// New はlevel以上のログを秘密情報を伏せたJSONでwに書くロガーを返す
+func New(w io.Writer, level slog.Leveler) *slog.Logger {
+	return slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{
+		Level:       level,
+		ReplaceAttr: redact,
+	}))
+}
+
+// FromEnv はLOG_LEVELのレベルでwに書くロガーを返す
+//
+// LOG_LEVELが不正な場合はinfoにして、その旨を警告として書く（ログの設定で起動を止めない）。
+func FromEnv(w io.Writer, getenv func(string) string) *slog.Logger {
+	level, err := ParseLevel(getenv("LOG_LEVEL"))
+	logger := New(w, level)
+	if err != nil {
+		logger.Warn("invalid LOG_LEVEL, using info", "error", err)
+	}
+	return logger
+}
+
+type loggerKey struct{}
+
+// NewContext はloggerを持つctxを返す
+func NewContext(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// FromContext はctxのロガーを返す（なければslog.Default()）
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// Request はリクエストのログに付ける識別情報
+type Request struct {
+	LambdaRequestID string // Lambdaの呼び出しID（lambdacontext.LambdaContext.AwsRequestID）
+	APIRequestID    string // API GatewayのrequestContext.requestId
+	Route           string // "GET /button-clicks/stats" など
+	TraceID         string // OpenTelemetryのトレースID（記録していなければ空）
+}
+
+// dbTimer はリクエスト中のデータベース処理の時間を集計する
+type dbTimer struct {
+	mu    sync.Mutex
+	total time.Duration
+	calls int
+}
+
+type dbTimerKey struct{}
+
+// Start はリクエストの識別情報を付けたロガーとDB時間の集計をctxに入れ、受信をdebugで書く
+//
+// 応答時に返り値のfinishを呼ぶと、ステータスコード・結果・レイテンシ・DB時間を1行で書く。
+func Start(ctx context.Context, base *slog.Logger, r Request) (context.Context, func(status int, err error)) {
+	start := time.Now()
+	var attrs []any
+	if r.LambdaRequestID != "" {
+		attrs = append(attrs, "lambda_request_id", r.LambdaRequestID)
+	}
+	if r.APIRequestID != "" {
+		attrs = append(attrs, "api_request_id", r.APIRequestID)
+	}
+	if r.Route != "" {
+		attrs = append(attrs, "route", r.Route)
+	}
+	if r.TraceID != "" {
+		attrs = append(attrs, "trace_id", r.TraceID)
+	}
+	logger := base.With(attrs...)
+	timer := &dbTimer{}
+	ctx = context.WithValue(NewContext(ctx, logger), dbTimerKey{}, timer)
+	logger.DebugContext(ctx, "request received")
+
+	return ctx, func(status int, err error) {
+		timer.mu.Lock()
+		db, calls := timer.total, timer.calls
+		timer.mu.Unlock()
+
+		outcome, level := "success", slog.LevelInfo
+		switch {
+		case err != nil || status >= 500:
+			outcome, level = "error", slog.LevelError
+		case status >= 400:
+			outcome, level = "client_error", slog.LevelWarn
+		}
+		attrs := []any{
+			"status", status,
+			"outcome", outcome,
+			"latency_ms", ms(time.Since(start)),
+			"db_ms", ms(db),
+			"db_calls", calls,
+		}
+		if err != nil {
+			attrs = append(attrs, "error", err)
+		}
+		logger.Log(ctx, level, "request completed", attrs...)
+	}
+}
+
+// TimeDB はデータベース処理の計測を始め、終わったときに呼ぶ関数を返す
+//
+// 計測した時間はStartのfinishが書くdb_msに加算される。Startを経ていないctxでは何もしない。
+//
+//	done := logging.TimeDB(ctx)
+//	page, err := repo.List(ctx, opts)
+//	done()
+func TimeDB(ctx context.Context) func() {
+	timer, ok := ctx.Value(dbTimerKey{}).(*dbTimer)
+	if !ok {
+		return func() {}
+	}
+	start := time.Now()
+	return func() {
+		d := time.Since(start)
+		timer.mu.Lock()
+		defer timer.mu.Unlock()
+		timer.total += d
+		timer.calls++
+	}
+}
+
+// ms は時間をミリ秒（小数点以下3桁まで）にする
+func ms(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
@@ -0,0 +1,153 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+// DSQLの認証トークン（dsql/authが返す署名付きURL）と同じ形の値
+const testToken = "abc.dsql.us-east-1.on.aws/?Action=DbConnectAdmin" +
+	"&X-Amz-Algorithm=AWS4-HMAC-SHA256&X-Amz-Credential=AKIAEXAMPLE%2F20250101%2Fus-east-1%2Fdsql%2Faws4_request" +
+	"&X-Amz-Date=20250101T000000Z&X-Amz-Expires=30&X-Amz-SignedHeaders=host&X-Amz-Signature=deadbeef"
+
+// logLine はNewのロガーでlogを実行し、書かれた1行を返す
+func logLine(t *testing.T, log func(l *slog.Logger)) (string, map[string]any) {
+	t.Helper()
+	var buf bytes.Buffer
+	log(New(&buf, slog.LevelDebug))
+	var m map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &m); err != nil {
+		t.Fatalf("log line %q: %v", buf.String(), err)
+	}
+	return buf.String(), m
+}
+
+// 秘密がどこにも残っていないことを確かめる
+func assertNoSecrets(t *testing.T, line string, secrets ...string) {
+	t.Helper()
+	for _, s := range secrets {
+		if strings.Contains(line, s) {
+			t.Errorf("log line contains %q: %s", s, line)
+		}
+	}
+}
+
+type stringer string
+
+func (s stringer) String() string { return string(s) }
+
+func TestRedactValues(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{"presigned token", testToken, "abc.dsql.us-east-1.on.aws/?Action=" + Redacted},
+		{"token in a message", "connect with " + testToken + " failed", "connect with abc.dsql.us-east-1.on.aws/?Action=" + Redacted + " failed"},
+		{"url password", "postgres://u:p@h:5432/db", "postgres://u:" + Redacted + "@h:5432/db"},
+		{"quoted keyword password", "host=h user=u password='x y' dbname=d", "host=h user=u password=" + Redacted + " dbname=d"},
+		{"keyword password", "host=h PASSWORD=xyz sslmode=require", "host=h PASSWORD=" + Redacted + " sslmode=require"},
+		{"nothing secret", "SELECT 1", "SELECT 1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, m := logLine(t, func(l *slog.Logger) {
+				l.Info(tt.value, "value", tt.value, "err", errors.New(tt.value), "stringer", stringer(tt.value))
+			})
+			for _, key := range []string{"msg", "value", "err", "stringer"} {
+				if m[key] != tt.want {
+					t.Errorf("%s = %q, want %q", key, m[key], tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestRedactSensitiveKeys(t *testing.T) {
+	line, m := logLine(t, func(l *slog.Logger) {
+		l.Info("connect",
+			"password", "hunter2",
+			"DB_PASSWORD", "hunter2",
+			"token", "opaque",
+			"Authorization", "Bearer opaque",
+			"database_url", "opaque",
+			"token_error", errors.New("opaque"),
+			"secret_name", stringer("opaque"),
+			"api_token", []byte("opaque"),
+			"token_ttl", 30*time.Second,
+			"token_count", 3,
+			"user", "admin",
+		)
+	})
+	for _, key := range []string{"password", "DB_PASSWORD", "token", "Authorization", "database_url", "token_error", "secret_name", "api_token"} {
+		if m[key] != Redacted {
+			t.Errorf("%s = %v, want %s", key, m[key], Redacted)
+		}
+	}
+	assertNoSecrets(t, line, "hunter2", "opaque")
+
+	// 秘密ではない数値や時間はそのまま書く
+	if m["token_ttl"] != float64(30*time.Second) || m["token_count"] != 3.0 || m["user"] != "admin" {
+		t.Errorf("token_ttl %v, token_count %v, user %v were changed", m["token_ttl"], m["token_count"], m["user"])
+	}
+}
+
+// credentialValue はLogValueで秘密を含むグループになる値
+type credentialValue struct{}
+
+func (credentialValue) LogValue() slog.Value {
+	return slog.GroupValue(slog.String("password", "hunter2"), slog.String("url", "postgres://u:hunter2@h/db"))
+}
+
+func TestRedactGroups(t *testing.T) {
+	line, m := logLine(t, func(l *slog.Logger) {
+		l.With(slog.Group("conn", "password", "with-secret")).
+			WithGroup("request").
+			Info("m",
+				slog.Group("db", slog.String("password", "hunter2"), slog.Any("err", errors.New("password=hunter2")), slog.String("host", "h")),
+				slog.Group("credentials", slog.String("access_key_id", "AKIAEXAMPLE"), slog.Int("expires", 30)),
+				slog.Any("value", credentialValue{}),
+				slog.String("token", testToken),
+			)
+	})
+	assertNoSecrets(t, line, "hunter2", "with-secret", "AKIAEXAMPLE", "deadbeef")
+
+	group := func(m map[string]any, key string) map[string]any {
+		g, ok := m[key].(map[string]any)
+		if !ok {
+			t.Fatalf("%s = %v, want a group", key, m[key])
+		}
+		return g
+	}
+	if got := group(m, "conn")["password"]; got != Redacted {
+		t.Errorf("conn.password = %v", got)
+	}
+	req := group(m, "request")
+	db := group(req, "db")
+	if db["password"] != Redacted || db["err"] != "password="+Redacted || db["host"] != "h" {
+		t.Errorf("request.db = %v", db)
+	}
+	// 名前が秘密を示すグループは文字列を伏せ、数値は残す
+	creds := group(req, "credentials")
+	if creds["access_key_id"] != Redacted || creds["expires"] != 30.0 {
+		t.Errorf("request.credentials = %v", creds)
+	}
+	value := group(req, "value")
+	if value["password"] != Redacted || value["url"] != "postgres://u:"+Redacted+"@h/db" {
+		t.Errorf("request.value = %v", value)
+	}
+	if req["token"] != Redacted {
+		t.Errorf("request.token = %v", req["token"])
+	}
+}
+
+func TestScrub(t *testing.T) {
+	if got := Scrub("dsn=postgres://admin:p%40ss@h/postgres?password=x"); strings.Contains(got, "p%40ss") || strings.Contains(got, "=x") {
+		t.Errorf("Scrub left a password: %q", got)
+	}
+}
@@ -0,0 +1,489 @@
+// Package migrations はbutton_clicksなどのスキーマをバージョン管理して適用する
+//
+// マイグレーションは sql/ に NNNN_name.up.sql / NNNN_name.down.sql として埋め込む。
+// 適用済みのバージョンとチェックサムは schema_migrations テーブルに記録する。
+//
+// Aurora DSQLの制約に合わせて次のように動作する。
+//   - 1つのトランザクションで実行できるDDLは1文だけのため、各文を個別に（自動コミットで）実行する
+//   - DDLとDMLを同じトランザクションに混在できないため、schema_migrationsの更新も別に行う
+//   - CREATE INDEX は ASYNC で作成し、sys.wait_for_job で完了を待つ
+//
+// 複数の文からなるマイグレーションは途中で失敗すると一部だけ適用された状態になる。
+// その場合はdirtyとして記録し、手動で修正してForceで解除するまで以降の適用を止める。
+package migrations
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"dsql-shared/dsqltx"
+	"dsql-shared/sqlscript"
+)
+
+// Table は適用済みのマイグレーションを記録するテーブル名
+const Table = "schema_migrations"
+
+//go:embed sql/*.sql
+var embedded embed.FS
+
+var (
+	// ErrDirty は途中で失敗したマイグレーションが残っている
+	ErrDirty = errors.New("migrations: database is dirty")
+	// ErrChecksumMismatch は適用済みのマイグレーションのファイルが変更された
+	ErrChecksumMismatch = errors.New("migrations: checksum mismatch")
+	// ErrIrreversible はdownファイルのないマイグレーションを戻そうとした
+	ErrIrreversible = errors.New("migrations: migration is irreversible")
+)
+
+// Migration は1つのバージョンのマイグレーション
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string // 空の場合は戻せない
+}
+
+// Checksum はupのSQLのSHA-256（16進数）を返す
+func (m Migration) Checksum() string {
+	sum := sha256.Sum256([]byte(m.Up))
+	return hex.EncodeToString(sum[:])
+}
+
+func (m Migration) String() string {
+	return fmt.Sprintf("%04d_%s", m.Version, m.Name)
+}
+
+// Applied はschema_migrationsの1行
+type Applied struct {
+	Version   int64
+	Name      string
+	Checksum  string
+	Dirty     bool
+	AppliedAt time.Time
+}
+
+// Status はマイグレーションごとの適用状況
+type Status struct {
+	Migration
+	// Applied は未適用の場合nil
+	Applied *Applied
+}
+
+// Pending は未適用かどうかを返す
+func (s Status) Pending() bool { return s.Applied == nil }
+
+// Modified は適用後にファイルが変更されたかどうかを返す
+func (s Status) Modified() bool {
+	return s.Applied != nil && s.Applied.Checksum != s.Checksum()
+}
+
+var fileName = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// All は埋め込みのマイグレーションをバージョン順に返す
+func All() ([]Migration, error) {
+	sub, err := fs.Sub(embedded, "sql")
+	if err != nil {
+		return nil, err
+	}
+	return Load(sub)
+}
+
+// Load はfsysの直下にあるマイグレーションファイルを読み込んで検証する
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, fmt.Errorf("migrations: failed to read directory: %w", err)
+	}
+
+	byVersion := map[int64]*Migration{}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".sql") {
+			continue
+		}
+		match := fileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("migrations: invalid file name %q (expected NNNN_name.up.sql or NNNN_name.down.sql)", entry.Name())
+		}
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil || version < 1 {
+			return nil, fmt.Errorf("migrations: invalid version in %q", entry.Name())
+		}
+		body, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, fmt.Errorf("migrations: failed to read %s: %w", entry.Name(), err)
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("migrations: version %d has conflicting names %q and %q", version, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	var migrations []Migration
+	for _, m := range byVersion {
+		if strings.TrimSpace(m.Up) == "" {
+			return nil, fmt.Errorf("migrations: %s has no up migration", m)
+		}
+		for _, script := range []string{m.Up, m.Down} {
+			if err := validate(script); err != nil {
+				return nil, fmt.Errorf("migrations: %s: %w", m, err)
+			}
+		}
+		migrations = append(migrations, *m)
+	}
+	slices.SortFunc(migrations, func(a, b Migration) int {
+		return cmp.Compare(a.Version, b.Version)
+	})
+	return migrations, nil
+}
+
+// validate はDSQLで実行できない書き方をしていないか確認する
+func validate(script string) error {
+	for _, stmt := range sqlscript.Statements(script) {
+		if stmt.Kind == sqlscript.KindTransaction {
+			return fmt.Errorf("%s is not allowed; each statement runs in its own transaction", stmt.Command)
+		}
+		if index, async := indexStatement(stmt.Text); index && !async {
+			return fmt.Errorf("use CREATE INDEX ASYNC on DSQL: %s", stmt.Text)
+		}
+	}
+	return nil
+}
+
+// DB はマイグレーションの実行に使うデータベース（*pgxpool.Pool、*pgx.Connなど）
+//
+// 各文は自動コミットで実行するため、トランザクション（pgx.Tx）は渡さないこと。
+type DB interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// Migrator はマイグレーションを適用する
+type Migrator struct {
+	db         DB
+	migrations []Migration
+	// Logf が設定されている場合、実行する文や進捗を出力する
+	Logf func(format string, args ...any)
+}
+
+// New はmigrationsをdbに適用するMigratorを返す
+func New(db DB, migrations []Migration) *Migrator {
+	return &Migrator{db: db, migrations: migrations}
+}
+
+func (m *Migrator) logf(format string, args ...any) {
+	if m.Logf != nil {
+		m.Logf(format, args...)
+	}
+}
+
+// exec はOCC競合を再試行しながらqueryを自動コミットで実行する
+func (m *Migrator) exec(ctx context.Context, query string, args ...any) error {
+	_, err := dsqltx.Retry(ctx, func(ctx context.Context) error {
+		_, err := m.db.Exec(ctx, query, args...)
+		return err
+	})
+	return err
+}
+
+// Init はschema_migrationsテーブルを作成する
+func (m *Migrator) Init(ctx context.Context) error {
+	err := m.exec(ctx, `CREATE TABLE IF NOT EXISTS `+Table+` (
+		version BIGINT PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		checksum VARCHAR(64) NOT NULL,
+		dirty BOOLEAN NOT NULL DEFAULT FALSE,
+		applied_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		return fmt.Errorf("migrations: failed to create %s: %w", Table, err)
+	}
+	return nil
+}
+
+// applied はschema_migrationsの内容をバージョンごとに返す
+//
+// テーブルがまだなければ（一度も適用していなければ）空を返す。
+func (m *Migrator) applied(ctx context.Context) (map[int64]Applied, error) {
+	rows, err := m.db.Query(ctx, "SELECT version, name, checksum, dirty, applied_at FROM "+Table+" ORDER BY version")
+	if isUndefinedTable(err) {
+		return map[int64]Applied{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("migrations: failed to read %s: %w", Table, err)
+	}
+	defer rows.Close()
+
+	applied := map[int64]Applied{}
+	for rows.Next() {
+		var a Applied
+		if err := rows.Scan(&a.Version, &a.Name, &a.Checksum, &a.Dirty, &a.AppliedAt); err != nil {
+			return nil, fmt.Errorf("migrations: failed to read %s: %w", Table, err)
+		}
+		applied[a.Version] = a
+	}
+	// pgxではサーバーのエラーが行の読み取りで返ることもある
+	if err := rows.Err(); isUndefinedTable(err) {
+		return map[int64]Applied{}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("migrations: failed to read %s: %w", Table, err)
+	}
+	return applied, nil
+}
+
+// isUndefinedTable はテーブルが存在しないエラー（SQLSTATE 42P01）かどうかを返す
+func isUndefinedTable(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "42P01"
+}
+
+// Status は全マイグレーションの適用状況をバージョン順に返す
+//
+// ファイルが存在しない適用済みのバージョンはエラーにする。読み取るだけで、
+// schema_migrationsがなければ作成せずにすべて未適用とする。
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, 0, len(m.migrations))
+	for _, mig := range m.migrations {
+		s := Status{Migration: mig}
+		if a, ok := applied[mig.Version]; ok {
+			s.Applied = &a
+			delete(applied, mig.Version)
+		}
+		statuses = append(statuses, s)
+	}
+	for version := range applied {
+		return nil, fmt.Errorf("migrations: version %d is applied but its file is missing", version)
+	}
+	return statuses, nil
+}
+
+// check はdirtyなバージョンや変更されたファイルがないことを確認する
+func check(statuses []Status) error {
+	var errs []error
+	for _, s := range statuses {
+		if s.Applied != nil && s.Applied.Dirty {
+			errs = append(errs, fmt.Errorf("%w: %s failed partway; fix the schema by hand and run force %d", ErrDirty, s.Migration, s.Version))
+		}
+		if s.Modified() {
+			errs = append(errs, fmt.Errorf("%w: %s was edited after it was applied (recorded %.12s, file %.12s)", ErrChecksumMismatch, s.Migration, s.Applied.Checksum, s.Checksum()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Up は未適用のマイグレーションをtargetのバージョンまで順に適用し、適用した数を返す
+//
+// targetが0の場合は最新まで適用する。
+func (m *Migrator) Up(ctx context.Context, target int64) (int, error) {
+	if err := m.Init(ctx); err != nil {
+		return 0, err
+	}
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if err := check(statuses); err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, s := range statuses {
+		if target > 0 && s.Version > target {
+			break
+		}
+		if !s.Pending() {
+			continue
+		}
+		if err := m.apply(ctx, s.Migration); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// Down は適用済みのマイグレーションを新しい順にsteps個戻し、戻した数を返す
+func (m *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if err := check(statuses); err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, s := range slices.Backward(statuses) {
+		if n >= steps {
+			break
+		}
+		if s.Pending() {
+			continue
+		}
+		if err := m.revert(ctx, s.Migration); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// Force はversionのdirtyを解除し、現在のファイルのチェックサムを記録する
+//
+// 途中で失敗したマイグレーションを手動で直した後や、意図してファイルを変更した後に使う。
+func (m *Migrator) Force(ctx context.Context, version int64) error {
+	idx := slices.IndexFunc(m.migrations, func(mig Migration) bool { return mig.Version == version })
+	if idx < 0 {
+		return fmt.Errorf("migrations: unknown version %d", version)
+	}
+	if err := m.Init(ctx); err != nil {
+		return err
+	}
+	mig := m.migrations[idx]
+	err := m.exec(ctx, "UPDATE "+Table+" SET name = $2, checksum = $3, dirty = FALSE WHERE version = $1",
+		mig.Version, mig.Name, mig.Checksum())
+	if err != nil {
+		return fmt.Errorf("migrations: failed to force %s: %w", mig, err)
+	}
+	return nil
+}
+
+// apply はmigの各文を個別に実行し、schema_migrationsに記録する
+func (m *Migrator) apply(ctx context.Context, mig Migration) error {
+	m.logf("applying %s", mig)
+
+	// 先にdirtyとして記録しておき、途中で失敗した場合にわかるようにする
+	err := m.exec(ctx, "INSERT INTO "+Table+" (version, name, checksum, dirty) VALUES ($1, $2, $3, TRUE)",
+		mig.Version, mig.Name, mig.Checksum())
+	if err != nil {
+		return fmt.Errorf("migrations: failed to record %s: %w", mig, err)
+	}
+
+	if err := m.run(ctx, mig.Up); err != nil {
+		return fmt.Errorf("migrations: %s failed and is marked dirty: %w", mig, err)
+	}
+
+	err = m.exec(ctx, "UPDATE "+Table+" SET dirty = FALSE, applied_at = CURRENT_TIMESTAMP WHERE version = $1", mig.Version)
+	if err != nil {
+		return fmt.Errorf("migrations: failed to record %s: %w", mig, err)
+	}
+	return nil
+}
+
+// revert はmigのdownを実行し、schema_migrationsから削除する
+func (m *Migrator) revert(ctx context.Context, mig Migration) error {
+	if strings.TrimSpace(mig.Down) == "" {
+		return fmt.Errorf("%w: %s", ErrIrreversible, mig)
+	}
+	m.logf("reverting %s", mig)
+
+	if err := m.exec(ctx, "UPDATE "+Table+" SET dirty = TRUE WHERE version = $1", mig.Version); err != nil {
+		return fmt.Errorf("migrations: failed to record %s: %w", mig, err)
+	}
+
+	if err := m.run(ctx, mig.Down); err != nil {
+		return fmt.Errorf("migrations: reverting %s failed and it is marked dirty: %w", mig, err)
+	}
+
+	if err := m.exec(ctx, "DELETE FROM "+Table+" WHERE version = $1", mig.Version); err != nil {
+		return fmt.Errorf("migrations: failed to record %s: %w", mig, err)
+	}
+	return nil
+}
+
+// run はscriptの各文を1文ずつ自動コミットで実行する
+func (m *Migrator) run(ctx context.Context, script string) error {
+	for _, stmt := range sqlscript.Split(script) {
+		m.logf("  %s", stmt)
+		if _, async := indexStatement(stmt); async {
+			if err := m.createIndexAsync(ctx, stmt); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := m.exec(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createIndexAsync はCREATE INDEX ASYNCを実行し、返されたジョブの完了を待つ
+func (m *Migrator) createIndexAsync(ctx context.Context, stmt string) error {
+	var jobID *string
+	_, err := dsqltx.Retry(ctx, func(ctx context.Context) error {
+		return m.db.QueryRow(ctx, stmt).Scan(&jobID)
+	})
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && jobID == nil) {
+		// IF NOT EXISTSで既に存在していた場合はジョブが作られない
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	m.logf("  waiting for index job %s", *jobID)
+	var ok bool
+	if err := m.db.QueryRow(ctx, "SELECT sys.wait_for_job($1)", *jobID).Scan(&ok); err != nil {
+		return fmt.Errorf("failed to wait for index job %s: %w", *jobID, err)
+	}
+	if !ok {
+		return fmt.Errorf("index job %s did not complete successfully (see sys.jobs)", *jobID)
+	}
+	return nil
+}
+
+// indexStatement はstmtがCREATE [UNIQUE] INDEX文かどうかと、ASYNCを指定しているかどうかを返す
+//
+// 先頭や途中のコメントは読み飛ばす。
+func indexStatement(stmt string) (index, async bool) {
+	var words []sqlscript.Token
+	for _, t := range sqlscript.Lex(stmt) {
+		if t.Kind == sqlscript.Space || t.Kind == sqlscript.Comment {
+			continue
+		}
+		if words = append(words, t); len(words) == 4 {
+			break
+		}
+	}
+
+	if len(words) == 0 || !words[0].Is("CREATE") {
+		return false, false
+	}
+	i := 1
+	if i < len(words) && words[i].Is("UNIQUE") {
+		i++
+	}
+	if i == len(words) || !words[i].Is("INDEX") {
+		return false, false
+	}
+	return true, i+1 < len(words) && words[i+1].Is("ASYNC")
+}
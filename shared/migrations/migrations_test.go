@@ -0,0 +1,189 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestIndexStatement(t *testing.T) {
+	tests := []struct {
+		stmt         string
+		index, async bool
+	}{
+		{"CREATE INDEX idx ON t (a)", true, false},
+		{"create unique index idx on t (a)", true, false},
+		{"CREATE INDEX ASYNC idx ON t (a)", true, true},
+		{"CREATE UNIQUE INDEX ASYNC IF NOT EXISTS idx ON t (a)", true, true},
+		{"-- 期間指定用\nCREATE INDEX ASYNC idx ON t (a)", true, true},
+		{"/* leading */ CREATE /* inner */ INDEX\n\tASYNC idx ON t (a)", true, true},
+		{"-- ASYNC\nCREATE INDEX idx ON t (a)", true, false},
+		{"CREATE TABLE index_log (id BIGINT)", false, false},
+		{`CREATE TABLE "INDEX" (id BIGINT)`, false, false},
+		{"SELECT 'CREATE INDEX ASYNC'", false, false},
+		{"", false, false},
+	}
+	for _, tt := range tests {
+		index, async := indexStatement(tt.stmt)
+		if index != tt.index || async != tt.async {
+			t.Errorf("indexStatement(%q) = %v, %v; want %v, %v", tt.stmt, index, async, tt.index, tt.async)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		script  string
+		wantErr string
+	}{
+		{"async index", "CREATE INDEX ASYNC idx ON t (a);", ""},
+		{"commented async index", "-- comment\n/* block */ CREATE INDEX ASYNC idx ON t (a);", ""},
+		{"sync index", "CREATE TABLE t (a INT);\nCREATE INDEX idx ON t (a);", "use CREATE INDEX ASYNC"},
+		{"commented sync index", "/* ASYNC */ create unique index idx on t (a);", "use CREATE INDEX ASYNC"},
+		{"begin", "BEGIN;\nCREATE TABLE t (a INT);\nCOMMIT;", "BEGIN is not allowed"},
+		{"commented commit", "CREATE TABLE t (a INT);\n-- done\ncommit;", "COMMIT is not allowed"},
+		{"savepoint", "SAVEPOINT s;", "SAVEPOINT is not allowed"},
+		{"begin inside dollar quotes", "DO $$ BEGIN PERFORM 1; END $$;", ""},
+		{"begin in a string", "INSERT INTO t (note) VALUES ('BEGIN; COMMIT;');", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validate(tt.script)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("validate: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("validate error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	fsys := fstest.MapFS{
+		"0002_add_index.up.sql":    {Data: []byte("CREATE INDEX ASYNC idx ON t (a);")},
+		"0001_create_t.up.sql":     {Data: []byte("CREATE TABLE t (a INT);")},
+		"0001_create_t.down.sql":   {Data: []byte("DROP TABLE t;")},
+		"README.md":                {Data: []byte("not a migration")},
+		"0003_irreversible.up.sql": {Data: []byte("ALTER TABLE t ADD COLUMN b INT;")},
+	}
+	migs, err := Load(fsys)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	var names []string
+	for _, m := range migs {
+		names = append(names, m.String())
+	}
+	if got := strings.Join(names, ","); got != "0001_create_t,0002_add_index,0003_irreversible" {
+		t.Errorf("migrations = %s", got)
+	}
+	if migs[0].Down != "DROP TABLE t;" || migs[2].Down != "" {
+		t.Errorf("down scripts = %q, %q", migs[0].Down, migs[2].Down)
+	}
+
+	bad := []struct {
+		name string
+		fsys fstest.MapFS
+		want string
+	}{
+		{"bad name", fstest.MapFS{"1-create.sql": {}}, "invalid file name"},
+		{"no up", fstest.MapFS{"0001_x.down.sql": {Data: []byte("DROP TABLE t;")}}, "has no up migration"},
+		{"conflicting names", fstest.MapFS{
+			"0001_a.up.sql": {Data: []byte("SELECT 1;")},
+			"0001_b.up.sql": {Data: []byte("SELECT 1;")},
+		}, "conflicting names"},
+		{"sync index", fstest.MapFS{"0001_x.up.sql": {Data: []byte("CREATE INDEX i ON t (a);")}}, "ASYNC"},
+	}
+	for _, tt := range bad {
+		if _, err := Load(tt.fsys); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: Load error = %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestEmbeddedMigrations(t *testing.T) {
+	migs, err := All()
+	if err != nil {
+		t.Fatalf("All: %v", err)
+	}
+	if len(migs) == 0 || migs[0].Version != 1 {
+		t.Fatalf("embedded migrations = %v", migs)
+	}
+}
+
+// fakeDB はschema_migrationsがないデータベースを模し、実行した文を記録する
+type fakeDB struct {
+	queryErr error // Queryが返すエラー
+	rowsErr  error // 行の読み取りで返すエラー
+	execs    []string
+}
+
+func (db *fakeDB) Exec(_ context.Context, sql string, _ ...any) (pgconn.CommandTag, error) {
+	db.execs = append(db.execs, sql)
+	return pgconn.CommandTag{}, nil
+}
+
+func (db *fakeDB) Query(context.Context, string, ...any) (pgx.Rows, error) {
+	if db.queryErr != nil {
+		return nil, db.queryErr
+	}
+	return &fakeRows{err: db.rowsErr}, nil
+}
+
+func (db *fakeDB) QueryRow(context.Context, string, ...any) pgx.Row {
+	return &fakeRows{err: errors.New("unexpected QueryRow")}
+}
+
+// fakeRows は行を返さずにerrで終わるpgx.Rows
+type fakeRows struct {
+	pgx.Rows
+	err error
+}
+
+func (r *fakeRows) Next() bool                    { return false }
+func (r *fakeRows) Close()                        {}
+func (r *fakeRows) Err() error                    { return r.err }
+func (r *fakeRows) Scan(...any) error             { return r.err }
+func (r *fakeRows) CommandTag() pgconn.CommandTag { return pgconn.CommandTag{} }
+
+func TestStatusWithoutTable(t *testing.T) {
+	undefined := &pgconn.PgError{Code: "42P01", Message: `relation "schema_migrations" does not exist`}
+	migs, err := All()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, db := range []*fakeDB{{queryErr: undefined}, {rowsErr: undefined}} {
+		statuses, err := New(db, migs).Status(context.Background())
+		if err != nil {
+			t.Fatalf("Status: %v", err)
+		}
+		if len(statuses) != len(migs) {
+			t.Fatalf("got %d statuses, want %d", len(statuses), len(migs))
+		}
+		for _, s := range statuses {
+			if !s.Pending() {
+				t.Errorf("%s is not pending", s.Migration)
+			}
+		}
+		// Statusは読み取りだけで、テーブルを作成しない
+		if len(db.execs) != 0 {
+			t.Errorf("Status executed %q", db.execs)
+		}
+	}
+
+	other := &fakeDB{queryErr: &pgconn.PgError{Code: "42501", Message: "permission denied"}}
+	if _, err := New(other, migs).Status(context.Background()); err == nil {
+		t.Error("Status ignored a permission error")
+	}
+}
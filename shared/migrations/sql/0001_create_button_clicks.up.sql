@@ -0,0 +1,10 @@
+-- ボタンクリックの記録テーブル
+-- DSQLはSERIAL/IDENTITYを使えないため、idはアプリケーション側（idgen）で採番する
+CREATE TABLE IF NOT EXISTS button_clicks (
+	id BIGINT PRIMARY KEY,
+	timestamp TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+	action VARCHAR(100),
+	user_agent TEXT,
+	ip_address VARCHAR(45),
+	created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS idx_button_clicks_timestamp;
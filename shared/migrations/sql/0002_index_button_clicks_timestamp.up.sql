@@ -0,0 +1,3 @@
+-- 一覧・集計APIの期間指定用
+-- DSQLでは既存テーブルへのインデックス作成はASYNCで行い、完了を待つ
+CREATE INDEX ASYNC IF NOT EXISTS idx_button_clicks_timestamp ON button_clicks ("timestamp");
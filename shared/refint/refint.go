@@ -0,0 +1,401 @@
+// Package refint はDSQLで使えない外部キーの代わりに、アプリケーション側で参照整合性を確認する
+//
+// テーブルと関連（子テーブルの列 → 親テーブルの列）を宣言したSchemaを作り、Insert・Update・Delete
+// を同じpgxのトランザクション内で呼び出す。dsqlconn.New で作成したプールと組み合わせる場合は
+// dsqltx.RunInTx の中で使う。
+//
+//	_, err := dsqltx.RunInTx(ctx, pool, func(tx pgx.Tx) error {
+//		return schema.Insert(ctx, tx, "orders", map[string]any{"id": id, "customer_id": customerID})
+//	})
+//
+// 親の行の存在確認は主キーに対する SELECT ... FOR UPDATE で行う。DSQLのOCCは書き込んだ行の
+// 競合しか検出しないため、これにより親の行を同時に削除したトランザクションと競合させる。
+package refint
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Action は親の行を削除したときの子の行の扱い（ON DELETE）
+type Action string
+
+const (
+	// Restrict は参照されている親の行の削除をエラーにする（NO ACTIONも同じ扱い）
+	Restrict Action = "RESTRICT"
+	// Cascade は参照している子の行も削除する
+	Cascade Action = "CASCADE"
+	// SetNull は参照している子の列をNULLにする
+	SetNull Action = "SET NULL"
+	// SetDefault は参照している子の列を既定値にする
+	SetDefault Action = "SET DEFAULT"
+)
+
+// maxDepth はCASCADEをたどる深さの上限（循環した参照の検出用）
+const maxDepth = 32
+
+// ErrViolation は参照整合性に違反した
+var ErrViolation = errors.New("refint: foreign key violation")
+
+// ViolationError は違反した関連と操作を表す（errors.Is(err, ErrViolation) が true になる）
+type ViolationError struct {
+	Relation Relation
+	Op       string // insert, update, delete
+	Value    any
+}
+
+func (e *ViolationError) Error() string {
+	r := e.Relation
+	switch e.Op {
+	case "delete", "update":
+		return fmt.Sprintf("refint: %s.%s=%v is still referenced by %s.%s", r.Parent, r.ParentColumn, e.Value, r.Child, r.Column)
+	default:
+		return fmt.Sprintf("refint: %s.%s=%v references a missing %s row", r.Child, r.Column, e.Value, r.Parent)
+	}
+}
+
+func (e *ViolationError) Is(target error) bool { return target == ErrViolation }
+
+// Table は関連に含まれるテーブル
+type Table struct {
+	Name string
+	// PrimaryKey は単一列の主キー。親になるテーブルでは必須
+	PrimaryKey string
+}
+
+// Relation は子テーブルの列から親テーブルの列への参照
+type Relation struct {
+	Child  string
+	Column string
+	Parent string
+	// ParentColumn が空の場合は親の主キー
+	ParentColumn string
+	// OnDelete が空の場合はRestrict
+	OnDelete Action
+}
+
+// Querier はトランザクション（pgx.Tx）
+type Querier interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// Schema はテーブルと関連の定義
+type Schema struct {
+	tables    map[string]Table
+	relations []Relation
+	children  map[string][]Relation // 親テーブル → そのテーブルを参照する関連
+	parents   map[string][]Relation // 子テーブル → そのテーブルからの関連
+}
+
+// New はtablesとrelationsを検証してSchemaを返す
+func New(tables []Table, relations []Relation) (*Schema, error) {
+	s := &Schema{
+		tables:   map[string]Table{},
+		children: map[string][]Relation{},
+		parents:  map[string][]Relation{},
+	}
+	var errs []error
+	for _, t := range tables {
+		if t.Name == "" {
+			errs = append(errs, errors.New("refint: table name is empty"))
+			continue
+		}
+		s.tables[t.Name] = t
+	}
+
+	for _, r := range relations {
+		parent, ok := s.tables[r.Parent]
+		switch {
+		case r.Column == "":
+			errs = append(errs, fmt.Errorf("refint: relation from %s has no column", r.Child))
+			continue
+		case s.tables[r.Child].Name == "":
+			errs = append(errs, fmt.Errorf("refint: %s.%s: unknown table %s", r.Child, r.Column, r.Child))
+			continue
+		case !ok:
+			errs = append(errs, fmt.Errorf("refint: %s.%s: unknown table %s", r.Child, r.Column, r.Parent))
+			continue
+		case parent.PrimaryKey == "":
+			errs = append(errs, fmt.Errorf("refint: %s.%s: parent table %s has no primary key", r.Child, r.Column, r.Parent))
+			continue
+		}
+		if r.ParentColumn == "" {
+			r.ParentColumn = parent.PrimaryKey
+		}
+		switch r.OnDelete {
+		case "", "NO ACTION":
+			r.OnDelete = Restrict
+		case Restrict, Cascade, SetNull, SetDefault:
+		default:
+			errs = append(errs, fmt.Errorf("refint: %s.%s: unknown action %q", r.Child, r.Column, r.OnDelete))
+			continue
+		}
+		s.relations = append(s.relations, r)
+		s.children[r.Parent] = append(s.children[r.Parent], r)
+		s.parents[r.Child] = append(s.parents[r.Child], r)
+	}
+
+	// CASCADEで削除する子がさらに参照されている場合は、子の主キーでたどる
+	for _, r := range s.relations {
+		if r.OnDelete == Cascade && len(s.children[r.Child]) > 0 && s.tables[r.Child].PrimaryKey == "" {
+			errs = append(errs, fmt.Errorf("refint: %s.%s: ON DELETE CASCADE into %s requires its primary key because it is referenced", r.Child, r.Column, r.Child))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// MustNew はNewと同じだが、定義が不正な場合はpanicする（パッケージ変数の初期化用）
+func MustNew(tables []Table, relations []Relation) *Schema {
+	s, err := New(tables, relations)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// Relations は定義した関連を返す
+func (s *Schema) Relations() []Relation {
+	return slices.Clone(s.relations)
+}
+
+// CheckInsert はtableに挿入する行の参照先が存在することを確認する
+//
+// valuesに含まれない列とNULLの列は確認しない。
+func (s *Schema) CheckInsert(ctx context.Context, q Querier, table string, values map[string]any) error {
+	for _, r := range s.parents[table] {
+		if v, ok := values[r.Column]; ok && v != nil {
+			if err := s.checkParent(ctx, q, r, v, "insert"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// CheckUpdate は主キーがkeyの行をvaluesで更新できるか確認する
+//
+// 子として参照先が存在することに加え、参照されている列を変更しないことを確認する（ON UPDATE NO ACTION）。
+func (s *Schema) CheckUpdate(ctx context.Context, q Querier, table string, key any, values map[string]any) error {
+	if err := s.CheckInsert(ctx, q, table, values); err != nil {
+		return err
+	}
+	pk, err := s.primaryKey(table)
+	if err != nil && len(s.children[table]) > 0 {
+		return err
+	}
+	for _, r := range s.children[table] {
+		v, ok := values[r.ParentColumn]
+		if !ok {
+			continue
+		}
+		query := fmt.Sprintf("SELECT EXISTS (SELECT 1 FROM %s WHERE %s IN (SELECT %s FROM %s WHERE %s = $1 AND %s IS DISTINCT FROM $2))",
+			quote(r.Child), quote(r.Column), quote(r.ParentColumn), quote(table), quote(pk), quote(r.ParentColumn))
+		var referenced bool
+		if err := q.QueryRow(ctx, query, key, v).Scan(&referenced); err != nil {
+			return err
+		}
+		if referenced {
+			return &ViolationError{Relation: r, Op: "update", Value: key}
+		}
+	}
+	return nil
+}
+
+// BeforeDelete は主キーがkeyの行を削除する前に、参照している行にON DELETEの動作を適用する
+func (s *Schema) BeforeDelete(ctx context.Context, q Querier, table string, key any) error {
+	return s.beforeDelete(ctx, q, table, key, 0)
+}
+
+// Insert は参照先を確認してからtableにvaluesの行を挿入する
+func (s *Schema) Insert(ctx context.Context, q Querier, table string, values map[string]any) error {
+	if len(values) == 0 {
+		return fmt.Errorf("refint: no values to insert into %s", table)
+	}
+	if err := s.CheckInsert(ctx, q, table, values); err != nil {
+		return err
+	}
+
+	columns, args := sortedValues(values)
+	placeholders := make([]string, len(columns))
+	for i := range columns {
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+	}
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", quote(table), quoteList(columns), strings.Join(placeholders, ", "))
+	_, err := q.Exec(ctx, query, args...)
+	return err
+}
+
+// Update は参照整合性を確認してから主キーがkeyの行をvaluesで更新し、更新した行数を返す
+func (s *Schema) Update(ctx context.Context, q Querier, table string, key any, values map[string]any) (int64, error) {
+	if len(values) == 0 {
+		return 0, nil
+	}
+	pk, err := s.primaryKey(table)
+	if err != nil {
+		return 0, err
+	}
+	if err := s.CheckUpdate(ctx, q, table, key, values); err != nil {
+		return 0, err
+	}
+
+	columns, args := sortedValues(values)
+	sets := make([]string, len(columns))
+	for i, c := range columns {
+		sets[i] = fmt.Sprintf("%s = $%d", quote(c), i+1)
+	}
+	args = append(args, key)
+	query := fmt.Sprintf("UPDATE %s SET %s WHERE %s = $%d", quote(table), strings.Join(sets, ", "), quote(pk), len(args))
+	tag, err := q.Exec(ctx, query, args...)
+	return tag.RowsAffected(), err
+}
+
+// Delete は参照している行にON DELETEの動作を適用してから主キーがkeyの行を削除し、削除した行数を返す
+func (s *Schema) Delete(ctx context.Context, q Querier, table string, key any) (int64, error) {
+	pk, err := s.primaryKey(table)
+	if err != nil {
+		return 0, err
+	}
+	if err := s.BeforeDelete(ctx, q, table, key); err != nil {
+		return 0, err
+	}
+	tag, err := q.Exec(ctx, fmt.Sprintf("DELETE FROM %s WHERE %s = $1", quote(table), quote(pk)), key)
+	return tag.RowsAffected(), err
+}
+
+// checkParent は関連rの親の行（ParentColumn = v）が存在することを確認する
+func (s *Schema) checkParent(ctx context.Context, q Querier, r Relation, v any, op string) error {
+	query := fmt.Sprintf("SELECT 1 FROM %s WHERE %s = $1", quote(r.Parent), quote(r.ParentColumn))
+	if r.ParentColumn == s.tables[r.Parent].PrimaryKey {
+		// 親の行を書き込み対象にして、同時に親を削除するトランザクションとOCCで競合させる
+		query += " FOR UPDATE"
+	} else {
+		query += " LIMIT 1"
+	}
+	var one int
+	err := q.QueryRow(ctx, query, v).Scan(&one)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return &ViolationError{Relation: r, Op: op, Value: v}
+	}
+	return err
+}
+
+func (s *Schema) beforeDelete(ctx context.Context, q Querier, table string, key any, depth int) error {
+	if depth > maxDepth {
+		return fmt.Errorf("refint: ON DELETE CASCADE from %s is nested more than %d levels", table, maxDepth)
+	}
+	rels := s.children[table]
+	if len(rels) == 0 {
+		return nil
+	}
+	pk := s.tables[table].PrimaryKey
+
+	// 何も変更しないうちにRESTRICTを確認する
+	for _, r := range rels {
+		if r.OnDelete != Restrict {
+			continue
+		}
+		var referenced bool
+		query := fmt.Sprintf("SELECT EXISTS (SELECT 1 FROM %s WHERE %s)", quote(r.Child), condition(r, pk))
+		if err := q.QueryRow(ctx, query, key).Scan(&referenced); err != nil {
+			return err
+		}
+		if referenced {
+			return &ViolationError{Relation: r, Op: "delete", Value: key}
+		}
+	}
+
+	for _, r := range rels {
+		cond := condition(r, pk)
+		switch r.OnDelete {
+		case Cascade:
+			if len(s.children[r.Child]) > 0 {
+				childPK := s.tables[r.Child].PrimaryKey
+				rows, err := q.Query(ctx, fmt.Sprintf("SELECT %s FROM %s WHERE %s", quote(childPK), quote(r.Child), cond), key)
+				if err != nil {
+					return err
+				}
+				keys, err := pgx.CollectRows(rows, pgx.RowTo[any])
+				if err != nil {
+					return err
+				}
+				for _, k := range keys {
+					if err := s.beforeDelete(ctx, q, r.Child, k, depth+1); err != nil {
+						return err
+					}
+				}
+			}
+			if _, err := q.Exec(ctx, fmt.Sprintf("DELETE FROM %s WHERE %s", quote(r.Child), cond), key); err != nil {
+				return err
+			}
+		case SetNull, SetDefault:
+			value := strings.TrimPrefix(string(r.OnDelete), "SET ")
+			query := fmt.Sprintf("UPDATE %s SET %s = %s WHERE %s", quote(r.Child), quote(r.Column), value, cond)
+			if _, err := q.Exec(ctx, query, key); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// condition は親の主キー（$1）の行を参照している子の行を選ぶ条件を返す
+func condition(r Relation, parentPK string) string {
+	cond := quote(r.Column) + " = $1"
+	if r.ParentColumn != parentPK {
+		cond = fmt.Sprintf("%s IN (SELECT %s FROM %s WHERE %s = $1)", quote(r.Column), quote(r.ParentColumn), quote(r.Parent), quote(parentPK))
+	}
+	// 自己参照の場合、削除する行自身は除く
+	if r.Child == r.Parent {
+		cond += " AND " + quote(parentPK) + " <> $1"
+	}
+	return cond
+}
+
+func (s *Schema) primaryKey(table string) (string, error) {
+	t, ok := s.tables[table]
+	if !ok {
+		return "", fmt.Errorf("refint: unknown table %s", table)
+	}
+	if t.PrimaryKey == "" {
+		return "", fmt.Errorf("refint: table %s has no primary key", table)
+	}
+	return t.PrimaryKey, nil
+}
+
+// sortedValues はvaluesを列名の順に並べる
+func sortedValues(values map[string]any) ([]string, []any) {
+	columns := make([]string, 0, len(values))
+	for c := range values {
+		columns = append(columns, c)
+	}
+	slices.Sort(columns)
+	args := make([]any, len(columns))
+	for i, c := range columns {
+		args[i] = values[c]
+	}
+	return columns, args
+}
+
+// quote はschema.table形式の名前を識別子として引用符で囲む
+func quote(name string) string {
+	return pgx.Identifier(strings.Split(name, ".")).Sanitize()
+}
+
+func quoteList(names []string) string {
+	quoted := make([]string, len(names))
+	for i, n := range names {
+		quoted[i] = quote(n)
+	}
+	return strings.Join(quoted, ", ")
+}
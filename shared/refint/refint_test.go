@@ -0,0 +1,253 @@
+package refint
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"dsql-shared/testdb"
+)
+
+// testSchema は customers → orders → order_items の3段の関連
+//
+// ordersとorder_itemsはCASCADE、paymentsはRESTRICT、reviewsはSET NULLで参照する。
+var testSchema = MustNew(
+	[]Table{
+		{Name: "customers", PrimaryKey: "id"},
+		{Name: "orders", PrimaryKey: "id"},
+		{Name: "order_items", PrimaryKey: "id"},
+		{Name: "payments", PrimaryKey: "id"},
+		{Name: "reviews", PrimaryKey: "id"},
+	},
+	[]Relation{
+		{Child: "orders", Column: "customer_id", Parent: "customers", OnDelete: Cascade},
+		{Child: "order_items", Column: "order_id", Parent: "orders", OnDelete: Cascade},
+		{Child: "payments", Column: "order_id", Parent: "orders"},
+		{Child: "reviews", Column: "customer_id", Parent: "customers", OnDelete: SetNull},
+	},
+)
+
+var testTables = []string{
+	"CREATE TABLE IF NOT EXISTS customers (id BIGINT PRIMARY KEY)",
+	"CREATE TABLE IF NOT EXISTS orders (id BIGINT PRIMARY KEY, customer_id BIGINT)",
+	"CREATE TABLE IF NOT EXISTS order_items (id BIGINT PRIMARY KEY, order_id BIGINT)",
+	"CREATE TABLE IF NOT EXISTS payments (id BIGINT PRIMARY KEY, order_id BIGINT)",
+	"CREATE TABLE IF NOT EXISTS reviews (id BIGINT PRIMARY KEY, customer_id BIGINT)",
+	"TRUNCATE customers, orders, order_items, payments, reviews",
+}
+
+// setupDB はテスト用のテーブルを空にして、次の行を挿入する
+//
+//	customers    1, 2
+//	orders       10, 11（customer 1）、20（customer 2）
+//	order_items  100, 101（order 10）、110（order 11）、200（order 20）
+//	reviews      1000（customer 1）
+func setupDB(t *testing.T) *pgxpool.Pool {
+	t.Helper()
+	p := testdb.Pool(t, "test_refint")
+	for _, sql := range testTables {
+		testdb.Exec(t, p, sql)
+	}
+	testdb.Exec(t, p, "INSERT INTO customers (id) VALUES (1), (2)")
+	testdb.Exec(t, p, "INSERT INTO orders (id, customer_id) VALUES (10, 1), (11, 1), (20, 2)")
+	testdb.Exec(t, p, "INSERT INTO order_items (id, order_id) VALUES (100, 10), (101, 10), (110, 11), (200, 20)")
+	testdb.Exec(t, p, "INSERT INTO reviews (id, customer_id) VALUES (1000, 1)")
+	return p
+}
+
+// errRollback は確認だけのトランザクションをロールバックさせる
+var errRollback = errors.New("rollback")
+
+// inTx はfnをトランザクション内で実行する。fnがエラーを返したらロールバックしてそのエラーを返す
+func inTx(t *testing.T, p *pgxpool.Pool, fn func(pgx.Tx) error) error {
+	t.Helper()
+	ctx := context.Background()
+	tx, err := p.Begin(ctx)
+	if err != nil {
+		t.Fatalf("Begin: %v", err)
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback(ctx)
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	return nil
+}
+
+// rowIDs はtableのidを昇順に返す
+func rowIDs(t *testing.T, p *pgxpool.Pool, table string) []int64 {
+	t.Helper()
+	rows, err := p.Query(context.Background(), "SELECT id FROM "+quote(table)+" ORDER BY id")
+	if err != nil {
+		t.Fatalf("select %s: %v", table, err)
+	}
+	ids, err := pgx.CollectRows(rows, pgx.RowTo[int64])
+	if err != nil {
+		t.Fatalf("select %s: %v", table, err)
+	}
+	return ids
+}
+
+func TestCheckInsert(t *testing.T) {
+	p := setupDB(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		table   string
+		values  map[string]any
+		wantErr bool
+	}{
+		{"existing parent", "orders", map[string]any{"id": 12, "customer_id": 1}, false},
+		{"missing parent", "orders", map[string]any{"id": 12, "customer_id": 99}, true},
+		{"null parent", "orders", map[string]any{"id": 12, "customer_id": nil}, false},
+		{"column omitted", "orders", map[string]any{"id": 12}, false},
+		{"missing grandparent row", "order_items", map[string]any{"id": 120, "order_id": 12}, true},
+		{"table without parents", "customers", map[string]any{"id": 3}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := inTx(t, p, func(tx pgx.Tx) error {
+				if err := testSchema.CheckInsert(ctx, tx, tt.table, tt.values); err != nil {
+					return err
+				}
+				// 確認だけのテストなので、毎回ロールバックする
+				return errRollback
+			})
+			if errors.Is(err, errRollback) {
+				err = nil
+			}
+			if !tt.wantErr {
+				if err != nil {
+					t.Errorf("CheckInsert: %v", err)
+				}
+				return
+			}
+			var v *ViolationError
+			if !errors.As(err, &v) || !errors.Is(err, ErrViolation) || v.Op != "insert" {
+				t.Fatalf("CheckInsert error = %v, want an insert violation", err)
+			}
+			if v.Relation.Child != tt.table {
+				t.Errorf("violated relation %+v, want one from %s", v.Relation, tt.table)
+			}
+		})
+	}
+}
+
+func TestInsertRejectsMissingParent(t *testing.T) {
+	p := setupDB(t)
+	err := inTx(t, p, func(tx pgx.Tx) error {
+		return testSchema.Insert(context.Background(), tx, "order_items", map[string]any{"id": 900, "order_id": 99})
+	})
+	if !errors.Is(err, ErrViolation) {
+		t.Fatalf("Insert error = %v, want ErrViolation", err)
+	}
+	if ids := rowIDs(t, p, "order_items"); slices.Contains(ids, 900) {
+		t.Errorf("order_items = %v, want no row 900", ids)
+	}
+}
+
+func TestDeleteCascadesMultipleLevels(t *testing.T) {
+	p := setupDB(t)
+	var deleted int64
+	err := inTx(t, p, func(tx pgx.Tx) error {
+		n, err := testSchema.Delete(context.Background(), tx, "customers", 1)
+		deleted = n
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if deleted != 1 {
+		t.Errorf("deleted %d customers, want 1", deleted)
+	}
+
+	want := map[string][]int64{
+		"customers":   {2},
+		"orders":      {20},
+		"order_items": {200},
+		"reviews":     {1000},
+	}
+	for table, ids := range want {
+		if got := rowIDs(t, p, table); !slices.Equal(got, ids) {
+			t.Errorf("%s = %v, want %v", table, got, ids)
+		}
+	}
+
+	// SET NULLの関連は行を残して列をNULLにする
+	var customerID *int64
+	if err := p.QueryRow(context.Background(), "SELECT customer_id FROM reviews WHERE id = 1000").Scan(&customerID); err != nil {
+		t.Fatal(err)
+	}
+	if customerID != nil {
+		t.Errorf("reviews.customer_id = %d, want NULL", *customerID)
+	}
+}
+
+func TestDeleteRestrictedBelowCascade(t *testing.T) {
+	p := setupDB(t)
+	// order 11の支払いがあるので、customer 1の削除はorder 11のCASCADEの先でRESTRICTになる
+	testdb.Exec(t, p, "INSERT INTO payments (id, order_id) VALUES (5000, 11)")
+
+	err := inTx(t, p, func(tx pgx.Tx) error {
+		_, err := testSchema.Delete(context.Background(), tx, "customers", 1)
+		return err
+	})
+	var v *ViolationError
+	if !errors.As(err, &v) || v.Op != "delete" {
+		t.Fatalf("Delete error = %v, want a delete violation", err)
+	}
+	if v.Relation.Child != "payments" || fmt.Sprint(v.Value) != "11" {
+		t.Errorf("violation = %+v, want payments referencing order 11", v)
+	}
+
+	// ロールバックすれば途中まで適用したON DELETEも残らない
+	want := map[string][]int64{
+		"customers":   {1, 2},
+		"orders":      {10, 11, 20},
+		"order_items": {100, 101, 110, 200},
+		"payments":    {5000},
+	}
+	for table, ids := range want {
+		if got := rowIDs(t, p, table); !slices.Equal(got, ids) {
+			t.Errorf("%s = %v, want %v", table, got, ids)
+		}
+	}
+
+	// 直接の子がRESTRICTなら何も変更する前にエラーにする
+	err = inTx(t, p, func(tx pgx.Tx) error {
+		_, err := testSchema.Delete(context.Background(), tx, "orders", 11)
+		return err
+	})
+	if !errors.Is(err, ErrViolation) || !strings.Contains(err.Error(), "payments.order_id") {
+		t.Errorf("Delete(orders, 11) error = %v, want a violation from payments.order_id", err)
+	}
+}
+
+func TestNewInvalidSchema(t *testing.T) {
+	_, err := New(
+		[]Table{{Name: "parents"}, {Name: "children"}},
+		[]Relation{
+			{Child: "children", Column: "parent_id", Parent: "parents"},
+			{Child: "children", Column: "other_id", Parent: "others"},
+			{Child: "children", Parent: "parents"},
+			{Child: "children", Column: "x", Parent: "children", OnDelete: "EXPLODE"},
+		},
+	)
+	if err == nil {
+		t.Fatal("New accepted an invalid schema")
+	}
+	for _, want := range []string{"has no primary key", "unknown table others", "has no column"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %q", err, want)
+		}
+	}
+}
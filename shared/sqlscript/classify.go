@@ -0,0 +1,176 @@
+package sqlscript
+
+import "strings"
+
+// Kind は文の種類
+type Kind int
+
+const (
+	KindOther       Kind = iota // SET、ANALYZEなど
+	KindQuery                   // SELECT、VALUES、TABLE、SHOW、EXPLAINなど
+	KindDML                     // INSERT、UPDATE、DELETE、MERGE
+	KindDDL                     // CREATE、ALTER、DROP、TRUNCATE、GRANTなど
+	KindTransaction             // BEGIN、COMMIT、ROLLBACKなど
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindQuery:
+		return "query"
+	case KindDML:
+		return "dml"
+	case KindDDL:
+		return "ddl"
+	case KindTransaction:
+		return "transaction"
+	default:
+		return "other"
+	}
+}
+
+// Statement は分類した文
+type Statement struct {
+	Text string
+	Kind Kind
+	// Command は文の種類を表すキーワード（大文字）。WITHで始まる文は本体のキーワードになる
+	Command string
+	// ReturnsRows は結果の行を返すかどうか（SELECT、RETURNING付きのINSERTなど）
+	ReturnsRows bool
+}
+
+// commandKinds はキーワードごとの文の種類
+var commandKinds = map[string]Kind{
+	"SELECT": KindQuery, "VALUES": KindQuery, "TABLE": KindQuery, "SHOW": KindQuery,
+	"EXPLAIN": KindQuery, "FETCH": KindQuery,
+
+	"INSERT": KindDML, "UPDATE": KindDML, "DELETE": KindDML, "MERGE": KindDML,
+
+	"CREATE": KindDDL, "ALTER": KindDDL, "DROP": KindDDL, "TRUNCATE": KindDDL,
+	"COMMENT": KindDDL, "GRANT": KindDDL, "REVOKE": KindDDL, "REINDEX": KindDDL,
+
+	"BEGIN": KindTransaction, "START": KindTransaction, "COMMIT": KindTransaction,
+	"END": KindTransaction, "ROLLBACK": KindTransaction, "ABORT": KindTransaction,
+	"SAVEPOINT": KindTransaction, "RELEASE": KindTransaction,
+}
+
+// Statements はscriptを文に分割して分類する
+func Statements(script string) []Statement {
+	var stmts []Statement
+	for _, s := range Split(script) {
+		stmts = append(stmts, Classify(s))
+	}
+	return stmts
+}
+
+// Classify は1つの文を分類する
+//
+// 先頭のコメントや括弧は読み飛ばす。WITHで始まる文は共通テーブル式の後の本体で判定し、
+// INSERT・UPDATE・DELETE・MERGEはRETURNINGがあれば行を返すものとする。
+// SELECT ... INTO はテーブルを作成するためDDLとして扱う。
+func Classify(stmt string) Statement {
+	st := Statement{Text: stmt}
+
+	var words []Token // 空白とコメントを除いた字句
+	for _, t := range Lex(stmt) {
+		if t.Kind != Space && t.Kind != Comment {
+			words = append(words, t)
+		}
+	}
+
+	i := 0
+	for i < len(words) && words[i].Text == "(" {
+		i++
+	}
+	if i == len(words) || words[i].Kind != Word {
+		return st
+	}
+	st.Command = strings.ToUpper(words[i].Text)
+	if st.Command == "WITH" {
+		i = skipCTEs(words, i+1)
+		if i == len(words) || words[i].Kind != Word {
+			return st
+		}
+		st.Command = strings.ToUpper(words[i].Text)
+	}
+	st.Kind = commandKinds[st.Command]
+
+	switch st.Kind {
+	case KindQuery:
+		st.ReturnsRows = true
+		if st.Command == "SELECT" && hasTopLevel(words[i+1:], "INTO") {
+			st.Kind, st.ReturnsRows = KindDDL, false
+		}
+	case KindDML:
+		st.ReturnsRows = hasTopLevel(words[i+1:], "RETURNING")
+	}
+	return st
+}
+
+// skipCTEs はWITHの直後から共通テーブル式を読み飛ばし、本体の最初の字句の位置を返す
+//
+//	[RECURSIVE] name [(columns)] AS [[NOT] MATERIALIZED] (query) [, ...]
+func skipCTEs(words []Token, i int) int {
+	if i < len(words) && words[i].Is("RECURSIVE") {
+		i++
+	}
+	for i < len(words) {
+		i++ // 名前
+		if i < len(words) && words[i].Text == "(" {
+			i = skipParens(words, i)
+		}
+		if i < len(words) && words[i].Is("AS") {
+			i++
+		}
+		if i < len(words) && words[i].Is("NOT") {
+			i++
+		}
+		if i < len(words) && words[i].Is("MATERIALIZED") {
+			i++
+		}
+		if i < len(words) && words[i].Text == "(" {
+			i = skipParens(words, i)
+		}
+		if i < len(words) && words[i].Text == "," {
+			i++
+			continue
+		}
+		break
+	}
+	for i < len(words) && words[i].Text == "(" {
+		i++
+	}
+	return i
+}
+
+// skipParens はwords[i]の開き括弧に対応する閉じ括弧の次の位置を返す
+func skipParens(words []Token, i int) int {
+	depth := 0
+	for ; i < len(words); i++ {
+		switch words[i].Text {
+		case "(":
+			depth++
+		case ")":
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return i
+}
+
+// hasTopLevel は括弧の外にwordがあるかどうかを返す
+func hasTopLevel(words []Token, word string) bool {
+	depth := 0
+	for _, t := range words {
+		switch {
+		case t.Text == "(":
+			depth++
+		case t.Text == ")":
+			depth--
+		case depth == 0 && t.Is(word):
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,111 @@
+package sqlscript
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		stmt        string
+		kind        Kind
+		command     string
+		returnsRows bool
+	}{
+		{"SELECT 1", KindQuery, "SELECT", true},
+		{"select * from t", KindQuery, "SELECT", true},
+		{"(SELECT 1) UNION (SELECT 2)", KindQuery, "SELECT", true},
+		{"VALUES (1), (2)", KindQuery, "VALUES", true},
+		{"TABLE button_clicks", KindQuery, "TABLE", true},
+		{"SHOW search_path", KindQuery, "SHOW", true},
+		{"EXPLAIN ANALYZE DELETE FROM t", KindQuery, "EXPLAIN", true},
+		{"SELECT * INTO backup FROM t", KindDDL, "SELECT", false},
+		{"SELECT (SELECT 1 INTO x) ", KindQuery, "SELECT", true},
+
+		{"WITH t AS (SELECT 1) SELECT * FROM t", KindQuery, "SELECT", true},
+		{"WITH RECURSIVE t(n) AS (VALUES (1) UNION ALL SELECT n + 1 FROM t) SELECT n FROM t", KindQuery, "SELECT", true},
+		{"WITH a AS MATERIALIZED (SELECT 1), b AS NOT MATERIALIZED (SELECT 2) TABLE a", KindQuery, "TABLE", true},
+		{"WITH d AS (DELETE FROM t RETURNING id) INSERT INTO log SELECT id FROM d", KindDML, "INSERT", false},
+		{"WITH d AS (SELECT 1) UPDATE t SET a = 1 RETURNING a", KindDML, "UPDATE", true},
+
+		{"INSERT INTO t VALUES (1)", KindDML, "INSERT", false},
+		{"INSERT INTO t VALUES (1) RETURNING id", KindDML, "INSERT", true},
+		{"insert into t select x from (select 1 returning) s", KindDML, "INSERT", false},
+		{"INSERT INTO t (returning) VALUES (1)", KindDML, "INSERT", false},
+		{`INSERT INTO t ("RETURNING") VALUES (1)`, KindDML, "INSERT", false},
+		{"UPDATE t SET a = 1", KindDML, "UPDATE", false},
+		{"DELETE FROM t RETURNING *", KindDML, "DELETE", true},
+		{"MERGE INTO t USING s ON t.id = s.id WHEN MATCHED THEN DELETE", KindDML, "MERGE", false},
+
+		{"CREATE TABLE t (id INT)", KindDDL, "CREATE", false},
+		{"CREATE INDEX ASYNC i ON t (a)", KindDDL, "CREATE", false},
+		{"ALTER TABLE t ADD COLUMN b INT", KindDDL, "ALTER", false},
+		{"DROP TABLE t", KindDDL, "DROP", false},
+		{"TRUNCATE t", KindDDL, "TRUNCATE", false},
+		{"GRANT SELECT ON t TO r", KindDDL, "GRANT", false},
+
+		{"BEGIN", KindTransaction, "BEGIN", false},
+		{"start transaction isolation level repeatable read", KindTransaction, "START", false},
+		{"COMMIT", KindTransaction, "COMMIT", false},
+		{"END", KindTransaction, "END", false},
+		{"ROLLBACK TO SAVEPOINT s", KindTransaction, "ROLLBACK", false},
+
+		{"SET search_path = public", KindOther, "SET", false},
+		{"DO $$ BEGIN PERFORM 1; END $$", KindOther, "DO", false},
+		{"ANALYZE t", KindOther, "ANALYZE", false},
+
+		{"-- leading comment\nSELECT 1", KindQuery, "SELECT", true},
+		{"/* block */ INSERT INTO t VALUES (1) RETURNING id", KindDML, "INSERT", true},
+		{"/* nested /* SELECT */ */ -- DELETE\n  COMMIT", KindTransaction, "COMMIT", false},
+		{"SELECT 1 -- RETURNING", KindQuery, "SELECT", true},
+		{"UPDATE t SET note = 'RETURNING' /* RETURNING */", KindDML, "UPDATE", false},
+
+		{"", KindOther, "", false},
+		{"-- only a comment", KindOther, "", false},
+		{"'SELECT'", KindOther, "", false},
+		{`"SELECT" 1`, KindOther, "", false},
+		{"WITH t AS (SELECT 1)", KindOther, "WITH", false},
+	}
+	for _, tt := range tests {
+		got := Classify(tt.stmt)
+		if got.Kind != tt.kind || got.Command != tt.command || got.ReturnsRows != tt.returnsRows {
+			t.Errorf("Classify(%q) = %s %q rows=%v; want %s %q rows=%v",
+				tt.stmt, got.Kind, got.Command, got.ReturnsRows, tt.kind, tt.command, tt.returnsRows)
+		}
+		if got.Text != tt.stmt {
+			t.Errorf("Classify(%q).Text = %q", tt.stmt, got.Text)
+		}
+	}
+}
+
+func TestStatements(t *testing.T) {
+	script := `-- setup
+BEGIN;
+CREATE TABLE "a;b" (note TEXT);
+INSERT INTO "a;b" VALUES (E'x\';y') RETURNING note;
+DO $body$ BEGIN RAISE NOTICE ';'; END $body$;
+COMMIT;
+WITH n AS (SELECT 1) SELECT * FROM n`
+
+	var kinds []Kind
+	var commands []string
+	for _, s := range Statements(script) {
+		kinds = append(kinds, s.Kind)
+		commands = append(commands, s.Command)
+	}
+	wantKinds := []Kind{KindTransaction, KindDDL, KindDML, KindOther, KindTransaction, KindQuery}
+	wantCommands := []string{"BEGIN", "CREATE", "INSERT", "DO", "COMMIT", "SELECT"}
+	if !slices.Equal(kinds, wantKinds) || !slices.Equal(commands, wantCommands) {
+		t.Errorf("Statements = %v %q, want %v %q", kinds, commands, wantKinds, wantCommands)
+	}
+}
+
+func TestKindString(t *testing.T) {
+	for k, want := range map[Kind]string{
+		KindOther: "other", KindQuery: "query", KindDML: "dml", KindDDL: "ddl", KindTransaction: "transaction",
+	} {
+		if got := k.String(); got != want {
+			t.Errorf("Kind(%d).String() = %q, want %q", k, got, want)
+		}
+	}
+}
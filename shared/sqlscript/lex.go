@@ -0,0 +1,210 @@
+package sqlscript
+
+import "strings"
+
+// TokenKind は字句の種類
+type TokenKind int
+
+const (
+	Space        TokenKind = iota // 空白と改行
+	Comment                       // -- から行末まで、または /* */（入れ子可）
+	Word                          // キーワードと引用符なしの識別子
+	QuotedIdent                   // "identifier"
+	String                        // 'text'、E'text'、B'0101'、X'ff'、U&'text'
+	DollarString                  // $$text$$、$tag$text$tag$
+	Number                        // 42、3.14、1e10、0xff
+	Param                         // $1
+	Punct                         // 演算子、括弧、カンマ、セミコロンなど（1文字ずつ）
+)
+
+// Token は字句
+type Token struct {
+	Kind TokenKind
+	Text string // 引用符やコメント記号を含む元の文字列
+	Pos  int    // script内のバイト位置
+	// Unterminated は文字列、引用符付き識別子、コメントが閉じないまま入力が終わったかどうか
+	Unterminated bool
+}
+
+// Is はtが大文字小文字を区別せずにwordと一致する引用符なしの単語かどうかを返す
+func (t Token) Is(word string) bool {
+	return t.Kind == Word && strings.EqualFold(t.Text, word)
+}
+
+// Lex はscriptを字句に分ける
+//
+// すべての字句のTextをつなげると元のscriptになる。
+func Lex(script string) []Token {
+	var tokens []Token
+	for i := 0; i < len(script); {
+		t := lexOne(script, i)
+		tokens = append(tokens, t)
+		i += len(t.Text)
+	}
+	return tokens
+}
+
+// lexOne はscript[i:]の先頭の字句を返す
+func lexOne(script string, i int) Token {
+	s := script[i:]
+	tok := func(kind TokenKind, n int, unterminated bool) Token {
+		return Token{Kind: kind, Text: s[:n], Pos: i, Unterminated: unterminated}
+	}
+
+	c := s[0]
+	switch {
+	case isSpace(c):
+		n := 1
+		for n < len(s) && isSpace(s[n]) {
+			n++
+		}
+		return tok(Space, n, false)
+	case strings.HasPrefix(s, "--"):
+		n := strings.IndexByte(s, '\n')
+		if n < 0 {
+			n = len(s)
+		}
+		return tok(Comment, n, false)
+	case strings.HasPrefix(s, "/*"):
+		n, ok := blockComment(s)
+		return tok(Comment, n, !ok)
+	case c == '\'':
+		n, ok := quoted(s, 0, '\'', false)
+		return tok(String, n, !ok)
+	case c == '"':
+		n, ok := quoted(s, 0, '"', false)
+		return tok(QuotedIdent, n, !ok)
+	case (c == 'E' || c == 'e') && len(s) > 1 && s[1] == '\'' && !precededByWord(script, i):
+		// バックスラッシュでエスケープする文字列（E'it\'s'）
+		n, ok := quoted(s, 1, '\'', true)
+		return tok(String, n, !ok)
+	case (c == 'B' || c == 'b' || c == 'X' || c == 'x') && len(s) > 1 && s[1] == '\'' && !precededByWord(script, i):
+		n, ok := quoted(s, 1, '\'', false)
+		return tok(String, n, !ok)
+	case (c == 'U' || c == 'u') && strings.HasPrefix(s[1:], "&'") && !precededByWord(script, i):
+		n, ok := quoted(s, 2, '\'', false)
+		return tok(String, n, !ok)
+	case (c == 'U' || c == 'u') && strings.HasPrefix(s[1:], `&"`) && !precededByWord(script, i):
+		n, ok := quoted(s, 2, '"', false)
+		return tok(QuotedIdent, n, !ok)
+	case c == '$':
+		if n := digits(s[1:]); n > 0 {
+			return tok(Param, 1+n, false)
+		}
+		if tag := dollarTag(s); tag != "" && !precededByWord(script, i) {
+			end := strings.Index(s[len(tag):], tag)
+			if end < 0 {
+				return tok(DollarString, len(s), true)
+			}
+			return tok(DollarString, len(tag)+end+len(tag), false)
+		}
+		return tok(Punct, 1, false)
+	case isDigit(c) || c == '.' && len(s) > 1 && isDigit(s[1]):
+		return tok(Number, number(s), false)
+	case isWordStart(c):
+		n := 1
+		for n < len(s) && isWordPart(s[n]) {
+			n++
+		}
+		return tok(Word, n, false)
+	default:
+		return tok(Punct, 1, false)
+	}
+}
+
+// quoted はs[start]から始まる引用符で囲まれた部分の長さを返す
+//
+// 引用符を2つ重ねたものはエスケープとして扱う。backslashがtrueなら \' もエスケープになる。
+func quoted(s string, start int, q byte, backslash bool) (int, bool) {
+	for j := start + 1; j < len(s); j++ {
+		switch {
+		case backslash && s[j] == '\\':
+			j++
+		case s[j] == q:
+			if j+1 < len(s) && s[j+1] == q {
+				j++
+				continue
+			}
+			return j + 1, true
+		}
+	}
+	return len(s), false
+}
+
+// blockComment は入れ子を考慮した /* */ コメントの長さを返す
+func blockComment(s string) (int, bool) {
+	depth := 0
+	for j := 0; j+1 < len(s); j++ {
+		switch {
+		case s[j] == '/' && s[j+1] == '*':
+			depth++
+			j++
+		case s[j] == '*' && s[j+1] == '/':
+			depth--
+			j++
+			if depth == 0 {
+				return j + 1, true
+			}
+		}
+	}
+	return len(s), false
+}
+
+// number は数値リテラルの長さを返す
+func number(s string) int {
+	n := 0
+	for n < len(s) {
+		c := s[n]
+		switch {
+		case isWordPart(c) || c == '.':
+			n++
+		case (c == '+' || c == '-') && (s[n-1] == 'e' || s[n-1] == 'E') && !strings.HasPrefix(strings.ToLower(s), "0x"):
+			n++
+		default:
+			return n
+		}
+	}
+	return n
+}
+
+// dollarTag はsの先頭がドル引用符（$$ や $tag$）ならそのタグを返す
+func dollarTag(s string) string {
+	for j := 1; j < len(s); j++ {
+		c := s[j]
+		if c == '$' {
+			return s[:j+1]
+		}
+		if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80 || j > 1 && isDigit(c)) {
+			return ""
+		}
+	}
+	return ""
+}
+
+// precededByWord はscript[i]の直前が識別子の一部かどうかを返す（foo$bar$ や abcE'x' の区別）
+func precededByWord(script string, i int) bool {
+	return i > 0 && isWordPart(script[i-1])
+}
+
+func digits(s string) int {
+	n := 0
+	for n < len(s) && isDigit(s[n]) {
+		n++
+	}
+	return n
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v'
+}
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+
+// isWordStart はPostgreSQLの識別子の先頭に使える文字（UTF-8のマルチバイト文字を含む）かどうかを返す
+func isWordStart(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80
+}
+
+func isWordPart(c byte) bool {
+	return isWordStart(c) || isDigit(c) || c == '$'
+}
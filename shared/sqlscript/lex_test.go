@@ -0,0 +1,148 @@
+package sqlscript
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestLex(t *testing.T) {
+	type tok struct {
+		kind TokenKind
+		text string
+	}
+	tests := []struct {
+		name   string
+		script string
+		want   []tok
+	}{
+		{"words and punct", "SELECT a,b;", []tok{
+			{Word, "SELECT"}, {Space, " "}, {Word, "a"}, {Punct, ","}, {Word, "b"}, {Punct, ";"},
+		}},
+		{"line comment", "-- note; here\nSELECT", []tok{
+			{Comment, "-- note; here"}, {Space, "\n"}, {Word, "SELECT"},
+		}},
+		{"nested block comment", "/* a /* b; */ c */x", []tok{
+			{Comment, "/* a /* b; */ c */"}, {Word, "x"},
+		}},
+		{"string with doubled quote", "'it''s;'", []tok{{String, "'it''s;'"}}},
+		{"escape string", `E'it\'s; \\'`, []tok{{String, `E'it\'s; \\'`}}},
+		{"lower-case escape string", `e'\''`, []tok{{String, `e'\''`}}},
+		{"word ending in e", `type'x'`, []tok{{Word, "type"}, {String, "'x'"}}},
+		{"bit and hex strings", "B'01' X'ff'", []tok{{String, "B'01'"}, {Space, " "}, {String, "X'ff'"}}},
+		{"unicode string", `U&'d\0061t'`, []tok{{String, `U&'d\0061t'`}}},
+		{"quoted identifier", `"a;""b"`, []tok{{QuotedIdent, `"a;""b"`}}},
+		{"unicode identifier", `U&"d;"`, []tok{{QuotedIdent, `U&"d;"`}}},
+		{"dollar quotes", "$$a;'b$$", []tok{{DollarString, "$$a;'b$$"}}},
+		{"tagged dollar quotes", "$fn$ $$; $fn$", []tok{{DollarString, "$fn$ $$; $fn$"}}},
+		{"dollar in identifier", "foo$bar$", []tok{{Word, "foo$bar$"}}},
+		{"param", "$1+$23", []tok{{Param, "$1"}, {Punct, "+"}, {Param, "$23"}}},
+		{"numbers", "42 3.14 .5 1e-10 0xff", []tok{
+			{Number, "42"}, {Space, " "}, {Number, "3.14"}, {Space, " "}, {Number, ".5"},
+			{Space, " "}, {Number, "1e-10"}, {Space, " "}, {Number, "0xff"},
+		}},
+		{"hex is not an exponent", "0xe-1", []tok{{Number, "0xe"}, {Punct, "-"}, {Number, "1"}}},
+		{"multibyte identifier", "SELECT 名前", []tok{{Word, "SELECT"}, {Space, " "}, {Word, "名前"}}},
+		{"cast", "a::int", []tok{{Word, "a"}, {Punct, ":"}, {Punct, ":"}, {Word, "int"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []tok
+			for _, tk := range Lex(tt.script) {
+				if tk.Unterminated {
+					t.Errorf("token %q is unterminated", tk.Text)
+				}
+				got = append(got, tok{tk.Kind, tk.Text})
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Lex(%q)\n got %v\nwant %v", tt.script, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLexUnterminated(t *testing.T) {
+	tests := []struct {
+		script string
+		kind   TokenKind
+	}{
+		{"'abc", String},
+		{`E'abc\'`, String},
+		{`"abc`, QuotedIdent},
+		{"/* a /* b */", Comment},
+		{"$$abc", DollarString},
+		{"$tag$abc$$", DollarString},
+	}
+	for _, tt := range tests {
+		toks := Lex(tt.script)
+		last := toks[len(toks)-1]
+		if last.Kind != tt.kind || !last.Unterminated || last.Text != tt.script {
+			t.Errorf("Lex(%q) last token = %+v, want an unterminated %d spanning the input", tt.script, last, tt.kind)
+		}
+	}
+}
+
+// 字句をつなげると元のスクリプトになり、Posは各字句の位置を指す
+func TestLexRoundTrip(t *testing.T) {
+	script := "WITH t AS (SELECT 'a;' AS \"x;\", $$;$$, E'\\';' /* ; */)\n-- ;\nSELECT * FROM t WHERE id = $1;"
+	var b strings.Builder
+	for _, tk := range Lex(script) {
+		if script[tk.Pos:tk.Pos+len(tk.Text)] != tk.Text {
+			t.Errorf("token %q has Pos %d", tk.Text, tk.Pos)
+		}
+		b.WriteString(tk.Text)
+	}
+	if b.String() != script {
+		t.Errorf("joined tokens = %q, want %q", b.String(), script)
+	}
+}
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		want   []string
+	}{
+		{"two statements", "SELECT 1; SELECT 2;", []string{"SELECT 1", "SELECT 2"}},
+		{"no trailing semicolon", "SELECT 1;\nSELECT 2", []string{"SELECT 1", "SELECT 2"}},
+		{"empty statements", ";; SELECT 1;;", []string{"SELECT 1"}},
+		{"semicolon in string", "SELECT 'a;b'; SELECT 2", []string{"SELECT 'a;b'", "SELECT 2"}},
+		{"semicolon in escape string", `SELECT E'\';'; SELECT 2`, []string{`SELECT E'\';'`, "SELECT 2"}},
+		{"semicolon in quoted identifier", `SELECT 1 AS "a;b"; SELECT 2`, []string{`SELECT 1 AS "a;b"`, "SELECT 2"}},
+		{"semicolon in dollar quotes", "DO $$ BEGIN PERFORM 1; END $$; SELECT 2", []string{"DO $$ BEGIN PERFORM 1; END $$", "SELECT 2"}},
+		{"semicolon in tagged dollar quotes", "SELECT $x$;$$;$x$; SELECT 2", []string{"SELECT $x$;$$;$x$", "SELECT 2"}},
+		{"comments removed", "-- first;\nSELECT 1 /* ; */ + 2; -- trailing", []string{"SELECT 1   + 2"}},
+		{"only comments", "-- nothing\n/* here */", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Split(tt.script); !slices.Equal(got, tt.want) {
+				t.Errorf("Split(%q) = %q, want %q", tt.script, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestComplete(t *testing.T) {
+	tests := []struct {
+		script string
+		want   bool
+	}{
+		{"SELECT 1;", true},
+		{"SELECT 1;\n", true},
+		{"SELECT 1; -- done", true},
+		{"SELECT 1", false},
+		{"SELECT 1; SELECT", false},
+		{"SELECT 'a;", false},
+		{"SELECT \"a;", false},
+		{"SELECT $$a;", false},
+		{"SELECT 1 /* ;", false},
+		{"-- only a comment;", false},
+		{";", false},
+	}
+	for _, tt := range tests {
+		if got := Complete(tt.script); got != tt.want {
+			t.Errorf("Complete(%q) = %v, want %v", tt.script, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+// Package sqlscript は複数の文を含むSQLスクリプトを扱う
+package sqlscript
+
+import "strings"
+
+// Split はscriptをセミコロンで文に分割する
+//
+// 文字列リテラル、引用符付きの識別子、ドル引用符の中のセミコロンは区切りとみなさない。
+// コメントは取り除き、空の文は返さない。
+func Split(script string) []string {
+	stmts, rest, _ := split(script)
+	if rest != "" {
+		stmts = append(stmts, rest)
+	}
+	return stmts
+}
+
+// Complete はscriptが1つ以上の文を含み、最後の文がセミコロンで終わっているかどうかを返す
+//
+// 対話的な入力で、文の終わりまで行を読み続けるかどうかの判定に使う。閉じていない
+// 引用符やコメントの中のセミコロンは文の終わりとみなさない。
+func Complete(script string) bool {
+	stmts, rest, open := split(script)
+	return len(stmts) > 0 && rest == "" && !open
+}
+
+// split はscriptをセミコロンで終わる文と、末尾のセミコロンで終わっていない部分に分ける
+//
+// openは末尾が閉じていない引用符やコメントの中で終わっているかどうか。
+func split(script string) (stmts []string, rest string, open bool) {
+	var cur strings.Builder
+	for _, t := range Lex(script) {
+		open = t.Unterminated
+		switch {
+		case t.Kind == Comment:
+			cur.WriteByte(' ')
+		case t.Kind == Punct && t.Text == ";":
+			if s := strings.TrimSpace(cur.String()); s != "" {
+				stmts = append(stmts, s)
+			}
+			cur.Reset()
+		default:
+			cur.WriteString(t.Text)
+		}
+	}
+	return stmts, strings.TrimSpace(cur.String()), open
+}
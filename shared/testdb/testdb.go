@@ -0,0 +1,94 @@
+// Package testdb はローカルのPostgreSQLを使うテストの接続とテーブルの準備を行う
+//
+// 接続先はTEST_DATABASE_URLで指定し、未設定ならテストをスキップする。go testは
+// パッケージごとのテストを並行して実行するため、テーブルはパッケージごとのスキーマに作る。
+package testdb
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"dsql-shared/migrations"
+)
+
+// EnvURL はテスト用のPostgreSQLの接続先を指定する環境変数名
+const EnvURL = "TEST_DATABASE_URL"
+
+// Execer はSQLを実行できるもの（*pgxpool.Pool、pgx.Txなど）
+type Execer interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+}
+
+// URL はTEST_DATABASE_URLの値を返す。未設定ならtをスキップする
+func URL(t testing.TB) string {
+	t.Helper()
+	url := os.Getenv(EnvURL)
+	if url == "" {
+		t.Skipf("%s is not set", EnvURL)
+	}
+	return url
+}
+
+// Pool はTEST_DATABASE_URLに接続し、search_pathをschemaにしたプールを返す
+//
+// schemaがなければ作成する。プールはテストの終了時に閉じる。
+func Pool(t testing.TB, schema string) *pgxpool.Pool {
+	t.Helper()
+	cfg, err := pgxpool.ParseConfig(URL(t))
+	if err != nil {
+		t.Fatalf("invalid %s: %v", EnvURL, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	ident := pgx.Identifier{schema}.Sanitize()
+	conn, err := pgx.ConnectConfig(ctx, cfg.ConnConfig.Copy())
+	if err != nil {
+		t.Fatalf("failed to connect to %s: %v", EnvURL, err)
+	}
+	_, err = conn.Exec(ctx, "CREATE SCHEMA IF NOT EXISTS "+ident)
+	conn.Close(ctx)
+	if err != nil {
+		t.Fatalf("failed to create schema %s: %v", schema, err)
+	}
+
+	cfg.ConnConfig.RuntimeParams["search_path"] = schema
+	pool, err := pgxpool.NewWithConfig(ctx, cfg)
+	if err != nil {
+		t.Fatalf("failed to create pool: %v", err)
+	}
+	t.Cleanup(pool.Close)
+	return pool
+}
+
+// ResetButtonClicks はbutton_clicksがなければ作成し、すべての行を削除する
+//
+// テーブルはmigrationsの最初のバージョンで作る（以降のCREATE INDEX ASYNCはDSQL専用のため）。
+func ResetButtonClicks(t testing.TB, db Execer) {
+	t.Helper()
+	all, err := migrations.All()
+	if err != nil {
+		t.Fatalf("failed to load migrations: %v", err)
+	}
+	ctx := context.Background()
+	if _, err := db.Exec(ctx, all[0].Up); err != nil {
+		t.Fatalf("failed to create button_clicks: %v", err)
+	}
+	if _, err := db.Exec(ctx, "TRUNCATE button_clicks"); err != nil {
+		t.Fatalf("failed to truncate button_clicks: %v", err)
+	}
+}
+
+// Exec はsqlを実行する。失敗したらテストを中止する
+func Exec(t testing.TB, db Execer, sql string, args ...any) {
+	t.Helper()
+	if _, err := db.Exec(context.Background(), sql, args...); err != nil {
+		t.Fatalf("%s: %v", sql, err)
+	}
+}
@@ -0,0 +1,166 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"unicode"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// PgxTracer はpgxのトレーサーのフックでクエリ・接続・プールからの取得をスパンにする
+//
+// pgx.ConnConfig.Tracerに設定する。クエリの引数は記録しない（SQL文だけを記録する）。
+type PgxTracer struct{}
+
+var (
+	_ pgx.QueryTracer       = PgxTracer{}
+	_ pgx.BatchTracer       = PgxTracer{}
+	_ pgx.PrepareTracer     = PgxTracer{}
+	_ pgx.CopyFromTracer    = PgxTracer{}
+	_ pgx.ConnectTracer     = PgxTracer{}
+	_ pgxpool.AcquireTracer = PgxTracer{}
+)
+
+func (PgxTracer) TraceQueryStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	ctx, _ = Tracer().Start(ctx, operation(data.SQL), trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(append(connAttrs(conn), attribute.String("db.query.text", data.SQL))...))
+	return ctx
+}
+
+func (PgxTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	endQuery(trace.SpanFromContext(ctx), data.CommandTag, data.Err)
+}
+
+func (PgxTracer) TraceBatchStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceBatchStartData) context.Context {
+	ctx, _ = Tracer().Start(ctx, "BATCH", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(append(connAttrs(conn), attribute.Int("db.operation.batch.size", data.Batch.Len()))...))
+	return ctx
+}
+
+func (PgxTracer) TraceBatchQuery(ctx context.Context, _ *pgx.Conn, data pgx.TraceBatchQueryData) {
+	span := trace.SpanFromContext(ctx)
+	attrs := []attribute.KeyValue{attribute.String("db.query.text", data.SQL)}
+	if data.Err != nil {
+		attrs = append(attrs, attribute.String("error.type", errorType(data.Err)))
+	}
+	span.AddEvent("query", trace.WithAttributes(attrs...))
+}
+
+func (PgxTracer) TraceBatchEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceBatchEndData) {
+	End(trace.SpanFromContext(ctx), data.Err)
+}
+
+func (PgxTracer) TracePrepareStart(ctx context.Context, conn *pgx.Conn, data pgx.TracePrepareStartData) context.Context {
+	ctx, _ = Tracer().Start(ctx, "PREPARE "+operation(data.SQL), trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(append(connAttrs(conn), attribute.String("db.query.text", data.SQL))...))
+	return ctx
+}
+
+func (PgxTracer) TracePrepareEnd(ctx context.Context, _ *pgx.Conn, data pgx.TracePrepareEndData) {
+	End(trace.SpanFromContext(ctx), data.Err)
+}
+
+func (PgxTracer) TraceCopyFromStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceCopyFromStartData) context.Context {
+	ctx, _ = Tracer().Start(ctx, "COPY", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(append(connAttrs(conn), attribute.String("db.collection.name", data.TableName.Sanitize()))...))
+	return ctx
+}
+
+func (PgxTracer) TraceCopyFromEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceCopyFromEndData) {
+	endQuery(trace.SpanFromContext(ctx), data.CommandTag, data.Err)
+}
+
+// TraceConnectStart は新しい接続（TCP・TLS・認証）のスパンを開始する。
+// BeforeConnectでのトークン生成はこの前に行われる
+func (PgxTracer) TraceConnectStart(ctx context.Context, data pgx.TraceConnectStartData) context.Context {
+	ctx, _ = Tracer().Start(ctx, "pgx.connect", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system.name", "postgresql"),
+			attribute.String("server.address", data.ConnConfig.Host),
+			attribute.String("db.namespace", data.ConnConfig.Database),
+		))
+	return ctx
+}
+
+func (PgxTracer) TraceConnectEnd(ctx context.Context, data pgx.TraceConnectEndData) {
+	End(trace.SpanFromContext(ctx), data.Err)
+}
+
+// TraceAcquireStart はプールからの接続の取得（空きがなければ接続の作成や待ち）のスパンを開始する
+func (PgxTracer) TraceAcquireStart(ctx context.Context, pool *pgxpool.Pool, _ pgxpool.TraceAcquireStartData) context.Context {
+	stat := pool.Stat()
+	ctx, _ = Tracer().Start(ctx, "pgxpool.acquire", trace.WithAttributes(
+		attribute.Int("db.client.connection.pool.idle", int(stat.IdleConns())),
+		attribute.Int("db.client.connection.pool.total", int(stat.TotalConns())),
+		attribute.Int("db.client.connection.pool.max", int(stat.MaxConns())),
+	))
+	return ctx
+}
+
+func (PgxTracer) TraceAcquireEnd(ctx context.Context, _ *pgxpool.Pool, data pgxpool.TraceAcquireEndData) {
+	End(trace.SpanFromContext(ctx), data.Err)
+}
+
+// endQuery は結果の行数とエラーを記録してスパンを終了する
+func endQuery(span trace.Span, tag pgconn.CommandTag, err error) {
+	if err != nil {
+		span.SetAttributes(attribute.String("error.type", errorType(err)))
+	} else {
+		span.SetAttributes(attribute.Int64("db.response.rows", tag.RowsAffected()))
+	}
+	End(span, err)
+}
+
+func connAttrs(conn *pgx.Conn) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{attribute.String("db.system.name", "postgresql")}
+	if conn != nil {
+		cfg := conn.Config()
+		attrs = append(attrs,
+			attribute.String("server.address", cfg.Host),
+			attribute.String("db.namespace", cfg.Database),
+		)
+	}
+	return attrs
+}
+
+// operation はSQL文の最初のキーワード（SELECT、INSERTなど）をスパン名として返す
+//
+// 先頭のコメントと括弧は読み飛ばす。スパン名に文の残りが入らないよう、英字の並びだけを使う。
+func operation(sql string) string {
+	for {
+		sql = strings.TrimLeft(sql, " \t\r\n(")
+		switch {
+		case strings.HasPrefix(sql, "--"):
+			_, sql, _ = strings.Cut(sql, "\n")
+		case strings.HasPrefix(sql, "/*"):
+			_, sql, _ = strings.Cut(sql, "*/")
+		default:
+			end := strings.IndexFunc(sql, func(r rune) bool { return !unicode.IsLetter(r) && r != '_' })
+			if end == -1 {
+				end = len(sql)
+			}
+			if end == 0 {
+				return "QUERY"
+			}
+			return strings.ToUpper(sql[:end])
+		}
+	}
+}
+
+// errorType はエラーの種類（SQLSTATEがあればその値）を返す
+func errorType(err error) string {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return "canceled"
+	}
+	return "error"
+}
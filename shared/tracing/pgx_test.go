@@ -0,0 +1,149 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestOperation(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want string
+	}{
+		{"SELECT 1", "SELECT"},
+		{"  insert into t values (1)", "INSERT"},
+		{"update t set a = 1", "UPDATE"},
+		{"BEGIN;", "BEGIN"},
+		{"COMMIT", "COMMIT"},
+		{"VALUES(1)", "VALUES"},
+		{"(SELECT 1) UNION (SELECT 2)", "SELECT"},
+		{"/* batch */ SELECT 1", "SELECT"},
+		{"/* a */ -- b\n/* c */INSERT INTO t VALUES (1)", "INSERT"},
+		{"SELECT*FROM t", "SELECT"},
+		{"-- note\nDELETE FROM t", "DELETE"},
+		{"-- one\n  -- two\n\tSELECT 1", "SELECT"},
+		{"with t as (select 1) select * from t", "WITH"},
+		{"", "QUERY"},
+		{"-- only a comment", "QUERY"},
+		{"/* unterminated", "QUERY"},
+		{"$1", "QUERY"},
+	}
+	for _, tt := range tests {
+		if got := operation(tt.sql); got != tt.want {
+			t.Errorf("operation(%q) = %q, want %q", tt.sql, got, tt.want)
+		}
+	}
+}
+
+func TestErrorType(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{&pgconn.PgError{Code: "40001"}, "40001"},
+		{fmt.Errorf("insert: %w", &pgconn.PgError{Code: "23505"}), "23505"},
+		{context.DeadlineExceeded, "canceled"},
+		{fmt.Errorf("query: %w", context.Canceled), "canceled"},
+		{pgx.ErrNoRows, "error"},
+	}
+	for _, tt := range tests {
+		if got := errorType(tt.err); got != tt.want {
+			t.Errorf("errorType(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestPgxTracerQuery(t *testing.T) {
+	rec := record(t)
+	var tracer PgxTracer
+	const sql = "-- insert a click\nINSERT INTO button_clicks (id) VALUES ($1)"
+
+	ctx, parent := Start(context.Background(), "handler")
+	ctx = tracer.TraceQueryStart(ctx, nil, pgx.TraceQueryStartData{SQL: sql, Args: []any{"secret-arg"}})
+	tracer.TraceQueryEnd(ctx, nil, pgx.TraceQueryEndData{CommandTag: pgconn.NewCommandTag("INSERT 0 1")})
+	parent.End()
+
+	s := rec.Ended()[0]
+	if s.Name() != "INSERT" || s.SpanKind() != trace.SpanKindClient {
+		t.Errorf("span %q kind %v, want an INSERT client span", s.Name(), s.SpanKind())
+	}
+	if s.Parent().SpanID() != parent.SpanContext().SpanID() {
+		t.Errorf("parent = %v, want the handler span", s.Parent())
+	}
+	a := attrs(s)
+	if a["db.query.text"].AsString() != sql || a["db.system.name"].AsString() != "postgresql" || a["db.response.rows"].AsInt64() != 1 {
+		t.Errorf("attributes = %v", s.Attributes())
+	}
+	for _, kv := range s.Attributes() {
+		if kv.Value.Emit() == "secret-arg" {
+			t.Errorf("query arguments were recorded: %v", kv)
+		}
+	}
+	if _, ok := a["error.type"]; ok || s.Status().Code == codes.Error {
+		t.Errorf("successful query has error.type %v, status %+v", a["error.type"], s.Status())
+	}
+}
+
+func TestPgxTracerQueryError(t *testing.T) {
+	rec := record(t)
+	var tracer PgxTracer
+	pgErr := &pgconn.PgError{Code: "40001", Message: "change conflicts with another transaction"}
+
+	ctx := tracer.TraceQueryStart(context.Background(), nil, pgx.TraceQueryStartData{SQL: "UPDATE t SET a = 1"})
+	tracer.TraceQueryEnd(ctx, nil, pgx.TraceQueryEndData{Err: fmt.Errorf("update: %w", pgErr)})
+
+	s := onlySpan(t, rec)
+	if s.Name() != "UPDATE" {
+		t.Errorf("span name = %q", s.Name())
+	}
+	a := attrs(s)
+	if a["error.type"].AsString() != "40001" {
+		t.Errorf("error.type = %v, want the SQLSTATE", a["error.type"])
+	}
+	if _, ok := a["db.response.rows"]; ok {
+		t.Errorf("failed query has db.response.rows %v", a["db.response.rows"])
+	}
+	if s.Status().Code != codes.Error || s.Status().Description != "update: "+pgErr.Error() {
+		t.Errorf("status = %+v, want the error", s.Status())
+	}
+	if events := s.Events(); len(events) != 1 || events[0].Name != "exception" {
+		t.Errorf("events = %+v, want the recorded error", events)
+	}
+}
+
+func TestPgxTracerPrepareAndConnect(t *testing.T) {
+	rec := record(t)
+	var tracer PgxTracer
+
+	ctx := tracer.TracePrepareStart(context.Background(), nil, pgx.TracePrepareStartData{SQL: "select * from t"})
+	tracer.TracePrepareEnd(ctx, nil, pgx.TracePrepareEndData{})
+
+	cfg, err := pgx.ParseConfig("postgres://admin@example.dsql.us-east-1.on.aws/postgres")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx = tracer.TraceConnectStart(context.Background(), pgx.TraceConnectStartData{ConnConfig: cfg})
+	tracer.TraceConnectEnd(ctx, pgx.TraceConnectEndData{Err: context.DeadlineExceeded})
+
+	spans := rec.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("%d spans were recorded, want 2", len(spans))
+	}
+	if spans[0].Name() != "PREPARE SELECT" || spans[0].Status().Code == codes.Error {
+		t.Errorf("prepare span %q status %+v", spans[0].Name(), spans[0].Status())
+	}
+	connect := spans[1]
+	a := attrs(connect)
+	if connect.Name() != "pgx.connect" || a["server.address"].AsString() != "example.dsql.us-east-1.on.aws" || a["db.namespace"].AsString() != "postgres" {
+		t.Errorf("connect span %q %v", connect.Name(), connect.Attributes())
+	}
+	if connect.Status().Code != codes.Error {
+		t.Errorf("connect status = %+v, want the error", connect.Status())
+	}
+}
@@ -0,0 +1,59 @@
+// Package provider はOpenTelemetryのTracerProviderとOTLPエクスポーターを設定する
+//
+// LambdaのmainやinitでSetupを呼ぶ。CLIなどSetupを呼ばないプログラムではスパンはno-opのまま。
+// OTLP/HTTPのエクスポーターはSDKとProtocol Buffersに依存して大きいため、tracingとは別のパッケージにしている。
+package provider
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// Setup はOTEL_TRACES_EXPORTERに従ってグローバルのTracerProviderを設定し、送信待ちのスパンを送る関数を返す
+//
+//   - 未設定・none: 何もしない（スパンはno-op）
+//   - otlp: OTLP/HTTPで送る。送り先などはOTEL_EXPORTER_OTLP_ENDPOINTなどSDKの標準の環境変数で指定する
+//
+// サービス名はOTEL_SERVICE_NAMEがなければserviceになる。Lambdaは応答後に凍結されるため、
+// 呼び出しごとに返り値の関数でスパンを送ること。
+func Setup(ctx context.Context, service string, getenv func(string) string) (flush func(context.Context) error, err error) {
+	noop := func(context.Context) error { return nil }
+
+	switch exporter := strings.ToLower(strings.TrimSpace(getenv("OTEL_TRACES_EXPORTER"))); exporter {
+	case "", "none":
+		return noop, nil
+	case "otlp":
+	default:
+		return noop, fmt.Errorf("OTEL_TRACES_EXPORTER must be otlp or none: %q", exporter)
+	}
+
+	exp, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return noop, fmt.Errorf("failed to create OTLP trace exporter: %w", err)
+	}
+	// 後のオプションが優先されるため、OTEL_SERVICE_NAMEとOTEL_RESOURCE_ATTRIBUTESで上書きできる
+	res, err := resource.New(ctx,
+		resource.WithAttributes(attribute.String("service.name", service)),
+		resource.WithTelemetrySDK(),
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		return noop, fmt.Errorf("failed to create trace resource: %w", err)
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return tp.ForceFlush, nil
+}
@@ -0,0 +1,52 @@
+package provider
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+func TestSetupWithoutExporter(t *testing.T) {
+	for _, value := range []string{"", "none", " NONE "} {
+		tp, prop := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+		flush, err := Setup(context.Background(), "test", func(key string) string {
+			if key == "OTEL_TRACES_EXPORTER" {
+				return value
+			}
+			return ""
+		})
+		if err != nil {
+			t.Fatalf("OTEL_TRACES_EXPORTER=%q: %v", value, err)
+		}
+		if err := flush(context.Background()); err != nil {
+			t.Errorf("OTEL_TRACES_EXPORTER=%q: flush: %v", value, err)
+		}
+		if otel.GetTracerProvider() != tp || otel.GetTextMapPropagator() != prop {
+			t.Errorf("OTEL_TRACES_EXPORTER=%q changed the global provider or propagator", value)
+		}
+		if _, ok := otel.GetTracerProvider().(*sdktrace.TracerProvider); ok {
+			t.Errorf("OTEL_TRACES_EXPORTER=%q installed an SDK provider", value)
+		}
+	}
+}
+
+func TestSetupUnknownExporter(t *testing.T) {
+	tp := otel.GetTracerProvider()
+	flush, err := Setup(context.Background(), "test", func(key string) string {
+		if key == "OTEL_TRACES_EXPORTER" {
+			return "jaeger"
+		}
+		return ""
+	})
+	if err == nil {
+		t.Fatal("Setup succeeded with OTEL_TRACES_EXPORTER=jaeger")
+	}
+	if flush == nil || flush(context.Background()) != nil {
+		t.Error("Setup did not return a no-op flush with the error")
+	}
+	if otel.GetTracerProvider() != tp {
+		t.Error("Setup changed the global provider after failing")
+	}
+}
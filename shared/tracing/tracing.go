@@ -0,0 +1,91 @@
+// Package tracing はOpenTelemetryのスパンでハンドラー・接続・クエリの時間を記録する
+//
+// このパッケージはOpenTelemetryのAPIだけを使い、スパンはグローバルのTracerProviderに送る。
+// 既定はno-opで、エクスポーターはLambdaの起動時にtracing/providerのSetupで設定する。
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Name はスパンを作るTracerの名前
+const Name = "dsql-shared"
+
+// Tracer はグローバルのTracerProviderのTracerを返す
+//
+// SetTracerProviderより前に取得したTracerも、設定後はそのTracerProviderに送る。
+func Tracer() trace.Tracer {
+	return otel.Tracer(Name)
+}
+
+// Start はctxのスパンの子としてスパンを開始する
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End はerrがあればスパンにエラーとして記録して終了する
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// StartInvocation はLambdaの呼び出し（API Gatewayのリクエスト）のスパンを開始する
+//
+// headersにtraceparentがあれば、そのトレースの続きとして記録する。
+func StartInvocation(ctx context.Context, route string, headers map[string]string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	ctx = otel.GetTextMapPropagator().Extract(ctx, headerCarrier(headers))
+	return Tracer().Start(ctx, route, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(attrs...))
+}
+
+// EndInvocation は応答のステータスコードを記録して呼び出しのスパンを終了する（5xxとerrはエラー）
+func EndInvocation(span trace.Span, status int, err error) {
+	span.SetAttributes(attribute.Int("http.response.status_code", status))
+	if err == nil && status >= 500 {
+		span.SetStatus(codes.Error, http.StatusText(status))
+	}
+	End(span, err)
+}
+
+// TraceID はctxのスパンのトレースIDを返す（記録していなければ空）
+func TraceID(ctx context.Context) string {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return ""
+	}
+	return sc.TraceID().String()
+}
+
+// headerCarrier はAPI Gatewayのヘッダーをpropagation.TextMapCarrierにする（名前の大文字小文字は区別しない）
+type headerCarrier map[string]string
+
+func (c headerCarrier) Get(key string) string {
+	if v, ok := c[key]; ok {
+		return v
+	}
+	for k, v := range c {
+		if strings.EqualFold(k, key) {
+			return v
+		}
+	}
+	return ""
+}
+
+func (c headerCarrier) Set(key, value string) { c[key] = value }
+
+func (c headerCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
@@ -0,0 +1,145 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// record はテストの間、グローバルのTracerProviderを終了したスパンを記録するものにする
+func record(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	rec := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec))
+	prevTP, prevProp := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(prevTP)
+		otel.SetTextMapPropagator(prevProp)
+		tp.Shutdown(context.Background())
+	})
+	return rec
+}
+
+// onlySpan は記録したスパンが1つだけであることを確かめて返す
+func onlySpan(t *testing.T, rec *tracetest.SpanRecorder) sdktrace.ReadOnlySpan {
+	t.Helper()
+	spans := rec.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("%d spans were recorded, want 1", len(spans))
+	}
+	return spans[0]
+}
+
+// attrs はスパンの属性をキーごとの値にする
+func attrs(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	m := map[attribute.Key]attribute.Value{}
+	for _, kv := range span.Attributes() {
+		m[kv.Key] = kv.Value
+	}
+	return m
+}
+
+func TestEnd(t *testing.T) {
+	rec := record(t)
+	_, span := Start(context.Background(), "work", attribute.String("k", "v"))
+	End(span, errors.New("boom"))
+
+	s := onlySpan(t, rec)
+	if s.Name() != "work" || attrs(s)["k"].AsString() != "v" {
+		t.Errorf("span %q %v", s.Name(), s.Attributes())
+	}
+	if s.Status().Code != codes.Error || s.Status().Description != "boom" {
+		t.Errorf("status = %+v, want the error", s.Status())
+	}
+	if events := s.Events(); len(events) != 1 || events[0].Name != "exception" {
+		t.Errorf("events = %+v, want the recorded error", events)
+	}
+}
+
+func TestStartInvocationPropagatesTraceparent(t *testing.T) {
+	rec := record(t)
+	const (
+		traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+		spanID  = "00f067aa0ba902b7"
+	)
+	// API Gatewayはヘッダー名の大文字小文字をそのまま渡す
+	headers := map[string]string{"Traceparent": "00-" + traceID + "-" + spanID + "-01"}
+	ctx, span := StartInvocation(context.Background(), "GET /button-clicks", headers, attribute.String("faas.invocation_id", "inv-1"))
+	if got := TraceID(ctx); got != traceID {
+		t.Errorf("TraceID = %q, want %q", got, traceID)
+	}
+	EndInvocation(span, 200, nil)
+
+	s := onlySpan(t, rec)
+	if s.Name() != "GET /button-clicks" || s.SpanKind() != trace.SpanKindServer {
+		t.Errorf("span %q kind %v", s.Name(), s.SpanKind())
+	}
+	if s.Parent().TraceID().String() != traceID || s.Parent().SpanID().String() != spanID || !s.Parent().IsRemote() {
+		t.Errorf("parent = %v, want the remote span from traceparent", s.Parent())
+	}
+	a := attrs(s)
+	if a["faas.invocation_id"].AsString() != "inv-1" || a["http.response.status_code"].AsInt64() != 200 {
+		t.Errorf("attributes = %v", s.Attributes())
+	}
+	if s.Status().Code == codes.Error {
+		t.Errorf("status = %+v, want no error", s.Status())
+	}
+}
+
+func TestStartInvocationWithoutTraceparent(t *testing.T) {
+	rec := record(t)
+	ctx, span := StartInvocation(context.Background(), "POST /record", nil)
+	if TraceID(ctx) == "" {
+		t.Error("TraceID is empty, want a new trace")
+	}
+	EndInvocation(span, 200, nil)
+	if s := onlySpan(t, rec); s.Parent().IsValid() {
+		t.Errorf("parent = %v, want a root span", s.Parent())
+	}
+}
+
+func TestEndInvocationStatus(t *testing.T) {
+	tests := []struct {
+		status int
+		err    error
+		want   codes.Code
+		desc   string
+	}{
+		{200, nil, codes.Unset, ""},
+		{404, nil, codes.Unset, ""},
+		{503, nil, codes.Error, "Service Unavailable"},
+		{200, errors.New("boom"), codes.Error, "boom"},
+	}
+	for _, tt := range tests {
+		rec := record(t)
+		_, span := StartInvocation(context.Background(), "GET /", nil)
+		EndInvocation(span, tt.status, tt.err)
+		s := onlySpan(t, rec)
+		if s.Status().Code != tt.want || s.Status().Description != tt.desc {
+			t.Errorf("EndInvocation(%d, %v) status = %+v, want %v %q", tt.status, tt.err, s.Status(), tt.want, tt.desc)
+		}
+	}
+}
+
+func TestTraceIDWithoutSpan(t *testing.T) {
+	if got := TraceID(context.Background()); got != "" {
+		t.Errorf("TraceID = %q, want empty", got)
+	}
+}
+
+func TestHeaderCarrier(t *testing.T) {
+	c := headerCarrier{"TraceParent": "a"}
+	if c.Get("traceparent") != "a" || c.Get("tracestate") != "" {
+		t.Errorf("Get = %q, %q", c.Get("traceparent"), c.Get("tracestate"))
+	}
+}